@@ -6,8 +6,15 @@ import (
 
 	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/assessment"
 	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/billing"
 	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/business_risk"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/notifications"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/organizations"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/pricing"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/ratelimit"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/sms"
 	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/website_risk"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/webhooks"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -72,6 +79,11 @@ func NewServer() (*Server, error) {
 		"Content-Type",
 		"Accept",
 		"Authorization",
+		"X-API-Key",
+		"X-Organization-ID",
+		"X-Step-Up-Token",
+		"X-TOTP-Code",
+		"Idempotency-Key",
 		"X-Requested-With",
 		"Access-Control-Request-Method",
 		"Access-Control-Request-Headers",
@@ -83,6 +95,12 @@ func NewServer() (*Server, error) {
 		"Access-Control-Allow-Credentials",
 		"Access-Control-Allow-Headers",
 		"Access-Control-Allow-Methods",
+		"RateLimit-Limit",
+		"RateLimit-Remaining",
+		"RateLimit-Reset",
+		"RateLimit-Policy",
+		"Retry-After",
+		"Idempotent-Replayed",
 	}
 	config.AllowCredentials = true
 	config.MaxAge = 86400 // 24 hours
@@ -93,67 +111,212 @@ func NewServer() (*Server, error) {
 	authGroup := router.Group("/api/auth")
 	{
 		// Public routes
-		authGroup.POST("/login", auth.LoginHandler)
-		authGroup.POST("/register", auth.RegisterHandler)
-		authGroup.POST("/users", auth.CreateUserHandler) // For user setup after Supabase auth
+		public := ratelimit.Middleware(ratelimit.PublicPolicy)
+		authGroup.POST("/login", public, auth.LoginHandler)
+		authGroup.POST("/register", public, auth.RegisterHandler)
+		authGroup.POST("/users", public, auth.CreateUserHandler) // For user setup after Supabase auth
 		authGroup.GET("/plans", auth.GetSubscriptionPlansHandler) // Public plan info
 		
 		// Protected routes
 		protected := authGroup.Group("")
-		protected.Use(auth.AuthMiddleware())
+		protected.Use(auth.AuthMiddleware(), ratelimit.Middleware(ratelimit.APIPolicy), auth.Idempotency())
 		{
-			protected.POST("/logout", auth.LogoutHandler)
+			protected.POST("/logout", auth.Audit(auth.AuditLogout, "session"), auth.LogoutHandler)
+			protected.GET("/sessions", auth.ListSessionsHandler)
+			protected.DELETE("/sessions", auth.Audit(auth.AuditSessionRevoked, "session"), auth.RevokeOtherSessionsHandler)
+			protected.DELETE("/sessions/:id", auth.Audit(auth.AuditSessionRevoked, "session"), auth.RevokeSessionHandler)
 			protected.GET("/verify", auth.VerifyTokenHandler)
 			protected.GET("/profile", auth.GetProfileHandler)
 			protected.PUT("/profile", auth.UpdateProfileHandler)
 			protected.GET("/credits", auth.GetCreditsHandler)
+			protected.GET("/credits/usage", auth.RequirePermission(auth.PermBillingRead), auth.GetCreditUsageHandler)
 			
-			// Phone verification routes
-			protected.POST("/send-phone-verification", auth.SendPhoneVerificationHandler)
-			protected.POST("/verify-phone-code", auth.VerifyPhoneCodeHandler)
-			protected.PUT("/phone", auth.UpdatePhoneHandler)
+			// Phone verification routes (each attempt may send an SMS)
+			phoneLimit := ratelimit.Middleware(ratelimit.PhoneVerificationPolicy)
+			protected.POST("/send-phone-verification", phoneLimit, auth.SendPhoneVerificationHandler)
+			protected.POST("/verify-phone-code", phoneLimit, auth.VerifyPhoneCodeHandler)
+			protected.PUT("/phone", phoneLimit, auth.UpdatePhoneHandler)
+			protected.GET("/phone-verification/deliveries/:id", auth.GetPhoneVerificationDeliveryHandler)
+
+			// Two-factor authentication (code attempts are rate limited)
+			twoFactorLimit := ratelimit.Middleware(ratelimit.TwoFactorPolicy)
+			protected.GET("/2fa", auth.GetTwoFactorStatusHandler)
+			protected.POST("/2fa/enroll", twoFactorLimit, auth.EnrollTwoFactorHandler)
+			protected.POST("/2fa/confirm", twoFactorLimit, auth.Audit(auth.AuditTwoFactorEnabled, "two_factor"), auth.ConfirmTwoFactorHandler)
+			protected.POST("/2fa/verify", twoFactorLimit, auth.VerifyTwoFactorHandler)
+			protected.POST("/2fa/recovery-codes", twoFactorLimit, auth.RequireStepUp(), auth.RegenerateRecoveryCodesHandler)
+			protected.DELETE("/2fa", twoFactorLimit, auth.RequireStepUp(), auth.Audit(auth.AuditTwoFactorDisabled, "two_factor"), auth.DisableTwoFactorHandler)
+
+			// API key management (user JWT only)
+			protected.GET("/api-keys", auth.ListAPIKeysHandler)
+			protected.POST("/api-keys", auth.RequireStepUp(), auth.Audit(auth.AuditAPIKeyCreated, "api_key"), auth.CreateAPIKeyHandler)
+			protected.DELETE("/api-keys/:id", auth.Audit(auth.AuditAPIKeyRevoked, "api_key"), auth.RevokeAPIKeyHandler)
+
+			// Subscription plan changes (upgrades apply now, downgrades and cancellations at period end)
+			protected.GET("/subscription", auth.GetSubscriptionHandler)
+			protected.PUT("/subscription", auth.RequireStepUp(), auth.Audit(auth.AuditSubscriptionChanged, "subscription"), billing.ChangeSubscriptionHandler)
+			protected.POST("/subscription/cancel", auth.RequireStepUp(), auth.Audit(auth.AuditSubscriptionCancelled, "subscription"), auth.CancelSubscriptionHandler)
+			protected.PUT("/subscription/overage", auth.Audit(auth.AuditSubscriptionChanged, "subscription"), auth.UpdateOverageHandler)
 		}
 	}
 
+	// Assessment routes check role permissions per route: in an organization
+	// (X-Organization-ID) the member's role decides, personal workspaces hold all.
+
 	// API v1 routes
 	v1 := router.Group("/api/v1")
+	v1.Use(auth.AuthMiddleware(auth.ScopeAssessments), ratelimit.Middleware(ratelimit.APIPolicy), billing.LowBalanceWarning(), auth.Idempotency()) // Require authentication (JWT or API key)
 	{
 		// Assessment endpoints
-		v1.POST("/assessments", assessment.CreateAssessmentHandler)
-		v1.GET("/assessments", assessment.ListAssessmentsHandler)
-		v1.GET("/assessments/:id", assessment.GetAssessmentHandler)
+		v1.POST("/assessments", ratelimit.Middleware(ratelimit.AssessmentPolicy), auth.RequirePermission(auth.PermAssessmentsCreate), auth.Audit(auth.AuditAssessmentCreated, "assessment"), assessment.CreateAssessmentHandler)
+		v1.GET("/assessments", auth.RequirePermission(auth.PermAssessmentsRead), assessment.ListAssessmentsHandler)
+		v1.GET("/assessments/:id", auth.RequirePermission(auth.PermAssessmentsRead), auth.Audit(auth.AuditAssessmentViewed, "assessment"), assessment.GetAssessmentHandler)
 	}
 
 	// Business Risk Prevention routes (protected)
 	brp := router.Group("/api/business-risk-prevention")
-	brp.Use(auth.AuthMiddleware()) // Require authentication
+	brp.Use(auth.AuthMiddleware(auth.ScopeBusinessRisk), ratelimit.Middleware(ratelimit.APIPolicy), billing.LowBalanceWarning(), auth.Idempotency()) // Require authentication (JWT or API key)
 	{
 		// Assessment endpoints
-		brp.POST("/assessments", business_risk.CreateBusinessRiskAssessmentHandler)
-		brp.GET("/assessments", business_risk.ListBusinessRiskAssessmentsHandler)
-		brp.GET("/assessments/:id", business_risk.GetBusinessRiskAssessmentHandler)
-		brp.PUT("/assessments/:id", business_risk.UpdateBusinessRiskAssessmentHandler)
-		brp.DELETE("/assessments/:id", business_risk.DeleteBusinessRiskAssessmentHandler)
-		brp.DELETE("/assessments/bulk", business_risk.DeleteBusinessRiskAssessmentsHandler)
-		brp.POST("/assessments/:id/rerun", business_risk.RerunBusinessRiskAssessmentHandler)
+		brp.POST("/assessments", auth.RequirePermission(auth.PermAssessmentsCreate), auth.Audit(auth.AuditAssessmentCreated, "business_risk_assessment"), business_risk.CreateBusinessRiskAssessmentHandler)
+		brp.GET("/assessments", auth.RequirePermission(auth.PermAssessmentsRead), business_risk.ListBusinessRiskAssessmentsHandler)
+		brp.GET("/assessments/:id", auth.RequirePermission(auth.PermAssessmentsRead), auth.Audit(auth.AuditAssessmentViewed, "business_risk_assessment"), business_risk.GetBusinessRiskAssessmentHandler)
+		brp.PUT("/assessments/:id", auth.RequirePermission(auth.PermAssessmentsUpdate), auth.Audit(auth.AuditAssessmentUpdated, "business_risk_assessment"), business_risk.UpdateBusinessRiskAssessmentHandler)
+		brp.DELETE("/assessments/:id", auth.RequirePermission(auth.PermAssessmentsDelete), auth.Audit(auth.AuditAssessmentDeleted, "business_risk_assessment"), business_risk.DeleteBusinessRiskAssessmentHandler)
+		brp.DELETE("/assessments/bulk", auth.RequirePermission(auth.PermAssessmentsDelete), auth.Audit(auth.AuditAssessmentDeleted, "business_risk_assessment"), business_risk.DeleteBusinessRiskAssessmentsHandler)
+		brp.POST("/assessments/:id/rerun", auth.RequirePermission(auth.PermAssessmentsCreate), auth.Audit(auth.AuditAssessmentCreated, "business_risk_assessment"), business_risk.RerunBusinessRiskAssessmentHandler)
 
 		// Export endpoints
-		brp.GET("/export/csv", business_risk.ExportBusinessRiskAssessmentsCSVHandler)
-		brp.GET("/assessments/:id/export/pdf", business_risk.ExportBusinessRiskAssessmentPDFHandler)
+		brp.GET("/export/csv", auth.RequirePermission(auth.PermAssessmentsExport), auth.RequireStepUp(), auth.Audit(auth.AuditAssessmentExported, "business_risk_assessment"), business_risk.ExportBusinessRiskAssessmentsCSVHandler)
+		brp.GET("/assessments/:id/export/pdf", auth.RequirePermission(auth.PermAssessmentsExport), auth.RequireStepUp(), auth.Audit(auth.AuditAssessmentExported, "business_risk_assessment"), business_risk.ExportBusinessRiskAssessmentPDFHandler)
 
 		// Insights endpoint
-		brp.GET("/insights", business_risk.GetBusinessRiskInsightsHandler)
+		brp.GET("/insights", auth.RequirePermission(auth.PermAssessmentsRead), business_risk.GetBusinessRiskInsightsHandler)
 	}
 
 	// Website Risk Assessment routes (protected)
 	wra := router.Group("/api/website-risk-assessment")
-	wra.Use(auth.AuthMiddleware()) // Require authentication
+	wra.Use(auth.AuthMiddleware(auth.ScopeWebsiteRisk), ratelimit.Middleware(ratelimit.APIPolicy), billing.LowBalanceWarning(), auth.Idempotency()) // Require authentication (JWT or API key)
+	{
+		wra.POST("/do-assessment", ratelimit.Middleware(ratelimit.AssessmentPolicy), auth.RequirePermission(auth.PermAssessmentsCreate), auth.Audit(auth.AuditAssessmentCreated, "assessment"), website_risk.DoRiskAssessmentHandler)
+		wra.POST("/get-assessment", auth.RequirePermission(auth.PermAssessmentsRead), auth.Audit(auth.AuditAssessmentViewed, "assessment"), website_risk.GetRiskAssessmentHandler)
+		wra.POST("/manual-update", auth.RequirePermission(auth.PermAssessmentsQualify), auth.RequireStepUp(), auth.Audit(auth.AuditAssessmentOverridden, "assessment"), website_risk.ManualQualificationUpdateHandler)
+		wra.GET("/assessments", auth.RequirePermission(auth.PermAssessmentsRead), website_risk.ListAssessmentsHandler)
+		wra.GET("/assessments/:id", auth.RequirePermission(auth.PermAssessmentsRead), auth.Audit(auth.AuditAssessmentViewed, "assessment"), website_risk.GetAssessmentByIDHandler)
+
+		// Batch submission endpoints
+		wra.POST("/batches", ratelimit.Middleware(ratelimit.AssessmentPolicy), auth.RequirePermission(auth.PermAssessmentsCreate), auth.Audit(auth.AuditAssessmentCreated, "assessment_batch"), website_risk.CreateBatchHandler)
+		wra.GET("/batches", auth.RequirePermission(auth.PermAssessmentsRead), website_risk.ListBatchesHandler)
+		wra.GET("/batches/:id", auth.RequirePermission(auth.PermAssessmentsRead), website_risk.GetBatchHandler)
+		wra.POST("/batches/:id/cancel", auth.RequirePermission(auth.PermAssessmentsCreate), auth.Audit(auth.AuditAssessmentUpdated, "assessment_batch"), website_risk.CancelBatchHandler)
+		wra.GET("/batches/:id/results", auth.RequirePermission(auth.PermAssessmentsRead), auth.Audit(auth.AuditAssessmentViewed, "assessment_batch"), website_risk.GetBatchResultsHandler)
+		wra.GET("/batches/:id/export/csv", auth.RequirePermission(auth.PermAssessmentsExport), auth.RequireStepUp(), auth.Audit(auth.AuditAssessmentExported, "assessment_batch"), website_risk.ExportBatchResultsCSVHandler)
+	}
+
+	// Outbound webhook routes (protected)
+	wh := router.Group("/api/webhooks")
+	wh.Use(auth.AuthMiddleware(auth.ScopeWebhooks), ratelimit.Middleware(ratelimit.APIPolicy), auth.RequirePermission(auth.PermIntegrationsManage), auth.Idempotency()) // Require authentication (JWT or API key)
+	{
+		wh.POST("", webhooks.CreateEndpointHandler)
+		wh.GET("", webhooks.ListEndpointsHandler)
+		wh.GET("/:id", webhooks.GetEndpointHandler)
+		wh.PUT("/:id", webhooks.UpdateEndpointHandler)
+		wh.DELETE("/:id", webhooks.DeleteEndpointHandler)
+		wh.POST("/:id/test", webhooks.TestEndpointHandler)
+		wh.GET("/:id/deliveries", webhooks.ListDeliveriesHandler)
+		wh.POST("/:id/deliveries/:delivery_id/redeliver", webhooks.RedeliverHandler)
+	}
+
+	// SMS provider delivery reports (public, verified by provider signature)
+	router.POST("/api/sms/status/twilio", sms.TwilioStatusHandler)
+
+	// Notification routing rules (protected)
+	notif := router.Group("/api/notifications")
+	notif.Use(auth.AuthMiddleware(), ratelimit.Middleware(ratelimit.APIPolicy), auth.RequirePermission(auth.PermIntegrationsManage), auth.Idempotency()) // Require authentication
+	{
+		notif.GET("/channels", notifications.ListChannelsHandler)
+		notif.GET("/rules", notifications.ListRulesHandler)
+		notif.POST("/rules", notifications.CreateRuleHandler)
+		notif.GET("/rules/:id", notifications.GetRuleHandler)
+		notif.PUT("/rules/:id", notifications.UpdateRuleHandler)
+		notif.DELETE("/rules/:id", notifications.DeleteRuleHandler)
+		notif.POST("/rules/:id/test", notifications.TestRuleHandler)
+	}
+
+	// Organizations, members, invites and shared credits (protected)
+	orgs := router.Group("/api/organizations")
+	orgs.Use(auth.AuthMiddleware(), ratelimit.Middleware(ratelimit.APIPolicy), auth.Idempotency()) // Require authentication
+	{
+		orgs.GET("", organizations.ListOrganizationsHandler)
+		orgs.POST("", organizations.CreateOrganizationHandler)
+		orgs.POST("/invites/accept", organizations.AcceptInviteHandler)
+		orgs.GET("/:id", organizations.GetOrganizationHandler)
+		orgs.PUT("/:id", organizations.UpdateOrganizationHandler)
+		orgs.PUT("/:id/members/:user_id", organizations.UpdateMemberHandler)
+		orgs.DELETE("/:id/members/:user_id", organizations.RemoveMemberHandler)
+		orgs.GET("/:id/invites", organizations.ListInvitesHandler)
+		orgs.POST("/:id/invites", organizations.CreateInviteHandler)
+		orgs.DELETE("/:id/invites/:invite_id", organizations.RevokeInviteHandler)
+		orgs.GET("/:id/roles", organizations.ListRolesHandler)
+		orgs.PUT("/:id/roles/:role", organizations.UpdateRoleHandler)
+		orgs.DELETE("/:id/roles/:role", organizations.ResetRoleHandler)
+		orgs.GET("/:id/credits", organizations.GetCreditsHandler)
+		orgs.POST("/:id/credits/transfer", organizations.TransferCreditsHandler)
+	}
+
+	// Credit package purchases. Webhooks and the fake checkout page are public
+	// and verified by the payment provider's signature.
+	bill := router.Group("/api/billing")
+	{
+		bill.GET("/packages", billing.ListPackagesHandler)
+		bill.POST("/webhooks/:provider", billing.WebhookHandler)
+		bill.POST("/fake-checkout/:session_id", ratelimit.Middleware(ratelimit.PublicPolicy), billing.FakeCheckoutHandler)
+
+		protectedBilling := bill.Group("")
+		protectedBilling.Use(auth.AuthMiddleware(), ratelimit.Middleware(ratelimit.APIPolicy), auth.Idempotency())
+		protectedBilling.POST("/checkout", auth.RequirePermission(auth.PermBillingManage), auth.Audit(auth.AuditCheckoutCreated, "credit_purchase"), billing.CreateCheckoutHandler)
+		protectedBilling.GET("/purchases", auth.RequirePermission(auth.PermBillingRead), billing.ListPurchasesHandler)
+		protectedBilling.GET("/usage", auth.RequirePermission(auth.PermBillingRead), billing.GetUsageHandler)
+		protectedBilling.GET("/invoices", auth.RequirePermission(auth.PermBillingRead), billing.ListInvoicesHandler)
+		protectedBilling.GET("/invoices/:id", auth.RequirePermission(auth.PermBillingRead), billing.GetInvoiceHandler)
+		protectedBilling.GET("/invoices/:id/pdf", auth.RequirePermission(auth.PermBillingRead), billing.DownloadInvoiceHandler)
+
+		// Low-balance alerts and auto-recharge of the workspace's wallet
+		protectedBilling.GET("/alerts", auth.RequirePermission(auth.PermBillingRead), billing.ListAlertRulesHandler)
+		protectedBilling.POST("/alerts", auth.RequirePermission(auth.PermBillingManage), auth.Audit(auth.AuditCreditAlertChanged, "credit_alert_rule"), billing.CreateAlertRuleHandler)
+		protectedBilling.PUT("/alerts/:id", auth.RequirePermission(auth.PermBillingManage), auth.Audit(auth.AuditCreditAlertChanged, "credit_alert_rule"), billing.UpdateAlertRuleHandler)
+		protectedBilling.DELETE("/alerts/:id", auth.RequirePermission(auth.PermBillingManage), auth.Audit(auth.AuditCreditAlertChanged, "credit_alert_rule"), billing.DeleteAlertRuleHandler)
+	}
+
+	// Assessment pricing; quotes apply the workspace's plan discount
+	prices := router.Group("/api/pricing")
+	{
+		prices.GET("/catalog", pricing.CatalogHandler)
+		prices.POST("/quote", auth.AuthMiddleware(), ratelimit.Middleware(ratelimit.APIPolicy), auth.Idempotency(), pricing.QuoteHandler)
+	}
+
+	// Audit log of the workspace (X-Organization-ID selects an organization)
+	audit := router.Group("/api/audit-logs")
+	audit.Use(auth.AuthMiddleware(), ratelimit.Middleware(ratelimit.APIPolicy), auth.RequirePermission(auth.PermAuditRead))
+	{
+		audit.GET("", auth.ListAuditLogsHandler)
+		audit.GET("/verify", auth.VerifyAuditLogsHandler)
+	}
+
+	// Platform admin back office (user_profiles.is_platform_admin; every action is audited)
+	admin := router.Group("/api/admin")
+	admin.Use(auth.AuthMiddleware(), ratelimit.Middleware(ratelimit.APIPolicy), auth.RequirePlatformAdmin(), auth.Idempotency())
 	{
-		wra.POST("/do-assessment", website_risk.DoRiskAssessmentHandler)
-		wra.POST("/get-assessment", website_risk.GetRiskAssessmentHandler)
-		wra.POST("/manual-update", website_risk.ManualQualificationUpdateHandler)
-		wra.GET("/assessments", website_risk.ListAssessmentsHandler)
-		wra.GET("/assessments/:id", website_risk.GetAssessmentByIDHandler)
+		admin.GET("/users", auth.SearchUsersHandler)
+		admin.GET("/users/:id", auth.Audit(auth.AuditAdminUserViewed, "user"), auth.GetAdminUserHandler)
+		admin.GET("/users/:id/assessments", auth.Audit(auth.AuditAdminUserViewed, "user"), auth.ListUserAssessmentsHandler)
+		admin.GET("/users/:id/credits/transactions", auth.Audit(auth.AuditAdminUserViewed, "user"), auth.ListUserCreditTransactionsHandler)
+		admin.POST("/users/:id/credits/bonus", auth.RequireStepUp(), auth.Audit(auth.AuditAdminCreditsGranted, "user"), auth.GrantBonusCreditsHandler)
+		admin.POST("/users/:id/credits/bonus/revoke", auth.RequireStepUp(), auth.Audit(auth.AuditAdminCreditsRevoked, "user"), auth.RevokeBonusCreditsHandler)
+		admin.POST("/users/:id/suspend", auth.RequireStepUp(), auth.Audit(auth.AuditAdminUserSuspended, "user"), auth.SuspendUserHandler)
+		admin.POST("/users/:id/reactivate", auth.RequireStepUp(), auth.Audit(auth.AuditAdminUserReactivated, "user"), auth.ReactivateUserHandler)
+		admin.PUT("/users/:id/subscription", auth.RequireStepUp(), auth.Audit(auth.AuditAdminPlanChanged, "user"), auth.ChangeUserPlanHandler)
+		admin.POST("/users/:id/impersonate", auth.RequireStepUp(), auth.Audit(auth.AuditAdminImpersonationStarted, "user"), auth.ImpersonateUserHandler)
 	}
 
 	// Health check endpoint
@@ -199,10 +362,15 @@ func NewServer() (*Server, error) {
 				"auth_verify":                   "/api/auth/verify",
 				"auth_profile":                  "/api/auth/profile",
 				"auth_credits":                  "/api/auth/credits",
+				"auth_credits_usage":            "/api/auth/credits/usage",
 				"auth_phone_send":               "/api/auth/send-phone-verification",
 				"auth_phone_verify":             "/api/auth/verify-phone-code",
 				"auth_phone_update":             "/api/auth/phone",
+				"auth_phone_delivery":           "/api/auth/phone-verification/deliveries/:id",
 				"auth_plans":                    "/api/auth/plans",
+				"auth_api_keys":                 "/api/auth/api-keys",
+				"auth_two_factor":               "/api/auth/2fa",
+				"auth_sessions":                 "/api/auth/sessions",
 				"create_assessment":             "/api/v1/assessments",
 				"list_assessments":              "/api/v1/assessments",
 				"get_assessment":                "/api/v1/assessments/:id",
@@ -215,6 +383,31 @@ func NewServer() (*Server, error) {
 				"website_risk_manual_update":    "/api/website-risk-assessment/manual-update",
 				"website_risk_list_assessments": "/api/website-risk-assessment/assessments",
 				"website_risk_get_by_id":        "/api/website-risk-assessment/assessments/:id",
+				"website_risk_batches":          "/api/website-risk-assessment/batches",
+				"website_risk_batch_results":    "/api/website-risk-assessment/batches/:id/results",
+				"website_risk_batch_cancel":     "/api/website-risk-assessment/batches/:id/cancel",
+				"website_risk_batch_export_csv": "/api/website-risk-assessment/batches/:id/export/csv",
+				"webhooks":                      "/api/webhooks",
+				"webhook_test":                  "/api/webhooks/:id/test",
+				"webhook_deliveries":            "/api/webhooks/:id/deliveries",
+				"webhook_redeliver":             "/api/webhooks/:id/deliveries/:delivery_id/redeliver",
+				"notification_channels":         "/api/notifications/channels",
+				"notification_rules":            "/api/notifications/rules",
+				"notification_rule_test":        "/api/notifications/rules/:id/test",
+				"organizations":                 "/api/organizations",
+				"organization_members":          "/api/organizations/:id/members/:user_id",
+				"organization_invites":          "/api/organizations/:id/invites",
+				"organization_invite_accept":    "/api/organizations/invites/accept",
+				"organization_roles":            "/api/organizations/:id/roles",
+				"organization_credits":          "/api/organizations/:id/credits",
+				"billing_packages":              "/api/billing/packages",
+				"billing_checkout":              "/api/billing/checkout",
+				"billing_purchases":             "/api/billing/purchases",
+				"billing_alerts":                "/api/billing/alerts",
+				"billing_webhook":               "/api/billing/webhooks/:provider",
+				"audit_logs":                    "/api/audit-logs",
+				"audit_logs_verify":             "/api/audit-logs/verify",
+				"admin_users":                   "/api/admin/users",
 			},
 		})
 	})
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/api"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/billing"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/business_risk"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/config"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/notifications"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/organizations"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/pricing"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/ratelimit"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/salesforce"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/sms"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/webhooks"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/website_risk"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	// Load environment variables from .env file if it exists
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found: %v", err)
+	}
+
+	// Load production configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Set production mode if specified
+	if cfg.IsProduction() {
+		log.Printf("🚀 Starting in PRODUCTION mode")
+		log.Printf("📊 Database: PostgreSQL=%s, MongoDB=%s", cfg.PostgresHost, cfg.MongoHost)
+	} else {
+		log.Printf("🔧 Starting in DEVELOPMENT mode")
+	}
+
+	// Initialize rate limiting (independent of Supabase)
+	rateLimitStore := ratelimit.Store(ratelimit.NewMemoryStore())
+	if cfg.RateLimitStore == "redis" {
+		redisStore, err := ratelimit.NewRedisStore(cfg.RedisConnectionString())
+		if err != nil {
+			log.Printf("Warning: Rate limit store unavailable, using in-memory limits: %v", err)
+		} else {
+			rateLimitStore = redisStore
+		}
+	}
+	ratelimit.Init(rateLimitStore, cfg.RateLimitEnabled)
+	if cfg.RateLimitEnabled {
+		log.Printf("✅ Rate limiting enabled (%T)", rateLimitStore)
+	} else {
+		log.Printf("Warning: Rate limiting disabled")
+	}
+
+	// Initialize Salesforce integration (independent of Supabase)
+	sfConfig := salesforce.Config{
+		AuthFlow:      cfg.SalesforceAuthFlow,
+		LoginURL:      cfg.SalesforceLoginURL,
+		InstanceURL:   cfg.SalesforceInstanceURL,
+		APIVersion:    cfg.SalesforceAPIVersion,
+		ClientID:      cfg.SalesforceClientID,
+		ClientSecret:  cfg.SalesforceClientSecret,
+		Username:      cfg.SalesforceUsername,
+		PrivateKeyPEM: cfg.SalesforcePrivateKey,
+		ObjectType:    cfg.SalesforceObjectType,
+		Fields: salesforce.FieldMap{
+			QualificationStatus: cfg.SalesforceFieldMap["qualification_status"],
+			RiskScore:           cfg.SalesforceFieldMap["risk_score"],
+			RiskCategory:        cfg.SalesforceFieldMap["risk_category"],
+			ReportLink:          cfg.SalesforceFieldMap["report_link"],
+		},
+		ReportURL: cfg.SalesforceReportURL,
+	}
+	if err := salesforce.Init(sfConfig); err != nil {
+		log.Printf("Warning: Salesforce integration disabled: %v", err)
+	} else {
+		log.Printf("✅ Salesforce integration initialized (%s, %s)", sfConfig.AuthFlow, sfConfig.ObjectType)
+	}
+
+	// Initialize services with configuration
+	if cfg.SupabaseURL == "" || cfg.SupabaseServiceKey == "" {
+		log.Printf("Warning: Supabase configuration not available")
+		log.Printf("The server will start but database operations may fail")
+	} else {
+		// Initialize authentication system
+		if err := auth.InitAuth(cfg.SupabaseURL, cfg.SupabaseServiceKey); err != nil {
+			log.Printf("Warning: Failed to initialize auth system: %v", err)
+		} else {
+			log.Printf("✅ Authentication system initialized")
+		}
+
+		// Verify Supabase access tokens locally instead of calling Supabase per
+		// request when a JWT secret or JWKS URL is configured
+		issuer := cfg.AuthJWTIssuer
+		if issuer == "" {
+			issuer = strings.TrimRight(cfg.SupabaseURL, "/") + "/auth/v1"
+		}
+		jwtConfig := auth.JWTConfig{
+			Secret:         cfg.SupabaseJWTSecret,
+			JWKSURL:        cfg.SupabaseJWKSURL,
+			Issuer:         issuer,
+			Audience:       cfg.AuthJWTAudience,
+			RemoteFallback: cfg.AuthRemoteFallback,
+			JWKSCacheTTL:   time.Duration(cfg.AuthJWKSCacheTTL) * time.Second,
+		}
+		if err := auth.InitJWTVerification(jwtConfig); err != nil {
+			log.Printf("Warning: Local JWT verification disabled, using Supabase introspection: %v", err)
+		} else {
+			log.Printf("✅ Local JWT verification enabled (HS256: %t, JWKS: %s, remote fallback: %t)",
+				jwtConfig.Secret != "", jwtConfig.JWKSURL, jwtConfig.RemoteFallback)
+		}
+
+		// Initialize SMS service
+		smsConfig := sms.Config{
+			Enabled:   cfg.EnableSMS,
+			Providers: cfg.SMSProviders,
+			AWSRegion: cfg.AWSRegion,
+			SenderID:  cfg.SMSSenderID,
+			Twilio: sms.TwilioConfig{
+				AccountSID:        cfg.TwilioAccountSID,
+				AuthToken:         cfg.TwilioAuthToken,
+				From:              cfg.TwilioFrom,
+				StatusCallbackURL: cfg.TwilioStatusCallbackURL,
+			},
+			SinkFile: cfg.SMSSinkFile,
+			SinkURL:  cfg.SMSSinkURL,
+		}
+		if err := sms.InitSMS(cfg.SupabaseURL, cfg.SupabaseServiceKey, smsConfig); err != nil {
+			log.Printf("Warning: Failed to initialize SMS service: %v", err)
+			log.Printf("Phone verification will use development mode")
+		} else {
+			log.Printf("✅ SMS service initialized")
+		}
+
+		// Initialize website risk assessment database
+		if err := website_risk.InitDatabase(cfg.SupabaseURL, cfg.SupabaseServiceKey); err != nil {
+			log.Printf("Warning: Failed to initialize website risk database: %v", err)
+			log.Printf("Website risk assessments will use in-memory storage")
+		} else {
+			log.Printf("✅ Website risk assessment database initialized")
+		}
+
+		// Initialize business risk assessment database
+		if err := business_risk.InitDatabase(cfg.SupabaseURL, cfg.SupabaseServiceKey); err != nil {
+			log.Printf("Warning: Failed to initialize business risk database: %v", err)
+			log.Printf("Business risk assessments will use mock data")
+		} else {
+			log.Printf("✅ Business risk assessment database initialized")
+		}
+
+		// Initialize outbound webhooks
+		if err := webhooks.InitWebhooks(cfg.SupabaseURL, cfg.SupabaseServiceKey); err != nil {
+			log.Printf("Warning: Failed to initialize webhooks: %v", err)
+			log.Printf("Assessment lifecycle webhooks will not be delivered")
+		} else {
+			log.Printf("✅ Webhooks initialized")
+		}
+
+		// Initialize notification channels and the digest worker
+		notificationSettings := notifications.Settings{
+			AppBaseURL: cfg.AppBaseURL,
+			SMTP: notifications.SMTPConfig{
+				Host:     cfg.SMTPHost,
+				Port:     cfg.SMTPPort,
+				Username: cfg.SMTPUsername,
+				Password: cfg.SMTPPassword,
+				From:     cfg.SMTPFrom,
+			},
+		}
+		if err := notifications.InitNotifications(cfg.SupabaseURL, cfg.SupabaseServiceKey, notificationSettings); err != nil {
+			log.Printf("Warning: Failed to initialize notifications: %v", err)
+		} else {
+			notifications.StartDigestWorker(context.Background())
+			log.Printf("✅ Notifications initialized")
+		}
+
+		// Initialize organizations
+		if err := organizations.InitOrganizations(cfg.SupabaseURL, cfg.SupabaseServiceKey, cfg.AppBaseURL); err != nil {
+			log.Printf("Warning: Failed to initialize organizations: %v", err)
+		} else {
+			log.Printf("✅ Organizations initialized")
+		}
+
+		// Initialize the assessment pricing catalog
+		pricingConfig := pricing.Config{CreditValueCents: cfg.AssessmentCreditValueCents}
+		if err := pricing.InitPricing(cfg.SupabaseURL, cfg.SupabaseServiceKey, pricingConfig); err != nil {
+			log.Printf("Warning: Using the built-in pricing catalog: %v", err)
+		} else {
+			log.Printf("✅ Assessment pricing catalog loaded")
+		}
+
+		// Initialize credit purchases and the payment provider
+		billingConfig := billing.Config{
+			Provider:            cfg.PaymentProvider,
+			StripeSecretKey:     cfg.StripeSecretKey,
+			StripeWebhookSecret: cfg.StripeWebhookSecret,
+			FakeWebhookSecret:   cfg.PaymentFakeWebhookSecret,
+			AppBaseURL:          cfg.AppBaseURL,
+			Production:          cfg.IsProduction(),
+		}
+		if err := billing.InitBilling(cfg.SupabaseURL, cfg.SupabaseServiceKey, billingConfig); err != nil {
+			log.Printf("Warning: Credit purchases disabled: %v", err)
+		} else {
+			log.Printf("✅ Billing initialized (%s)", cfg.PaymentProvider)
+		}
+
+		// Log production database connections
+		if cfg.IsProduction() {
+			log.Printf("✅ PostgreSQL: %s", cfg.PostgresConnectionString())
+			log.Printf("✅ MongoDB: %s", cfg.MongoConnectionString())
+			log.Printf("✅ Redis: %s", cfg.RedisConnectionString())
+		}
+	}
+
+	// Create server
+	server, err := api.NewServer()
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+
+	// Start server
+	address := fmt.Sprintf(":%s", cfg.Port)
+	log.Printf("🚀 QuarkFin Platform E2E Backend starting on port %s", cfg.Port)
+	if cfg.IsProduction() {
+		log.Printf("📍 Production URLs:")
+		log.Printf("  🌐 Frontend: https://app.quarkfinai.com")
+		log.Printf("  🔗 API: https://api.quarkfinai.com")
+		log.Printf("  🏥 Health: https://api.quarkfinai.com/ping")
+	} else {
+		log.Printf("📍 Development URLs:")
+		log.Printf("  🏥 Health check: http://localhost%s/ping", address)
+		log.Printf("  📖 API docs: http://localhost%s/", address)
+	}
+
+	if err := server.Start(address); err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+}
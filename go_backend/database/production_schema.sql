@@ -27,21 +27,58 @@ CREATE TABLE IF NOT EXISTS user_profiles (
     created_at            TIMESTAMPTZ DEFAULT NOW(),
     updated_at            TIMESTAMPTZ DEFAULT NOW(),
     last_login_at         TIMESTAMPTZ,
-    status                VARCHAR(20) DEFAULT 'active' -- active, suspended, deleted
+    status                VARCHAR(20) DEFAULT 'active', -- active, suspended, deleted
+    suspended_at          TIMESTAMPTZ,
+    suspension_reason     TEXT,
+    is_platform_admin     BOOLEAN NOT NULL DEFAULT FALSE -- support staff allowed to use the admin API
 );
 
--- Phone verification tracking
+-- Phone verification tracking (codes are stored as HMAC-SHA256; rows are kept
+-- for 24h of send limits, only the newest code per user and phone is valid)
 CREATE TABLE IF NOT EXISTS phone_verifications (
     id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     user_id           UUID REFERENCES user_profiles(id),
-    phone             VARCHAR(20) NOT NULL,
-    verification_code VARCHAR(6) NOT NULL,
+    phone             VARCHAR(20) NOT NULL, -- E.164
+    country_code      VARCHAR(2),
+    channel           VARCHAR(10) NOT NULL DEFAULT 'sms' CHECK (channel IN ('sms', 'voice')),
+    code_hash         VARCHAR(64) NOT NULL,
     attempts          INTEGER DEFAULT 0,
     verified_at       TIMESTAMPTZ,
     expires_at        TIMESTAMPTZ NOT NULL,
+    locked_until      TIMESTAMPTZ, -- set after too many incorrect codes
     created_at        TIMESTAMPTZ DEFAULT NOW()
 );
 
+-- SMS and voice messages with provider delivery status (bodies are not stored)
+CREATE TABLE IF NOT EXISTS sms_messages (
+    id                  UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id             UUID REFERENCES user_profiles(id) ON DELETE SET NULL,
+    channel             VARCHAR(10) NOT NULL DEFAULT 'sms' CHECK (channel IN ('sms', 'voice')),
+    to_number           VARCHAR(20) NOT NULL, -- E.164
+    template            VARCHAR(50) NOT NULL,
+    locale              VARCHAR(10) NOT NULL DEFAULT 'en',
+    provider            VARCHAR(20), -- sns, twilio, file, http, log
+    provider_message_id VARCHAR(100),
+    status              VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'sent', 'delivered', 'undelivered', 'failed')),
+    provider_status     VARCHAR(30), -- raw status from the provider's callback
+    error_message       TEXT,
+    attempts            INTEGER NOT NULL DEFAULT 0, -- providers tried, including failovers
+    created_at          TIMESTAMPTZ DEFAULT NOW(),
+    updated_at          TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- TOTP two-factor authentication (secret encrypted, recovery codes hashed)
+CREATE TABLE IF NOT EXISTS user_two_factor (
+    user_id              UUID PRIMARY KEY REFERENCES user_profiles(id) ON DELETE CASCADE,
+    secret_ciphertext    TEXT NOT NULL,
+    enabled              BOOLEAN NOT NULL DEFAULT FALSE, -- set once a code confirms enrollment
+    enabled_at           TIMESTAMPTZ,
+    last_used_step       BIGINT NOT NULL DEFAULT 0, -- TOTP time step of the last accepted code
+    recovery_code_hashes TEXT[] NOT NULL DEFAULT '{}',
+    created_at           TIMESTAMPTZ DEFAULT NOW(),
+    updated_at           TIMESTAMPTZ DEFAULT NOW()
+);
+
 -- User sessions and activity tracking
 CREATE TABLE IF NOT EXISTS user_sessions (
     id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -55,7 +92,54 @@ CREATE TABLE IF NOT EXISTS user_sessions (
     created_at        TIMESTAMPTZ DEFAULT NOW(),
     expires_at        TIMESTAMPTZ,
     is_active         BOOLEAN DEFAULT TRUE,
-    last_activity_at  TIMESTAMPTZ DEFAULT NOW()
+    last_activity_at  TIMESTAMPTZ DEFAULT NOW(),
+    revoked_at        TIMESTAMPTZ -- set when signed out; tokens of the session are rejected
+);
+
+-- Organizations (teams sharing assessments and a credit pool)
+CREATE TABLE IF NOT EXISTS organizations (
+    id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name              VARCHAR(255) NOT NULL,
+    require_two_factor BOOLEAN NOT NULL DEFAULT FALSE, -- members must enable TOTP to act for the organization
+    created_by        UUID REFERENCES user_profiles(id) NOT NULL,
+    created_at        TIMESTAMPTZ DEFAULT NOW(),
+    updated_at        TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Organization membership
+CREATE TABLE IF NOT EXISTS organization_members (
+    org_id            UUID REFERENCES organizations(id) ON DELETE CASCADE NOT NULL,
+    user_id           UUID REFERENCES user_profiles(id) NOT NULL,
+    role              VARCHAR(20) NOT NULL DEFAULT 'analyst' CHECK (role IN ('owner', 'admin', 'reviewer', 'analyst', 'viewer', 'billing')),
+    invited_by        UUID REFERENCES user_profiles(id),
+    joined_at         TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+-- Per-organization role permissions (roles without a row use the built-in defaults;
+-- owners always hold every permission)
+CREATE TABLE IF NOT EXISTS organization_roles (
+    org_id            UUID REFERENCES organizations(id) ON DELETE CASCADE NOT NULL,
+    role              VARCHAR(20) NOT NULL CHECK (role IN ('admin', 'reviewer', 'analyst', 'viewer', 'billing')),
+    permissions       TEXT[] NOT NULL DEFAULT '{}',
+    updated_by        UUID REFERENCES user_profiles(id),
+    updated_at        TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (org_id, role)
+);
+
+-- Pending invitations (only the SHA-256 hash of the invite token is stored)
+CREATE TABLE IF NOT EXISTS organization_invites (
+    id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    org_id            UUID REFERENCES organizations(id) ON DELETE CASCADE NOT NULL,
+    email             VARCHAR(255) NOT NULL,
+    role              VARCHAR(20) NOT NULL DEFAULT 'analyst' CHECK (role IN ('owner', 'admin', 'reviewer', 'analyst', 'viewer', 'billing')),
+    token_hash        VARCHAR(64) UNIQUE NOT NULL,
+    invited_by        UUID REFERENCES user_profiles(id) NOT NULL,
+    expires_at        TIMESTAMPTZ NOT NULL,
+    accepted_at       TIMESTAMPTZ,
+    accepted_by       UUID REFERENCES user_profiles(id),
+    revoked_at        TIMESTAMPTZ,
+    created_at        TIMESTAMPTZ DEFAULT NOW()
 );
 
 -- =====================================================================
@@ -73,18 +157,20 @@ CREATE TABLE IF NOT EXISTS subscription_plans (
     yearly_price          DECIMAL(10,2),
     overage_price_per_credit DECIMAL(6,4) NOT NULL,
     features              JSONB,
+    requests_per_minute   INTEGER, -- API rate limit; NULL uses the built-in default for the plan
+    assessment_discount_percent INTEGER NOT NULL DEFAULT 0 CHECK (assessment_discount_percent BETWEEN 0 AND 100), -- off the credits of each assessment
     is_active             BOOLEAN DEFAULT TRUE,
     created_at            TIMESTAMPTZ DEFAULT NOW(),
     updated_at            TIMESTAMPTZ DEFAULT NOW()
 );
 
 -- Insert fixed plans (only if not exists)
-INSERT INTO subscription_plans (plan_name, plan_type, monthly_credits, yearly_credits, monthly_price, yearly_price, overage_price_per_credit, features) 
+INSERT INTO subscription_plans (plan_name, plan_type, monthly_credits, yearly_credits, monthly_price, yearly_price, overage_price_per_credit, features, requests_per_minute) 
 VALUES 
-('Free', 'free', 500, NULL, 0.00, NULL, 0.020, '["basic_reports"]'),
-('Startup', 'paid', 5000, 60000, 49.00, 539.00, 0.009, '["basic_reports", "api_access"]'),
-('Pro', 'paid', 15000, 180000, 149.00, 1639.00, 0.007, '["basic_reports", "api_access", "priority_support", "custom_integrations"]'),
-('Enterprise', 'paid', 50000, 600000, 499.00, 5490.00, 0.005, '["all_features", "dedicated_support", "custom_deployment"]')
+('Free', 'free', 500, NULL, 0.00, NULL, 0.020, '["basic_reports"]', 30),
+('Startup', 'paid', 5000, 60000, 49.00, 539.00, 0.009, '["basic_reports", "api_access"]', 120),
+('Pro', 'paid', 15000, 180000, 149.00, 1639.00, 0.007, '["basic_reports", "api_access", "priority_support", "custom_integrations"]', 300),
+('Enterprise', 'paid', 50000, 600000, 499.00, 5490.00, 0.005, '["all_features", "dedicated_support", "custom_deployment"]', 1000)
 ON CONFLICT (plan_name) DO NOTHING;
 
 -- User subscriptions
@@ -100,6 +186,17 @@ CREATE TABLE IF NOT EXISTS user_subscriptions (
     auto_renew            BOOLEAN DEFAULT TRUE,
     cancellation_reason   TEXT,
     cancelled_at          TIMESTAMPTZ,
+
+    -- Downgrade taking effect at the end of the paid period
+    scheduled_plan_id     INTEGER REFERENCES subscription_plans(id),
+    scheduled_billing_cycle VARCHAR(20),
+    scheduled_change_at   TIMESTAMPTZ,
+
+    -- Overage: when the credits run out, usage continues at the plan's
+    -- overage price up to a spending cap per credit period (NULL = no cap)
+    overage_enabled       BOOLEAN NOT NULL DEFAULT FALSE,
+    overage_cap_cents     INTEGER CHECK (overage_cap_cents >= 0),
+
     created_at            TIMESTAMPTZ DEFAULT NOW(),
     updated_at            TIMESTAMPTZ DEFAULT NOW()
 );
@@ -112,6 +209,7 @@ CREATE TABLE IF NOT EXISTS user_subscriptions (
 CREATE TABLE IF NOT EXISTS user_credits (
     id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     user_id               UUID REFERENCES user_profiles(id) UNIQUE,
+    org_id                UUID REFERENCES organizations(id) UNIQUE, -- set on organization credit pools (user_id is NULL)
     
     -- Subscription credits
     monthly_allocation    INTEGER DEFAULT 0,
@@ -120,9 +218,13 @@ CREATE TABLE IF NOT EXISTS user_credits (
     -- Pay-as-you-go credits
     recharged_credits     INTEGER DEFAULT 0,
     bonus_credits         INTEGER DEFAULT 0,
+    overage_credits       INTEGER DEFAULT 0, -- granted beyond the balance this credit period, billed as overage
     
     -- Usage tracking
     used_credits          INTEGER DEFAULT 0,
+    reserved_credits      INTEGER DEFAULT 0, -- held for queued assessments until captured or released
+    total_credits         INTEGER GENERATED ALWAYS AS (subscription_credits + recharged_credits + bonus_credits + overage_credits) STORED,
+    available_credits     INTEGER GENERATED ALWAYS AS (subscription_credits + recharged_credits + bonus_credits + overage_credits - used_credits - reserved_credits) STORED,
     
     -- Reset tracking
     last_reset_date       TIMESTAMPTZ,
@@ -153,22 +255,165 @@ VALUES
 ('Bulk Pack', 5000, 60.00, 40, FALSE)
 ON CONFLICT DO NOTHING;
 
--- Detailed credit transaction history
+-- Assessment pricing catalog: the credits of each assessment type and of the
+-- optional checks not included in it
+CREATE TABLE IF NOT EXISTS assessment_pricing (
+    product               VARCHAR(30) NOT NULL CHECK (product IN ('website_risk', 'business_risk')),
+    item_key              VARCHAR(50) NOT NULL,
+    item_type             VARCHAR(20) NOT NULL CHECK (item_type IN ('assessment', 'check')),
+    name                  VARCHAR(100) NOT NULL,
+    credits               INTEGER NOT NULL CHECK (credits >= 0),
+    included_checks       TEXT[] NOT NULL DEFAULT '{}', -- checks an assessment type runs at no extra cost
+    is_active             BOOLEAN DEFAULT TRUE,
+    created_at            TIMESTAMPTZ DEFAULT NOW(),
+    updated_at            TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (product, item_key)
+);
+
+-- Insert the default prices (only if not exists)
+INSERT INTO assessment_pricing (product, item_key, item_type, name, credits, included_checks)
+VALUES
+('website_risk', 'quick', 'assessment', 'Quick Scan', 1, '{mcc_classification,reputation_lookup}'),
+('website_risk', 'comprehensive', 'assessment', 'Comprehensive', 3, '{mcc_classification,reputation_lookup}'),
+('website_risk', 'mcc_classification', 'check', 'AI MCC classification', 1, '{}'),
+('website_risk', 'reputation_lookup', 'check', 'Reputation lookups', 1, '{}'),
+('business_risk', 'quick', 'assessment', 'Quick Scan', 1, '{}'),
+('business_risk', 'comprehensive', 'assessment', 'Comprehensive', 3, '{mcc_classification,reputation_lookup}'),
+('business_risk', 'mcc_classification', 'check', 'AI MCC classification', 1, '{}'),
+('business_risk', 'reputation_lookup', 'check', 'Reputation lookups', 1, '{}')
+ON CONFLICT (product, item_key) DO NOTHING;
+
+-- Credits held for queued work. A reservation is captured (charged) as its
+-- assessments complete and released (returned) when they fail or are cancelled.
+CREATE TABLE IF NOT EXISTS credit_reservations (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    wallet_id             UUID REFERENCES user_credits(id) NOT NULL,
+    user_id               UUID REFERENCES user_profiles(id), -- acting user
+    org_id                UUID REFERENCES organizations(id), -- set when reserved from an organization pool
+    credits               INTEGER NOT NULL CHECK (credits > 0),
+    captured_credits      INTEGER NOT NULL DEFAULT 0,
+    released_credits      INTEGER NOT NULL DEFAULT 0,
+    status                VARCHAR(20) NOT NULL DEFAULT 'reserved', -- reserved, captured, released, settled (partly both)
+    idempotency_key       VARCHAR(255) UNIQUE,
+    description           TEXT,
+    expires_at            TIMESTAMPTZ NOT NULL DEFAULT NOW() + INTERVAL '1 day', -- unsettled credits are released after this
+    created_at            TIMESTAMPTZ DEFAULT NOW(),
+    updated_at            TIMESTAMPTZ DEFAULT NOW(),
+    CHECK (captured_credits + released_credits <= credits)
+);
+
+-- Detailed credit transaction history: one row per movement of credits
 CREATE TABLE IF NOT EXISTS credit_transactions (
     id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     user_id               UUID REFERENCES user_profiles(id),
-    transaction_type      VARCHAR(30) NOT NULL, -- subscription_allocation, recharge_purchase, assessment_usage, refund, bonus, monthly_reset
+    org_id                UUID REFERENCES organizations(id), -- organization pool the transaction applies to
+    transaction_type      VARCHAR(30) NOT NULL, -- subscription_allocation, recharge_purchase, assessment_usage, credit_reservation, reservation_release, refund, bonus, monthly_reset, subscription_proration, overage_accrual, overage_reversal, organization_transfer, bonus_revocation
     credit_change         INTEGER NOT NULL, -- positive for add, negative for deduct
+    reserved_change       INTEGER NOT NULL DEFAULT 0, -- change of the credits held by reservations
     balance_before        INTEGER NOT NULL,
     balance_after         INTEGER NOT NULL,
     description           TEXT,
+    idempotency_key       VARCHAR(255) UNIQUE, -- repeated calls with the same key apply once
     
     -- Related records
     assessment_id         BIGINT, -- will reference assessments(id)
+    reservation_id        UUID REFERENCES credit_reservations(id),
     metadata              JSONB,
     created_at            TIMESTAMPTZ DEFAULT NOW()
 );
 
+-- Double-entry ledger: every credit transaction posts entries that sum to
+-- zero. Wallet accounts are available, reserved and consumed; external
+-- (grants and purchases) and transfer (between wallets) have no wallet.
+CREATE TABLE IF NOT EXISTS credit_ledger_entries (
+    id                    BIGSERIAL PRIMARY KEY,
+    transaction_id        UUID REFERENCES credit_transactions(id) NOT NULL,
+    wallet_id             UUID REFERENCES user_credits(id),
+    account               VARCHAR(20) NOT NULL, -- available, reserved, consumed, external, transfer
+    amount                INTEGER NOT NULL,
+    created_at            TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Credit package purchases and paid plan upgrades: a checkout with the
+-- payment provider and, once paid, the receipt of what was granted
+CREATE TABLE IF NOT EXISTS credit_purchases (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id               UUID REFERENCES user_profiles(id) NOT NULL, -- purchasing user
+    org_id                UUID REFERENCES organizations(id), -- credited organization pool (NULL = personal wallet)
+    package_id            INTEGER REFERENCES credit_packages(id), -- NULL for plan upgrades
+    plan_id               INTEGER REFERENCES subscription_plans(id), -- plan upgrades: the new plan
+    billing_cycle         VARCHAR(20), -- plan upgrades: the new billing cycle
+    credits               INTEGER NOT NULL CHECK (credits >= 0), -- plan upgrades: prorated credits
+    amount_cents          INTEGER NOT NULL,
+    currency              VARCHAR(3) NOT NULL DEFAULT 'usd',
+    provider              VARCHAR(20) NOT NULL, -- stripe, fake
+    provider_session_id   VARCHAR(255),
+    provider_payment_id   VARCHAR(255),
+    provider_customer_id  VARCHAR(255), -- customer whose saved payment method auto-recharge charges
+    source                VARCHAR(20) NOT NULL DEFAULT 'checkout', -- checkout, auto_recharge, plan_upgrade
+    status                VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, completed, failed, expired
+    checkout_url          TEXT,
+    receipt_number        VARCHAR(40) UNIQUE,
+    transaction_id        UUID REFERENCES credit_transactions(id), -- recharge_purchase or subscription_proration transaction
+    created_at            TIMESTAMPTZ DEFAULT NOW(),
+    updated_at            TIMESTAMPTZ DEFAULT NOW(),
+    completed_at          TIMESTAMPTZ,
+    UNIQUE(provider, provider_session_id),
+    CHECK ((source = 'plan_upgrade') = (package_id IS NULL AND plan_id IS NOT NULL AND billing_cycle IS NOT NULL))
+);
+
+-- Monthly invoices of a wallet: subscription charges, credit packages
+-- (already paid at checkout) and overage. One invoice per wallet and month.
+CREATE SEQUENCE IF NOT EXISTS invoice_number_seq;
+CREATE TABLE IF NOT EXISTS invoices (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    invoice_number        VARCHAR(40) UNIQUE NOT NULL,
+    wallet_id             UUID REFERENCES user_credits(id) NOT NULL,
+    user_id               UUID REFERENCES user_profiles(id), -- set on personal invoices
+    org_id                UUID REFERENCES organizations(id), -- set on organization invoices
+    period_start          TIMESTAMPTZ NOT NULL,
+    period_end            TIMESTAMPTZ NOT NULL,
+    currency              VARCHAR(3) NOT NULL DEFAULT 'usd',
+    line_items            JSONB NOT NULL DEFAULT '[]', -- [{"type", "description", "quantity", "unit_amount_cents", "amount_cents"}]
+    total_cents           INTEGER NOT NULL,
+    amount_paid_cents     INTEGER NOT NULL DEFAULT 0, -- credit packages paid at checkout
+    amount_due_cents      INTEGER NOT NULL,
+    status                VARCHAR(20) NOT NULL DEFAULT 'open', -- open, paid
+    created_at            TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE(wallet_id, period_start)
+);
+
+-- Payment provider webhook events received
+CREATE TABLE IF NOT EXISTS payment_events (
+    provider              VARCHAR(20) NOT NULL,
+    event_id              VARCHAR(255) NOT NULL,
+    event_type            VARCHAR(100),
+    purchase_id           UUID REFERENCES credit_purchases(id),
+    payload               JSONB,
+    received_at           TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (provider, event_id)
+);
+
+-- Low-balance alerts of a wallet: when the available credits fall to the
+-- threshold the rule emails and/or publishes a webhook, and optionally buys a
+-- credit package, at most once per credit period
+CREATE TABLE IF NOT EXISTS credit_alert_rules (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id               UUID REFERENCES user_profiles(id) NOT NULL, -- owner of a personal wallet, creator in an organization
+    org_id                UUID REFERENCES organizations(id), -- organization pool (NULL = personal wallet)
+    threshold_type        VARCHAR(10) NOT NULL CHECK (threshold_type IN ('credits', 'percent')), -- percent of the monthly allocation
+    threshold             INTEGER NOT NULL CHECK (threshold >= 0),
+    notify_email          BOOLEAN NOT NULL DEFAULT TRUE,
+    email                 VARCHAR(255), -- recipient; the rule's user when NULL
+    notify_webhook        BOOLEAN NOT NULL DEFAULT TRUE,
+    auto_recharge_package_id INTEGER REFERENCES credit_packages(id), -- package bought when triggered (NULL = no auto-recharge)
+    is_active             BOOLEAN NOT NULL DEFAULT TRUE,
+    last_triggered_at     TIMESTAMPTZ,
+    created_at            TIMESTAMPTZ DEFAULT NOW(),
+    updated_at            TIMESTAMPTZ DEFAULT NOW(),
+    CHECK (threshold_type <> 'percent' OR threshold <= 100)
+);
+
 -- =====================================================================
 -- 4. MULTI-TENANT ASSESSMENTS (User Isolation)
 -- =====================================================================
@@ -177,6 +422,7 @@ CREATE TABLE IF NOT EXISTS credit_transactions (
 CREATE TABLE IF NOT EXISTS assessments (
     id BIGSERIAL PRIMARY KEY,
     user_id               UUID REFERENCES user_profiles(id) NOT NULL, -- CRITICAL: User isolation
+    org_id                UUID REFERENCES organizations(id), -- owning organization (NULL = personal)
     created_at            TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at            TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     
@@ -187,6 +433,7 @@ CREATE TABLE IF NOT EXISTS assessments (
     
     -- Credit tracking
     credits_consumed      INTEGER DEFAULT 1,
+    credit_reservation_id UUID REFERENCES credit_reservations(id), -- captured on completion, released on failure
     assessment_cost       DECIMAL(5,4),
     assessment_type       VARCHAR(20) DEFAULT 'comprehensive', -- quick, comprehensive
     
@@ -208,24 +455,159 @@ CREATE TABLE IF NOT EXISTS assessments (
     updated_by            TEXT DEFAULT 'system'
 );
 
+-- Batch submissions (CSV/JSON uploads of merchant lists)
+CREATE TABLE IF NOT EXISTS assessment_batches (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id               UUID REFERENCES user_profiles(id) NOT NULL,
+    org_id                UUID REFERENCES organizations(id), -- owning organization (NULL = personal)
+    status                VARCHAR(20) NOT NULL DEFAULT 'queued', -- queued, processing, completed, cancelled
+    source                VARCHAR(10) NOT NULL DEFAULT 'csv', -- csv, json
+    total_rows            INTEGER NOT NULL DEFAULT 0,
+    valid_rows            INTEGER NOT NULL DEFAULT 0,
+    invalid_rows          INTEGER NOT NULL DEFAULT 0,
+    credits_reserved      INTEGER NOT NULL DEFAULT 0,
+    credit_reservation_id UUID REFERENCES credit_reservations(id),
+    rows                  JSONB NOT NULL DEFAULT '[]', -- submitted rows, validation errors and linked assessment ids
+    created_at            TIMESTAMPTZ DEFAULT NOW(),
+    updated_at            TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Outbound webhook endpoints (assessment lifecycle events)
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id               UUID REFERENCES user_profiles(id) NOT NULL,
+    org_id                UUID REFERENCES organizations(id) ON DELETE CASCADE, -- owning organization (NULL = personal)
+    url                   TEXT NOT NULL,
+    description           TEXT DEFAULT '',
+    secret                VARCHAR(100) NOT NULL, -- HMAC-SHA256 signing secret
+    event_types           JSONB NOT NULL DEFAULT '[]', -- e.g. ["assessment.completed"] or ["*"]
+    is_active             BOOLEAN DEFAULT true,
+    last_sent_at          TIMESTAMPTZ,
+    created_at            TIMESTAMPTZ DEFAULT NOW(),
+    updated_at            TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Webhook delivery log (one row per delivery, updated on every attempt)
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    endpoint_id           UUID REFERENCES webhook_endpoints(id) ON DELETE CASCADE NOT NULL,
+    user_id               UUID REFERENCES user_profiles(id) NOT NULL,
+    event_id              UUID NOT NULL,
+    event_type            VARCHAR(100) NOT NULL,
+    payload               JSONB NOT NULL,
+    status                VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, retrying, succeeded, failed
+    attempts              INTEGER NOT NULL DEFAULT 0,
+    response_status       INTEGER,
+    error_message         TEXT,
+    next_attempt_at       TIMESTAMPTZ,
+    delivered_at          TIMESTAMPTZ,
+    redelivery_of         UUID REFERENCES webhook_deliveries(id) ON DELETE SET NULL,
+    created_at            TIMESTAMPTZ DEFAULT NOW(),
+    updated_at            TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Notification routing rules (email, Slack, Microsoft Teams)
+CREATE TABLE IF NOT EXISTS notification_rules (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id               UUID REFERENCES user_profiles(id) NOT NULL,
+    name                  VARCHAR(100) NOT NULL,
+    channel               VARCHAR(20) NOT NULL CHECK (channel IN ('email', 'slack', 'teams')),
+    target                TEXT NOT NULL, -- email address or incoming webhook URL
+    event_types           JSONB NOT NULL DEFAULT '[]', -- empty matches every event
+    risk_categories       JSONB NOT NULL DEFAULT '[]', -- empty matches every risk category
+    delivery_mode         VARCHAR(20) NOT NULL DEFAULT 'immediate' CHECK (delivery_mode IN ('immediate', 'digest')),
+    digest_interval       VARCHAR(20) NOT NULL DEFAULT 'daily' CHECK (digest_interval IN ('hourly', 'daily')),
+    is_active             BOOLEAN DEFAULT true,
+    last_digest_at        TIMESTAMPTZ,
+    created_at            TIMESTAMPTZ DEFAULT NOW(),
+    updated_at            TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Events queued for digest notification rules
+CREATE TABLE IF NOT EXISTS notification_digest_items (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    rule_id               UUID REFERENCES notification_rules(id) ON DELETE CASCADE NOT NULL,
+    user_id               UUID REFERENCES user_profiles(id) NOT NULL,
+    event                 JSONB NOT NULL,
+    created_at            TIMESTAMPTZ DEFAULT NOW(),
+    sent_at               TIMESTAMPTZ
+);
+
+-- API keys for machine-to-machine access (only the SHA-256 hash is stored)
+CREATE TABLE IF NOT EXISTS api_keys (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id               UUID REFERENCES user_profiles(id) NOT NULL, -- creator
+    org_id                UUID REFERENCES organizations(id), -- organization keys act in this organization
+    name                  VARCHAR(100) NOT NULL,
+    prefix                VARCHAR(20) UNIQUE NOT NULL, -- public identifier, e.g. qf_3f9a1c7be210
+    key_hash              VARCHAR(64) NOT NULL, -- hex SHA-256 of the full key
+    scopes                JSONB NOT NULL DEFAULT '[]', -- route groups, e.g. ["website_risk", "webhooks"]
+    expires_at            TIMESTAMPTZ,
+    last_used_at          TIMESTAMPTZ,
+    last_used_ip          VARCHAR(45),
+    revoked_at            TIMESTAMPTZ,
+    created_at            TIMESTAMPTZ DEFAULT NOW()
+);
+
 -- =====================================================================
 -- 5. USER ACTIVITY & ANALYTICS
 -- =====================================================================
 
--- User activity logging
+-- User activity logging (audit log). Entries form a hash chain per
+-- organization, or per user for personal activity; see chain_activity_log.
 CREATE TABLE IF NOT EXISTS user_activity_logs (
     id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     user_id               UUID REFERENCES user_profiles(id),
+    org_id                UUID REFERENCES organizations(id), -- NULL for personal activity
     session_id            UUID REFERENCES user_sessions(id),
-    action                VARCHAR(100) NOT NULL, -- login, assessment_created, report_viewed, credit_purchased
-    resource_type         VARCHAR(50), -- assessment, report, configuration, payment
+    action                VARCHAR(100) NOT NULL, -- auth.login, assessment.created, assessment.exported, credits.consumed
+    resource_type         VARCHAR(50), -- assessment, assessment_batch, session, api_key
     resource_id           VARCHAR(255),
     credits_consumed      INTEGER DEFAULT 0,
     metadata              JSONB,
     ip_address            INET,
     user_agent            TEXT,
     duration_seconds      INTEGER,
-    created_at            TIMESTAMPTZ DEFAULT NOW()
+    created_at            TIMESTAMPTZ DEFAULT NOW(),
+    chain_key             TEXT NOT NULL, -- org_id, else user_id
+    sequence_number       BIGINT NOT NULL,
+    payload_hash          CHAR(64) NOT NULL, -- SHA-256 of the canonical entry, computed by the API
+    prev_hash             CHAR(64), -- entry_hash of the previous entry in the chain
+    entry_hash            CHAR(64) NOT NULL, -- SHA-256 of prev_hash || payload_hash
+    UNIQUE(chain_key, sequence_number)
+);
+
+-- Background jobs of the API scheduler. An instance runs a job only after
+-- claiming it, so each run happens once however many replicas are up.
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    job_name              VARCHAR(100) PRIMARY KEY,
+    locked_by             VARCHAR(255), -- instance that claimed the current run
+    locked_until          TIMESTAMPTZ, -- no other instance runs the job before this
+    last_started_at       TIMESTAMPTZ,
+    last_finished_at      TIMESTAMPTZ,
+    last_result           JSONB,
+    last_error            TEXT
+);
+
+-- Responses to mutating requests sent with an Idempotency-Key, replayed when
+-- the user retries with the same key until the key expires
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id               UUID REFERENCES user_profiles(id) ON DELETE CASCADE NOT NULL,
+    idempotency_key       VARCHAR(255) NOT NULL,
+    method                VARCHAR(10) NOT NULL,
+    path                  TEXT NOT NULL,
+    request_hash          CHAR(64) NOT NULL, -- SHA-256 of method, path, workspace and body
+    status                VARCHAR(20) NOT NULL DEFAULT 'processing' CHECK (status IN ('processing', 'completed')),
+    claim_id              UUID NOT NULL, -- request processing the key
+    locked_until          TIMESTAMPTZ NOT NULL, -- a key still processing may be claimed again afterwards
+    response_status       INTEGER,
+    response_headers      JSONB,
+    response_body         TEXT, -- base64
+    created_at            TIMESTAMPTZ DEFAULT NOW(),
+    completed_at          TIMESTAMPTZ,
+    expires_at            TIMESTAMPTZ NOT NULL,
+    UNIQUE(user_id, idempotency_key)
 );
 
 -- =====================================================================
@@ -242,11 +624,18 @@ CREATE INDEX IF NOT EXISTS idx_user_profiles_onboarding ON user_profiles(onboard
 CREATE INDEX IF NOT EXISTS idx_phone_verifications_user ON phone_verifications(user_id);
 CREATE INDEX IF NOT EXISTS idx_phone_verifications_phone ON phone_verifications(phone);
 CREATE INDEX IF NOT EXISTS idx_phone_verifications_expires ON phone_verifications(expires_at);
+CREATE INDEX IF NOT EXISTS idx_phone_verifications_user_created ON phone_verifications(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_phone_verifications_phone_created ON phone_verifications(phone, created_at DESC);
+
+-- SMS delivery indexes
+CREATE INDEX IF NOT EXISTS idx_sms_messages_user ON sms_messages(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_sms_messages_provider_id ON sms_messages(provider, provider_message_id);
 
 -- User sessions indexes
 CREATE INDEX IF NOT EXISTS idx_user_sessions_user ON user_sessions(user_id);
 CREATE INDEX IF NOT EXISTS idx_user_sessions_active ON user_sessions(is_active);
 CREATE INDEX IF NOT EXISTS idx_user_sessions_created ON user_sessions(created_at);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user_activity ON user_sessions(user_id, last_activity_at DESC) WHERE is_active;
 
 -- Subscription indexes
 CREATE INDEX IF NOT EXISTS idx_user_subscriptions_user ON user_subscriptions(user_id);
@@ -255,8 +644,23 @@ CREATE INDEX IF NOT EXISTS idx_user_subscriptions_billing ON user_subscriptions(
 
 -- Credit indexes
 CREATE INDEX IF NOT EXISTS idx_user_credits_user ON user_credits(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_credits_next_reset ON user_credits(next_reset_date);
 CREATE INDEX IF NOT EXISTS idx_credit_transactions_user ON credit_transactions(user_id);
 CREATE INDEX IF NOT EXISTS idx_credit_transactions_type ON credit_transactions(transaction_type);
+CREATE INDEX IF NOT EXISTS idx_credit_transactions_reservation ON credit_transactions(reservation_id) WHERE reservation_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_credit_reservations_open ON credit_reservations(expires_at) WHERE status = 'reserved';
+CREATE INDEX IF NOT EXISTS idx_credit_ledger_entries_wallet ON credit_ledger_entries(wallet_id, account);
+CREATE INDEX IF NOT EXISTS idx_credit_ledger_entries_transaction ON credit_ledger_entries(transaction_id);
+CREATE INDEX IF NOT EXISTS idx_credit_purchases_user_created ON credit_purchases(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_credit_purchases_org_created ON credit_purchases(org_id, created_at DESC) WHERE org_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_credit_transactions_user_created ON credit_transactions(user_id, created_at) WHERE org_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_credit_transactions_org_created ON credit_transactions(org_id, created_at) WHERE org_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_invoices_user_period ON invoices(user_id, period_start DESC) WHERE org_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_invoices_org_period ON invoices(org_id, period_start DESC) WHERE org_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_credit_purchases_customer ON credit_purchases(user_id, org_id, provider, completed_at DESC) WHERE provider_customer_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_credit_alert_rules_user ON credit_alert_rules(user_id) WHERE org_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_credit_alert_rules_org ON credit_alert_rules(org_id) WHERE org_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys(expires_at);
 
 -- CRITICAL: Multi-tenant assessment indexes
 CREATE INDEX IF NOT EXISTS idx_assessments_user_id ON assessments(user_id);
@@ -275,10 +679,34 @@ CREATE INDEX IF NOT EXISTS idx_assessments_risk_score ON assessments(risk_score)
 -- JSON indexes
 CREATE INDEX IF NOT EXISTS idx_assessments_data_gin ON assessments USING GIN (assessment_data);
 
+-- Organization indexes
+CREATE INDEX IF NOT EXISTS idx_organization_members_user ON organization_members(user_id);
+CREATE INDEX IF NOT EXISTS idx_organization_invites_pending ON organization_invites(org_id) WHERE accepted_at IS NULL AND revoked_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_assessments_org_created ON assessments(org_id, created_at DESC) WHERE org_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_assessment_batches_org_created ON assessment_batches(org_id, created_at DESC) WHERE org_id IS NOT NULL;
+
+-- Batch indexes
+CREATE INDEX IF NOT EXISTS idx_assessment_batches_user_created ON assessment_batches(user_id, created_at DESC);
+
+-- Webhook indexes
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_user ON webhook_endpoints(user_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_org ON webhook_endpoints(org_id) WHERE org_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint_created ON webhook_deliveries(endpoint_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status IN ('pending', 'retrying');
+
+-- Notification indexes
+CREATE INDEX IF NOT EXISTS idx_notification_rules_user ON notification_rules(user_id);
+CREATE INDEX IF NOT EXISTS idx_notification_digest_items_pending ON notification_digest_items(rule_id, created_at) WHERE sent_at IS NULL;
+
+-- API key indexes
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+
 -- Activity log indexes
 CREATE INDEX IF NOT EXISTS idx_activity_logs_user ON user_activity_logs(user_id);
 CREATE INDEX IF NOT EXISTS idx_activity_logs_action ON user_activity_logs(action);
 CREATE INDEX IF NOT EXISTS idx_activity_logs_created ON user_activity_logs(created_at);
+CREATE INDEX IF NOT EXISTS idx_activity_logs_org_created ON user_activity_logs(org_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_activity_logs_resource ON user_activity_logs(resource_type, resource_id);
 
 -- =====================================================================
 -- 7. ROW LEVEL SECURITY (RLS) - Critical for Multi-Tenant
@@ -288,11 +716,33 @@ CREATE INDEX IF NOT EXISTS idx_activity_logs_created ON user_activity_logs(creat
 ALTER TABLE assessments ENABLE ROW LEVEL SECURITY;
 ALTER TABLE user_credits ENABLE ROW LEVEL SECURITY;
 ALTER TABLE credit_transactions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE credit_reservations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE credit_ledger_entries ENABLE ROW LEVEL SECURITY;
 ALTER TABLE user_activity_logs ENABLE ROW LEVEL SECURITY;
+ALTER TABLE assessment_batches ENABLE ROW LEVEL SECURITY;
+ALTER TABLE webhook_endpoints ENABLE ROW LEVEL SECURITY;
+ALTER TABLE webhook_deliveries ENABLE ROW LEVEL SECURITY;
+ALTER TABLE notification_rules ENABLE ROW LEVEL SECURITY;
+ALTER TABLE notification_digest_items ENABLE ROW LEVEL SECURITY;
+ALTER TABLE api_keys ENABLE ROW LEVEL SECURITY;
+ALTER TABLE organizations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE organization_members ENABLE ROW LEVEL SECURITY;
+ALTER TABLE organization_invites ENABLE ROW LEVEL SECURITY;
+ALTER TABLE organization_roles ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sms_messages ENABLE ROW LEVEL SECURITY;
+ALTER TABLE user_two_factor ENABLE ROW LEVEL SECURITY;
+ALTER TABLE user_sessions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE credit_purchases ENABLE ROW LEVEL SECURITY;
+ALTER TABLE invoices ENABLE ROW LEVEL SECURITY;
+ALTER TABLE credit_alert_rules ENABLE ROW LEVEL SECURITY;
+ALTER TABLE payment_events ENABLE ROW LEVEL SECURITY; -- no policies: service role only
+ALTER TABLE scheduled_jobs ENABLE ROW LEVEL SECURITY; -- no policies: service role only
+ALTER TABLE idempotency_keys ENABLE ROW LEVEL SECURITY; -- no policies: service role only
 
 -- RLS Policies for data isolation
 CREATE POLICY IF NOT EXISTS assessments_user_isolation ON assessments
-    USING (user_id = auth.uid());
+    USING (user_id = auth.uid() AND org_id IS NULL
+        OR org_id IN (SELECT org_id FROM organization_members WHERE user_id = auth.uid()));
 
 CREATE POLICY IF NOT EXISTS user_credits_isolation ON user_credits
     USING (user_id = auth.uid());
@@ -300,7 +750,68 @@ CREATE POLICY IF NOT EXISTS user_credits_isolation ON user_credits
 CREATE POLICY IF NOT EXISTS credit_transactions_isolation ON credit_transactions
     USING (user_id = auth.uid());
 
+CREATE POLICY IF NOT EXISTS credit_reservations_isolation ON credit_reservations
+    USING (user_id = auth.uid() AND org_id IS NULL
+        OR org_id IN (SELECT org_id FROM organization_members WHERE user_id = auth.uid()));
+
+CREATE POLICY IF NOT EXISTS credit_ledger_entries_isolation ON credit_ledger_entries
+    USING (wallet_id IN (SELECT id FROM user_credits WHERE user_id = auth.uid()
+        OR org_id IN (SELECT org_id FROM organization_members WHERE user_id = auth.uid())));
+
+CREATE POLICY IF NOT EXISTS credit_purchases_isolation ON credit_purchases
+    USING (user_id = auth.uid() AND org_id IS NULL
+        OR org_id IN (SELECT org_id FROM organization_members WHERE user_id = auth.uid()));
+
+CREATE POLICY IF NOT EXISTS invoices_isolation ON invoices
+    USING (user_id = auth.uid() AND org_id IS NULL
+        OR org_id IN (SELECT org_id FROM organization_members WHERE user_id = auth.uid()));
+
+CREATE POLICY IF NOT EXISTS credit_alert_rules_isolation ON credit_alert_rules
+    USING (user_id = auth.uid() AND org_id IS NULL
+        OR org_id IN (SELECT org_id FROM organization_members WHERE user_id = auth.uid()));
+
 CREATE POLICY IF NOT EXISTS activity_logs_isolation ON user_activity_logs
+    USING (user_id = auth.uid() AND org_id IS NULL
+        OR org_id IN (SELECT org_id FROM organization_members WHERE user_id = auth.uid()));
+
+CREATE POLICY IF NOT EXISTS assessment_batches_isolation ON assessment_batches
+    USING (user_id = auth.uid() AND org_id IS NULL
+        OR org_id IN (SELECT org_id FROM organization_members WHERE user_id = auth.uid()));
+
+CREATE POLICY IF NOT EXISTS webhook_endpoints_isolation ON webhook_endpoints
+    USING (user_id = auth.uid());
+
+CREATE POLICY IF NOT EXISTS webhook_deliveries_isolation ON webhook_deliveries
+    USING (user_id = auth.uid());
+
+CREATE POLICY IF NOT EXISTS notification_rules_isolation ON notification_rules
+    USING (user_id = auth.uid());
+
+CREATE POLICY IF NOT EXISTS notification_digest_items_isolation ON notification_digest_items
+    USING (user_id = auth.uid());
+
+CREATE POLICY IF NOT EXISTS api_keys_isolation ON api_keys
+    USING (user_id = auth.uid());
+
+CREATE POLICY IF NOT EXISTS organizations_member_access ON organizations
+    USING (id IN (SELECT org_id FROM organization_members WHERE user_id = auth.uid()));
+
+CREATE POLICY IF NOT EXISTS organization_members_isolation ON organization_members
+    USING (user_id = auth.uid());
+
+CREATE POLICY IF NOT EXISTS organization_invites_isolation ON organization_invites
+    USING (invited_by = auth.uid());
+
+CREATE POLICY IF NOT EXISTS organization_roles_member_access ON organization_roles
+    USING (org_id IN (SELECT org_id FROM organization_members WHERE user_id = auth.uid()));
+
+CREATE POLICY IF NOT EXISTS sms_messages_isolation ON sms_messages
+    USING (user_id = auth.uid());
+
+CREATE POLICY IF NOT EXISTS user_two_factor_isolation ON user_two_factor
+    USING (user_id = auth.uid());
+
+CREATE POLICY IF NOT EXISTS user_sessions_isolation ON user_sessions
     USING (user_id = auth.uid());
 
 -- =====================================================================
@@ -332,6 +843,1443 @@ CREATE TRIGGER IF NOT EXISTS update_user_credits_updated_at
     FOR EACH ROW 
     EXECUTE FUNCTION update_updated_at_column();
 
+-- Audit log hash chain: each entry's hash covers the previous entry of its
+-- chain, so changing or removing an entry breaks every later hash. The lock
+-- serializes inserts per chain across API instances.
+CREATE OR REPLACE FUNCTION chain_activity_log()
+RETURNS TRIGGER AS $$
+DECLARE
+    previous RECORD;
+BEGIN
+    NEW.chain_key := COALESCE(NEW.org_id::TEXT, NEW.user_id::TEXT, 'system');
+    PERFORM pg_advisory_xact_lock(hashtext('user_activity_logs:' || NEW.chain_key));
+
+    SELECT sequence_number, entry_hash INTO previous
+    FROM user_activity_logs
+    WHERE chain_key = NEW.chain_key
+    ORDER BY sequence_number DESC
+    LIMIT 1;
+
+    NEW.sequence_number := COALESCE(previous.sequence_number, 0) + 1;
+    NEW.prev_hash := previous.entry_hash;
+    NEW.entry_hash := encode(sha256(convert_to(COALESCE(previous.entry_hash, '') || NEW.payload_hash, 'UTF8')), 'hex');
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER IF NOT EXISTS chain_user_activity_logs
+    BEFORE INSERT ON user_activity_logs
+    FOR EACH ROW
+    EXECUTE FUNCTION chain_activity_log();
+
+-- Audit log entries are append-only
+CREATE OR REPLACE FUNCTION prevent_activity_log_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'user_activity_logs is append-only';
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER IF NOT EXISTS protect_user_activity_logs
+    BEFORE UPDATE OR DELETE ON user_activity_logs
+    FOR EACH ROW
+    EXECUTE FUNCTION prevent_activity_log_changes();
+
+-- Credit ledger. Balances change only through these functions: each locks
+-- the wallet row, so concurrent requests cannot spend the same credits, and
+-- posts a credit_transactions row with credit_ledger_entries summing to zero.
+-- A wallet's balance is total minus used credits; reserved credits are part
+-- of the balance but not available. Calls repeating an idempotency key
+-- return the earlier result without moving credits again.
+
+-- Locks and returns the wallet of a user (p_org_id NULL) or an organization
+CREATE OR REPLACE FUNCTION lock_credit_wallet(p_user_id UUID, p_org_id UUID)
+RETURNS user_credits AS $$
+DECLARE
+    v_wallet user_credits;
+BEGIN
+    IF p_org_id IS NULL THEN
+        SELECT * INTO v_wallet FROM user_credits WHERE user_id = p_user_id FOR UPDATE;
+    ELSE
+        SELECT * INTO v_wallet FROM user_credits WHERE org_id = p_org_id FOR UPDATE;
+    END IF;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'user credits not found' USING ERRCODE = 'P0002';
+    END IF;
+    RETURN v_wallet;
+END;
+$$ language 'plpgsql';
+
+CREATE OR REPLACE FUNCTION credit_wallet_balance(p_wallet user_credits)
+RETURNS INTEGER AS $$
+    SELECT COALESCE(p_wallet.subscription_credits, 0) + COALESCE(p_wallet.recharged_credits, 0)
+        + COALESCE(p_wallet.bonus_credits, 0) + COALESCE(p_wallet.overage_credits, 0)
+        - COALESCE(p_wallet.used_credits, 0);
+$$ language 'sql' IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION credit_wallet_available(p_wallet user_credits)
+RETURNS INTEGER AS $$
+    SELECT credit_wallet_balance(p_wallet) - COALESCE(p_wallet.reserved_credits, 0);
+$$ language 'sql' IMMUTABLE;
+
+-- Records a movement of credits. p_entries is a JSON array of
+-- {"wallet_id", "account", "amount"} that must sum to zero.
+CREATE OR REPLACE FUNCTION post_credit_transaction(
+    p_wallet user_credits,
+    p_user_id UUID,
+    p_transaction_type TEXT,
+    p_credit_change INTEGER,
+    p_reserved_change INTEGER,
+    p_description TEXT,
+    p_idempotency_key TEXT,
+    p_assessment_id BIGINT,
+    p_reservation_id UUID,
+    p_entries JSONB
+)
+RETURNS credit_transactions AS $$
+DECLARE
+    v_transaction credit_transactions;
+BEGIN
+    IF (SELECT COALESCE(SUM((e->>'amount')::INTEGER), 0) FROM jsonb_array_elements(p_entries) e) <> 0 THEN
+        RAISE EXCEPTION 'unbalanced credit transaction %', p_entries;
+    END IF;
+
+    INSERT INTO credit_transactions (user_id, org_id, transaction_type, credit_change, reserved_change,
+        balance_before, balance_after, description, idempotency_key, assessment_id, reservation_id)
+    VALUES (p_user_id, p_wallet.org_id, p_transaction_type, p_credit_change, p_reserved_change,
+        credit_wallet_balance(p_wallet), credit_wallet_balance(p_wallet) + p_credit_change,
+        p_description, p_idempotency_key, p_assessment_id, p_reservation_id)
+    RETURNING * INTO v_transaction;
+
+    INSERT INTO credit_ledger_entries (transaction_id, wallet_id, account, amount)
+    SELECT v_transaction.id, NULLIF(e->>'wallet_id', '')::UUID, e->>'account', (e->>'amount')::INTEGER
+    FROM jsonb_array_elements(p_entries) e;
+
+    RETURN v_transaction;
+END;
+$$ language 'plpgsql';
+
+CREATE OR REPLACE FUNCTION credit_ledger_entry(p_wallet_id UUID, p_account TEXT, p_amount INTEGER)
+RETURNS JSONB AS $$
+    SELECT jsonb_build_object('wallet_id', p_wallet_id, 'account', p_account, 'amount', p_amount);
+$$ language 'sql' IMMUTABLE;
+
+-- Covers what a personal wallet is short of p_credits with overage credits
+-- (overage_accrual), billed at the plan's overage price. Requires overage on
+-- the user's paid subscription and keeps the credit period's overage within
+-- its spending cap. Returns the updated wallet.
+CREATE OR REPLACE FUNCTION accrue_overage_credits(p_wallet user_credits, p_user_id UUID, p_credits INTEGER)
+RETURNS user_credits AS $$
+DECLARE
+    v_subscription user_subscriptions;
+    v_plan subscription_plans;
+    v_transaction credit_transactions;
+    v_shortfall INTEGER := p_credits - credit_wallet_available(p_wallet);
+BEGIN
+    IF p_wallet.user_id IS NULL THEN
+        RAISE EXCEPTION 'insufficient credits';
+    END IF;
+
+    SELECT * INTO v_subscription FROM user_subscriptions
+    WHERE user_id = p_wallet.user_id AND status IN ('active', 'cancelled')
+    ORDER BY status = 'active' DESC, start_date DESC
+    LIMIT 1;
+    IF NOT FOUND OR NOT v_subscription.overage_enabled THEN
+        RAISE EXCEPTION 'insufficient credits';
+    END IF;
+    SELECT * INTO v_plan FROM subscription_plans WHERE id = v_subscription.plan_id;
+    IF v_plan.plan_type = 'free' OR COALESCE(v_plan.overage_price_per_credit, 0) <= 0 THEN
+        RAISE EXCEPTION 'insufficient credits';
+    END IF;
+    IF v_subscription.overage_cap_cents IS NOT NULL
+       AND (COALESCE(p_wallet.overage_credits, 0) + v_shortfall) * v_plan.overage_price_per_credit * 100 > v_subscription.overage_cap_cents THEN
+        RAISE EXCEPTION 'overage spending cap reached';
+    END IF;
+
+    v_transaction := post_credit_transaction(p_wallet, p_user_id, 'overage_accrual', v_shortfall, 0,
+        format('Overage of %s credits at $%s per credit', v_shortfall, v_plan.overage_price_per_credit), NULL, NULL, NULL,
+        jsonb_build_array(credit_ledger_entry(NULL, 'external', -v_shortfall),
+                          credit_ledger_entry(p_wallet.id, 'available', v_shortfall)));
+    UPDATE credit_transactions
+    SET metadata = jsonb_build_object(
+        'subscription_id', v_subscription.id,
+        'plan_id', v_plan.id,
+        'price_per_credit', v_plan.overage_price_per_credit,
+        'amount_cents', v_shortfall * v_plan.overage_price_per_credit * 100)
+    WHERE id = v_transaction.id;
+
+    UPDATE user_credits SET overage_credits = COALESCE(overage_credits, 0) + v_shortfall, updated_at = NOW()
+    WHERE id = p_wallet.id
+    RETURNING * INTO p_wallet;
+    RETURN p_wallet;
+END;
+$$ language 'plpgsql';
+
+-- Holds credits for queued work, going into overage like consume_credits
+CREATE OR REPLACE FUNCTION reserve_credits(
+    p_user_id UUID,
+    p_org_id UUID,
+    p_credits INTEGER,
+    p_idempotency_key TEXT DEFAULT NULL,
+    p_description TEXT DEFAULT NULL,
+    p_ttl_seconds INTEGER DEFAULT 86400
+)
+RETURNS credit_reservations AS $$
+DECLARE
+    v_wallet user_credits;
+    v_reservation credit_reservations;
+BEGIN
+    IF p_credits <= 0 THEN
+        RAISE EXCEPTION 'credits must be positive';
+    END IF;
+
+    v_wallet := lock_credit_wallet(p_user_id, p_org_id);
+
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_reservation FROM credit_reservations WHERE idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            RETURN v_reservation;
+        END IF;
+    END IF;
+
+    IF credit_wallet_available(v_wallet) < p_credits THEN
+        v_wallet := accrue_overage_credits(v_wallet, p_user_id, p_credits);
+    END IF;
+
+    INSERT INTO credit_reservations (wallet_id, user_id, org_id, credits, idempotency_key, description, expires_at)
+    VALUES (v_wallet.id, p_user_id, p_org_id, p_credits, p_idempotency_key, p_description,
+        NOW() + make_interval(secs => p_ttl_seconds))
+    RETURNING * INTO v_reservation;
+
+    PERFORM post_credit_transaction(v_wallet, p_user_id, 'credit_reservation', 0, p_credits,
+        p_description, NULL, NULL, v_reservation.id,
+        jsonb_build_array(credit_ledger_entry(v_wallet.id, 'available', -p_credits),
+                          credit_ledger_entry(v_wallet.id, 'reserved', p_credits)));
+
+    UPDATE user_credits SET reserved_credits = COALESCE(reserved_credits, 0) + p_credits, updated_at = NOW()
+    WHERE id = v_wallet.id;
+
+    RETURN v_reservation;
+END;
+$$ language 'plpgsql';
+
+-- Captures (p_capture) or releases p_credits of a reservation, all that is
+-- left when p_credits is NULL. Returns the transaction, or NULL when nothing
+-- moved because the key was used before or the reservation is settled.
+CREATE OR REPLACE FUNCTION settle_credit_reservation(
+    p_reservation_id UUID,
+    p_capture BOOLEAN,
+    p_credits INTEGER DEFAULT NULL,
+    p_assessment_id BIGINT DEFAULT NULL,
+    p_description TEXT DEFAULT NULL,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS credit_transactions AS $$
+DECLARE
+    v_reservation credit_reservations;
+    v_wallet user_credits;
+    v_transaction credit_transactions;
+    v_remaining INTEGER;
+    v_credits INTEGER;
+    v_captured INTEGER;
+    v_released INTEGER;
+BEGIN
+    SELECT * INTO v_reservation FROM credit_reservations WHERE id = p_reservation_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'credit reservation not found' USING ERRCODE = 'P0002';
+    END IF;
+
+    IF p_idempotency_key IS NOT NULL AND EXISTS (
+        SELECT 1 FROM credit_transactions WHERE idempotency_key = p_idempotency_key
+    ) THEN
+        RETURN NULL;
+    END IF;
+
+    v_remaining := v_reservation.credits - v_reservation.captured_credits - v_reservation.released_credits;
+    IF p_credits IS NULL AND v_remaining = 0 THEN
+        RETURN NULL;
+    END IF;
+    v_credits := COALESCE(p_credits, v_remaining);
+    IF v_credits <= 0 OR v_credits > v_remaining THEN
+        RAISE EXCEPTION 'credit reservation has % credits left, cannot settle %', v_remaining, v_credits;
+    END IF;
+
+    SELECT * INTO v_wallet FROM user_credits WHERE id = v_reservation.wallet_id FOR UPDATE;
+
+    IF p_capture THEN
+        v_transaction := post_credit_transaction(v_wallet, v_reservation.user_id, 'assessment_usage', -v_credits, -v_credits,
+            COALESCE(p_description, v_reservation.description), p_idempotency_key, p_assessment_id, v_reservation.id,
+            jsonb_build_array(credit_ledger_entry(v_wallet.id, 'reserved', -v_credits),
+                              credit_ledger_entry(v_wallet.id, 'consumed', v_credits)));
+        UPDATE user_credits
+        SET used_credits = COALESCE(used_credits, 0) + v_credits,
+            reserved_credits = reserved_credits - v_credits,
+            updated_at = NOW()
+        WHERE id = v_wallet.id;
+    ELSE
+        v_transaction := post_credit_transaction(v_wallet, v_reservation.user_id, 'reservation_release', 0, -v_credits,
+            COALESCE(p_description, v_reservation.description), p_idempotency_key, p_assessment_id, v_reservation.id,
+            jsonb_build_array(credit_ledger_entry(v_wallet.id, 'reserved', -v_credits),
+                              credit_ledger_entry(v_wallet.id, 'available', v_credits)));
+        UPDATE user_credits
+        SET reserved_credits = reserved_credits - v_credits, updated_at = NOW()
+        WHERE id = v_wallet.id;
+    END IF;
+
+    v_captured := v_reservation.captured_credits + CASE WHEN p_capture THEN v_credits ELSE 0 END;
+    v_released := v_reservation.released_credits + CASE WHEN p_capture THEN 0 ELSE v_credits END;
+
+    UPDATE credit_reservations
+    SET captured_credits = v_captured,
+        released_credits = v_released,
+        status = CASE
+            WHEN v_captured + v_released < credits THEN 'reserved'
+            WHEN v_released = 0 THEN 'captured'
+            WHEN v_captured = 0 THEN 'released'
+            ELSE 'settled'
+        END,
+        updated_at = NOW()
+    WHERE id = v_reservation.id;
+
+    RETURN v_transaction;
+END;
+$$ language 'plpgsql';
+
+-- Charges credits immediately, without a reservation. Personal wallets with
+-- overage enabled go into overage instead of failing.
+CREATE OR REPLACE FUNCTION consume_credits(
+    p_user_id UUID,
+    p_org_id UUID,
+    p_credits INTEGER,
+    p_transaction_type TEXT DEFAULT 'assessment_usage',
+    p_description TEXT DEFAULT NULL,
+    p_assessment_id BIGINT DEFAULT NULL,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS credit_transactions AS $$
+DECLARE
+    v_wallet user_credits;
+    v_transaction credit_transactions;
+BEGIN
+    IF p_credits <= 0 THEN
+        RAISE EXCEPTION 'credits must be positive';
+    END IF;
+
+    v_wallet := lock_credit_wallet(p_user_id, p_org_id);
+
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_transaction FROM credit_transactions WHERE idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            RETURN v_transaction;
+        END IF;
+    END IF;
+
+    IF credit_wallet_available(v_wallet) < p_credits THEN
+        v_wallet := accrue_overage_credits(v_wallet, p_user_id, p_credits);
+    END IF;
+
+    v_transaction := post_credit_transaction(v_wallet, p_user_id, p_transaction_type, -p_credits, 0,
+        p_description, p_idempotency_key, p_assessment_id, NULL,
+        jsonb_build_array(credit_ledger_entry(v_wallet.id, 'available', -p_credits),
+                          credit_ledger_entry(v_wallet.id, 'consumed', p_credits)));
+
+    UPDATE user_credits SET used_credits = COALESCE(used_credits, 0) + p_credits, updated_at = NOW()
+    WHERE id = v_wallet.id;
+
+    RETURN v_transaction;
+END;
+$$ language 'plpgsql';
+
+-- Moves credits from a member's wallet into an organization's pool and
+-- returns the organization's transaction
+CREATE OR REPLACE FUNCTION transfer_organization_credits(
+    p_user_id UUID,
+    p_org_id UUID,
+    p_credits INTEGER,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS credit_transactions AS $$
+DECLARE
+    v_user_wallet user_credits;
+    v_org_wallet user_credits;
+    v_transaction credit_transactions;
+    v_description TEXT := format('Transfer of %s credits to organization %s', p_credits, p_org_id);
+BEGIN
+    IF p_credits <= 0 THEN
+        RAISE EXCEPTION 'credits must be positive';
+    END IF;
+
+    -- Lock both wallets in a fixed order so opposite transfers cannot deadlock
+    PERFORM 1 FROM user_credits WHERE user_id = p_user_id OR org_id = p_org_id ORDER BY id FOR UPDATE;
+    v_user_wallet := lock_credit_wallet(p_user_id, NULL);
+    v_org_wallet := lock_credit_wallet(NULL, p_org_id);
+
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_transaction FROM credit_transactions WHERE idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            RETURN v_transaction;
+        END IF;
+    END IF;
+
+    IF credit_wallet_available(v_user_wallet) < p_credits THEN
+        RAISE EXCEPTION 'insufficient credits';
+    END IF;
+
+    PERFORM post_credit_transaction(v_user_wallet, p_user_id, 'organization_transfer', -p_credits, 0,
+        v_description, NULL, NULL, NULL,
+        jsonb_build_array(credit_ledger_entry(v_user_wallet.id, 'available', -p_credits),
+                          credit_ledger_entry(NULL, 'transfer', p_credits)));
+    v_transaction := post_credit_transaction(v_org_wallet, p_user_id, 'organization_transfer', p_credits, 0,
+        v_description, p_idempotency_key, NULL, NULL,
+        jsonb_build_array(credit_ledger_entry(NULL, 'transfer', -p_credits),
+                          credit_ledger_entry(v_org_wallet.id, 'available', p_credits)));
+
+    UPDATE user_credits SET used_credits = COALESCE(used_credits, 0) + p_credits, updated_at = NOW()
+    WHERE id = v_user_wallet.id;
+    UPDATE user_credits SET recharged_credits = COALESCE(recharged_credits, 0) + p_credits, updated_at = NOW()
+    WHERE id = v_org_wallet.id;
+
+    RETURN v_transaction;
+END;
+$$ language 'plpgsql';
+
+-- Grants the credits of a paid purchase as recharged credits and records
+-- the receipt on the transaction. A purchase is granted once; repeated calls
+-- (webhook retries) return the first transaction.
+CREATE OR REPLACE FUNCTION complete_credit_purchase(
+    p_purchase_id UUID,
+    p_provider_payment_id TEXT,
+    p_amount_cents INTEGER,
+    p_currency TEXT
+)
+RETURNS credit_transactions AS $$
+DECLARE
+    v_purchase credit_purchases;
+    v_package credit_packages;
+    v_wallet user_credits;
+    v_transaction credit_transactions;
+    v_receipt_number TEXT;
+BEGIN
+    SELECT * INTO v_purchase FROM credit_purchases WHERE id = p_purchase_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'purchase not found' USING ERRCODE = 'P0002';
+    END IF;
+
+    IF v_purchase.status = 'completed' THEN
+        SELECT * INTO v_transaction FROM credit_transactions WHERE id = v_purchase.transaction_id;
+        RETURN v_transaction;
+    END IF;
+
+    IF p_amount_cents <> v_purchase.amount_cents OR lower(p_currency) <> lower(v_purchase.currency) THEN
+        RAISE EXCEPTION 'payment of % % does not match purchase of % %',
+            p_amount_cents, p_currency, v_purchase.amount_cents, v_purchase.currency;
+    END IF;
+
+    SELECT * INTO v_package FROM credit_packages WHERE id = v_purchase.package_id;
+    v_wallet := lock_credit_wallet(v_purchase.user_id, v_purchase.org_id);
+    v_receipt_number := 'QF-' || to_char(NOW() AT TIME ZONE 'UTC', 'YYYYMMDD') || '-'
+        || upper(substr(replace(v_purchase.id::TEXT, '-', ''), 1, 10));
+
+    v_transaction := post_credit_transaction(v_wallet, v_purchase.user_id, 'recharge_purchase', v_purchase.credits, 0,
+        format('Purchase of %s (%s credits)', v_package.package_name, v_purchase.credits),
+        'credit_purchase:' || v_purchase.id, NULL, NULL,
+        jsonb_build_array(credit_ledger_entry(NULL, 'external', -v_purchase.credits),
+                          credit_ledger_entry(v_wallet.id, 'available', v_purchase.credits)));
+
+    UPDATE credit_transactions
+    SET metadata = jsonb_build_object(
+        'receipt_number', v_receipt_number,
+        'purchase_id', v_purchase.id,
+        'package_id', v_purchase.package_id,
+        'package_name', v_package.package_name,
+        'amount_cents', v_purchase.amount_cents,
+        'currency', v_purchase.currency,
+        'provider', v_purchase.provider,
+        'provider_payment_id', p_provider_payment_id)
+    WHERE id = v_transaction.id
+    RETURNING * INTO v_transaction;
+
+    UPDATE user_credits SET recharged_credits = COALESCE(recharged_credits, 0) + v_purchase.credits, updated_at = NOW()
+    WHERE id = v_wallet.id;
+
+    UPDATE credit_purchases
+    SET status = 'completed',
+        provider_payment_id = p_provider_payment_id,
+        receipt_number = v_receipt_number,
+        transaction_id = v_transaction.id,
+        completed_at = NOW(),
+        updated_at = NOW()
+    WHERE id = v_purchase.id;
+
+    RETURN v_transaction;
+END;
+$$ language 'plpgsql';
+
+-- Grants (p_credits > 0) or revokes (p_credits < 0) bonus credits of a
+-- personal wallet on behalf of a platform admin. Revoking is limited to the
+-- bonus credits still available. The reason and admin are kept in metadata.
+CREATE OR REPLACE FUNCTION adjust_bonus_credits(
+    p_user_id UUID,
+    p_credits INTEGER,
+    p_reason TEXT,
+    p_admin_id UUID,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS credit_transactions AS $$
+DECLARE
+    v_wallet user_credits;
+    v_transaction credit_transactions;
+BEGIN
+    IF p_credits = 0 THEN
+        RAISE EXCEPTION 'credits must not be zero';
+    END IF;
+
+    v_wallet := lock_credit_wallet(p_user_id, NULL);
+
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_transaction FROM credit_transactions WHERE idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            RETURN v_transaction;
+        END IF;
+    END IF;
+
+    IF p_credits < 0 AND -p_credits > LEAST(COALESCE(v_wallet.bonus_credits, 0), credit_wallet_available(v_wallet)) THEN
+        RAISE EXCEPTION 'insufficient bonus credits';
+    END IF;
+
+    v_transaction := post_credit_transaction(v_wallet, p_user_id,
+        CASE WHEN p_credits > 0 THEN 'bonus' ELSE 'bonus_revocation' END, p_credits, 0,
+        p_reason, p_idempotency_key, NULL, NULL,
+        jsonb_build_array(credit_ledger_entry(NULL, 'external', -p_credits),
+                          credit_ledger_entry(v_wallet.id, 'available', p_credits)));
+    UPDATE credit_transactions
+    SET metadata = jsonb_build_object('reason', p_reason, 'admin_id', p_admin_id)
+    WHERE id = v_transaction.id
+    RETURNING * INTO v_transaction;
+
+    UPDATE user_credits SET bonus_credits = COALESCE(bonus_credits, 0) + p_credits, updated_at = NOW()
+    WHERE id = v_wallet.id;
+
+    RETURN v_transaction;
+END;
+$$ language 'plpgsql';
+
+-- Counts a guess of a phone verification code before it is compared.
+-- Returns the attempts made including this one, or 0 when the verification
+-- is verified, used up or missing, so concurrent guesses cannot exceed
+-- p_max_attempts.
+CREATE OR REPLACE FUNCTION claim_phone_verification_attempt(p_verification_id UUID, p_max_attempts INTEGER)
+RETURNS INTEGER AS $$
+DECLARE
+    v_attempts INTEGER;
+BEGIN
+    UPDATE phone_verifications
+    SET attempts = COALESCE(attempts, 0) + 1
+    WHERE id = p_verification_id
+      AND verified_at IS NULL
+      AND COALESCE(attempts, 0) < p_max_attempts
+    RETURNING attempts INTO v_attempts;
+    RETURN COALESCE(v_attempts, 0);
+END;
+$$ language 'plpgsql';
+
+-- Marks a low-balance alert rule triggered unless it already was in the
+-- wallet's current credit period (since its last reset, or the UTC month for
+-- wallets without resets). Returns whether the caller should alert, so only
+-- one instance does.
+CREATE OR REPLACE FUNCTION claim_credit_alert(p_rule_id UUID)
+RETURNS BOOLEAN AS $$
+BEGIN
+    UPDATE credit_alert_rules r
+    SET last_triggered_at = NOW(), updated_at = NOW()
+    FROM user_credits w
+    WHERE r.id = p_rule_id
+      AND r.is_active
+      AND (r.org_id IS NULL AND w.user_id = r.user_id OR w.org_id = r.org_id)
+      AND (r.last_triggered_at IS NULL OR r.last_triggered_at <
+          COALESCE(w.last_reset_date, date_trunc('month', NOW() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'));
+    RETURN FOUND;
+END;
+$$ language 'plpgsql';
+
+-- Releases what is left of reservations past their expiry, such as those of
+-- assessments lost in a restart. Safe to run from several instances.
+CREATE OR REPLACE FUNCTION release_expired_credit_reservations()
+RETURNS INTEGER AS $$
+DECLARE
+    v_id UUID;
+    v_count INTEGER := 0;
+BEGIN
+    FOR v_id IN
+        SELECT id FROM credit_reservations
+        WHERE status = 'reserved' AND expires_at < NOW()
+        FOR UPDATE SKIP LOCKED
+    LOOP
+        PERFORM settle_credit_reservation(v_id, FALSE, NULL, NULL, 'Reservation expired', NULL);
+        v_count := v_count + 1;
+    END LOOP;
+    RETURN v_count;
+END;
+$$ language 'plpgsql';
+
+-- Claims the next run of a scheduled job for p_lease_seconds. Returns FALSE
+-- when another instance holds the lease.
+CREATE OR REPLACE FUNCTION claim_scheduled_job(p_job_name TEXT, p_instance_id TEXT, p_lease_seconds INTEGER)
+RETURNS BOOLEAN AS $$
+DECLARE
+    v_claimed BOOLEAN;
+BEGIN
+    INSERT INTO scheduled_jobs (job_name) VALUES (p_job_name) ON CONFLICT (job_name) DO NOTHING;
+
+    UPDATE scheduled_jobs
+    SET locked_by = p_instance_id,
+        locked_until = NOW() + make_interval(secs => p_lease_seconds),
+        last_started_at = NOW()
+    WHERE job_name = p_job_name
+      AND (locked_until IS NULL OR locked_until <= NOW())
+    RETURNING TRUE INTO v_claimed;
+
+    RETURN COALESCE(v_claimed, FALSE);
+END;
+$$ language 'plpgsql';
+
+-- Records the outcome of a run. The lease is kept until it expires so the
+-- job does not run again before its next interval.
+CREATE OR REPLACE FUNCTION finish_scheduled_job(p_job_name TEXT, p_instance_id TEXT, p_result JSONB, p_error TEXT)
+RETURNS BOOLEAN AS $$
+BEGIN
+    UPDATE scheduled_jobs
+    SET last_finished_at = NOW(), last_result = p_result, last_error = p_error
+    WHERE job_name = p_job_name AND locked_by = p_instance_id;
+    RETURN FOUND;
+END;
+$$ language 'plpgsql';
+
+-- Claims up to p_limit webhook deliveries whose next attempt is due. Their
+-- next_attempt_at moves p_lease_seconds ahead, so no other worker sends them
+-- meanwhile and a delivery whose sender died is retried after the lease.
+CREATE OR REPLACE FUNCTION claim_due_webhook_deliveries(p_limit INTEGER, p_lease_seconds INTEGER)
+RETURNS SETOF webhook_deliveries AS $$
+BEGIN
+    RETURN QUERY
+    UPDATE webhook_deliveries
+    SET next_attempt_at = NOW() + make_interval(secs => p_lease_seconds),
+        updated_at = NOW()
+    WHERE id IN (
+        SELECT id FROM webhook_deliveries
+        WHERE status IN ('pending', 'retrying') AND next_attempt_at <= NOW()
+        ORDER BY next_attempt_at
+        LIMIT p_limit
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING *;
+END;
+$$ language 'plpgsql';
+
+-- Claims an idempotency key for a request with p_claim_id. A new key, an
+-- expired one or one whose processing lease ran out is claimed; otherwise
+-- the stored key is returned unchanged for the caller to replay or reject.
+CREATE OR REPLACE FUNCTION claim_idempotency_key(
+    p_user_id UUID,
+    p_key TEXT,
+    p_method TEXT,
+    p_path TEXT,
+    p_request_hash TEXT,
+    p_claim_id UUID,
+    p_lease_seconds INTEGER,
+    p_retention_seconds INTEGER
+)
+RETURNS idempotency_keys AS $$
+DECLARE
+    v_key idempotency_keys;
+BEGIN
+    INSERT INTO idempotency_keys (user_id, idempotency_key, method, path, request_hash, claim_id, locked_until, expires_at)
+    VALUES (p_user_id, p_key, p_method, p_path, p_request_hash, p_claim_id,
+        NOW() + make_interval(secs => p_lease_seconds), NOW() + make_interval(secs => p_retention_seconds))
+    ON CONFLICT (user_id, idempotency_key) DO UPDATE
+    SET method = EXCLUDED.method,
+        path = EXCLUDED.path,
+        request_hash = EXCLUDED.request_hash,
+        status = 'processing',
+        claim_id = EXCLUDED.claim_id,
+        locked_until = EXCLUDED.locked_until,
+        response_status = NULL,
+        response_headers = NULL,
+        response_body = NULL,
+        created_at = NOW(),
+        completed_at = NULL,
+        expires_at = EXCLUDED.expires_at
+    WHERE idempotency_keys.expires_at <= NOW()
+       OR (idempotency_keys.status = 'processing' AND idempotency_keys.locked_until <= NOW())
+    RETURNING * INTO v_key;
+    IF FOUND THEN
+        RETURN v_key;
+    END IF;
+
+    SELECT * INTO v_key FROM idempotency_keys WHERE user_id = p_user_id AND idempotency_key = p_key;
+    RETURN v_key;
+END;
+$$ language 'plpgsql';
+
+-- Number of whole p_step periods between p_anchor and p_at. Periods count
+-- from the anchor so month-end dates do not drift (Jan 31, Feb 28, Mar 31).
+CREATE OR REPLACE FUNCTION subscription_periods_elapsed(p_anchor TIMESTAMPTZ, p_at TIMESTAMPTZ, p_step INTERVAL)
+RETURNS INTEGER AS $$
+DECLARE
+    v_periods INTEGER := 0;
+BEGIN
+    WHILE p_anchor + (v_periods + 1) * p_step <= p_at LOOP
+        v_periods := v_periods + 1;
+    END LOOP;
+    RETURN v_periods;
+END;
+$$ language 'plpgsql' STABLE;
+
+-- Credits a subscription allocates each month. Yearly plans are billed once
+-- a year; their yearly credits are allocated in twelve monthly parts.
+CREATE OR REPLACE FUNCTION subscription_monthly_allocation(p_plan subscription_plans, p_billing_cycle TEXT)
+RETURNS INTEGER AS $$
+    SELECT CASE WHEN p_billing_cycle = 'yearly' AND p_plan.yearly_credits IS NOT NULL
+        THEN p_plan.yearly_credits / 12
+        ELSE p_plan.monthly_credits
+    END;
+$$ language 'sql' IMMUTABLE;
+
+-- Starts a new credit period on a wallet: subscription credits left from the
+-- last period expire (monthly_reset) and the new allocation is granted
+-- (subscription_allocation). Purchased and bonus credits carry over, less
+-- any usage beyond the subscription credits. Overage credits count as used
+-- last; any left unused are taken back unbilled (overage_reversal). The
+-- transactions are keyed by subscription and period, so a period is never
+-- reset twice. When the period starts a billing period, the allocation's
+-- metadata records the plan charge (charge_cents) for invoicing.
+CREATE OR REPLACE FUNCTION reset_subscription_credits(
+    p_wallet_id UUID,
+    p_subscription_id UUID,
+    p_allocation INTEGER,
+    p_period_start TIMESTAMPTZ,
+    p_next_reset TIMESTAMPTZ,
+    p_description TEXT
+)
+RETURNS credit_transactions AS $$
+DECLARE
+    v_wallet user_credits;
+    v_transaction credit_transactions;
+    v_period_key TEXT := format('%s:%s', p_subscription_id, to_char(p_period_start AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS'));
+    v_subscription user_subscriptions;
+    v_plan subscription_plans;
+    v_charge_cents INTEGER := 0;
+    v_unused_overage INTEGER;
+    v_overage_price NUMERIC;
+    v_used INTEGER;
+    v_expired INTEGER;
+    v_overflow INTEGER;
+    v_from_recharged INTEGER;
+BEGIN
+    SELECT * INTO v_wallet FROM user_credits WHERE id = p_wallet_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'user credits not found' USING ERRCODE = 'P0002';
+    END IF;
+
+    SELECT * INTO v_transaction FROM credit_transactions WHERE idempotency_key = 'subscription_allocation:' || v_period_key;
+    IF FOUND THEN
+        UPDATE user_credits SET next_reset_date = GREATEST(next_reset_date, p_next_reset) WHERE id = v_wallet.id;
+        RETURN v_transaction;
+    END IF;
+
+    v_unused_overage := LEAST(COALESCE(v_wallet.overage_credits, 0), GREATEST(credit_wallet_balance(v_wallet), 0));
+    IF v_unused_overage > 0 THEN
+        SELECT (metadata->>'price_per_credit')::NUMERIC INTO v_overage_price FROM credit_transactions
+        WHERE user_id = v_wallet.user_id AND org_id IS NULL AND transaction_type = 'overage_accrual'
+        ORDER BY created_at DESC
+        LIMIT 1;
+        v_transaction := post_credit_transaction(v_wallet, v_wallet.user_id, 'overage_reversal', -v_unused_overage, 0,
+            'Unused overage credits are not billed', 'overage_reversal:' || v_period_key, NULL, NULL,
+            jsonb_build_array(credit_ledger_entry(v_wallet.id, 'available', -v_unused_overage),
+                              credit_ledger_entry(NULL, 'external', v_unused_overage)));
+        UPDATE credit_transactions
+        SET metadata = jsonb_build_object(
+            'price_per_credit', v_overage_price,
+            'amount_cents', -v_unused_overage * COALESCE(v_overage_price, 0) * 100)
+        WHERE id = v_transaction.id;
+        UPDATE user_credits SET overage_credits = overage_credits - v_unused_overage
+        WHERE id = v_wallet.id
+        RETURNING * INTO v_wallet;
+    END IF;
+
+    -- Usage covered by overage credits was beyond every other credit
+    v_used := COALESCE(v_wallet.used_credits, 0) - COALESCE(v_wallet.overage_credits, 0);
+    v_expired := GREATEST(COALESCE(v_wallet.subscription_credits, 0) - v_used, 0);
+    v_overflow := GREATEST(v_used - COALESCE(v_wallet.subscription_credits, 0), 0);
+    v_from_recharged := LEAST(COALESCE(v_wallet.recharged_credits, 0), v_overflow);
+
+    IF v_expired > 0 THEN
+        PERFORM post_credit_transaction(v_wallet, v_wallet.user_id, 'monthly_reset', -v_expired, 0,
+            'Unused subscription credits expired', 'monthly_reset:' || v_period_key, NULL, NULL,
+            jsonb_build_array(credit_ledger_entry(v_wallet.id, 'available', -v_expired),
+                              credit_ledger_entry(NULL, 'external', v_expired)));
+    END IF;
+
+    -- Fold the period's usage into the carried-over credits
+    UPDATE user_credits
+    SET subscription_credits = 0,
+        recharged_credits = COALESCE(recharged_credits, 0) - v_from_recharged,
+        bonus_credits = COALESCE(bonus_credits, 0) - (v_overflow - v_from_recharged),
+        overage_credits = 0,
+        used_credits = 0
+    WHERE id = v_wallet.id
+    RETURNING * INTO v_wallet;
+
+    v_transaction := post_credit_transaction(v_wallet, v_wallet.user_id, 'subscription_allocation', p_allocation, 0,
+        p_description, 'subscription_allocation:' || v_period_key, NULL, NULL,
+        jsonb_build_array(credit_ledger_entry(NULL, 'external', -p_allocation),
+                          credit_ledger_entry(v_wallet.id, 'available', p_allocation)));
+
+    SELECT * INTO v_subscription FROM user_subscriptions WHERE id = p_subscription_id;
+    IF FOUND THEN
+        SELECT * INTO v_plan FROM subscription_plans WHERE id = v_subscription.plan_id;
+        -- Monthly plans are charged every period, yearly ones on their anniversary
+        IF v_subscription.billing_cycle <> 'yearly' THEN
+            v_charge_cents := ROUND(v_plan.monthly_price * 100);
+        ELSIF v_subscription.start_date + subscription_periods_elapsed(v_subscription.start_date, p_period_start, INTERVAL '1 year')
+                * INTERVAL '1 year' = p_period_start THEN
+            v_charge_cents := ROUND(COALESCE(v_plan.yearly_price, v_plan.monthly_price * 12) * 100);
+        END IF;
+        UPDATE credit_transactions
+        SET metadata = jsonb_build_object(
+            'subscription_id', v_subscription.id,
+            'plan_id', v_plan.id,
+            'plan_name', v_plan.plan_name,
+            'billing_cycle', v_subscription.billing_cycle,
+            'charge_cents', v_charge_cents)
+        WHERE id = v_transaction.id
+        RETURNING * INTO v_transaction;
+    END IF;
+
+    UPDATE user_credits
+    SET subscription_credits = p_allocation,
+        monthly_allocation = p_allocation,
+        last_reset_date = p_period_start,
+        next_reset_date = p_next_reset,
+        updated_at = NOW()
+    WHERE id = v_wallet.id;
+
+    RETURN v_transaction;
+END;
+$$ language 'plpgsql';
+
+-- One pass of subscription billing:
+--   1. cancelled subscriptions past their paid period, and active ones due
+--      for billing with auto_renew off, expire and fall back to Free
+--   2. renewing subscriptions move to their next billing date, switching
+--      to a scheduled downgrade
+--   3. wallets whose credit period ended are reset (monthly for every plan)
+-- Rows are claimed with SKIP LOCKED and resets are keyed by period, so runs
+-- on several instances never apply a change twice. Returns how many rows
+-- each step handled; a step that handled p_batch_size rows has more to do.
+CREATE OR REPLACE FUNCTION run_subscription_cycle(p_batch_size INTEGER DEFAULT 200)
+RETURNS JSONB AS $$
+DECLARE
+    v_subscription user_subscriptions;
+    v_row RECORD;
+    v_free_plan_id INTEGER;
+    v_step INTERVAL;
+    v_periods INTEGER;
+    v_expired INTEGER := 0;
+    v_renewed INTEGER := 0;
+    v_reset INTEGER := 0;
+BEGIN
+    SELECT id INTO v_free_plan_id FROM subscription_plans WHERE plan_name = 'Free' AND is_active;
+
+    FOR v_subscription IN
+        SELECT * FROM user_subscriptions
+        WHERE (status = 'cancelled' AND COALESCE(end_date, next_billing_date, cancelled_at) <= NOW())
+           OR (status = 'active' AND NOT COALESCE(auto_renew, TRUE) AND next_billing_date <= NOW())
+        ORDER BY id
+        LIMIT p_batch_size
+        FOR UPDATE SKIP LOCKED
+    LOOP
+        UPDATE user_subscriptions
+        SET status = 'expired', end_date = COALESCE(end_date, next_billing_date, NOW()), updated_at = NOW()
+        WHERE id = v_subscription.id;
+
+        IF v_free_plan_id IS NOT NULL AND NOT EXISTS (
+            SELECT 1 FROM user_subscriptions WHERE user_id = v_subscription.user_id AND status = 'active'
+        ) THEN
+            INSERT INTO user_subscriptions (user_id, plan_id, billing_cycle, status, start_date, next_billing_date, auto_renew)
+            VALUES (v_subscription.user_id, v_free_plan_id, 'monthly', 'active', NOW(), NOW() + INTERVAL '1 month', TRUE);
+            -- The Free plan's first period starts now
+            UPDATE user_credits SET next_reset_date = NOW() WHERE user_id = v_subscription.user_id;
+        END IF;
+        v_expired := v_expired + 1;
+    END LOOP;
+
+    FOR v_subscription IN
+        SELECT * FROM user_subscriptions
+        WHERE status = 'active'
+          AND (next_billing_date IS NULL OR (COALESCE(auto_renew, TRUE) AND next_billing_date <= NOW()))
+        ORDER BY id
+        LIMIT p_batch_size
+        FOR UPDATE SKIP LOCKED
+    LOOP
+        IF v_subscription.scheduled_change_at <= NOW() THEN
+            -- A new billing cycle counts from the end of the old one
+            IF v_subscription.scheduled_billing_cycle IS DISTINCT FROM v_subscription.billing_cycle THEN
+                v_subscription.start_date := v_subscription.scheduled_change_at;
+            END IF;
+            v_subscription.plan_id := v_subscription.scheduled_plan_id;
+            v_subscription.billing_cycle := COALESCE(v_subscription.scheduled_billing_cycle, v_subscription.billing_cycle);
+            v_subscription.scheduled_plan_id := NULL;
+            v_subscription.scheduled_billing_cycle := NULL;
+            v_subscription.scheduled_change_at := NULL;
+        END IF;
+
+        v_step := CASE WHEN v_subscription.billing_cycle = 'yearly' THEN INTERVAL '1 year' ELSE INTERVAL '1 month' END;
+        v_periods := subscription_periods_elapsed(v_subscription.start_date, NOW(), v_step);
+        UPDATE user_subscriptions
+        SET plan_id = v_subscription.plan_id,
+            billing_cycle = v_subscription.billing_cycle,
+            start_date = v_subscription.start_date,
+            scheduled_plan_id = v_subscription.scheduled_plan_id,
+            scheduled_billing_cycle = v_subscription.scheduled_billing_cycle,
+            scheduled_change_at = v_subscription.scheduled_change_at,
+            next_billing_date = v_subscription.start_date + (v_periods + 1) * v_step,
+            updated_at = NOW()
+        WHERE id = v_subscription.id;
+        v_renewed := v_renewed + 1;
+    END LOOP;
+
+    FOR v_row IN
+        SELECT w.id AS wallet_id, w.next_reset_date, s.id AS subscription_id, s.start_date, s.billing_cycle, p AS plan
+        FROM user_credits w
+        JOIN LATERAL (
+            SELECT * FROM user_subscriptions
+            WHERE user_id = w.user_id AND status IN ('active', 'cancelled')
+            ORDER BY status = 'active' DESC, start_date DESC
+            LIMIT 1
+        ) s ON TRUE
+        JOIN subscription_plans p ON p.id = s.plan_id
+        WHERE w.user_id IS NOT NULL AND (w.next_reset_date IS NULL OR w.next_reset_date <= NOW())
+        ORDER BY w.id
+        LIMIT p_batch_size
+        FOR UPDATE OF w SKIP LOCKED
+    LOOP
+        v_periods := subscription_periods_elapsed(v_row.start_date, NOW(), INTERVAL '1 month');
+        IF v_row.next_reset_date IS NULL THEN
+            -- Wallets created before the scheduler keep their credits until
+            -- the subscription's next monthly anniversary
+            UPDATE user_credits
+            SET last_reset_date = COALESCE(last_reset_date, v_row.start_date + v_periods * INTERVAL '1 month'),
+                next_reset_date = v_row.start_date + (v_periods + 1) * INTERVAL '1 month'
+            WHERE id = v_row.wallet_id;
+        ELSE
+            PERFORM reset_subscription_credits(v_row.wallet_id, v_row.subscription_id,
+                subscription_monthly_allocation(v_row.plan, v_row.billing_cycle),
+                v_row.start_date + v_periods * INTERVAL '1 month',
+                v_row.start_date + (v_periods + 1) * INTERVAL '1 month',
+                format('%s plan monthly credits', (v_row.plan).plan_name));
+        END IF;
+        v_reset := v_reset + 1;
+    END LOOP;
+
+    RETURN jsonb_build_object('expired', v_expired, 'renewed', v_renewed, 'reset', v_reset);
+END;
+$$ language 'plpgsql';
+
+-- Changes the plan of a user's active subscription. Upgrades take effect
+-- immediately: in the same billing cycle the wallet is granted the extra
+-- monthly credits for the rest of the credit period (subscription_proration)
+-- and the price difference for the rest of the billing period is charged; a
+-- new billing cycle starts a new subscription period now, charged in full.
+-- An upgrade with a charge is only applied with its paid plan_upgrade
+-- purchase (p_purchase_id, see complete_plan_upgrade) or, for admins, with
+-- p_invoice_charge, which leaves the charge to the monthly invoice. Without
+-- either it returns 'payment_required' and the charge, changing nothing.
+-- Downgrades, including yearly to monthly, are scheduled for the end of the
+-- paid period and applied by run_subscription_cycle. Asking for the current
+-- plan drops a scheduled downgrade.
+DROP FUNCTION IF EXISTS change_subscription_plan(UUID, INTEGER, TEXT);
+CREATE OR REPLACE FUNCTION change_subscription_plan(
+    p_user_id UUID,
+    p_plan_id INTEGER,
+    p_billing_cycle TEXT,
+    p_purchase_id UUID DEFAULT NULL,
+    p_invoice_charge BOOLEAN DEFAULT FALSE
+)
+RETURNS JSONB AS $$
+DECLARE
+    v_subscription user_subscriptions;
+    v_current subscription_plans;
+    v_plan subscription_plans;
+    v_wallet user_credits;
+    v_purchase credit_purchases;
+    v_change TEXT;
+    v_step INTERVAL;
+    v_period_start TIMESTAMPTZ;
+    v_period_end TIMESTAMPTZ;
+    v_billing_start TIMESTAMPTZ;
+    v_allocation INTEGER;
+    v_prorated INTEGER := 0;
+    v_charge_cents INTEGER := 0;
+    v_charge JSONB;
+    v_transaction credit_transactions;
+    v_effective_at TIMESTAMPTZ := NOW();
+BEGIN
+    IF p_billing_cycle NOT IN ('monthly', 'yearly') THEN
+        RAISE EXCEPTION 'invalid billing cycle';
+    END IF;
+
+    SELECT * INTO v_subscription FROM user_subscriptions
+    WHERE user_id = p_user_id AND status IN ('active', 'cancelled')
+    ORDER BY status = 'active' DESC, start_date DESC
+    LIMIT 1
+    FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'no active subscription found' USING ERRCODE = 'P0002';
+    END IF;
+    IF v_subscription.status = 'cancelled' THEN
+        RAISE EXCEPTION 'subscription is cancelled';
+    END IF;
+
+    SELECT * INTO v_plan FROM subscription_plans WHERE id = p_plan_id AND is_active;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'subscription plan not found' USING ERRCODE = 'P0002';
+    END IF;
+    IF p_billing_cycle = 'yearly' AND v_plan.yearly_price IS NULL THEN
+        RAISE EXCEPTION 'billing cycle not available for plan';
+    END IF;
+    SELECT * INTO v_current FROM subscription_plans WHERE id = v_subscription.plan_id;
+
+    IF p_purchase_id IS NOT NULL THEN
+        SELECT * INTO v_purchase FROM credit_purchases
+        WHERE id = p_purchase_id AND user_id = p_user_id AND source = 'plan_upgrade'
+          AND plan_id = p_plan_id AND billing_cycle = p_billing_cycle;
+        IF NOT FOUND THEN
+            RAISE EXCEPTION 'purchase does not match the plan change';
+        END IF;
+    END IF;
+
+    IF v_plan.id = v_current.id AND p_billing_cycle = v_subscription.billing_cycle THEN
+        IF p_purchase_id IS NOT NULL THEN
+            RAISE EXCEPTION 'plan change is no longer an upgrade';
+        END IF;
+        v_change := CASE WHEN v_subscription.scheduled_plan_id IS NULL THEN 'unchanged' ELSE 'scheduled_change_cancelled' END;
+        UPDATE user_subscriptions
+        SET scheduled_plan_id = NULL, scheduled_billing_cycle = NULL, scheduled_change_at = NULL, updated_at = NOW()
+        WHERE id = v_subscription.id
+        RETURNING * INTO v_subscription;
+
+    ELSIF v_plan.monthly_price > v_current.monthly_price
+       OR (v_plan.id = v_current.id AND p_billing_cycle = 'yearly') THEN
+        v_allocation := subscription_monthly_allocation(v_plan, p_billing_cycle);
+        v_step := CASE WHEN p_billing_cycle = 'yearly' THEN INTERVAL '1 year' ELSE INTERVAL '1 month' END;
+
+        IF p_billing_cycle = v_subscription.billing_cycle THEN
+            v_period_start := v_subscription.start_date
+                + subscription_periods_elapsed(v_subscription.start_date, NOW(), INTERVAL '1 month') * INTERVAL '1 month';
+            v_period_end := v_period_start + INTERVAL '1 month';
+            v_prorated := GREATEST(ROUND(
+                (v_allocation - subscription_monthly_allocation(v_current, v_subscription.billing_cycle))
+                * EXTRACT(EPOCH FROM v_period_end - NOW()) / EXTRACT(EPOCH FROM v_period_end - v_period_start)), 0);
+
+            -- The price difference is charged for the rest of the billing period
+            v_billing_start := v_subscription.start_date
+                + subscription_periods_elapsed(v_subscription.start_date, NOW(), v_step) * v_step;
+            v_charge_cents := GREATEST(ROUND(
+                (CASE WHEN p_billing_cycle = 'yearly'
+                    THEN COALESCE(v_plan.yearly_price, 0) - COALESCE(v_current.yearly_price, 0)
+                    ELSE v_plan.monthly_price - v_current.monthly_price END) * 100
+                * EXTRACT(EPOCH FROM v_billing_start + v_step - NOW()) / EXTRACT(EPOCH FROM v_billing_start + v_step - v_billing_start)), 0);
+        ELSE
+            -- The new billing period starts now and is charged in full, as at renewal
+            v_charge_cents := ROUND(CASE WHEN p_billing_cycle = 'yearly' THEN v_plan.yearly_price ELSE v_plan.monthly_price END * 100);
+        END IF;
+
+        IF v_charge_cents > 0 AND p_purchase_id IS NULL AND NOT p_invoice_charge THEN
+            RETURN jsonb_build_object(
+                'change', 'payment_required',
+                'subscription', to_jsonb(v_subscription),
+                'plan_name', v_plan.plan_name,
+                'prorated_credits', v_prorated,
+                'charge_cents', v_charge_cents,
+                'effective_at', v_effective_at
+            );
+        END IF;
+
+        -- A paid upgrade is on the invoice as its purchase; otherwise the
+        -- charge is invoiced from the transaction
+        IF p_purchase_id IS NOT NULL THEN
+            v_charge_cents := v_purchase.amount_cents;
+            v_charge := jsonb_build_object('purchase_id', v_purchase.id, 'amount_cents', v_purchase.amount_cents);
+        ELSE
+            v_charge := jsonb_build_object('charge_cents', v_charge_cents);
+        END IF;
+
+        v_change := 'upgraded';
+        v_wallet := lock_credit_wallet(p_user_id, NULL);
+
+        IF p_billing_cycle = v_subscription.billing_cycle THEN
+            IF v_prorated > 0 OR v_charge_cents > 0 THEN
+                v_transaction := post_credit_transaction(v_wallet, p_user_id, 'subscription_proration', v_prorated, 0,
+                    format('Prorated credits for the upgrade to %s', v_plan.plan_name), NULL, NULL, NULL,
+                    jsonb_build_array(credit_ledger_entry(NULL, 'external', -v_prorated),
+                                      credit_ledger_entry(v_wallet.id, 'available', v_prorated)));
+                UPDATE credit_transactions
+                SET metadata = jsonb_build_object(
+                    'subscription_id', v_subscription.id,
+                    'plan_id', v_plan.id,
+                    'plan_name', v_plan.plan_name,
+                    'billing_cycle', p_billing_cycle) || v_charge
+                WHERE id = v_transaction.id
+                RETURNING * INTO v_transaction;
+            END IF;
+            UPDATE user_credits
+            SET subscription_credits = COALESCE(subscription_credits, 0) + v_prorated,
+                monthly_allocation = v_allocation,
+                updated_at = NOW()
+            WHERE id = v_wallet.id;
+
+            UPDATE user_subscriptions
+            SET plan_id = v_plan.id,
+                scheduled_plan_id = NULL, scheduled_billing_cycle = NULL, scheduled_change_at = NULL,
+                updated_at = NOW()
+            WHERE id = v_subscription.id
+            RETURNING * INTO v_subscription;
+        ELSE
+            UPDATE user_subscriptions
+            SET plan_id = v_plan.id,
+                billing_cycle = p_billing_cycle,
+                start_date = NOW(),
+                next_billing_date = NOW() + v_step,
+                scheduled_plan_id = NULL, scheduled_billing_cycle = NULL, scheduled_change_at = NULL,
+                updated_at = NOW()
+            WHERE id = v_subscription.id
+            RETURNING * INTO v_subscription;
+
+            v_transaction := reset_subscription_credits(v_wallet.id, v_subscription.id, v_allocation,
+                v_subscription.start_date, v_subscription.start_date + INTERVAL '1 month',
+                format('%s plan monthly credits', v_plan.plan_name));
+            UPDATE credit_transactions
+            SET metadata = (metadata - 'charge_cents') || v_charge
+            WHERE id = v_transaction.id
+            RETURNING * INTO v_transaction;
+        END IF;
+
+    ELSE
+        IF p_purchase_id IS NOT NULL THEN
+            RAISE EXCEPTION 'plan change is no longer an upgrade';
+        END IF;
+        v_change := 'downgrade_scheduled';
+        v_effective_at := COALESCE(v_subscription.next_billing_date, NOW());
+        UPDATE user_subscriptions
+        SET scheduled_plan_id = v_plan.id,
+            scheduled_billing_cycle = p_billing_cycle,
+            scheduled_change_at = v_effective_at,
+            updated_at = NOW()
+        WHERE id = v_subscription.id
+        RETURNING * INTO v_subscription;
+    END IF;
+
+    RETURN jsonb_build_object(
+        'change', v_change,
+        'subscription', to_jsonb(v_subscription),
+        'plan_name', v_plan.plan_name,
+        'prorated_credits', v_prorated,
+        'charge_cents', v_charge_cents,
+        'effective_at', v_effective_at,
+        'transaction_id', v_transaction.id
+    );
+END;
+$$ language 'plpgsql';
+
+-- Applies the plan upgrade paid by a plan_upgrade purchase and records its
+-- receipt. Repeated calls return the completed purchase without applying the
+-- upgrade again.
+CREATE OR REPLACE FUNCTION complete_plan_upgrade(
+    p_purchase_id UUID,
+    p_provider_payment_id TEXT,
+    p_amount_cents INTEGER,
+    p_currency TEXT
+)
+RETURNS credit_purchases AS $$
+DECLARE
+    v_purchase credit_purchases;
+    v_change JSONB;
+BEGIN
+    SELECT * INTO v_purchase FROM credit_purchases WHERE id = p_purchase_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'purchase not found' USING ERRCODE = 'P0002';
+    END IF;
+
+    IF v_purchase.status = 'completed' THEN
+        RETURN v_purchase;
+    END IF;
+
+    IF p_amount_cents <> v_purchase.amount_cents OR lower(p_currency) <> lower(v_purchase.currency) THEN
+        RAISE EXCEPTION 'payment of % % does not match purchase of % %',
+            p_amount_cents, p_currency, v_purchase.amount_cents, v_purchase.currency;
+    END IF;
+
+    v_change := change_subscription_plan(v_purchase.user_id, v_purchase.plan_id, v_purchase.billing_cycle, v_purchase.id);
+
+    UPDATE credit_purchases
+    SET status = 'completed',
+        credits = (v_change->>'prorated_credits')::INTEGER,
+        provider_payment_id = p_provider_payment_id,
+        receipt_number = 'QF-' || to_char(NOW() AT TIME ZONE 'UTC', 'YYYYMMDD') || '-'
+            || upper(substr(replace(v_purchase.id::TEXT, '-', ''), 1, 10)),
+        transaction_id = (v_change->>'transaction_id')::UUID,
+        completed_at = NOW(),
+        updated_at = NOW()
+    WHERE id = v_purchase.id
+    RETURNING * INTO v_purchase;
+
+    RETURN v_purchase;
+END;
+$$ language 'plpgsql';
+
+-- Cancels a user's subscription at the end of its paid period. It keeps its
+-- plan until run_subscription_cycle expires it and falls back to Free; a
+-- scheduled downgrade is dropped.
+CREATE OR REPLACE FUNCTION cancel_subscription(p_user_id UUID, p_reason TEXT)
+RETURNS user_subscriptions AS $$
+DECLARE
+    v_subscription user_subscriptions;
+BEGIN
+    SELECT * INTO v_subscription FROM user_subscriptions
+    WHERE user_id = p_user_id AND status IN ('active', 'cancelled')
+    ORDER BY status = 'active' DESC, start_date DESC
+    LIMIT 1
+    FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'no active subscription found' USING ERRCODE = 'P0002';
+    END IF;
+    IF v_subscription.status = 'cancelled' THEN
+        RAISE EXCEPTION 'subscription is cancelled';
+    END IF;
+    IF EXISTS (SELECT 1 FROM subscription_plans WHERE id = v_subscription.plan_id AND plan_type = 'free') THEN
+        RAISE EXCEPTION 'free plan cannot be cancelled';
+    END IF;
+
+    UPDATE user_subscriptions
+    SET status = 'cancelled',
+        auto_renew = FALSE,
+        cancellation_reason = p_reason,
+        cancelled_at = NOW(),
+        end_date = COALESCE(next_billing_date, NOW()),
+        scheduled_plan_id = NULL, scheduled_billing_cycle = NULL, scheduled_change_at = NULL,
+        updated_at = NOW()
+    WHERE id = v_subscription.id
+    RETURNING * INTO v_subscription;
+
+    RETURN v_subscription;
+END;
+$$ language 'plpgsql';
+
+-- Whether a credit transaction or purchase of (p_user_id, p_org_id) belongs
+-- to a wallet: an organization's pool, or a user's personal wallet
+CREATE OR REPLACE FUNCTION credit_wallet_owns(p_wallet user_credits, p_user_id UUID, p_org_id UUID)
+RETURNS BOOLEAN AS $$
+    SELECT CASE WHEN p_wallet.org_id IS NULL
+        THEN p_user_id = p_wallet.user_id AND p_org_id IS NULL
+        ELSE p_org_id = p_wallet.org_id
+    END;
+$$ language 'sql' IMMUTABLE;
+
+-- Credit movements of a user's personal wallet (p_org_id NULL) or an
+-- organization's pool since p_from, summed per UTC month and transaction
+-- type. amount_cents sums the money recorded on the transactions: plan
+-- charges, package payments and overage.
+CREATE OR REPLACE FUNCTION credit_usage_by_month(p_user_id UUID, p_org_id UUID, p_from TIMESTAMPTZ)
+RETURNS TABLE (month TIMESTAMPTZ, transaction_type VARCHAR, transactions BIGINT, credits BIGINT, amount_cents NUMERIC) AS $$
+    SELECT date_trunc('month', t.created_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC',
+        t.transaction_type,
+        COUNT(*),
+        SUM(t.credit_change),
+        SUM(COALESCE((t.metadata->>'amount_cents')::NUMERIC, (t.metadata->>'charge_cents')::NUMERIC, 0))
+    FROM credit_transactions t
+    WHERE t.created_at >= p_from
+      AND (p_org_id IS NULL AND t.user_id = p_user_id AND t.org_id IS NULL OR t.org_id = p_org_id)
+    GROUP BY 1, 2
+    ORDER BY 1, 2;
+$$ language 'sql' STABLE;
+
+-- Credits consumed by assessments from a user's personal wallet (p_org_id
+-- NULL) or an organization's pool in [p_from, p_to), summed per UTC p_bucket
+-- (day, week or month), assessment type and acting user
+CREATE OR REPLACE FUNCTION credit_consumption(p_user_id UUID, p_org_id UUID, p_from TIMESTAMPTZ, p_to TIMESTAMPTZ, p_bucket TEXT)
+RETURNS TABLE (bucket TIMESTAMPTZ, assessment_type VARCHAR, user_id UUID, transactions BIGINT, credits BIGINT) AS $$
+    SELECT date_trunc(p_bucket, t.created_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC',
+        COALESCE(a.assessment_type, 'other'),
+        t.user_id,
+        COUNT(*),
+        -SUM(t.credit_change)
+    FROM credit_transactions t
+    LEFT JOIN assessments a ON a.id = t.assessment_id
+    WHERE t.transaction_type = 'assessment_usage'
+      AND t.created_at >= p_from AND t.created_at < p_to
+      AND (p_org_id IS NULL AND t.user_id = p_user_id AND t.org_id IS NULL OR t.org_id = p_org_id)
+    GROUP BY 1, 2, 3
+    ORDER BY 1, 2, 3;
+$$ language 'sql' STABLE;
+
+-- Builds the invoice of a wallet for [p_period_start, p_period_end): plan
+-- charges and prorated upgrades recorded on the ledger, credit packages and
+-- plan upgrades completed in the period (already paid at checkout) and
+-- overage net of unused overage credits. Returns the existing invoice when
+-- the period was invoiced before, or NULL when there is nothing to bill.
+CREATE OR REPLACE FUNCTION generate_invoice(p_wallet_id UUID, p_period_start TIMESTAMPTZ, p_period_end TIMESTAMPTZ)
+RETURNS invoices AS $$
+DECLARE
+    v_wallet user_credits;
+    v_invoice invoices;
+    v_row RECORD;
+    v_lines JSONB := '[]';
+    v_charge INTEGER;
+    v_paid INTEGER := 0;
+    v_total INTEGER;
+    v_overage_credits INTEGER;
+    v_overage_cents NUMERIC;
+BEGIN
+    SELECT * INTO v_invoice FROM invoices WHERE wallet_id = p_wallet_id AND period_start = p_period_start;
+    IF FOUND THEN
+        RETURN v_invoice;
+    END IF;
+
+    SELECT * INTO v_wallet FROM user_credits WHERE id = p_wallet_id;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'user credits not found' USING ERRCODE = 'P0002';
+    END IF;
+
+    FOR v_row IN
+        SELECT * FROM credit_transactions t
+        WHERE credit_wallet_owns(v_wallet, t.user_id, t.org_id)
+          AND t.transaction_type IN ('subscription_allocation', 'subscription_proration')
+          AND COALESCE((t.metadata->>'charge_cents')::INTEGER, 0) > 0
+          AND t.created_at >= p_period_start AND t.created_at < p_period_end
+        ORDER BY t.created_at
+    LOOP
+        v_charge := (v_row.metadata->>'charge_cents')::INTEGER;
+        v_lines := v_lines || jsonb_build_array(jsonb_build_object(
+            'type', CASE WHEN v_row.transaction_type = 'subscription_proration' THEN 'proration' ELSE 'plan' END,
+            'description', CASE WHEN v_row.transaction_type = 'subscription_proration'
+                THEN format('Upgrade to the %s plan, prorated', v_row.metadata->>'plan_name')
+                ELSE format('%s plan, %s', v_row.metadata->>'plan_name', v_row.metadata->>'billing_cycle') END,
+            'quantity', 1,
+            'unit_amount_cents', v_charge,
+            'amount_cents', v_charge,
+            'date', v_row.created_at,
+            'reference', v_row.id));
+    END LOOP;
+
+    FOR v_row IN
+        SELECT p.*, k.package_name, s.plan_name FROM credit_purchases p
+        LEFT JOIN credit_packages k ON k.id = p.package_id
+        LEFT JOIN subscription_plans s ON s.id = p.plan_id
+        WHERE p.status = 'completed' AND credit_wallet_owns(v_wallet, p.user_id, p.org_id)
+          AND p.completed_at >= p_period_start AND p.completed_at < p_period_end
+        ORDER BY p.completed_at
+    LOOP
+        v_lines := v_lines || jsonb_build_array(jsonb_build_object(
+            'type', CASE WHEN v_row.source = 'plan_upgrade' THEN 'plan_upgrade' ELSE 'credit_package' END,
+            'description', CASE WHEN v_row.source = 'plan_upgrade'
+                THEN format('Upgrade to the %s plan, %s', v_row.plan_name, v_row.billing_cycle)
+                ELSE format('%s (%s credits)', v_row.package_name, v_row.credits) END,
+            'quantity', 1,
+            'unit_amount_cents', v_row.amount_cents,
+            'amount_cents', v_row.amount_cents,
+            'date', v_row.completed_at,
+            'reference', v_row.receipt_number));
+        v_paid := v_paid + v_row.amount_cents;
+    END LOOP;
+
+    SELECT COALESCE(SUM(t.credit_change), 0), COALESCE(SUM((t.metadata->>'amount_cents')::NUMERIC), 0)
+    INTO v_overage_credits, v_overage_cents
+    FROM credit_transactions t
+    WHERE credit_wallet_owns(v_wallet, t.user_id, t.org_id)
+      AND t.transaction_type IN ('overage_accrual', 'overage_reversal')
+      AND t.created_at >= p_period_start AND t.created_at < p_period_end;
+    IF v_overage_credits <> 0 THEN
+        v_lines := v_lines || jsonb_build_array(jsonb_build_object(
+            'type', 'overage',
+            'description', CASE WHEN v_overage_credits > 0
+                THEN format('Overage, %s credits', v_overage_credits)
+                ELSE format('Unused overage credits returned, %s credits', -v_overage_credits) END,
+            'quantity', v_overage_credits,
+            'unit_amount_cents', ROUND(v_overage_cents / v_overage_credits, 4),
+            'amount_cents', ROUND(v_overage_cents)));
+    END IF;
+
+    IF jsonb_array_length(v_lines) = 0 THEN
+        RETURN NULL;
+    END IF;
+
+    SELECT SUM((l->>'amount_cents')::INTEGER) INTO v_total FROM jsonb_array_elements(v_lines) l;
+
+    INSERT INTO invoices (invoice_number, wallet_id, user_id, org_id, period_start, period_end, line_items,
+        total_cents, amount_paid_cents, amount_due_cents, status)
+    VALUES ('INV-' || to_char(p_period_start AT TIME ZONE 'UTC', 'YYYYMM') || '-' || lpad(nextval('invoice_number_seq')::TEXT, 6, '0'),
+        v_wallet.id, v_wallet.user_id, v_wallet.org_id, p_period_start, p_period_end, v_lines,
+        v_total, v_paid, GREATEST(v_total - v_paid, 0), CASE WHEN v_total > v_paid THEN 'open' ELSE 'paid' END)
+    ON CONFLICT (wallet_id, period_start) DO NOTHING
+    RETURNING * INTO v_invoice;
+    IF NOT FOUND THEN
+        SELECT * INTO v_invoice FROM invoices WHERE wallet_id = p_wallet_id AND period_start = p_period_start;
+    END IF;
+    RETURN v_invoice;
+END;
+$$ language 'plpgsql';
+
+-- Invoices up to p_batch_size wallets with billable activity in the UTC
+-- month starting p_period_start and no invoice for it yet. Returns how many
+-- wallets were handled; a full batch means more may be left.
+CREATE OR REPLACE FUNCTION generate_monthly_invoices(p_period_start TIMESTAMPTZ, p_batch_size INTEGER DEFAULT 200)
+RETURNS INTEGER AS $$
+DECLARE
+    v_period_end TIMESTAMPTZ := p_period_start + INTERVAL '1 month';
+    v_wallet_id UUID;
+    v_count INTEGER := 0;
+BEGIN
+    FOR v_wallet_id IN
+        SELECT w.id FROM user_credits w
+        WHERE NOT EXISTS (SELECT 1 FROM invoices i WHERE i.wallet_id = w.id AND i.period_start = p_period_start)
+          AND (EXISTS (
+                SELECT 1 FROM credit_transactions t
+                WHERE credit_wallet_owns(w, t.user_id, t.org_id)
+                  AND t.created_at >= p_period_start AND t.created_at < v_period_end
+                  AND (t.transaction_type IN ('overage_accrual', 'overage_reversal')
+                    OR t.transaction_type IN ('subscription_allocation', 'subscription_proration')
+                       AND COALESCE((t.metadata->>'charge_cents')::INTEGER, 0) > 0))
+            OR EXISTS (
+                SELECT 1 FROM credit_purchases p
+                WHERE p.status = 'completed' AND credit_wallet_owns(w, p.user_id, p.org_id)
+                  AND p.completed_at >= p_period_start AND p.completed_at < v_period_end))
+        ORDER BY w.id
+        LIMIT p_batch_size
+    LOOP
+        PERFORM generate_invoice(v_wallet_id, p_period_start, v_period_end);
+        v_count := v_count + 1;
+    END LOOP;
+    RETURN v_count;
+END;
+$$ language 'plpgsql';
+
+-- Admin user search: profiles whose ID equals p_query or whose email, name,
+-- company or phone contain it, optionally with a status, newest first
+CREATE OR REPLACE FUNCTION search_user_profiles(p_query TEXT, p_status TEXT, p_limit INTEGER, p_offset INTEGER)
+RETURNS SETOF user_profiles AS $$
+    SELECT * FROM user_profiles p
+    WHERE (p_status IS NULL OR p.status = p_status)
+      AND (COALESCE(p_query, '') = ''
+        OR p.id::TEXT = p_query
+        OR p.email ILIKE '%' || p_query || '%'
+        OR p.full_name ILIKE '%' || p_query || '%'
+        OR p.company_name ILIKE '%' || p_query || '%'
+        OR p.phone LIKE '%' || p_query || '%')
+    ORDER BY p.created_at DESC
+    LIMIT p_limit OFFSET p_offset;
+$$ language 'sql' STABLE;
+
 -- Function to auto-create user profile after signup
 CREATE OR REPLACE FUNCTION handle_new_user() 
 RETURNS TRIGGER AS $$
@@ -381,7 +2329,9 @@ ALTER TABLE user_credits ADD CONSTRAINT IF NOT EXISTS chk_credits_non_negative C
     subscription_credits >= 0 AND 
     recharged_credits >= 0 AND 
     bonus_credits >= 0 AND 
-    used_credits >= 0
+    overage_credits >= 0 AND
+    used_credits >= 0 AND
+    reserved_credits >= 0
 );
 
 -- =====================================================================
@@ -398,7 +2348,7 @@ DELETE FROM assessments WHERE user_id IS NULL;
 DO $$
 BEGIN
     RAISE NOTICE '✅ QuarkfinAI Multi-Tenant Production Schema Setup Complete';
-    RAISE NOTICE '📊 Tables created: user_profiles, phone_verifications, user_sessions, subscription_plans, user_subscriptions, user_credits, credit_packages, credit_transactions, assessments, user_activity_logs';
+    RAISE NOTICE '📊 Tables created: user_profiles, phone_verifications, sms_messages, user_two_factor, user_sessions, subscription_plans, user_subscriptions, user_credits, credit_packages, assessment_pricing, credit_reservations, credit_transactions, credit_ledger_entries, credit_purchases, payment_events, credit_alert_rules, invoices, assessments, user_activity_logs, assessment_batches, webhook_endpoints, webhook_deliveries, notification_rules, notification_digest_items, api_keys, organizations, organization_members, organization_roles, organization_invites, scheduled_jobs, idempotency_keys';
     RAISE NOTICE '🔒 Row Level Security enabled for data isolation';
     RAISE NOTICE '📈 Indexes created for optimal performance';
     RAISE NOTICE '🎯 Ready for Monday production launch!';
@@ -6,8 +6,10 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
 	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/db"
 	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/scrapers"
 	"github.com/gin-gonic/gin"
@@ -17,6 +19,8 @@ import (
 // Assessment represents the assessment data model
 type Assessment struct {
 	Id          int64     `json:"id"`
+	UserID      string    `json:"user_id"`
+	OrgID       *string   `json:"org_id"`
 	CreatedAt   time.Time `json:"created_at"`
 	Website     string    `json:"website"`
 	CountryCode string    `json:"country_code"`
@@ -28,6 +32,8 @@ type Assessment struct {
 
 // AssessmentInsert represents the data for inserting a new assessment
 type AssessmentInsert struct {
+	UserID      string `json:"user_id"`
+	OrgID       *string `json:"org_id,omitempty"`
 	Website     string `json:"website"`
 	CountryCode string `json:"country_code"`
 	Status      string `json:"status"`
@@ -58,6 +64,20 @@ type AssessmentResponse struct {
 
 // CreateAssessmentHandler handles POST /api/v1/assessments
 func CreateAssessmentHandler(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
 	var req CreateAssessmentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -77,6 +97,8 @@ func CreateAssessmentHandler(c *gin.Context) {
 
 	// Create initial assessment record
 	newAssessment := AssessmentInsert{
+		UserID:      userID,
+		OrgID:       workspace.OrgIDPtr(),
 		Website:     req.Website,
 		CountryCode: req.CountryCode,
 		Status:      "pending",
@@ -106,6 +128,7 @@ func CreateAssessmentHandler(c *gin.Context) {
 
 	// Start risk assessment in background
 	go runRiskAssessment(createdAssessment.Id, req.Website, req.CountryCode, dbClient)
+	auth.SetAuditResource(c, strconv.FormatInt(createdAssessment.Id, 10))
 
 	// Return the created assessment
 	response := AssessmentResponse{
@@ -122,7 +145,11 @@ func CreateAssessmentHandler(c *gin.Context) {
 // GetAssessmentHandler handles GET /api/v1/assessments/:id
 func GetAssessmentHandler(c *gin.Context) {
 	id := c.Param("id")
-	
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid assessment ID"})
+		return
+	}
+
 	// Create database client
 	supabaseURL := os.Getenv("SUPABASE_URL")
 	supabaseKey := os.Getenv("SUPABASE_SERVICE_KEY")
@@ -157,6 +184,14 @@ func GetAssessmentHandler(c *gin.Context) {
 
 	assessment := results[0]
 
+	orgID := ""
+	if assessment.OrgID != nil {
+		orgID = *assessment.OrgID
+	}
+	if !auth.AuthorizeAssessmentAccess(c, assessment.UserID, orgID) {
+		return
+	}
+
 	// Build response
 	response := AssessmentResponse{
 		Id:          assessment.Id,
@@ -186,6 +221,20 @@ func GetAssessmentHandler(c *gin.Context) {
 
 // ListAssessmentsHandler handles GET /api/v1/assessments
 func ListAssessmentsHandler(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
 	// Create database client
 	supabaseURL := os.Getenv("SUPABASE_URL")
 	supabaseKey := os.Getenv("SUPABASE_SERVICE_KEY")
@@ -197,9 +246,15 @@ func ListAssessmentsHandler(c *gin.Context) {
 		return
 	}
 
-	// Query all assessments
+	// Query the workspace's assessments
 	var results []Assessment
-	body, _, err := dbClient.From("assessments").Select("*", "", false).Order("created_at", &postgrest.OrderOpts{Ascending: false}).Execute()
+	query := dbClient.From("assessments").Select("*", "", false)
+	if workspace.IsOrganization() {
+		query = query.Eq("org_id", workspace.OrgID)
+	} else {
+		query = query.Eq("user_id", userID).Is("org_id", "null")
+	}
+	body, _, err := query.Order("created_at", &postgrest.OrderOpts{Ascending: false}).Execute()
 	if err != nil {
 		log.Printf("DB Query Error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query assessments"})
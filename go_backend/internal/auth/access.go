@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuthorizeAssessmentAccess checks that the authenticated user may access an
+// assessment owned by ownerID within organization orgID (empty for personal
+// assessments). Organization assessments are visible to every member;
+// personal ones only to their owner. When access is denied it writes the
+// 401/403 response (500 if membership cannot be looked up) and returns
+// false, so handlers can simply return.
+func AuthorizeAssessmentAccess(c *gin.Context, ownerID, orgID string) bool {
+	userID := GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return false
+	}
+
+	var allowed bool
+	switch key := GetAPIKey(c); {
+	case key != nil && key.OrgID != nil && *key.OrgID != orgID:
+		// Organization API keys only reach that organization's assessments
+		allowed = false
+	case orgID != "":
+		_, err := GetOrganizationMembership(orgID, userID)
+		if err != nil && !errors.Is(err, ErrNotOrganizationMember) {
+			log.Printf("❌ AuthorizeAssessmentAccess: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to verify organization membership",
+				"code":  "DATABASE_FETCH_ERROR",
+			})
+			return false
+		}
+		allowed = err == nil
+	default:
+		allowed = ownerID != "" && ownerID == userID
+	}
+
+	if !allowed {
+		log.Printf("🚫 AuthorizeAssessmentAccess: User %s denied access to assessment owned by %s (org %q)", userID, ownerID, orgID)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "You do not have access to this assessment",
+			"code":  "ASSESSMENT_ACCESS_DENIED",
+		})
+		return false
+	}
+
+	return true
+}
+
+// AuthorizeAssessmentPermission is AuthorizeAssessmentAccess for actions that
+// need a permission in the assessment's own organization (route checks only
+// cover the workspace named by the request). Owners of personal assessments
+// hold every permission.
+func AuthorizeAssessmentPermission(c *gin.Context, ownerID, orgID, permission string) bool {
+	if !AuthorizeAssessmentAccess(c, ownerID, orgID) {
+		return false
+	}
+	if orgID == "" {
+		return true
+	}
+
+	allowed, err := HasOrganizationPermission(orgID, GetUserID(c), permission)
+	if err != nil {
+		log.Printf("❌ AuthorizeAssessmentPermission: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load organization permissions",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":      "Your role does not allow this action",
+			"code":       "PERMISSION_DENIED",
+			"permission": permission,
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	supa "github.com/nedpals/supabase-go"
+)
+
+func TestAuthorizeAssessmentAccessMembershipLookup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	failing := true
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			http.Error(w, `{"message":"unavailable"}`, http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer storage.Close()
+	supabaseClient = supa.CreateClient(storage.URL, "service-key")
+	defer func() { supabaseClient = nil }()
+
+	authorize := func() int {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Set("user_id", "user-1")
+		if AuthorizeAssessmentAccess(c, "user-2", "org-1") {
+			t.Fatal("access granted")
+		}
+		return recorder.Code
+	}
+
+	// A failed lookup is a server error, not a denial
+	if code := authorize(); code != http.StatusInternalServerError {
+		t.Errorf("expected 500 when the lookup fails, got %d", code)
+	}
+	failing = false
+	if code := authorize(); code != http.StatusForbidden {
+		t.Errorf("expected 403 for a non-member, got %d", code)
+	}
+}
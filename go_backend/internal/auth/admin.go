@@ -0,0 +1,344 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Account statuses of user_profiles
+const (
+	AccountActive    = "active"
+	AccountSuspended = "suspended"
+	AccountDeleted   = "deleted"
+)
+
+// Audit actions of the admin API. Events are recorded for the acting admin
+// with the affected user as the resource.
+const (
+	AuditAdminUserViewed           = "admin.user_viewed"
+	AuditAdminCreditsGranted       = "admin.credits_granted"
+	AuditAdminCreditsRevoked       = "admin.credits_revoked"
+	AuditAdminUserSuspended        = "admin.user_suspended"
+	AuditAdminUserReactivated      = "admin.user_reactivated"
+	AuditAdminPlanChanged          = "admin.plan_changed"
+	AuditAdminImpersonationStarted = "admin.impersonation_started"
+)
+
+// ImpersonationHeader carries a token from POST /api/admin/users/:id/impersonate.
+// Platform admins send it with their own credentials to read as the user.
+const ImpersonationHeader = "X-Impersonate-Token"
+
+// impersonationTTL is how long an impersonation token is valid
+const impersonationTTL = 30 * time.Minute
+
+// impersonationKey signs impersonation tokens. InitAuth derives it from the
+// service key with HKDF under its own label.
+var impersonationKey []byte
+
+var (
+	errAccountSuspended = errors.New("account is suspended")
+
+	// ErrInsufficientBonusCredits matches the exception adjust_bonus_credits
+	// raises when revoking more bonus credits than are available
+	ErrInsufficientBonusCredits = errors.New("insufficient bonus credits")
+)
+
+// accountState caches what the middleware last saw of a user's profile
+type accountState struct {
+	email         string
+	status        string
+	platformAdmin bool
+	checkedAt     time.Time
+}
+
+var accountCache = struct {
+	sync.Mutex
+	entries map[string]accountState
+}{entries: map[string]accountState{}}
+
+// loadAccountState returns the user's status and admin flag, re-read every
+// sessionCheckInterval. Users without a profile yet are active.
+func loadAccountState(userID string) (accountState, error) {
+	accountCache.Lock()
+	state, found := accountCache.entries[userID]
+	accountCache.Unlock()
+	if found && time.Since(state.checkedAt) < sessionCheckInterval {
+		return state, nil
+	}
+
+	if supabaseClient == nil {
+		return accountState{status: AccountActive, checkedAt: time.Now()}, nil
+	}
+
+	var profiles []UserProfile
+	err := supabaseClient.DB.From("user_profiles").
+		Select("id,email,status,is_platform_admin").
+		Eq("id", userID).
+		Execute(&profiles)
+	if err != nil {
+		return accountState{}, fmt.Errorf("failed to fetch account status: %v", err)
+	}
+
+	state = accountState{status: AccountActive, checkedAt: time.Now()}
+	if len(profiles) > 0 {
+		state.email = profiles[0].Email
+		state.platformAdmin = profiles[0].IsPlatformAdmin
+		if profiles[0].Status != "" {
+			state.status = profiles[0].Status
+		}
+	}
+
+	accountCache.Lock()
+	accountCache.entries[userID] = state
+	accountCache.Unlock()
+	return state, nil
+}
+
+// forgetAccountState drops the cached state so this instance sees a status
+// change right away
+func forgetAccountState(userID string) {
+	accountCache.Lock()
+	delete(accountCache.entries, userID)
+	accountCache.Unlock()
+}
+
+// checkAccountActive returns errAccountSuspended for suspended and deleted
+// users. Storage errors are logged and the request allowed.
+func checkAccountActive(userID string) error {
+	state, err := loadAccountState(userID)
+	if err != nil {
+		log.Printf("⚠️ checkAccountActive: %v", err)
+		return nil
+	}
+	if state.status != AccountActive {
+		return errAccountSuspended
+	}
+	return nil
+}
+
+// rejectSuspendedAccount aborts the request of a suspended user with 403
+func rejectSuspendedAccount(c *gin.Context, userID string) bool {
+	if checkAccountActive(userID) != errAccountSuspended {
+		return false
+	}
+	log.Printf("🚫 AuthMiddleware: Account %s is suspended", userID)
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "This account has been suspended",
+		"code":  "ACCOUNT_SUSPENDED",
+	})
+	c.Abort()
+	return true
+}
+
+// RequirePlatformAdmin rejects requests of users who are not platform admins
+// (user_profiles.is_platform_admin). Use after AuthMiddleware.
+func RequirePlatformAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := GetUserID(c)
+		if userID == "" || GetAPIKey(c) != nil || GetImpersonatorID(c) != "" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Platform admin access required",
+				"code":  "PLATFORM_ADMIN_REQUIRED",
+			})
+			c.Abort()
+			return
+		}
+
+		state, err := loadAccountState(userID)
+		if err != nil {
+			log.Printf("❌ RequirePlatformAdmin: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Failed to verify admin access",
+				"code":  "AUTH_SERVICE_UNAVAILABLE",
+			})
+			c.Abort()
+			return
+		}
+		if !state.platformAdmin {
+			log.Printf("🚫 RequirePlatformAdmin: User %s is not a platform admin (%s)", userID, c.Request.URL.Path)
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Platform admin access required",
+				"code":  "PLATFORM_ADMIN_REQUIRED",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// issueImpersonationToken returns a token letting adminID read as userID,
+// valid until the returned time
+func issueImpersonationToken(adminID, userID string, now time.Time) (string, time.Time) {
+	expiresAt := now.Add(impersonationTTL)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(adminID + "|" + userID + "|" + strconv.FormatInt(expiresAt.Unix(), 10)))
+	return payload + "." + signImpersonationPayload(payload), expiresAt
+}
+
+// verifyImpersonationToken returns the user an impersonation token issued
+// to adminID lets them read as, if it is valid and unexpired
+func verifyImpersonationToken(token, adminID string, now time.Time) (string, bool) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(signImpersonationPayload(payload))) {
+		return "", false
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(string(decoded), "|")
+	if len(parts) != 3 || parts[0] != adminID {
+		return "", false
+	}
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || now.Unix() >= expiresAt {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func signImpersonationPayload(payload string) string {
+	mac := hmac.New(sha256.New, impersonationKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// applyImpersonation switches the request to the user named by an
+// impersonation token. Impersonation is read-only and only for admins who
+// still are platform admins. It writes the error response and returns false
+// when the token cannot be used.
+func applyImpersonation(c *gin.Context, admin *authenticatedUser, token string) bool {
+	reject := func(status int, message, code string) bool {
+		log.Printf("🚫 AuthMiddleware: Impersonation by %s rejected on %s %s: %s", admin.ID, c.Request.Method, c.Request.URL.Path, code)
+		c.JSON(status, gin.H{
+			"error": message,
+			"code":  code,
+		})
+		c.Abort()
+		return false
+	}
+
+	userID, ok := verifyImpersonationToken(token, admin.ID, time.Now())
+	if !ok {
+		return reject(http.StatusUnauthorized, "Invalid or expired impersonation token", "INVALID_IMPERSONATION_TOKEN")
+	}
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return reject(http.StatusForbidden, "Impersonation is read-only", "IMPERSONATION_READ_ONLY")
+	}
+
+	adminState, err := loadAccountState(admin.ID)
+	if err != nil || !adminState.platformAdmin {
+		return reject(http.StatusForbidden, "Platform admin access required", "PLATFORM_ADMIN_REQUIRED")
+	}
+	target, err := loadAccountState(userID)
+	if err != nil {
+		log.Printf("❌ AuthMiddleware: %v", err)
+		return reject(http.StatusServiceUnavailable, "Failed to load impersonated user", "AUTH_SERVICE_UNAVAILABLE")
+	}
+
+	log.Printf("🕵️ AuthMiddleware: Admin %s reading as user %s on %s", admin.ID, userID, c.Request.URL.Path)
+	setUserContext(c, &authenticatedUser{ID: userID, Email: target.email})
+	c.Set("impersonator_id", admin.ID)
+	return true
+}
+
+// GetImpersonatorID returns the platform admin reading as the request's
+// user, empty when the request is not impersonated
+func GetImpersonatorID(c *gin.Context) string {
+	return c.GetString("impersonator_id")
+}
+
+// SearchUsers returns the profiles matching query (an ID, or part of an
+// email, name, company or phone) and status, both optional
+func SearchUsers(query, status string, limit, offset int) ([]UserProfile, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var profiles []UserProfile
+	err := supabaseClient.DB.Rpc("search_user_profiles", map[string]interface{}{
+		"p_query":  strings.TrimSpace(query),
+		"p_status": optionalString(status),
+		"p_limit":  limit,
+		"p_offset": offset,
+	}).Execute(&profiles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %v", err)
+	}
+	return profiles, nil
+}
+
+// SetAccountStatus suspends or reactivates a user. Suspending signs the user
+// out everywhere; their tokens and API keys are rejected with 403 from then
+// on (within sessionCheckInterval on other instances).
+func SetAccountStatus(userID, status, reason string) (*UserProfile, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	update := map[string]interface{}{
+		"status":            status,
+		"suspended_at":      nil,
+		"suspension_reason": nil,
+		"updated_at":        time.Now().UTC().Format(time.RFC3339),
+	}
+	if status == AccountSuspended {
+		update["suspended_at"] = time.Now().UTC().Format(time.RFC3339)
+		update["suspension_reason"] = optionalString(reason)
+	}
+
+	var profiles []UserProfile
+	err := supabaseClient.DB.From("user_profiles").Update(update).Eq("id", userID).Execute(&profiles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update account status: %v", err)
+	}
+	if len(profiles) == 0 {
+		return nil, nil
+	}
+	forgetAccountState(userID)
+
+	if status == AccountSuspended {
+		revoked, err := RevokeOtherSessions(userID, "")
+		if err != nil {
+			log.Printf("⚠️ SetAccountStatus: %v", err)
+		}
+		log.Printf("⛔ SetAccountStatus: Suspended user %s, %d sessions revoked", userID, revoked)
+	} else {
+		log.Printf("✅ SetAccountStatus: User %s is now %s", userID, status)
+	}
+	return &profiles[0], nil
+}
+
+// AdjustBonusCredits grants (credits > 0) or revokes (credits < 0) bonus
+// credits of a user's personal wallet, recording the reason and the admin
+func AdjustBonusCredits(userID string, credits int, reason, adminID string) (*CreditTransaction, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var transaction CreditTransaction
+	err := supabaseClient.DB.Rpc("adjust_bonus_credits", map[string]interface{}{
+		"p_user_id":  userID,
+		"p_credits":  credits,
+		"p_reason":   reason,
+		"p_admin_id": adminID,
+	}).Execute(&transaction)
+	if err != nil {
+		return nil, raisedError(err, "bonus credits", ErrInsufficientBonusCredits, ErrCreditWalletNotFound)
+	}
+
+	log.Printf("🎁 AdjustBonusCredits: Admin %s changed bonus credits of user %s by %d: %s", adminID, userID, credits, reason)
+	return &transaction, nil
+}
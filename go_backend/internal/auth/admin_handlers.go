@@ -0,0 +1,419 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxAdminReasonLength bounds the reasons admins record with their actions
+	maxAdminReasonLength = 500
+	defaultAdminPageSize = 50
+	maxAdminPageSize     = 200
+)
+
+// AdminCreditsRequest is the body of POST /api/admin/users/:id/credits/bonus
+// and /credits/bonus/revoke
+type AdminCreditsRequest struct {
+	Credits int    `json:"credits" binding:"required,min=1"`
+	Reason  string `json:"reason" binding:"required"`
+}
+
+// AdminSuspendRequest is the body of POST /api/admin/users/:id/suspend
+type AdminSuspendRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// AdminUserResponse is a user as shown to platform admins
+type AdminUserResponse struct {
+	Profile      *UserProfile      `json:"profile"`
+	Credits      *UserCredits      `json:"credits"`
+	Subscription *UserSubscription `json:"subscription"`
+	Plan         *SubscriptionPlan `json:"plan"`
+}
+
+// SearchUsersHandler handles GET /api/admin/users?q=&status=&limit=&offset=
+func SearchUsersHandler(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && status != AccountActive && status != AccountSuspended && status != AccountDeleted {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "status must be active, suspended or deleted",
+			"code":  "INVALID_STATUS",
+		})
+		return
+	}
+	limit, offset, ok := adminPage(c)
+	if !ok {
+		return
+	}
+
+	if !requireAuthDatabase(c) {
+		return
+	}
+
+	users, err := SearchUsers(c.Query("q"), status, limit, offset)
+	if err != nil {
+		log.Printf("❌ SearchUsersHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to search users",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+	if users == nil {
+		users = []UserProfile{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":  users,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// GetAdminUserHandler handles GET /api/admin/users/:id: the user's profile,
+// wallet and subscription
+func GetAdminUserHandler(c *gin.Context) {
+	profile, ok := adminUserForRequest(c)
+	if !ok {
+		return
+	}
+
+	response := AdminUserResponse{Profile: profile}
+	credits, err := GetUserCredits(profile.ID)
+	if err != nil {
+		log.Printf("⚠️ GetAdminUserHandler: No wallet for user %s: %v", profile.ID, err)
+	}
+	response.Credits = credits
+	subscription, plan, err := GetUserSubscription(profile.ID)
+	if err != nil {
+		log.Printf("⚠️ GetAdminUserHandler: No subscription for user %s: %v", profile.ID, err)
+	}
+	response.Subscription = subscription
+	response.Plan = plan
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ListUserAssessmentsHandler handles GET /api/admin/users/:id/assessments,
+// the user's most recent assessments
+func ListUserAssessmentsHandler(c *gin.Context) {
+	profile, ok := adminUserForRequest(c)
+	if !ok {
+		return
+	}
+	limit, _, ok := adminPage(c)
+	if !ok {
+		return
+	}
+
+	var assessments []map[string]interface{}
+	err := supabaseClient.DB.From("assessments").
+		Select("*").
+		OrderBy("created_at", "desc").
+		Limit(limit).
+		Eq("user_id", profile.ID).
+		Execute(&assessments)
+	if err != nil {
+		log.Printf("❌ ListUserAssessmentsHandler: Failed to fetch assessments of user %s: %v", profile.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch assessments",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+	if assessments == nil {
+		assessments = []map[string]interface{}{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"assessments": assessments,
+		"total":       len(assessments),
+	})
+}
+
+// ListUserCreditTransactionsHandler handles GET
+// /api/admin/users/:id/credits/transactions, the user's most recent credit
+// movements in any wallet
+func ListUserCreditTransactionsHandler(c *gin.Context) {
+	profile, ok := adminUserForRequest(c)
+	if !ok {
+		return
+	}
+	limit, _, ok := adminPage(c)
+	if !ok {
+		return
+	}
+
+	var transactions []CreditTransaction
+	err := supabaseClient.DB.From("credit_transactions").
+		Select("*").
+		OrderBy("created_at", "desc").
+		Limit(limit).
+		Eq("user_id", profile.ID).
+		Execute(&transactions)
+	if err != nil {
+		log.Printf("❌ ListUserCreditTransactionsHandler: Failed to fetch transactions of user %s: %v", profile.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch credit transactions",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+	if transactions == nil {
+		transactions = []CreditTransaction{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": transactions,
+		"total":        len(transactions),
+	})
+}
+
+// GrantBonusCreditsHandler handles POST /api/admin/users/:id/credits/bonus
+func GrantBonusCreditsHandler(c *gin.Context) {
+	adjustBonusCredits(c, 1)
+}
+
+// RevokeBonusCreditsHandler handles POST /api/admin/users/:id/credits/bonus/revoke.
+// Only bonus credits the user has not spent can be revoked.
+func RevokeBonusCreditsHandler(c *gin.Context) {
+	adjustBonusCredits(c, -1)
+}
+
+func adjustBonusCredits(c *gin.Context, sign int) {
+	var req AdminCreditsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "credits (at least 1) and reason are required",
+			"code":  "INVALID_REQUEST",
+		})
+		return
+	}
+	reason, ok := adminReason(c, req.Reason)
+	if !ok {
+		return
+	}
+
+	profile, ok := adminUserForRequest(c)
+	if !ok {
+		return
+	}
+
+	transaction, err := AdjustBonusCredits(profile.ID, sign*req.Credits, reason, GetUserID(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInsufficientBonusCredits):
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "The user does not have that many unspent bonus credits",
+				"code":  "INSUFFICIENT_BONUS_CREDITS",
+			})
+		case errors.Is(err, ErrCreditWalletNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "User credits not found",
+				"code":  "CREDITS_NOT_FOUND",
+			})
+		default:
+			log.Printf("❌ adjustBonusCredits: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to update bonus credits",
+				"code":  "CREDIT_UPDATE_ERROR",
+			})
+		}
+		return
+	}
+
+	SetAuditMetadata(c, "credits", transaction.CreditChange)
+	SetAuditMetadata(c, "reason", reason)
+	SetAuditMetadata(c, "transaction_id", transaction.ID)
+	c.JSON(http.StatusOK, transaction)
+}
+
+// SuspendUserHandler handles POST /api/admin/users/:id/suspend. The user is
+// signed out everywhere and their tokens and API keys are rejected.
+func SuspendUserHandler(c *gin.Context) {
+	var req AdminSuspendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "reason is required",
+			"code":  "INVALID_REQUEST",
+		})
+		return
+	}
+	reason, ok := adminReason(c, req.Reason)
+	if !ok {
+		return
+	}
+	if c.Param("id") == GetUserID(c) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "You cannot suspend your own account",
+			"code":  "CANNOT_SUSPEND_SELF",
+		})
+		return
+	}
+
+	setAccountStatus(c, AccountSuspended, reason)
+}
+
+// ReactivateUserHandler handles POST /api/admin/users/:id/reactivate
+func ReactivateUserHandler(c *gin.Context) {
+	setAccountStatus(c, AccountActive, "")
+}
+
+func setAccountStatus(c *gin.Context, status, reason string) {
+	if _, ok := adminUserForRequest(c); !ok {
+		return
+	}
+
+	profile, err := SetAccountStatus(c.Param("id"), status, reason)
+	if err != nil || profile == nil {
+		log.Printf("❌ setAccountStatus: Failed to set user %s %s: %v", c.Param("id"), status, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update account status",
+			"code":  "ACCOUNT_UPDATE_ERROR",
+		})
+		return
+	}
+
+	SetAuditMetadata(c, "status", status)
+	if reason != "" {
+		SetAuditMetadata(c, "reason", reason)
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// ChangeUserPlanHandler handles PUT /api/admin/users/:id/subscription, with
+// the same upgrade and downgrade rules as PUT /api/auth/subscription except
+// that upgrades apply without checkout and are charged on the monthly invoice
+func ChangeUserPlanHandler(c *gin.Context) {
+	req, ok := BindChangeSubscriptionRequest(c)
+	if !ok {
+		return
+	}
+
+	profile, ok := adminUserForRequest(c)
+	if !ok {
+		return
+	}
+
+	change, err := changeSubscriptionPlan(profile.ID, req.PlanID, req.BillingCycle, true)
+	if err != nil {
+		SubscriptionErrorResponse(c, "ChangeUserPlanHandler", err)
+		return
+	}
+
+	SetAuditMetadata(c, "subscription_id", change.Subscription.ID)
+	SetAuditMetadata(c, "change", change.Change)
+	SetAuditMetadata(c, "plan_id", req.PlanID)
+	SetAuditMetadata(c, "billing_cycle", req.BillingCycle)
+	SetAuditMetadata(c, "prorated_credits", change.ProratedCredits)
+	c.JSON(http.StatusOK, change)
+}
+
+// ImpersonateUserHandler handles POST /api/admin/users/:id/impersonate. The
+// returned token, sent in X-Impersonate-Token with the admin's own
+// credentials, makes GET requests run as the user.
+func ImpersonateUserHandler(c *gin.Context) {
+	profile, ok := adminUserForRequest(c)
+	if !ok {
+		return
+	}
+	if profile.ID == GetUserID(c) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "You cannot impersonate yourself",
+			"code":  "CANNOT_IMPERSONATE_SELF",
+		})
+		return
+	}
+
+	token, expiresAt := issueImpersonationToken(GetUserID(c), profile.ID, time.Now())
+	SetAuditMetadata(c, "expires_at", expiresAt.UTC().Format(time.RFC3339))
+	OmitIdempotentResponse(c)
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"header":     ImpersonationHeader,
+		"user_id":    profile.ID,
+		"read_only":  true,
+		"expires_at": expiresAt,
+	})
+}
+
+// adminUserForRequest loads the user named by the :id parameter, writing the
+// error response when there is none
+func adminUserForRequest(c *gin.Context) (*UserProfile, bool) {
+	if !requireAuthDatabase(c) {
+		return nil, false
+	}
+
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid user ID",
+			"code":  "INVALID_USER_ID",
+		})
+		return nil, false
+	}
+
+	var profiles []UserProfile
+	if err := supabaseClient.DB.From("user_profiles").Select("*").Eq("id", userID).Execute(&profiles); err != nil {
+		log.Printf("❌ adminUserForRequest: Failed to fetch user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch user",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return nil, false
+	}
+	if len(profiles) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
+			"code":  "USER_NOT_FOUND",
+		})
+		return nil, false
+	}
+	return &profiles[0], true
+}
+
+// adminReason validates the reason an admin gives for an action
+func adminReason(c *gin.Context, reason string) (string, bool) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" || len(reason) > maxAdminReasonLength {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "reason must be between 1 and 500 characters",
+			"code":  "INVALID_REASON",
+		})
+		return "", false
+	}
+	return reason, true
+}
+
+// adminPage reads the limit and offset query parameters
+func adminPage(c *gin.Context) (int, int, bool) {
+	limit, offset := defaultAdminPageSize, 0
+	var err error
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxAdminPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "limit must be between 1 and 200",
+				"code":  "INVALID_LIMIT",
+			})
+			return 0, 0, false
+		}
+	}
+	if value := c.Query("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "offset must not be negative",
+				"code":  "INVALID_OFFSET",
+			})
+			return 0, 0, false
+		}
+	}
+	return limit, offset, true
+}
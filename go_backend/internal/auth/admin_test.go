@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestImpersonationToken(t *testing.T) {
+	impersonationKey, _ = deriveKey("service-key", "impersonation")
+	defer func() { impersonationKey = nil }()
+
+	now := time.Now()
+	token, expiresAt := issueImpersonationToken("admin-1", "user-1", now)
+	if !expiresAt.Equal(now.Add(impersonationTTL)) {
+		t.Errorf("expires at %v", expiresAt)
+	}
+	if userID, ok := verifyImpersonationToken(token, "admin-1", now); !ok || userID != "user-1" {
+		t.Errorf("verifyImpersonationToken = %q, %v", userID, ok)
+	}
+	if _, ok := verifyImpersonationToken(token, "admin-2", now); ok {
+		t.Error("token accepted for another admin")
+	}
+	if _, ok := verifyImpersonationToken(token, "admin-1", now.Add(impersonationTTL)); ok {
+		t.Error("expired token accepted")
+	}
+
+	// Tokens do not depend on the two-factor key
+	twoFactorKey, _ = deriveKey("service-key", "two-factor")
+	defer func() { twoFactorKey = nil }()
+	if _, ok := verifyImpersonationToken(token, "admin-1", now); !ok {
+		t.Error("token rejected after the two-factor key changed")
+	}
+	if hmac.Equal(twoFactorKey, impersonationKey) {
+		t.Error("two-factor and impersonation keys are the same")
+	}
+
+	payload, signature, _ := strings.Cut(token, ".")
+	if _, ok := verifyImpersonationToken(payload+"x."+signature, "admin-1", now); ok {
+		t.Error("tampered token accepted")
+	}
+}
+
+func TestApplyImpersonation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	impersonationKey, _ = deriveKey("service-key", "impersonation")
+	defer func() { impersonationKey = nil }()
+
+	now := time.Now()
+	accountCache.Lock()
+	accountCache.entries["admin-1"] = accountState{status: AccountActive, platformAdmin: true, checkedAt: now}
+	accountCache.entries["admin-2"] = accountState{status: AccountActive, checkedAt: now}
+	accountCache.entries["user-1"] = accountState{email: "user@example.com", status: AccountActive, checkedAt: now}
+	accountCache.Unlock()
+	defer func() {
+		for _, id := range []string{"admin-1", "admin-2", "user-1"} {
+			forgetAccountState(id)
+		}
+	}()
+
+	tests := []struct {
+		admin  string
+		method string
+		status int
+	}{
+		{"admin-1", http.MethodGet, http.StatusOK},
+		{"admin-1", http.MethodPost, http.StatusForbidden},   // read-only
+		{"admin-1", http.MethodDelete, http.StatusForbidden}, // read-only
+		{"admin-2", http.MethodGet, http.StatusForbidden},    // no longer a platform admin
+	}
+	for _, tt := range tests {
+		token, _ := issueImpersonationToken(tt.admin, "user-1", now)
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(tt.method, "/api/v1/assessments", nil)
+		setUserContext(c, &authenticatedUser{ID: tt.admin})
+
+		ok := applyImpersonation(c, &authenticatedUser{ID: tt.admin}, token)
+		if ok != (tt.status == http.StatusOK) || (!ok && rec.Code != tt.status) {
+			t.Errorf("%s %s: ok = %v, status %d, want %d", tt.admin, tt.method, ok, rec.Code, tt.status)
+			continue
+		}
+		if ok && (GetUserID(c) != "user-1" || GetUserEmail(c) != "user@example.com" || GetImpersonatorID(c) != tt.admin) {
+			t.Errorf("impersonated context: user %q (%q), impersonator %q", GetUserID(c), GetUserEmail(c), GetImpersonatorID(c))
+		}
+	}
+}
+
+func TestRequirePlatformAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	now := time.Now()
+	accountCache.Lock()
+	accountCache.entries["admin-1"] = accountState{status: AccountActive, platformAdmin: true, checkedAt: now}
+	accountCache.entries["user-1"] = accountState{status: AccountActive, checkedAt: now}
+	accountCache.Unlock()
+	defer func() {
+		forgetAccountState("admin-1")
+		forgetAccountState("user-1")
+	}()
+
+	tests := []struct {
+		userID       string
+		impersonator string
+		want         int
+	}{
+		{"admin-1", "", http.StatusOK},
+		{"user-1", "", http.StatusForbidden},
+		{"user-1", "admin-1", http.StatusForbidden}, // impersonated requests never reach the admin API
+		{"", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			if tt.userID != "" {
+				c.Set("user_id", tt.userID)
+			}
+			if tt.impersonator != "" {
+				c.Set("impersonator_id", tt.impersonator)
+			}
+		})
+		router.GET("/api/admin/users", RequirePlatformAdmin(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/users", nil))
+		if rec.Code != tt.want {
+			t.Errorf("user %q impersonated by %q: status %d, want %d", tt.userID, tt.impersonator, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestSuspendedAccountRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	accountCache.Lock()
+	accountCache.entries["user-1"] = accountState{status: AccountSuspended, checkedAt: time.Now()}
+	accountCache.Unlock()
+	defer forgetAccountState("user-1")
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	if !rejectSuspendedAccount(c, "user-1") || rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "ACCOUNT_SUSPENDED") {
+		t.Errorf("suspended account: status %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Users without a profile yet are active
+	rec = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(rec)
+	if rejectSuspendedAccount(c, "user-2") {
+		t.Error("user without a profile rejected")
+	}
+	forgetAccountState("user-2")
+}
+
+func TestUpdateProfileProtectedFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, body := range []string{`{"status":"active"}`, `{"is_platform_admin":true}`, `{"full_name":"A","suspension_reason":null}`} {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPut, "/api/auth/profile", strings.NewReader(body))
+		c.Set("user_id", "user-1")
+
+		UpdateProfileHandler(c)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "PROTECTED_FIELD") {
+			t.Errorf("%s: status %d %s", body, rec.Code, rec.Body.String())
+		}
+	}
+}
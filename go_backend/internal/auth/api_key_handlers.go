@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ListAPIKeysHandler handles GET /api/auth/api-keys
+func ListAPIKeysHandler(c *gin.Context) {
+	userID := GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireAuthDatabase(c) {
+		return
+	}
+
+	var keys []APIKey
+	err := supabaseClient.DB.From("api_keys").
+		Select("*").
+		OrderBy("created_at", "desc").
+		Eq("user_id", userID).
+		Execute(&keys)
+	if err != nil {
+		log.Printf("❌ ListAPIKeysHandler: Failed to fetch keys for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch API keys",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": keys,
+		"total":    len(keys),
+		"scopes":   SupportedAPIKeyScopes,
+	})
+}
+
+// CreateAPIKeyHandler handles POST /api/auth/api-keys. The plaintext key is
+// only returned in this response.
+func CreateAPIKeyHandler(c *gin.Context) {
+	userID := GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireAuthDatabase(c) {
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"code":    "INVALID_REQUEST",
+			"details": err.Error(),
+		})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !containsString(SupportedAPIKeyScopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":  "Unsupported API key scope: " + scope,
+				"code":   "INVALID_API_KEY_SCOPES",
+				"scopes": SupportedAPIKeyScopes,
+			})
+			return
+		}
+	}
+
+	if req.OrgID != "" {
+		allowed, err := HasOrganizationPermission(req.OrgID, userID, PermOrganizationManage)
+		if err != nil || !allowed {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Your organization role does not allow issuing organization API keys",
+				"code":  "ORGANIZATION_ACCESS_DENIED",
+			})
+			return
+		}
+	}
+
+	allowed, err := UserHasFeature(userID, FeatureAPIAccess)
+	if err != nil {
+		log.Printf("❌ CreateAPIKeyHandler: Failed to check plan for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check subscription plan",
+			"code":  "SUBSCRIPTION_FETCH_ERROR",
+		})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "API access is not included in your plan",
+			"code":  "FEATURE_NOT_AVAILABLE",
+		})
+		return
+	}
+
+	key, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		log.Printf("❌ CreateAPIKeyHandler: Failed to generate key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate API key",
+			"code":  "API_KEY_CREATE_ERROR",
+		})
+		return
+	}
+
+	record := map[string]interface{}{
+		"id":       uuid.NewString(),
+		"user_id":  userID,
+		"name":     req.Name,
+		"prefix":   prefix,
+		"key_hash": hash,
+		"scopes":   req.Scopes,
+	}
+	if req.OrgID != "" {
+		record["org_id"] = req.OrgID
+	}
+	if req.ExpiresInDays != nil {
+		record["expires_at"] = time.Now().UTC().AddDate(0, 0, *req.ExpiresInDays).Format(time.RFC3339)
+	}
+
+	var results []APIKey
+	if err := supabaseClient.DB.From("api_keys").Insert(record).Execute(&results); err != nil || len(results) == 0 {
+		log.Printf("❌ CreateAPIKeyHandler: Failed to insert key for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create API key",
+			"code":  "API_KEY_CREATE_ERROR",
+		})
+		return
+	}
+
+	log.Printf("🔑 CreateAPIKeyHandler: Issued API key %s for user %s", prefix, userID)
+	SetAuditResource(c, results[0].ID)
+	SetAuditMetadata(c, "prefix", prefix)
+	SetAuditMetadata(c, "scopes", req.Scopes)
+	OmitIdempotentResponse(c)
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key": results[0],
+		"key":     key,
+		"message": "Store this key securely; it will not be shown again",
+	})
+}
+
+// RevokeAPIKeyHandler handles DELETE /api/auth/api-keys/:id
+func RevokeAPIKeyHandler(c *gin.Context) {
+	userID := GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireAuthDatabase(c) {
+		return
+	}
+
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid API key ID",
+			"code":  "INVALID_API_KEY_ID",
+		})
+		return
+	}
+
+	var keys []APIKey
+	err := supabaseClient.DB.From("api_keys").
+		Select("*").
+		Eq("id", id).
+		Execute(&keys)
+	if err != nil {
+		log.Printf("❌ RevokeAPIKeyHandler: Failed to fetch key %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch API key",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+	if len(keys) == 0 || !canRevokeAPIKey(&keys[0], userID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "API key not found",
+			"code":  "API_KEY_NOT_FOUND",
+		})
+		return
+	}
+
+	key := keys[0]
+	if key.RevokedAt == nil {
+		var results []APIKey
+		err = supabaseClient.DB.From("api_keys").
+			Update(map[string]interface{}{"revoked_at": time.Now().UTC().Format(time.RFC3339)}).
+			Eq("id", key.ID).
+			Execute(&results)
+		if err != nil || len(results) == 0 {
+			log.Printf("❌ RevokeAPIKeyHandler: Failed to revoke key %s: %v", key.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to revoke API key",
+				"code":  "API_KEY_REVOKE_ERROR",
+			})
+			return
+		}
+		key = results[0]
+		forgetAPIKey(key.Prefix)
+		log.Printf("🔒 RevokeAPIKeyHandler: Revoked API key %s for user %s", key.Prefix, userID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "API key revoked",
+		"api_key": key,
+	})
+}
+
+// canRevokeAPIKey reports whether the user created the key or may manage the
+// organization it belongs to
+func canRevokeAPIKey(key *APIKey, userID string) bool {
+	if key.UserID == userID {
+		return true
+	}
+	if key.OrgID == nil {
+		return false
+	}
+	allowed, err := HasOrganizationPermission(*key.OrgID, userID, PermOrganizationManage)
+	return err == nil && allowed
+}
+
+// requireAuthDatabase writes a 503 when the auth store is not configured
+func requireAuthDatabase(c *gin.Context) bool {
+	if supabaseClient == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Database connection not available",
+			"code":  "DATABASE_CONNECTION_ERROR",
+		})
+		return false
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
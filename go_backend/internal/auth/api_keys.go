@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API key scopes, one per route group that accepts API keys
+const (
+	ScopeAssessments  = "assessments"   // /api/v1
+	ScopeBusinessRisk = "business_risk" // /api/business-risk-prevention
+	ScopeWebsiteRisk  = "website_risk"  // /api/website-risk-assessment
+	ScopeWebhooks     = "webhooks"      // /api/webhooks
+)
+
+// SupportedAPIKeyScopes lists the scopes a key can be granted
+var SupportedAPIKeyScopes = []string{
+	ScopeAssessments,
+	ScopeBusinessRisk,
+	ScopeWebsiteRisk,
+	ScopeWebhooks,
+}
+
+const (
+	apiKeyPrefix       = "qf_"
+	apiKeyIDBytes      = 6  // 12 hex chars after qf_
+	apiKeySecretBytes  = 24 // 32 base64url chars
+	apiKeyCacheTTL     = time.Minute
+	apiKeyTouchSpacing = time.Minute // minimum interval between last_used_at writes
+)
+
+var (
+	errInvalidAPIKey       = errors.New("invalid API key")
+	errAPIKeyRevoked       = errors.New("API key has been revoked")
+	errAPIKeyExpired       = errors.New("API key has expired")
+	errAPIAccessNotInPlan  = errors.New("API access is not included in the current plan")
+	errAPIKeyScopeMismatch = errors.New("API key is not scoped for this endpoint")
+)
+
+// APIKey is a hashed machine-to-machine credential owned by a user, or by an
+// organization when OrgID is set (requests then act in that organization)
+type APIKey struct {
+	ID         string     `json:"id" db:"id"`
+	UserID     string     `json:"user_id" db:"user_id"`
+	OrgID      *string    `json:"org_id" db:"org_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	KeyHash    string     `json:"-" db:"key_hash"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+	LastUsedIP *string    `json:"last_used_ip" db:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// CreateAPIKeyRequest represents the request to issue a new API key
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays *int     `json:"expires_in_days" binding:"omitempty,min=1,max=365"`
+	OrgID         string   `json:"org_id" binding:"omitempty,uuid"` // issue an organization key
+}
+
+// allows reports whether the key was granted any of the given scopes
+func (k *APIKey) allows(scopes []string) bool {
+	for _, granted := range k.Scopes {
+		for _, scope := range scopes {
+			if granted == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// usable returns an error when the key is revoked or expired
+func (k *APIKey) usable(now time.Time) error {
+	if k.RevokedAt != nil {
+		return errAPIKeyRevoked
+	}
+	if k.ExpiresAt != nil && now.After(*k.ExpiresAt) {
+		return errAPIKeyExpired
+	}
+	return nil
+}
+
+// isAPIKey reports whether a credential looks like an API key rather than a JWT
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// generateAPIKey returns a new key of the form qf_<12 hex>_<secret>, its
+// public prefix and the hash stored at rest
+func generateAPIKey() (key, prefix, hash string, err error) {
+	id := make([]byte, apiKeyIDBytes)
+	secret := make([]byte, apiKeySecretBytes)
+	if _, err = rand.Read(id); err != nil {
+		return "", "", "", err
+	}
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = apiKeyPrefix + hex.EncodeToString(id)
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, hashAPIKey(key), nil
+}
+
+// parseAPIKeyPrefix extracts the public prefix used to look a key up
+func parseAPIKeyPrefix(key string) (string, bool) {
+	if !isAPIKey(key) {
+		return "", false
+	}
+	rest := strings.TrimPrefix(key, apiKeyPrefix)
+	sep := strings.IndexByte(rest, '_')
+	if sep != apiKeyIDBytes*2 || len(rest) == sep+1 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(rest[:sep]); err != nil {
+		return "", false
+	}
+	return apiKeyPrefix + rest[:sep], true
+}
+
+// hashAPIKey hashes a key for storage. Keys carry 192 bits of randomness, so
+// a plain SHA-256 is sufficient (no password stretching needed).
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedAPIKey is a key record with the owner details needed per request
+type cachedAPIKey struct {
+	key         APIKey
+	email       string
+	planAllowed bool
+	loadedAt    time.Time
+}
+
+// apiKeyCache avoids a database round trip per API request. Revocations made
+// on another instance take effect once the entry expires (apiKeyCacheTTL).
+var apiKeyCache = struct {
+	sync.Mutex
+	entries map[string]*cachedAPIKey
+}{entries: map[string]*cachedAPIKey{}}
+
+// authenticateAPIKey resolves the owner of an API key presented for a route
+// group requiring one of scopes
+func authenticateAPIKey(rawKey, clientIP string, scopes []string) (*authenticatedUser, *APIKey, error) {
+	prefix, ok := parseAPIKeyPrefix(rawKey)
+	if !ok {
+		return nil, nil, errInvalidAPIKey
+	}
+
+	entry, err := loadAPIKey(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(rawKey)), []byte(entry.key.KeyHash)) != 1 {
+		return nil, nil, errInvalidAPIKey
+	}
+
+	now := time.Now()
+	if err := entry.key.usable(now); err != nil {
+		return nil, nil, err
+	}
+	if !entry.planAllowed {
+		return nil, nil, errAPIAccessNotInPlan
+	}
+	if !entry.key.allows(scopes) {
+		return nil, nil, errAPIKeyScopeMismatch
+	}
+
+	touchAPIKey(entry, clientIP, now)
+
+	apiKeyCache.Lock()
+	key := entry.key
+	apiKeyCache.Unlock()
+	return &authenticatedUser{ID: key.UserID, Email: entry.email}, &key, nil
+}
+
+// loadAPIKey returns the key with the given prefix from the cache or database
+func loadAPIKey(prefix string) (*cachedAPIKey, error) {
+	apiKeyCache.Lock()
+	entry, found := apiKeyCache.entries[prefix]
+	apiKeyCache.Unlock()
+	if found && time.Since(entry.loadedAt) < apiKeyCacheTTL {
+		return entry, nil
+	}
+
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("authentication is not configured")
+	}
+
+	var keys []APIKey
+	err := supabaseClient.DB.From("api_keys").
+		Select("*").
+		Eq("prefix", prefix).
+		Execute(&keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch API key: %v", err)
+	}
+	if len(keys) == 0 {
+		return nil, errInvalidAPIKey
+	}
+
+	entry = &cachedAPIKey{key: keys[0], loadedAt: time.Now()}
+	if allowed, err := UserHasFeature(entry.key.UserID, FeatureAPIAccess); err != nil {
+		log.Printf("⚠️ loadAPIKey: Failed to check plan for user %s: %v", entry.key.UserID, err)
+	} else {
+		entry.planAllowed = allowed
+	}
+	if profile, err := GetUserProfile(entry.key.UserID); err == nil {
+		entry.email = profile.Email
+	}
+
+	apiKeyCache.Lock()
+	apiKeyCache.entries[prefix] = entry
+	apiKeyCache.Unlock()
+	return entry, nil
+}
+
+// forgetAPIKey drops a key from the local cache (after revocation)
+func forgetAPIKey(prefix string) {
+	apiKeyCache.Lock()
+	delete(apiKeyCache.entries, prefix)
+	apiKeyCache.Unlock()
+}
+
+// touchAPIKey records last use, at most once per apiKeyTouchSpacing per key
+func touchAPIKey(entry *cachedAPIKey, clientIP string, now time.Time) {
+	apiKeyCache.Lock()
+	if entry.key.LastUsedAt != nil && now.Sub(*entry.key.LastUsedAt) < apiKeyTouchSpacing {
+		apiKeyCache.Unlock()
+		return
+	}
+	entry.key.LastUsedAt = &now
+	entry.key.LastUsedIP = &clientIP
+	keyID := entry.key.ID
+	apiKeyCache.Unlock()
+
+	if supabaseClient == nil {
+		return
+	}
+	go func() {
+		var results []map[string]interface{}
+		err := supabaseClient.DB.From("api_keys").
+			Update(map[string]interface{}{
+				"last_used_at": now.UTC().Format(time.RFC3339),
+				"last_used_ip": clientIP,
+			}).
+			Eq("id", keyID).
+			Execute(&results)
+		if err != nil {
+			log.Printf("⚠️ touchAPIKey: Failed to record use of key %s: %v", keyID, err)
+		}
+	}()
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGenerateAndParseAPIKey(t *testing.T) {
+	key, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, ok := parseAPIKeyPrefix(key)
+	if !ok || parsed != prefix {
+		t.Fatalf("expected prefix %q, got %q (ok=%v)", prefix, parsed, ok)
+	}
+	if hashAPIKey(key) != hash || hash == key {
+		t.Error("hash should be deterministic and differ from the key")
+	}
+
+	for _, invalid := range []string{"", "qf_", "qf_abc_secret", prefix, prefix + "_", "qf_zzzzzzzzzzzz_secret", "eyJhbGciOi"} {
+		if _, ok := parseAPIKeyPrefix(invalid); ok {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+// cacheTestKey places a key in the cache so the middleware never hits the database
+func cacheTestKey(t *testing.T, scopes []string, planAllowed bool) string {
+	key, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent := time.Now()
+	apiKeyCache.Lock()
+	apiKeyCache.entries[prefix] = &cachedAPIKey{
+		key:         APIKey{ID: "key-1", UserID: "user-123", Prefix: prefix, KeyHash: hash, Scopes: scopes, LastUsedAt: &recent},
+		planAllowed: planAllowed,
+		loadedAt:    time.Now(),
+	}
+	apiKeyCache.Unlock()
+	t.Cleanup(func() { forgetAPIKey(prefix) })
+	return key
+}
+
+func TestAuthMiddlewareAPIKeyScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ok := func(c *gin.Context) { c.String(http.StatusOK, GetUserID(c)) }
+	router.GET("/website", AuthMiddleware(ScopeWebsiteRisk), ok)
+	router.GET("/webhooks", AuthMiddleware(ScopeWebhooks), ok)
+	router.GET("/profile", AuthMiddleware(), ok)
+
+	key := cacheTestKey(t, []string{ScopeWebsiteRisk}, true)
+	noPlanKey := cacheTestKey(t, []string{ScopeWebsiteRisk}, false)
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		value  string
+		status int
+	}{
+		{"bearer key in scope", "/website", "Authorization", "Bearer " + key, http.StatusOK},
+		{"x-api-key in scope", "/website", "X-API-Key", key, http.StatusOK},
+		{"key out of scope", "/webhooks", "X-API-Key", key, http.StatusForbidden},
+		{"jwt-only route", "/profile", "X-API-Key", key, http.StatusForbidden},
+		{"plan without api_access", "/website", "X-API-Key", noPlanKey, http.StatusForbidden},
+		{"wrong secret", "/website", "X-API-Key", key + "x", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set(tt.header, tt.value)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d (%s)", tt.name, tt.status, rec.Code, rec.Body.String())
+		}
+		if tt.status == http.StatusOK && rec.Body.String() != "user-123" {
+			t.Errorf("%s: expected key owner in context, got %q", tt.name, rec.Body.String())
+		}
+	}
+}
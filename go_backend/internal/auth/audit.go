@@ -0,0 +1,435 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Audit actions recorded in user_activity_logs
+const (
+	AuditLogin                 = "auth.login"
+	AuditLogout                = "auth.logout"
+	AuditSessionRevoked        = "auth.session_revoked"
+	AuditTwoFactorEnabled      = "auth.two_factor_enabled"
+	AuditTwoFactorDisabled     = "auth.two_factor_disabled"
+	AuditAPIKeyCreated         = "api_key.created"
+	AuditAPIKeyRevoked         = "api_key.revoked"
+	AuditAssessmentCreated     = "assessment.created"
+	AuditAssessmentViewed      = "assessment.viewed"
+	AuditAssessmentUpdated     = "assessment.updated"
+	AuditAssessmentDeleted     = "assessment.deleted"
+	AuditAssessmentExported    = "assessment.exported"
+	AuditAssessmentOverridden  = "assessment.overridden"
+	AuditCreditsConsumed       = "credits.consumed"
+	AuditCreditsTransferred    = "credits.transferred"
+	AuditCreditsPurchased      = "credits.purchased"
+	AuditCheckoutCreated       = "billing.checkout_created"
+	AuditCreditAlertChanged    = "billing.credit_alert_changed"
+	AuditSubscriptionChanged   = "subscription.changed"
+	AuditSubscriptionCancelled = "subscription.cancelled"
+)
+
+const (
+	auditQueueSize      = 1000
+	auditResourceKey    = "audit_resource_id"
+	auditMetadataKey    = "audit_metadata"
+	auditSystemChain    = "system" // chain of events without a user
+	auditVerifyPageSize = 500
+)
+
+// AuditEvent is something a user did that the audit log records
+type AuditEvent struct {
+	UserID          string
+	OrgID           string // empty for personal workspace events
+	SessionID       string
+	Action          string
+	ResourceType    string
+	ResourceID      string
+	CreditsConsumed int
+	Metadata        map[string]interface{}
+	IPAddress       string
+	UserAgent       string
+}
+
+// AuditLog is a row of user_activity_logs. Rows form one hash chain per
+// organization (or per user for personal workspaces): entry_hash covers the
+// previous entry's hash and this entry's payload_hash, so editing, removing
+// or reordering entries breaks every later hash. The database assigns
+// sequence_number, prev_hash and entry_hash on insert.
+type AuditLog struct {
+	ID              string                 `json:"id"`
+	UserID          *string                `json:"user_id"`
+	OrgID           *string                `json:"org_id"`
+	SessionID       *string                `json:"session_id"`
+	Action          string                 `json:"action"`
+	ResourceType    *string                `json:"resource_type"`
+	ResourceID      *string                `json:"resource_id"`
+	CreditsConsumed int                    `json:"credits_consumed"`
+	Metadata        map[string]interface{} `json:"metadata"`
+	IPAddress       *string                `json:"ip_address"`
+	UserAgent       *string                `json:"user_agent"`
+	CreatedAt       time.Time              `json:"created_at"`
+	ChainKey        string                 `json:"chain_key"`
+	SequenceNumber  int64                  `json:"sequence_number"`
+	PayloadHash     string                 `json:"payload_hash"`
+	PrevHash        *string                `json:"prev_hash"`
+	EntryHash       string                 `json:"entry_hash"`
+}
+
+// auditPayload is the canonical form of an entry hashed into payload_hash.
+// Fields are in a fixed order and absent values are empty strings, so the
+// hash can be recomputed from the stored row.
+type auditPayload struct {
+	ID              string          `json:"id"`
+	UserID          string          `json:"user_id"`
+	OrgID           string          `json:"org_id"`
+	SessionID       string          `json:"session_id"`
+	Action          string          `json:"action"`
+	ResourceType    string          `json:"resource_type"`
+	ResourceID      string          `json:"resource_id"`
+	CreditsConsumed int             `json:"credits_consumed"`
+	Metadata        json.RawMessage `json:"metadata"`
+	IPAddress       string          `json:"ip_address"`
+	UserAgent       string          `json:"user_agent"`
+	CreatedAt       string          `json:"created_at"`
+}
+
+var (
+	auditQueue      chan *AuditLog
+	auditWriterOnce sync.Once
+)
+
+// startAuditWriter starts the goroutine that writes queued audit events
+func startAuditWriter() {
+	auditWriterOnce.Do(func() {
+		auditQueue = make(chan *AuditLog, auditQueueSize)
+		go func() {
+			for entry := range auditQueue {
+				writeAuditLog(entry)
+			}
+		}()
+	})
+}
+
+// RecordAuditEvent appends an event to the audit log. Events are written in
+// the background; when the queue is full the caller writes it directly so
+// that no event is dropped.
+func RecordAuditEvent(event AuditEvent) {
+	if supabaseClient == nil || event.Action == "" {
+		return
+	}
+
+	entry, err := newAuditLog(event, time.Now())
+	if err != nil {
+		log.Printf("⚠️ RecordAuditEvent: Failed to build %s entry: %v", event.Action, err)
+		return
+	}
+
+	select {
+	case auditQueue <- entry:
+	default:
+		log.Printf("⚠️ RecordAuditEvent: Queue full, writing %s synchronously", event.Action)
+		writeAuditLog(entry)
+	}
+}
+
+// newAuditLog builds the row for an event, including its payload hash
+func newAuditLog(event AuditEvent, now time.Time) (*AuditLog, error) {
+	entry := &AuditLog{
+		ID:              uuid.New().String(),
+		UserID:          optionalString(event.UserID),
+		OrgID:           optionalString(event.OrgID),
+		SessionID:       optionalString(event.SessionID),
+		Action:          event.Action,
+		ResourceType:    optionalString(event.ResourceType),
+		ResourceID:      optionalString(event.ResourceID),
+		CreditsConsumed: event.CreditsConsumed,
+		Metadata:        event.Metadata,
+		IPAddress:       optionalString(canonicalIP(event.IPAddress)),
+		UserAgent:       optionalString(event.UserAgent),
+		// Postgres keeps microseconds, so hash what will be read back
+		CreatedAt: now.UTC().Truncate(time.Microsecond),
+	}
+
+	hash, err := auditPayloadHash(entry)
+	if err != nil {
+		return nil, err
+	}
+	entry.PayloadHash = hash
+	return entry, nil
+}
+
+func writeAuditLog(entry *AuditLog) {
+	row := map[string]interface{}{
+		"id":               entry.ID,
+		"action":           entry.Action,
+		"credits_consumed": entry.CreditsConsumed,
+		"created_at":       entry.CreatedAt.Format(time.RFC3339Nano),
+		"payload_hash":     entry.PayloadHash,
+	}
+	optional := map[string]*string{
+		"user_id":       entry.UserID,
+		"org_id":        entry.OrgID,
+		"session_id":    entry.SessionID,
+		"resource_type": entry.ResourceType,
+		"resource_id":   entry.ResourceID,
+		"ip_address":    entry.IPAddress,
+		"user_agent":    entry.UserAgent,
+	}
+	for column, value := range optional {
+		if value != nil {
+			row[column] = *value
+		}
+	}
+	if entry.Metadata != nil {
+		row["metadata"] = entry.Metadata
+	}
+
+	var results []map[string]interface{}
+	if err := supabaseClient.DB.From("user_activity_logs").Insert(row).Execute(&results); err != nil {
+		log.Printf("❌ RecordAuditEvent: Failed to write %s entry %s: %v", entry.Action, entry.ID, err)
+	}
+}
+
+// auditPayloadHash returns the hex SHA-256 of the entry's canonical payload
+func auditPayloadHash(entry *AuditLog) (string, error) {
+	// Maps marshal with sorted keys, so metadata read back from JSONB
+	// encodes exactly as it did when written
+	metadata, err := json.Marshal(entry.Metadata)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode metadata: %v", err)
+	}
+
+	payload, err := json.Marshal(auditPayload{
+		ID:              entry.ID,
+		UserID:          stringValue(entry.UserID),
+		OrgID:           stringValue(entry.OrgID),
+		SessionID:       stringValue(entry.SessionID),
+		Action:          entry.Action,
+		ResourceType:    stringValue(entry.ResourceType),
+		ResourceID:      stringValue(entry.ResourceID),
+		CreditsConsumed: entry.CreditsConsumed,
+		Metadata:        metadata,
+		IPAddress:       canonicalIP(stringValue(entry.IPAddress)),
+		UserAgent:       stringValue(entry.UserAgent),
+		CreatedAt:       entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit payload: %v", err)
+	}
+
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// auditEntryHash chains an entry to its predecessor; it must match the
+// chain_activity_log trigger
+func auditEntryHash(prevHash, payloadHash string) string {
+	sum := sha256.Sum256([]byte(prevHash + payloadHash))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalIP normalizes an address the way INET columns return it, and
+// drops values that are not addresses
+func canonicalIP(value string) string {
+	host := strings.TrimSuffix(strings.TrimSuffix(value, "/32"), "/128")
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// NewAuditEvent returns an event for the request's user, workspace, session
+// and client
+func NewAuditEvent(c *gin.Context, action, resourceType, resourceID string) AuditEvent {
+	event := AuditEvent{
+		UserID:       GetUserID(c),
+		SessionID:    GetSessionID(c),
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Metadata: map[string]interface{}{
+			"method": c.Request.Method,
+			"path":   c.Request.URL.Path,
+		},
+	}
+	if cached, exists := c.Get("workspace"); exists {
+		event.OrgID = cached.(Workspace).OrgID
+	}
+	if impersonator := GetImpersonatorID(c); impersonator != "" {
+		event.Metadata["impersonated_by"] = impersonator
+	}
+	if key := GetAPIKey(c); key != nil {
+		event.Metadata["api_key_id"] = key.ID
+		if key.OrgID != nil {
+			event.OrgID = *key.OrgID
+		}
+	}
+	return event
+}
+
+// SetAuditResource names the resource a request acted on, for handlers whose
+// route has no :id (such as creations)
+func SetAuditResource(c *gin.Context, resourceID string) {
+	c.Set(auditResourceKey, resourceID)
+}
+
+// SetAuditMetadata adds a detail, such as a new status, to the request's
+// audit event
+func SetAuditMetadata(c *gin.Context, key string, value interface{}) {
+	metadata, _ := c.Get(auditMetadataKey)
+	details, ok := metadata.(map[string]interface{})
+	if !ok {
+		details = map[string]interface{}{}
+		c.Set(auditMetadataKey, details)
+	}
+	details[key] = value
+}
+
+// Audit records action on resourceType once the handler has succeeded. The
+// resource is the one named with SetAuditResource, or the :id route parameter.
+func Audit(action, resourceType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		status := c.Writer.Status()
+		if status >= http.StatusBadRequest {
+			return
+		}
+
+		resourceID := c.GetString(auditResourceKey)
+		if resourceID == "" {
+			resourceID = c.Param("id")
+		}
+		event := NewAuditEvent(c, action, resourceType, resourceID)
+		if metadata, exists := c.Get(auditMetadataKey); exists {
+			for key, value := range metadata.(map[string]interface{}) {
+				event.Metadata[key] = value
+			}
+		}
+		event.Metadata["status"] = status
+		RecordAuditEvent(event)
+	}
+}
+
+// auditChainKey names the chain of a workspace; it must match the
+// chain_activity_log trigger
+func auditChainKey(workspace Workspace) string {
+	if workspace.IsOrganization() {
+		return workspace.OrgID
+	}
+	if workspace.UserID != "" {
+		return workspace.UserID
+	}
+	return auditSystemChain
+}
+
+// AuditChainReport is the result of verifying a workspace's audit chain
+type AuditChainReport struct {
+	Valid          bool    `json:"valid"`
+	EntriesChecked int     `json:"entries_checked"`
+	BrokenAt       *int64  `json:"broken_at,omitempty"` // sequence number of the first bad entry
+	BrokenEntryID  *string `json:"broken_entry_id,omitempty"`
+	Reason         string  `json:"reason,omitempty"`
+}
+
+// auditChainVerifier checks entries in sequence order
+type auditChainVerifier struct {
+	report   AuditChainReport
+	lastSeq  int64
+	lastHash string
+}
+
+// check verifies the next entry of the chain, returning false at the first
+// entry that was altered, removed or inserted out of order
+func (v *auditChainVerifier) check(entry *AuditLog) bool {
+	reason := ""
+	payloadHash, err := auditPayloadHash(entry)
+	switch {
+	case err != nil:
+		reason = err.Error()
+	case entry.SequenceNumber != v.lastSeq+1:
+		reason = fmt.Sprintf("expected sequence number %d", v.lastSeq+1)
+	case stringValue(entry.PrevHash) != v.lastHash:
+		reason = "previous hash does not match the preceding entry"
+	case payloadHash != entry.PayloadHash:
+		reason = "entry contents do not match its payload hash"
+	case auditEntryHash(v.lastHash, entry.PayloadHash) != entry.EntryHash:
+		reason = "entry hash does not match"
+	}
+
+	if reason != "" {
+		sequence, id := entry.SequenceNumber, entry.ID
+		v.report.BrokenAt = &sequence
+		v.report.BrokenEntryID = &id
+		v.report.Reason = reason
+		return false
+	}
+
+	v.report.EntriesChecked++
+	v.lastSeq = entry.SequenceNumber
+	v.lastHash = entry.EntryHash
+	return true
+}
+
+// VerifyAuditChain re-computes every hash of the workspace's audit chain
+func VerifyAuditChain(workspace Workspace) (*AuditChainReport, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	verifier := &auditChainVerifier{}
+	chainKey := auditChainKey(workspace)
+	for offset := 0; ; offset += auditVerifyPageSize {
+		var entries []AuditLog
+		err := supabaseClient.DB.From("user_activity_logs").
+			Select("*").
+			OrderBy("sequence_number", "asc").
+			LimitWithOffset(auditVerifyPageSize, offset).
+			Eq("chain_key", chainKey).
+			Execute(&entries)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch audit logs: %v", err)
+		}
+
+		for i := range entries {
+			if !verifier.check(&entries[i]) {
+				return &verifier.report, nil
+			}
+		}
+		if len(entries) < auditVerifyPageSize {
+			break
+		}
+	}
+
+	verifier.report.Valid = true
+	return &verifier.report, nil
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	auditDefaultPageSize = 50
+	auditMaxPageSize     = 100
+)
+
+// ListAuditLogsHandler handles GET /api/audit-logs. Results cover the
+// workspace (an organization with X-Organization-ID, else the caller's
+// personal activity), newest first, and can be filtered by action (comma
+// separated), resource_type, resource_id, user_id and a from/to time range.
+func ListAuditLogsHandler(c *gin.Context) {
+	workspace, ok := ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	if !requireAuthDatabase(c) {
+		return
+	}
+
+	limit := auditDefaultPageSize
+	offset := 0
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= auditMaxPageSize {
+			limit = parsed
+		}
+	}
+	if o := c.Query("offset"); o != "" {
+		if parsed, err := strconv.Atoi(o); err == nil && parsed >= 0 {
+			offset = parsed
+		}
+	}
+
+	// One extra row tells whether another page exists
+	query := workspace.Scope(supabaseClient.DB.From("user_activity_logs").
+		Select("*").
+		OrderBy("created_at", "desc").
+		LimitWithOffset(limit+1, offset))
+
+	if actions := c.Query("action"); actions != "" {
+		query = query.In("action", strings.Split(actions, ","))
+	}
+	if resourceType := c.Query("resource_type"); resourceType != "" {
+		query = query.Eq("resource_type", resourceType)
+	}
+	if resourceID := c.Query("resource_id"); resourceID != "" {
+		query = query.Eq("resource_id", resourceID)
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Eq("user_id", userID)
+	}
+	for _, param := range []string{"from", "to"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		at, err := parseAuditTime(value, param == "to")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid " + param + " time, use RFC 3339 or YYYY-MM-DD",
+				"code":  "INVALID_REQUEST",
+			})
+			return
+		}
+		if param == "from" {
+			query = query.Gte("created_at", at.UTC().Format(time.RFC3339Nano))
+		} else {
+			query = query.Lt("created_at", at.UTC().Format(time.RFC3339Nano))
+		}
+	}
+
+	var logs []AuditLog
+	if err := query.Execute(&logs); err != nil {
+		log.Printf("❌ ListAuditLogsHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch audit logs",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	hasMore := len(logs) > limit
+	if hasMore {
+		logs = logs[:limit]
+	}
+	if logs == nil {
+		logs = []AuditLog{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"logs":     logs,
+		"limit":    limit,
+		"offset":   offset,
+		"has_more": hasMore,
+	})
+}
+
+// VerifyAuditLogsHandler handles GET /api/audit-logs/verify, re-computing the
+// hash chain of the workspace's audit log to detect tampering
+func VerifyAuditLogsHandler(c *gin.Context) {
+	workspace, ok := ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	if !requireAuthDatabase(c) {
+		return
+	}
+
+	report, err := VerifyAuditChain(workspace)
+	if err != nil {
+		log.Printf("❌ VerifyAuditLogsHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to verify audit logs",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	if !report.Valid {
+		log.Printf("🚨 VerifyAuditLogsHandler: Audit chain %s broken at entry %d: %s", auditChainKey(workspace), *report.BrokenAt, report.Reason)
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// parseAuditTime accepts RFC 3339 times or dates; a "to" date includes the
+// whole day
+func parseAuditTime(value string, endOfRange bool) (time.Time, error) {
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfRange {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	postgrest "github.com/nedpals/supabase-go/postgrest/pkg"
+)
+
+// Credit ledger errors. Their messages match the exceptions raised by the
+// ledger functions in the database.
+var (
+	ErrInsufficientCredits  = errors.New("insufficient credits")
+	ErrCreditWalletNotFound = errors.New("user credits not found")
+	ErrReservationNotFound  = errors.New("credit reservation not found")
+	ErrOverageCapReached    = errors.New("overage spending cap reached")
+)
+
+// Credit reservation statuses
+const (
+	ReservationReserved = "reserved" // credits left to capture or release
+	ReservationCaptured = "captured"
+	ReservationReleased = "released"
+	ReservationSettled  = "settled" // partly captured, partly released
+)
+
+// Audit actions of the credit ledger not covered by AuditCreditsConsumed
+const (
+	AuditCreditsReserved = "credits.reserved"
+	AuditCreditsReleased = "credits.released"
+)
+
+// reservationTTL is how long credits stay held before the sweeper returns them
+const reservationTTL = 24 * time.Hour
+
+// reservationSweepInterval is how often expired reservations are released
+const reservationSweepInterval = 10 * time.Minute
+
+// CreditReservation is a row of credit_reservations: credits held for queued
+// work until they are captured (charged) or released (returned)
+type CreditReservation struct {
+	ID              string    `json:"id"`
+	WalletID        string    `json:"wallet_id"`
+	UserID          *string   `json:"user_id"`
+	OrgID           *string   `json:"org_id"`
+	Credits         int       `json:"credits"`
+	CapturedCredits int       `json:"captured_credits"`
+	ReleasedCredits int       `json:"released_credits"`
+	Status          string    `json:"status"`
+	IdempotencyKey  *string   `json:"idempotency_key"`
+	Description     *string   `json:"description"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// CreditTransaction is a row of credit_transactions: one movement of credits
+type CreditTransaction struct {
+	ID              string                 `json:"id"`
+	UserID          *string                `json:"user_id"`
+	OrgID           *string                `json:"org_id"`
+	TransactionType string                 `json:"transaction_type"`
+	CreditChange    int                    `json:"credit_change"`
+	ReservedChange  int                    `json:"reserved_change"`
+	BalanceBefore   int                    `json:"balance_before"`
+	BalanceAfter    int                    `json:"balance_after"`
+	Description     *string                `json:"description"`
+	IdempotencyKey  *string                `json:"idempotency_key"`
+	AssessmentID    *int64                 `json:"assessment_id"`
+	ReservationID   *string                `json:"reservation_id"`
+	Metadata        map[string]interface{} `json:"metadata"`
+	CreatedAt       time.Time              `json:"created_at"`
+}
+
+// ledgerError maps the exceptions raised by the ledger functions to errors
+// callers can check with errors.Is
+func ledgerError(err error) error {
+	return raisedError(err, "credit ledger", ErrInsufficientCredits, ErrOverageCapReached, ErrCreditWalletNotFound, ErrReservationNotFound)
+}
+
+// raisedError returns the known error whose message an exception raised by a
+// database function contains, or the exception prefixed by source
+func raisedError(err error, source string, known ...error) error {
+	var requestErr *postgrest.RequestError
+	if !errors.As(err, &requestErr) {
+		return err
+	}
+	for _, k := range known {
+		if strings.Contains(requestErr.Message, k.Error()) {
+			return k
+		}
+	}
+	return fmt.Errorf("%s: %s", source, requestErr.Message)
+}
+
+// ReserveCredits holds credits in the wallet of a user (orgID empty) or an
+// organization. Calls repeating idempotencyKey return the first reservation.
+func ReserveCredits(userID, orgID string, credits int, idempotencyKey, description string) (*CreditReservation, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var reservation CreditReservation
+	err := supabaseClient.DB.Rpc("reserve_credits", map[string]interface{}{
+		"p_user_id":         userID,
+		"p_org_id":          optionalString(orgID),
+		"p_credits":         credits,
+		"p_idempotency_key": optionalString(idempotencyKey),
+		"p_description":     optionalString(description),
+		"p_ttl_seconds":     int(reservationTTL.Seconds()),
+	}).Execute(&reservation)
+	if err != nil {
+		return nil, ledgerError(err)
+	}
+
+	log.Printf("💳 ReserveCredits: Reserved %d credits (reservation %s) for user %s", credits, reservation.ID, userID)
+	RecordAuditEvent(AuditEvent{
+		UserID:       userID,
+		OrgID:        orgID,
+		Action:       AuditCreditsReserved,
+		ResourceType: "credit_reservation",
+		ResourceID:   reservation.ID,
+		Metadata:     map[string]interface{}{"credits": credits},
+	})
+	return &reservation, nil
+}
+
+// CaptureCredits charges credits of a reservation, all that is left when
+// credits is 0. It returns the ledger transaction, or nil when nothing was
+// left to capture or idempotencyKey was used before.
+func CaptureCredits(reservationID string, credits int, assessmentID *int64, idempotencyKey string) (*CreditTransaction, error) {
+	transaction, err := settleReservation(reservationID, true, credits, assessmentID, "", idempotencyKey)
+	if err != nil || transaction == nil {
+		return nil, err
+	}
+
+	event := AuditEvent{
+		UserID:          stringValue(transaction.UserID),
+		OrgID:           stringValue(transaction.OrgID),
+		Action:          AuditCreditsConsumed,
+		ResourceType:    "credit_reservation",
+		ResourceID:      reservationID,
+		CreditsConsumed: -transaction.CreditChange,
+		Metadata: map[string]interface{}{
+			"transaction_type": transaction.TransactionType,
+			"balance_after":    transaction.BalanceAfter,
+		},
+	}
+	if assessmentID != nil {
+		event.ResourceType = "assessment"
+		event.ResourceID = strconv.FormatInt(*assessmentID, 10)
+		event.Metadata["reservation_id"] = reservationID
+	}
+	RecordAuditEvent(event)
+	return transaction, nil
+}
+
+// ReleaseCredits returns credits of a reservation to the wallet, all that is
+// left when credits is 0. It returns the ledger transaction, or nil when
+// nothing was left to release or idempotencyKey was used before.
+func ReleaseCredits(reservationID string, credits int, reason, idempotencyKey string) (*CreditTransaction, error) {
+	transaction, err := settleReservation(reservationID, false, credits, nil, reason, idempotencyKey)
+	if err != nil || transaction == nil {
+		return nil, err
+	}
+
+	RecordAuditEvent(AuditEvent{
+		UserID:       stringValue(transaction.UserID),
+		OrgID:        stringValue(transaction.OrgID),
+		Action:       AuditCreditsReleased,
+		ResourceType: "credit_reservation",
+		ResourceID:   reservationID,
+		Metadata:     map[string]interface{}{"credits": -transaction.ReservedChange, "reason": reason},
+	})
+	return transaction, nil
+}
+
+func settleReservation(reservationID string, capture bool, credits int, assessmentID *int64, description, idempotencyKey string) (*CreditTransaction, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	params := map[string]interface{}{
+		"p_reservation_id":  reservationID,
+		"p_capture":         capture,
+		"p_credits":         nil,
+		"p_assessment_id":   assessmentID,
+		"p_description":     optionalString(description),
+		"p_idempotency_key": optionalString(idempotencyKey),
+	}
+	if credits > 0 {
+		params["p_credits"] = credits
+	}
+
+	var transaction *CreditTransaction
+	if err := supabaseClient.DB.Rpc("settle_credit_reservation", params).Execute(&transaction); err != nil {
+		return nil, ledgerError(err)
+	}
+	if transaction == nil || transaction.ID == "" {
+		return nil, nil
+	}
+	return transaction, nil
+}
+
+// ReleaseExpiredReservations returns the credits of reservations that were
+// never settled, such as those of assessments lost in a restart
+func ReleaseExpiredReservations() (int, error) {
+	if supabaseClient == nil {
+		return 0, fmt.Errorf("supabase client not initialized")
+	}
+
+	var released int
+	if err := supabaseClient.DB.Rpc("release_expired_credit_reservations", map[string]interface{}{}).Execute(&released); err != nil {
+		return 0, ledgerError(err)
+	}
+	return released, nil
+}
+
+// releaseExpiredReservationsJob is the scheduled job releasing expired reservations
+func releaseExpiredReservationsJob() (interface{}, error) {
+	released, err := ReleaseExpiredReservations()
+	if err != nil {
+		return nil, err
+	}
+	if released > 0 {
+		log.Printf("♻️ ReleaseExpiredReservations: Released %d expired credit reservations", released)
+	}
+	return map[string]int{"released": released}, nil
+}
+
+// consumeCreditsNow charges credits to a wallet immediately, returning the
+// transaction recorded in the ledger
+func consumeCreditsNow(userID, orgID string, credits int, transactionType string, assessmentID *int64, description string) (*CreditTransaction, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var transaction CreditTransaction
+	err := supabaseClient.DB.Rpc("consume_credits", map[string]interface{}{
+		"p_user_id":          userID,
+		"p_org_id":           optionalString(orgID),
+		"p_credits":          credits,
+		"p_transaction_type": transactionType,
+		"p_description":      optionalString(description),
+		"p_assessment_id":    assessmentID,
+	}).Execute(&transaction)
+	if err != nil {
+		return nil, ledgerError(err)
+	}
+	return &transaction, nil
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	postgrest "github.com/nedpals/supabase-go/postgrest/pkg"
+)
+
+func TestLedgerError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{&postgrest.RequestError{Code: "P0001", Message: "insufficient credits"}, ErrInsufficientCredits},
+		{fmt.Errorf("rpc: %w", &postgrest.RequestError{Code: "P0001", Message: "user credits not found"}), ErrCreditWalletNotFound},
+		{&postgrest.RequestError{Code: "P0001", Message: "credit reservation not found"}, ErrReservationNotFound},
+		{&postgrest.RequestError{Code: "P0001", Message: "overage spending cap reached"}, ErrOverageCapReached},
+	}
+	for _, tt := range tests {
+		if got := ledgerError(tt.err); !errors.Is(got, tt.want) {
+			t.Errorf("ledgerError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+
+	other := ledgerError(&postgrest.RequestError{Code: "23514", Message: "check constraint violated"})
+	for _, known := range []error{ErrInsufficientCredits, ErrOverageCapReached, ErrCreditWalletNotFound, ErrReservationNotFound} {
+		if errors.Is(other, known) {
+			t.Errorf("unrelated database error mapped to %v", known)
+		}
+	}
+}
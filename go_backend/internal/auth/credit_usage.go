@@ -0,0 +1,374 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Groupings of credit usage over time
+const (
+	UsageByDay   = "day"
+	UsageByWeek  = "week" // weeks start on Monday, UTC
+	UsageByMonth = "month"
+)
+
+// defaultUsageRange is the period reported when no from date is given
+const defaultUsageRange = 30 * 24 * time.Hour
+
+// maxUsageRange is the longest period a usage report may cover
+const maxUsageRange = 366 * 24 * time.Hour
+
+// forecastWindow is the trailing period the burn rate is measured over
+const forecastWindow = 30 * 24 * time.Hour
+
+// consumptionRow is a row of credit_consumption
+type consumptionRow struct {
+	Bucket         time.Time `json:"bucket"`
+	AssessmentType string    `json:"assessment_type"`
+	UserID         *string   `json:"user_id"`
+	Transactions   int       `json:"transactions"`
+	Credits        int       `json:"credits"`
+}
+
+// UsageBucket is the credits consumed in a day, week or month
+type UsageBucket struct {
+	Start        time.Time `json:"start"`
+	Transactions int       `json:"transactions"`
+	Credits      int       `json:"credits"`
+}
+
+// AssessmentTypeUsage is the credits consumed by an assessment type
+type AssessmentTypeUsage struct {
+	AssessmentType string `json:"assessment_type"`
+	Transactions   int    `json:"transactions"`
+	Credits        int    `json:"credits"`
+}
+
+// MemberUsage is the credits a member consumed from an organization's pool
+type MemberUsage struct {
+	UserID       string `json:"user_id"`
+	Email        string `json:"email,omitempty"`
+	Transactions int    `json:"transactions"`
+	Credits      int    `json:"credits"`
+}
+
+// CreditForecast estimates when the balance runs out at the current burn rate
+type CreditForecast struct {
+	AvailableCredits   int        `json:"available_credits"`
+	WindowDays         float64    `json:"window_days"` // days the burn rate is measured over
+	CreditsConsumed    int        `json:"credits_consumed"`
+	DailyBurnRate      float64    `json:"daily_burn_rate"`
+	DaysRemaining      *float64   `json:"days_remaining"` // nil when no credits are being consumed
+	RunsOutAt          *time.Time `json:"runs_out_at"`
+	NextResetAt        *time.Time `json:"next_reset_at"`
+	RunsOutBeforeReset bool       `json:"runs_out_before_reset"`
+}
+
+// CreditUsage is the credit consumption of a workspace's wallet
+type CreditUsage struct {
+	GroupBy           string                `json:"group_by"`
+	From              time.Time             `json:"from"`
+	To                time.Time             `json:"to"`
+	TotalCredits      int                   `json:"total_credits"`
+	TotalTransactions int                   `json:"total_transactions"`
+	Series            []UsageBucket         `json:"series"`
+	ByAssessmentType  []AssessmentTypeUsage `json:"by_assessment_type"`
+	ByUser            []MemberUsage         `json:"by_user,omitempty"` // organization workspaces only
+	Forecast          *CreditForecast       `json:"forecast"`
+}
+
+// isUsageGrouping reports whether groupBy is a supported grouping
+func isUsageGrouping(groupBy string) bool {
+	return groupBy == UsageByDay || groupBy == UsageByWeek || groupBy == UsageByMonth
+}
+
+// usageBucketStart returns the start of the UTC day, week or month holding t
+func usageBucketStart(t time.Time, groupBy string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch groupBy {
+	case UsageByWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case UsageByMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return day
+}
+
+// usageBuckets returns the start of every bucket overlapping [from, to)
+func usageBuckets(from, to time.Time, groupBy string) []time.Time {
+	var buckets []time.Time
+	for start := usageBucketStart(from, groupBy); start.Before(to); {
+		buckets = append(buckets, start)
+		switch groupBy {
+		case UsageByWeek:
+			start = start.AddDate(0, 0, 7)
+		case UsageByMonth:
+			start = start.AddDate(0, 1, 0)
+		default:
+			start = start.AddDate(0, 0, 1)
+		}
+	}
+	return buckets
+}
+
+// summarizeConsumption folds rows of credit_consumption into a usage report
+// with a bucket for every period, including those without consumption
+func summarizeConsumption(rows []consumptionRow, from, to time.Time, groupBy string, byUser bool) *CreditUsage {
+	usage := &CreditUsage{
+		GroupBy:          groupBy,
+		From:             from,
+		To:               to,
+		Series:           []UsageBucket{},
+		ByAssessmentType: []AssessmentTypeUsage{},
+	}
+
+	buckets := map[time.Time]*UsageBucket{}
+	for _, start := range usageBuckets(from, to, groupBy) {
+		usage.Series = append(usage.Series, UsageBucket{Start: start})
+	}
+	for i := range usage.Series {
+		buckets[usage.Series[i].Start] = &usage.Series[i]
+	}
+
+	types := map[string]*AssessmentTypeUsage{}
+	users := map[string]*MemberUsage{}
+	for _, row := range rows {
+		usage.TotalCredits += row.Credits
+		usage.TotalTransactions += row.Transactions
+
+		if bucket, ok := buckets[usageBucketStart(row.Bucket, groupBy)]; ok {
+			bucket.Credits += row.Credits
+			bucket.Transactions += row.Transactions
+		}
+
+		t, ok := types[row.AssessmentType]
+		if !ok {
+			t = &AssessmentTypeUsage{AssessmentType: row.AssessmentType}
+			types[row.AssessmentType] = t
+		}
+		t.Credits += row.Credits
+		t.Transactions += row.Transactions
+
+		if byUser && row.UserID != nil {
+			u, ok := users[*row.UserID]
+			if !ok {
+				u = &MemberUsage{UserID: *row.UserID}
+				users[*row.UserID] = u
+			}
+			u.Credits += row.Credits
+			u.Transactions += row.Transactions
+		}
+	}
+
+	for _, t := range types {
+		usage.ByAssessmentType = append(usage.ByAssessmentType, *t)
+	}
+	sort.Slice(usage.ByAssessmentType, func(i, j int) bool {
+		a, b := usage.ByAssessmentType[i], usage.ByAssessmentType[j]
+		if a.Credits != b.Credits {
+			return a.Credits > b.Credits
+		}
+		return a.AssessmentType < b.AssessmentType
+	})
+
+	if byUser {
+		usage.ByUser = []MemberUsage{}
+		for _, u := range users {
+			usage.ByUser = append(usage.ByUser, *u)
+		}
+		sort.Slice(usage.ByUser, func(i, j int) bool {
+			a, b := usage.ByUser[i], usage.ByUser[j]
+			if a.Credits != b.Credits {
+				return a.Credits > b.Credits
+			}
+			return a.UserID < b.UserID
+		})
+	}
+	return usage
+}
+
+// forecastCredits projects when available credits run out if consumption
+// continues at the rate of consumed credits over window
+func forecastCredits(available, consumed int, window time.Duration, now time.Time, nextReset *time.Time) *CreditForecast {
+	days := window.Hours() / 24
+	forecast := &CreditForecast{
+		AvailableCredits: available,
+		WindowDays:       math.Round(days*10) / 10,
+		CreditsConsumed:  consumed,
+		NextResetAt:      nextReset,
+	}
+	if days <= 0 || consumed <= 0 {
+		return forecast
+	}
+
+	forecast.DailyBurnRate = math.Round(float64(consumed)/days*100) / 100
+	remaining := math.Max(float64(available), 0) / (float64(consumed) / days)
+	runsOut := now.Add(time.Duration(remaining * float64(24*time.Hour))).UTC()
+
+	remaining = math.Round(remaining*10) / 10
+	forecast.DaysRemaining = &remaining
+	forecast.RunsOutAt = &runsOut
+	forecast.RunsOutBeforeReset = nextReset != nil && runsOut.Before(*nextReset)
+	return forecast
+}
+
+// fetchConsumption calls credit_consumption for the workspace's wallet
+func fetchConsumption(workspace Workspace, from, to time.Time, groupBy string) ([]consumptionRow, error) {
+	var rows []consumptionRow
+	err := supabaseClient.DB.Rpc("credit_consumption", map[string]interface{}{
+		"p_user_id": workspace.UserID,
+		"p_org_id":  workspace.OrgIDPtr(),
+		"p_from":    from.UTC().Format(time.RFC3339),
+		"p_to":      to.UTC().Format(time.RFC3339),
+		"p_bucket":  groupBy,
+	}).Execute(&rows)
+	return rows, err
+}
+
+// GetCreditUsage reports the credits the workspace's wallet consumed in
+// [from, to) and forecasts when its balance runs out
+func GetCreditUsage(workspace Workspace, from, to time.Time, groupBy string) (*CreditUsage, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	rows, err := fetchConsumption(workspace, from, to, groupBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch credit consumption: %v", err)
+	}
+	usage := summarizeConsumption(rows, from, to, groupBy, workspace.IsOrganization())
+
+	if len(usage.ByUser) > 0 {
+		ids := make([]string, 0, len(usage.ByUser))
+		for _, u := range usage.ByUser {
+			ids = append(ids, u.UserID)
+		}
+		var profiles []UserProfile
+		if err := supabaseClient.DB.From("user_profiles").Select("*").In("id", ids).Execute(&profiles); err != nil {
+			log.Printf("⚠️ GetCreditUsage: Failed to fetch member profiles for org %s: %v", workspace.OrgID, err)
+		}
+		emails := map[string]string{}
+		for _, p := range profiles {
+			emails[p.ID] = p.Email
+		}
+		for i := range usage.ByUser {
+			usage.ByUser[i].Email = emails[usage.ByUser[i].UserID]
+		}
+	}
+
+	wallet, err := workspace.Credits()
+	if err != nil {
+		return nil, err
+	}
+
+	// Measure the burn rate over the trailing window, or since the wallet was
+	// created when it is younger
+	now := time.Now().UTC()
+	windowStart := now.Add(-forecastWindow)
+	if wallet.CreatedAt.After(windowStart) {
+		windowStart = wallet.CreatedAt
+	}
+	recent, err := fetchConsumption(workspace, windowStart, now, UsageByMonth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch recent credit consumption: %v", err)
+	}
+	consumed := 0
+	for _, row := range recent {
+		consumed += row.Credits
+	}
+	// A day at least, so a new wallet's first assessment doesn't project an
+	// extreme rate
+	window := max(now.Sub(windowStart), 24*time.Hour)
+	usage.Forecast = forecastCredits(wallet.AvailableCredits, consumed, window, now, wallet.NextResetDate)
+	return usage, nil
+}
+
+// parseUsageTime parses an RFC 3339 time or a YYYY-MM-DD date. A date given
+// as the end of a range includes the whole day.
+func parseUsageTime(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// usageRange reads the from and to query parameters, defaulting to the last
+// defaultUsageRange
+func usageRange(fromParam, toParam string, now time.Time) (time.Time, time.Time, error) {
+	to := now.UTC()
+	if toParam != "" {
+		t, err := parseUsageTime(toParam, true)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to must be an RFC 3339 time or a YYYY-MM-DD date")
+		}
+		to = t
+	}
+	from := to.Add(-defaultUsageRange)
+	if fromParam != "" {
+		t, err := parseUsageTime(fromParam, false)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from must be an RFC 3339 time or a YYYY-MM-DD date")
+		}
+		from = t
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	if to.Sub(from) > maxUsageRange {
+		return time.Time{}, time.Time{}, fmt.Errorf("the range may cover at most 366 days")
+	}
+	return from, to, nil
+}
+
+// GetCreditUsageHandler handles GET /api/auth/credits/usage
+func GetCreditUsageHandler(c *gin.Context) {
+	workspace, ok := ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	groupBy := c.DefaultQuery("group_by", UsageByDay)
+	if !isUsageGrouping(groupBy) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "group_by must be day, week or month",
+			"code":  "INVALID_GROUP_BY",
+		})
+		return
+	}
+
+	from, to, err := usageRange(c.Query("from"), c.Query("to"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+			"code":  "INVALID_DATE_RANGE",
+		})
+		return
+	}
+
+	usage, err := GetCreditUsage(workspace, from, to, groupBy)
+	if err != nil {
+		log.Printf("❌ GetCreditUsageHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch credit usage",
+			"code":  "CREDIT_USAGE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
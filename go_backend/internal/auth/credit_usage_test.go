@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsageBucketStart(t *testing.T) {
+	at := time.Date(2026, 10, 15, 17, 30, 0, 0, time.UTC) // a Thursday
+	tests := []struct {
+		groupBy string
+		want    time.Time
+	}{
+		{UsageByDay, time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+		{UsageByWeek, time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)},
+		{UsageByMonth, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := usageBucketStart(at, tt.groupBy); !got.Equal(tt.want) {
+			t.Errorf("usageBucketStart(%s) = %v, want %v", tt.groupBy, got, tt.want)
+		}
+	}
+
+	sunday := time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC)
+	if got := usageBucketStart(sunday, UsageByWeek); !got.Equal(time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("week of Sunday starts %v, want the Monday before", got)
+	}
+}
+
+func TestUsageBuckets(t *testing.T) {
+	from := time.Date(2026, 1, 30, 12, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	months := usageBuckets(from, to, UsageByMonth)
+	if len(months) != 3 || !months[0].Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("months = %v, want January to March", months)
+	}
+	if days := usageBuckets(from, to, UsageByDay); len(days) != 61 {
+		t.Errorf("got %d days, want 61", len(days))
+	}
+}
+
+func TestSummarizeConsumption(t *testing.T) {
+	alice, bob := "alice", "bob"
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 10, 4, 0, 0, 0, 0, time.UTC)
+	rows := []consumptionRow{
+		{Bucket: from, AssessmentType: "quick", UserID: &alice, Transactions: 2, Credits: 2},
+		{Bucket: from, AssessmentType: "comprehensive", UserID: &bob, Transactions: 1, Credits: 3},
+		{Bucket: from.AddDate(0, 0, 2), AssessmentType: "quick", UserID: &bob, Transactions: 1, Credits: 1},
+	}
+
+	usage := summarizeConsumption(rows, from, to, UsageByDay, true)
+	if usage.TotalCredits != 6 || usage.TotalTransactions != 4 {
+		t.Errorf("totals = %d credits, %d transactions, want 6 and 4", usage.TotalCredits, usage.TotalTransactions)
+	}
+	if len(usage.Series) != 3 || usage.Series[0].Credits != 5 || usage.Series[1].Credits != 0 || usage.Series[2].Credits != 1 {
+		t.Errorf("series = %+v, want 5, 0, 1 credits", usage.Series)
+	}
+	if len(usage.ByAssessmentType) != 2 || usage.ByAssessmentType[0].AssessmentType != "comprehensive" || usage.ByAssessmentType[1].Credits != 3 {
+		t.Errorf("by assessment type = %+v", usage.ByAssessmentType)
+	}
+	if len(usage.ByUser) != 2 || usage.ByUser[0].UserID != "bob" || usage.ByUser[0].Credits != 4 {
+		t.Errorf("by user = %+v, want bob first with 4 credits", usage.ByUser)
+	}
+
+	if personal := summarizeConsumption(rows, from, to, UsageByDay, false); personal.ByUser != nil {
+		t.Errorf("personal usage reported by user: %+v", personal.ByUser)
+	}
+}
+
+func TestForecastCredits(t *testing.T) {
+	now := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	reset := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	forecast := forecastCredits(50, 60, 30*24*time.Hour, now, &reset)
+	if forecast.DailyBurnRate != 2 || forecast.DaysRemaining == nil || *forecast.DaysRemaining != 25 {
+		t.Fatalf("forecast = %+v, want 2 credits a day for 25 days", forecast)
+	}
+	if !forecast.RunsOutAt.Equal(now.AddDate(0, 0, 25)) || forecast.RunsOutBeforeReset {
+		t.Errorf("runs out %v (before reset %v), want after the reset", forecast.RunsOutAt, forecast.RunsOutBeforeReset)
+	}
+
+	if soon := forecastCredits(10, 60, 30*24*time.Hour, now, &reset); !soon.RunsOutBeforeReset {
+		t.Errorf("10 credits at 2 a day should run out before the reset")
+	}
+
+	idle := forecastCredits(50, 0, 30*24*time.Hour, now, &reset)
+	if idle.DaysRemaining != nil || idle.RunsOutAt != nil || idle.RunsOutBeforeReset {
+		t.Errorf("idle wallet forecast = %+v, want no run-out date", idle)
+	}
+}
+
+func TestUsageRange(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	from, to, err := usageRange("", "", now)
+	if err != nil || !to.Equal(now) || !from.Equal(now.Add(-defaultUsageRange)) {
+		t.Errorf("default range = %v..%v, %v", from, to, err)
+	}
+
+	from, to, err = usageRange("2026-10-01", "2026-10-07", now)
+	if err != nil || !from.Equal(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2026, 10, 8, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("date range = %v..%v, %v, want the whole of October 7 included", from, to, err)
+	}
+
+	for _, params := range [][2]string{
+		{"yesterday", ""},
+		{"2026-10-07", "2026-10-01"},
+		{"2024-01-01", "2026-01-01"},
+	} {
+		if _, _, err := usageRange(params[0], params[1], now); err == nil {
+			t.Errorf("usageRange(%q, %q) accepted", params[0], params[1])
+		}
+	}
+}
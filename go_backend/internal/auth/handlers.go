@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -24,6 +25,15 @@ type UserProfileResponse struct {
 	Status              string  `json:"status"`
 	Plan                *SubscriptionPlan `json:"current_plan,omitempty"`
 	Credits             *UserCredits     `json:"credits,omitempty"`
+	Workspace           WorkspaceAccess   `json:"workspace"`
+	Organizations       []WorkspaceAccess `json:"organizations"`
+}
+
+// WorkspaceAccess is the user's role and effective permissions in a workspace
+type WorkspaceAccess struct {
+	OrgID       string   `json:"org_id,omitempty"`
+	Role        string   `json:"role,omitempty"`
+	Permissions []string `json:"permissions"`
 }
 
 // GetProfileHandler handles GET /api/auth/profile
@@ -123,6 +133,16 @@ func GetProfileHandler(c *gin.Context) {
 		}
 	}
 
+	// Effective permissions for the requested workspace and every organization
+	workspace, ok := ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+	organizations, err := listOrganizationAccess(userID)
+	if err != nil {
+		log.Printf("⚠️ GetProfileHandler: Failed to load organization access for user %s: %v", userID, err)
+	}
+
 	response := UserProfileResponse{
 		ID:                  profile.ID,
 		Email:               profile.Email,
@@ -138,6 +158,8 @@ func GetProfileHandler(c *gin.Context) {
 		Status:              profile.Status,
 		Plan:                plan,
 		Credits:             credits,
+		Workspace:           WorkspaceAccess{OrgID: workspace.OrgID, Role: workspace.Role, Permissions: workspace.Permissions},
+		Organizations:       organizations,
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -180,6 +202,9 @@ func GetSubscriptionPlansHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, plans)
 }
 
+// adminManagedProfileFields are the profile columns users cannot change themselves
+var adminManagedProfileFields = []string{"id", "status", "suspended_at", "suspension_reason", "is_platform_admin"}
+
 // UpdateProfileHandler handles PUT /api/auth/profile
 func UpdateProfileHandler(c *gin.Context) {
 	userID := GetUserID(c)
@@ -197,6 +222,17 @@ func UpdateProfileHandler(c *gin.Context) {
 		return
 	}
 
+	// Account status and admin access are managed through the admin API
+	for _, field := range adminManagedProfileFields {
+		if _, found := updateData[field]; found {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("%s cannot be changed", field),
+				"code":  "PROTECTED_FIELD",
+			})
+			return
+		}
+	}
+
 	// Add updated_at timestamp
 	updateData["updated_at"] = "NOW()"
 
@@ -286,7 +322,20 @@ func RegisterHandler(c *gin.Context) {
 
 // LogoutHandler handles POST /api/auth/logout
 func LogoutHandler(c *gin.Context) {
-	// For JWT tokens, logout is handled client-side by removing the token
+	// Revoke the session so its access tokens are rejected even before they expire
+	if sessionID := GetSessionID(c); sessionID != "" && supabaseClient != nil {
+		if _, err := RevokeSession(GetUserID(c), sessionID); err != nil {
+			log.Printf("⚠️ LogoutHandler: %v", err)
+		}
+
+		// Also end the Supabase session so its refresh token stops working
+		if token := c.GetString("access_token"); token != "" {
+			if err := supabaseClient.Auth.SignOut(c.Request.Context(), token); err != nil {
+				log.Printf("⚠️ LogoutHandler: Supabase sign out failed: %v", err)
+			}
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Logout successful",
 		"info":    "Remove JWT token from client storage",
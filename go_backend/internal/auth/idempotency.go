@@ -0,0 +1,324 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Headers of idempotent requests: the client's key, and the marker on
+// responses replayed from an earlier request
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+const (
+	// idempotencyRetention is how long a key's response is replayed
+	idempotencyRetention = 24 * time.Hour
+	// idempotencyLease is how long a request may process a key before a
+	// retry may claim it again, such as after a crash
+	idempotencyLease         = 15 * time.Minute
+	idempotencySweepInterval = time.Hour
+	maxIdempotencyKeyLength  = 255
+	// maxIdempotentBodyBytes bounds the body buffered to fingerprint a request
+	maxIdempotentBodyBytes = 10 << 20
+)
+
+// idempotencyCompleted is the status of a key whose response is stored
+const idempotencyCompleted = "completed"
+
+// omitResponseKey marks requests whose response body must not be stored
+const omitResponseKey = "idempotency_omit_response"
+
+// idempotentResponseHeaders are stored with a response and replayed
+var idempotentResponseHeaders = []string{"Content-Type", "Content-Disposition", "Location"}
+
+// idempotencyRecord is a row of idempotency_keys
+type idempotencyRecord struct {
+	ID              string            `json:"id"`
+	UserID          string            `json:"user_id"`
+	IdempotencyKey  string            `json:"idempotency_key"`
+	Method          string            `json:"method"`
+	Path            string            `json:"path"`
+	RequestHash     string            `json:"request_hash"`
+	Status          string            `json:"status"`
+	ClaimID         string            `json:"claim_id"`
+	LockedUntil     time.Time         `json:"locked_until"`
+	ResponseStatus  *int              `json:"response_status"`
+	ResponseHeaders map[string]string `json:"response_headers"`
+	ResponseBody    *string           `json:"response_body"`
+	CreatedAt       time.Time         `json:"created_at"`
+	CompletedAt     *time.Time        `json:"completed_at"`
+	ExpiresAt       time.Time         `json:"expires_at"`
+}
+
+// Idempotency makes POST, PUT, PATCH and DELETE requests sent with an
+// Idempotency-Key header safe to retry. The first request with a key runs
+// and its response is stored for idempotencyRetention; retries with the same
+// method, path, workspace and body get that response again, marked with
+// Idempotent-Replayed. A key reused for a different request, or while its
+// first request is still running, gets 409. Only replayable responses are
+// stored (see replayableStatus); after any other the key is released so the
+// request can be retried. Handlers returning secrets call
+// OmitIdempotentResponse: only their status is stored, and retries get 409.
+// Use after AuthMiddleware; keys are per user. Storage errors are logged and
+// the request runs normally.
+func Idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		userID := GetUserID(c)
+		if key == "" || userID == "" || !isMutatingMethod(c.Request.Method) || supabaseClient == nil {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("%s must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength),
+				"code":  "INVALID_IDEMPOTENCY_KEY",
+			})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": fmt.Sprintf("Request body exceeds the maximum of %d bytes", maxIdempotentBodyBytes),
+				"code":  "REQUEST_TOO_LARGE",
+			})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Failed to read request body",
+				"code":  "INVALID_REQUEST",
+			})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		path := c.Request.URL.RequestURI()
+		hash := requestFingerprint(c.Request.Method, path, c.GetHeader(OrganizationHeader), body)
+		claimID := uuid.NewString()
+		record, err := claimIdempotencyKey(userID, key, c.Request.Method, path, hash, claimID)
+		if err != nil {
+			log.Printf("⚠️ Idempotency: %v", err)
+			c.Next()
+			return
+		}
+		if record.ClaimID != claimID {
+			rejectOrReplay(c, record, hash)
+			c.Abort()
+			return
+		}
+
+		writer := &recordingResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		if status := writer.Status(); replayableStatus(status) {
+			err = completeIdempotencyKey(userID, key, claimID, status, writer.Header(), writer.body.Bytes(), !c.GetBool(omitResponseKey))
+		} else {
+			err = releaseIdempotencyKey(userID, key, claimID)
+		}
+		if err != nil {
+			log.Printf("⚠️ Idempotency: %v", err)
+		}
+	}
+}
+
+// OmitIdempotentResponse keeps the response of the current request out of
+// idempotency storage, for responses carrying secrets such as new API keys,
+// TOTP secrets, recovery codes and tokens. A retry with the same key gets
+// 409 IDEMPOTENT_RESPONSE_NOT_STORED instead of a replay.
+func OmitIdempotentResponse(c *gin.Context) {
+	c.Set(omitResponseKey, true)
+}
+
+// rejectOrReplay answers a request whose key was claimed by an earlier one
+func rejectOrReplay(c *gin.Context, record *idempotencyRecord, hash string) {
+	switch {
+	case record.ID != "" && record.RequestHash != hash:
+		log.Printf("🔁 Idempotency: Key %q of user %s reused for a different request to %s", record.IdempotencyKey, record.UserID, c.Request.URL.Path)
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "This Idempotency-Key was already used for a different request",
+			"code":  "IDEMPOTENCY_KEY_MISMATCH",
+		})
+	case record.Status != idempotencyCompleted || record.ResponseStatus == nil:
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "A request with this Idempotency-Key is still being processed",
+			"code":  "IDEMPOTENCY_KEY_IN_USE",
+		})
+	case record.ResponseBody == nil:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "A request with this Idempotency-Key already succeeded; its response holds secrets and is not stored",
+			"code":  "IDEMPOTENT_RESPONSE_NOT_STORED",
+		})
+	default:
+		body, err := base64.StdEncoding.DecodeString(stringValue(record.ResponseBody))
+		if err != nil {
+			log.Printf("❌ Idempotency: Stored response of key %s is corrupt: %v", record.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to replay the stored response",
+				"code":  "IDEMPOTENCY_REPLAY_ERROR",
+			})
+			return
+		}
+		log.Printf("🔁 Idempotency: Replaying %d response of key %q for user %s on %s", *record.ResponseStatus, record.IdempotencyKey, record.UserID, c.Request.URL.Path)
+		for name, value := range record.ResponseHeaders {
+			c.Header(name, value)
+		}
+		c.Header(IdempotentReplayedHeader, "true")
+		c.Status(*record.ResponseStatus)
+		c.Writer.Write(body)
+	}
+}
+
+// recordingResponseWriter keeps a copy of the response body
+type recordingResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingResponseWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingResponseWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// replayableStatus reports whether a response is stored for retries:
+// successes, and client errors a retry of the same request would get again.
+// Rejections that depend on changing state, such as 401, 402 (out of
+// credits), 403 and 429, and server errors are not.
+func replayableStatus(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusGone,
+		http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	return status >= 200 && status < 300
+}
+
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// requestFingerprint identifies what a request asks for, so a key cannot be
+// replayed for a different request
+func requestFingerprint(method, path, orgID string, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s\n", method, path, orgID)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// claimIdempotencyKey claims key for the request with claimID, or returns
+// the key as an earlier request left it
+func claimIdempotencyKey(userID, key, method, path, hash, claimID string) (*idempotencyRecord, error) {
+	var record idempotencyRecord
+	err := supabaseClient.DB.Rpc("claim_idempotency_key", map[string]interface{}{
+		"p_user_id":           userID,
+		"p_key":               key,
+		"p_method":            method,
+		"p_path":              path,
+		"p_request_hash":      hash,
+		"p_claim_id":          claimID,
+		"p_lease_seconds":     int(idempotencyLease.Seconds()),
+		"p_retention_seconds": int(idempotencyRetention.Seconds()),
+	}).Execute(&record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key: %v", err)
+	}
+	return &record, nil
+}
+
+// completeIdempotencyKey stores the response of the request holding the key,
+// without its body unless storeBody
+func completeIdempotencyKey(userID, key, claimID string, status int, header http.Header, body []byte, storeBody bool) error {
+	headers := map[string]string{}
+	for _, name := range idempotentResponseHeaders {
+		if value := header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+	var storedBody interface{}
+	if storeBody {
+		storedBody = base64.StdEncoding.EncodeToString(body)
+	}
+
+	var results []idempotencyRecord
+	err := supabaseClient.DB.From("idempotency_keys").
+		Update(map[string]interface{}{
+			"status":           idempotencyCompleted,
+			"response_status":  status,
+			"response_headers": headers,
+			"response_body":    storedBody,
+			"completed_at":     time.Now().UTC().Format(time.RFC3339),
+		}).
+		Eq("user_id", userID).
+		Eq("idempotency_key", key).
+		Eq("claim_id", claimID).
+		Execute(&results)
+	if err != nil {
+		return fmt.Errorf("failed to store response of idempotency key %q: %v", key, err)
+	}
+	return nil
+}
+
+// releaseIdempotencyKey forgets a key whose request failed, so a retry runs it again
+func releaseIdempotencyKey(userID, key, claimID string) error {
+	var results []idempotencyRecord
+	err := supabaseClient.DB.From("idempotency_keys").
+		Delete().
+		Eq("user_id", userID).
+		Eq("idempotency_key", key).
+		Eq("claim_id", claimID).
+		Execute(&results)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key %q: %v", key, err)
+	}
+	return nil
+}
+
+// deleteExpiredIdempotencyKeys removes keys past their retention window
+func deleteExpiredIdempotencyKeys() (interface{}, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var deleted []idempotencyRecord
+	err := supabaseClient.DB.From("idempotency_keys").
+		Delete().
+		Lt("expires_at", time.Now().UTC().Format(time.RFC3339)).
+		Execute(&deleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired idempotency keys: %v", err)
+	}
+	if len(deleted) > 0 {
+		log.Printf("♻️ deleteExpiredIdempotencyKeys: Deleted %d expired idempotency keys", len(deleted))
+	}
+	return map[string]int{"deleted": len(deleted)}, nil
+}
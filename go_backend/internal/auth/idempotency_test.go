@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	supa "github.com/nedpals/supabase-go"
+)
+
+func TestRequestFingerprint(t *testing.T) {
+	base := requestFingerprint(http.MethodPost, "/api/website-risk-assessment/do-assessment", "", []byte(`{"website":"example.com"}`))
+	if base != requestFingerprint(http.MethodPost, "/api/website-risk-assessment/do-assessment", "", []byte(`{"website":"example.com"}`)) {
+		t.Error("fingerprint of the same request changed")
+	}
+
+	others := []string{
+		requestFingerprint(http.MethodPost, "/api/website-risk-assessment/do-assessment", "", []byte(`{"website":"example.org"}`)),
+		requestFingerprint(http.MethodPut, "/api/website-risk-assessment/do-assessment", "", []byte(`{"website":"example.com"}`)),
+		requestFingerprint(http.MethodPost, "/api/v1/assessments", "", []byte(`{"website":"example.com"}`)),
+		requestFingerprint(http.MethodPost, "/api/website-risk-assessment/do-assessment", "org-1", []byte(`{"website":"example.com"}`)),
+	}
+	for i, other := range others {
+		if other == base {
+			t.Errorf("request %d has the same fingerprint as a different request", i)
+		}
+	}
+}
+
+func TestRejectOrReplay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	status := http.StatusCreated
+	body := base64.StdEncoding.EncodeToString([]byte(`{"id":42}`))
+	tests := []struct {
+		name   string
+		record idempotencyRecord
+		want   int
+		code   string
+	}{
+		{"different request", idempotencyRecord{ID: "k1", RequestHash: "other", Status: idempotencyCompleted, ResponseStatus: &status, ResponseBody: &body}, http.StatusConflict, "IDEMPOTENCY_KEY_MISMATCH"},
+		{"still processing", idempotencyRecord{ID: "k1", RequestHash: "hash", Status: "processing"}, http.StatusConflict, "IDEMPOTENCY_KEY_IN_USE"},
+		{"released meanwhile", idempotencyRecord{}, http.StatusConflict, "IDEMPOTENCY_KEY_IN_USE"},
+		{"completed", idempotencyRecord{ID: "k1", RequestHash: "hash", Status: idempotencyCompleted, ResponseStatus: &status,
+			ResponseHeaders: map[string]string{"Content-Type": "application/json"}, ResponseBody: &body}, http.StatusCreated, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/website-risk-assessment/do-assessment", nil)
+
+		rejectOrReplay(c, &tt.record, "hash")
+		if rec.Code != tt.want || !strings.Contains(rec.Body.String(), tt.code) {
+			t.Errorf("%s: status %d %s, want %d %s", tt.name, rec.Code, rec.Body.String(), tt.want, tt.code)
+		}
+		if tt.code == "" {
+			if rec.Body.String() != `{"id":42}` || rec.Header().Get(IdempotentReplayedHeader) != "true" || rec.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("%s: replayed %q with headers %v", tt.name, rec.Body.String(), rec.Header())
+			}
+		}
+	}
+}
+
+func TestRecordingResponseWriter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	var recorded *recordingResponseWriter
+	router.Use(func(c *gin.Context) {
+		recorded = &recordingResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = recorded
+		c.Next()
+	})
+	router.POST("/things", func(c *gin.Context) { c.JSON(http.StatusCreated, gin.H{"id": 1}) })
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/things", nil))
+	if recorded.Status() != http.StatusCreated || recorded.body.String() != rec.Body.String() || rec.Body.String() != `{"id":1}` {
+		t.Errorf("recorded %d %q, sent %q", recorded.Status(), recorded.body.String(), rec.Body.String())
+	}
+}
+
+func TestIdempotencySkipsSafeRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions} {
+		if isMutatingMethod(method) {
+			t.Errorf("%s treated as mutating", method)
+		}
+	}
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		if !isMutatingMethod(method) {
+			t.Errorf("%s not treated as mutating", method)
+		}
+	}
+
+	// Without storage requests run normally
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_id", "user-1") }, Idempotency())
+	calls := 0
+	router.POST("/things", func(c *gin.Context) { calls++; c.Status(http.StatusCreated) })
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/things", nil)
+		req.Header.Set(IdempotencyKeyHeader, "retry-1")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times without storage, want 2", calls)
+	}
+}
+
+func TestReplayableStatus(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity} {
+		if !replayableStatus(status) {
+			t.Errorf("%d not stored", status)
+		}
+	}
+	for _, status := range []int{http.StatusUnauthorized, http.StatusPaymentRequired, http.StatusForbidden, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		if replayableStatus(status) {
+			t.Errorf("%d stored, a retry could succeed", status)
+		}
+	}
+}
+
+func TestOmitIdempotentResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var stored map[string]interface{}
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/rest/v1/rpc/claim_idempotency_key":
+			var params map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&params)
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": "k1", "claim_id": params["p_claim_id"], "status": "processing"})
+		case r.URL.Path == "/rest/v1/idempotency_keys" && r.Method == http.MethodPatch:
+			json.NewDecoder(r.Body).Decode(&stored)
+			w.Write([]byte("[]"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer storage.Close()
+	supabaseClient = supa.CreateClient(storage.URL, "service-key")
+	defer func() { supabaseClient = nil }()
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_id", "user-1") }, Idempotency())
+	router.POST("/api/auth/api-keys", func(c *gin.Context) {
+		OmitIdempotentResponse(c)
+		c.JSON(http.StatusCreated, gin.H{"key": "qf_secret"})
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/api-keys", nil)
+	req.Header.Set(IdempotencyKeyHeader, "create-1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), "qf_secret") {
+		t.Fatalf("first request: %d %s", rec.Code, rec.Body.String())
+	}
+	if stored == nil || stored["response_body"] != nil || stored["response_status"] != float64(http.StatusCreated) {
+		t.Errorf("stored %v, want the status without the body", stored)
+	}
+
+	// A retry of a request whose response was not stored is refused, not replayed
+	rec = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/auth/api-keys", nil)
+	status := http.StatusCreated
+	rejectOrReplay(c, &idempotencyRecord{ID: "k1", RequestHash: "hash", Status: idempotencyCompleted, ResponseStatus: &status}, "hash")
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "IDEMPOTENT_RESPONSE_NOT_STORED") {
+		t.Errorf("status %d %s, want 409 IDEMPOTENT_RESPONSE_NOT_STORED", rec.Code, rec.Body.String())
+	}
+}
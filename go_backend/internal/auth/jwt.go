@@ -0,0 +1,345 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultJWKSCacheTTL   = 10 * time.Minute
+	minJWKSRefreshSpacing = 30 * time.Second
+	defaultClockLeeway    = 30 * time.Second
+)
+
+var (
+	// errVerifierUnavailable means the token could not be checked locally (no
+	// key material or JWKS unreachable), as opposed to the token being invalid
+	errVerifierUnavailable = errors.New("local token verification unavailable")
+	errUnknownSigningKey   = errors.New("token signed with unknown key")
+)
+
+// JWTConfig configures local verification of Supabase-issued access tokens
+type JWTConfig struct {
+	Secret         string        // HS256 shared secret (legacy Supabase JWT secret)
+	JWKSURL        string        // JWKS endpoint for asymmetric signing keys
+	Issuer         string        // expected iss claim, e.g. https://<project>.supabase.co/auth/v1
+	Audience       string        // expected aud claim, "authenticated" for Supabase users
+	RemoteFallback bool          // ask Supabase when the token cannot be verified locally
+	JWKSCacheTTL   time.Duration // how long fetched keys are trusted before refresh
+	Leeway         time.Duration // allowed clock skew for exp/nbf/iat
+}
+
+// Claims are the Supabase access token claims the API relies on
+type Claims struct {
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	SessionID string `json:"session_id"`
+	AAL       string `json:"aal"`
+	jwt.RegisteredClaims
+}
+
+// authenticatedUser is the identity resolved from a bearer token
+type authenticatedUser struct {
+	ID     string
+	Email  string
+	Claims *Claims // nil when resolved through remote introspection
+}
+
+// tokenVerifier verifies tokens locally with an HS256 secret and/or a JWKS
+type tokenVerifier struct {
+	cfg    JWTConfig
+	parser *jwt.Parser
+	jwks   *jwksCache
+}
+
+var verifier *tokenVerifier
+
+// InitJWTVerification enables local token verification in the auth middleware
+func InitJWTVerification(cfg JWTConfig) error {
+	v, err := newTokenVerifier(cfg)
+	if err != nil {
+		return err
+	}
+	verifier = v
+	return nil
+}
+
+func newTokenVerifier(cfg JWTConfig) (*tokenVerifier, error) {
+	if cfg.Secret == "" && cfg.JWKSURL == "" {
+		return nil, fmt.Errorf("JWT verification requires a secret or a JWKS URL")
+	}
+	if cfg.JWKSCacheTTL <= 0 {
+		cfg.JWKSCacheTTL = defaultJWKSCacheTTL
+	}
+	if cfg.Leeway <= 0 {
+		cfg.Leeway = defaultClockLeeway
+	}
+
+	// Every supported algorithm is parsed; one without key material is
+	// reported as errVerifierUnavailable by verify so remote fallback can run
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256", "ES256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+
+	v := &tokenVerifier{cfg: cfg, parser: jwt.NewParser(options...)}
+	if cfg.JWKSURL != "" {
+		v.jwks = newJWKSCache(cfg.JWKSURL, cfg.JWKSCacheTTL)
+	}
+	return v, nil
+}
+
+// verify parses and validates a token, returning errVerifierUnavailable
+// (wrapped) when the signing key could not be resolved
+func (v *tokenVerifier) verify(ctx context.Context, tokenString string) (*authenticatedUser, error) {
+	claims := &Claims{}
+	_, err := v.parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.Alg() {
+		case "HS256":
+			if v.cfg.Secret == "" {
+				return nil, fmt.Errorf("%w: no secret for HS256 tokens", errVerifierUnavailable)
+			}
+			return []byte(v.cfg.Secret), nil
+		default:
+			kid, _ := token.Header["kid"].(string)
+			return v.jwks.key(ctx, kid)
+		}
+	})
+	if err != nil {
+		if errors.Is(err, errVerifierUnavailable) || errors.Is(err, errUnknownSigningKey) {
+			return nil, fmt.Errorf("%w: %v", errVerifierUnavailable, err)
+		}
+		return nil, err
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("token has no subject")
+	}
+
+	return &authenticatedUser{ID: claims.Subject, Email: claims.Email, Claims: claims}, nil
+}
+
+// authenticateToken resolves the user for a bearer token: locally when a
+// verifier is configured, remotely when no verifier exists or when local
+// verification is unavailable and remote fallback is enabled
+func authenticateToken(ctx context.Context, token string) (*authenticatedUser, error) {
+	if verifier != nil {
+		user, err := verifier.verify(ctx, token)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, errVerifierUnavailable) || !verifier.cfg.RemoteFallback {
+			return nil, err
+		}
+		logAuthFallback(err)
+	}
+
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("authentication is not configured")
+	}
+	user, err := supabaseClient.Auth.User(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return &authenticatedUser{ID: user.ID, Email: user.Email}, nil
+}
+
+// jwksCache caches the public keys published at a JWKS URL. Keys are refreshed
+// after the TTL, or early when a token references an unknown kid (key rotation).
+// At most one refresh runs at a time and refreshes are at least
+// minJWKSRefreshSpacing apart, even while the endpoint is unreachable.
+type jwksCache struct {
+	url        string
+	ttl        time.Duration
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]interface{}
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	lastErr     error
+	// refreshing is closed when the running refresh finishes; nil when idle
+	refreshing chan struct{}
+}
+
+func newJWKSCache(url string, ttl time.Duration) *jwksCache {
+	return &jwksCache{
+		url:        url,
+		ttl:        ttl,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		keys:       map[string]interface{}{},
+	}
+}
+
+// key returns the public key for a kid, refreshing the key set when needed
+func (j *jwksCache) key(ctx context.Context, kid string) (interface{}, error) {
+	if j == nil {
+		return nil, errVerifierUnavailable
+	}
+
+	j.mu.Lock()
+	stale := time.Since(j.fetchedAt) > j.ttl
+	key, found := j.keys[kid]
+	if found && !stale {
+		j.mu.Unlock()
+		return key, nil
+	}
+
+	// Refresh when stale or on an unknown kid, but never hammer the endpoint
+	done := j.refreshing
+	if done == nil {
+		if time.Since(j.lastAttempt) < minJWKSRefreshSpacing {
+			err := j.lastErr
+			j.mu.Unlock()
+			return j.fallbackKey(key, found, err)
+		}
+		done = make(chan struct{})
+		j.refreshing = done
+		j.lastAttempt = time.Now()
+		go j.refresh(done)
+	}
+	j.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return j.fallbackKey(key, found, ctx.Err())
+	}
+
+	j.mu.Lock()
+	fresh, ok := j.keys[kid]
+	err := j.lastErr
+	j.mu.Unlock()
+	if ok {
+		return fresh, nil
+	}
+	return j.fallbackKey(key, found, err)
+}
+
+// fallbackKey answers a lookup the key set could not satisfy: the last known
+// key while the endpoint is down, otherwise why verification is unavailable
+func (j *jwksCache) fallbackKey(key interface{}, found bool, err error) (interface{}, error) {
+	switch {
+	case found:
+		return key, nil
+	case err != nil:
+		return nil, fmt.Errorf("%w: %v", errVerifierUnavailable, err)
+	}
+	return nil, errUnknownSigningKey
+}
+
+// refresh downloads the key set without holding j.mu, so callers with cached
+// keys are not blocked, then stores the result and closes done
+func (j *jwksCache) refresh(done chan struct{}) {
+	keys, err := j.fetch()
+
+	j.mu.Lock()
+	j.lastErr = err
+	if err == nil {
+		j.keys = keys
+		j.fetchedAt = time.Now()
+	}
+	j.refreshing = nil
+	j.mu.Unlock()
+	close(done)
+}
+
+// fetch downloads and parses the key set
+func (j *jwksCache) fetch() (map[string]interface{}, error) {
+	resp, err := j.httpClient.Get(j.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint responded with HTTP %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS document: %v", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		publicKey, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = publicKey
+	}
+	return keys, nil
+}
+
+// jsonWebKey is a single RSA or EC public key from a JWKS document
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	supa "github.com/nedpals/supabase-go"
+)
+
+const (
+	testIssuer   = "https://project.supabase.co/auth/v1"
+	testAudience = "authenticated"
+)
+
+func testClaims(expiresIn time.Duration) Claims {
+	return Claims{
+		Email: "analyst@example.com",
+		Role:  "authenticated",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user-123",
+			Issuer:    testIssuer,
+			Audience:  jwt.ClaimStrings{testAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+		},
+	}
+}
+
+func TestVerifyHS256Token(t *testing.T) {
+	v, err := newTokenVerifier(JWTConfig{Secret: "secret", Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(claims Claims, secret string) string {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		return token
+	}
+
+	user, err := v.verify(context.Background(), sign(testClaims(time.Hour), "secret"))
+	if err != nil {
+		t.Fatalf("expected valid token, got %v", err)
+	}
+	if user.ID != "user-123" || user.Email != "analyst@example.com" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	expired := testClaims(-time.Hour)
+	wrongAudience := testClaims(time.Hour)
+	wrongAudience.Audience = jwt.ClaimStrings{"anon"}
+	wrongIssuer := testClaims(time.Hour)
+	wrongIssuer.Issuer = "https://attacker.example.com"
+
+	for name, token := range map[string]string{
+		"expired":        sign(expired, "secret"),
+		"wrong audience": sign(wrongAudience, "secret"),
+		"wrong issuer":   sign(wrongIssuer, "secret"),
+		"wrong secret":   sign(testClaims(time.Hour), "other"),
+	} {
+		_, err := v.verify(context.Background(), token)
+		if err == nil {
+			t.Errorf("%s: expected rejection", name)
+		}
+		if errors.Is(err, errVerifierUnavailable) {
+			t.Errorf("%s: invalid tokens must not trigger remote fallback", name)
+		}
+	}
+}
+
+// jwksServer serves a mutable RSA key set and counts fetches
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int
+}
+
+func newJWKSServer() *jwksServer {
+	s := &jwksServer{keys: map[string]*rsa.PrivateKey{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		set := []map[string]string{}
+		for kid, key := range s.keys {
+			set = append(set, map[string]string{
+				"kid": kid,
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": set})
+	}))
+	return s
+}
+
+func (s *jwksServer) addKey(t *testing.T, kid string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.keys[kid] = key
+	s.mu.Unlock()
+	return key
+}
+
+func signRS256(key *rsa.PrivateKey, kid string, claims Claims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, _ := token.SignedString(key)
+	return signed
+}
+
+func TestVerifyJWKSTokenWithKeyRotation(t *testing.T) {
+	server := newJWKSServer()
+	defer server.Close()
+	firstKey := server.addKey(t, "key-1")
+
+	v, err := newTokenVerifier(JWTConfig{JWKSURL: server.URL, Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := v.verify(ctx, signRS256(firstKey, "key-1", testClaims(time.Hour))); err != nil {
+		t.Fatalf("expected valid token, got %v", err)
+	}
+	if _, err := v.verify(ctx, signRS256(firstKey, "key-1", testClaims(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	if server.fetches != 1 {
+		t.Errorf("expected keys to be cached, got %d fetches", server.fetches)
+	}
+
+	// A rotated key is picked up on first sight of its kid
+	v.jwks.lastAttempt = time.Time{}
+	secondKey := server.addKey(t, "key-2")
+	if _, err := v.verify(ctx, signRS256(secondKey, "key-2", testClaims(time.Hour))); err != nil {
+		t.Fatalf("expected rotated key to verify, got %v", err)
+	}
+
+	// Unknown keys are reported as unavailable so remote fallback can decide
+	strangerKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, err = v.verify(ctx, signRS256(strangerKey, "key-3", testClaims(time.Hour)))
+	if !errors.Is(err, errVerifierUnavailable) {
+		t.Errorf("expected unavailable error for unknown kid, got %v", err)
+	}
+}
+
+func TestJWKSRefreshSpacingWhileEndpointDown(t *testing.T) {
+	var mu sync.Mutex
+	fetches := 0
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cache := newJWKSCache(server.URL, time.Hour)
+
+	// Concurrent lookups share one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.key(context.Background(), "key-1"); !errors.Is(err, errVerifierUnavailable) {
+				t.Errorf("expected unavailable error, got %v", err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// Later lookups within the spacing do not fetch again
+	for i := 0; i < 3; i++ {
+		if _, err := cache.key(context.Background(), "key-1"); !errors.Is(err, errVerifierUnavailable) {
+			t.Errorf("expected unavailable error, got %v", err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches != 1 {
+		t.Errorf("expected 1 fetch of the unreachable JWKS, got %d", fetches)
+	}
+}
+
+func TestOptionalAuthMiddlewareChecksSessionAndAccount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	v, err := newTokenVerifier(JWTConfig{Secret: "secret", Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier = v
+	// Storage is unreachable; checks answer from their caches
+	supabaseClient = supa.CreateClient("http://127.0.0.1:1", "service-key")
+	defer func() { verifier, supabaseClient = nil, nil }()
+
+	sign := func(subject, sessionID string) string {
+		claims := testClaims(time.Hour)
+		claims.Subject, claims.SessionID = subject, sessionID
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		return token
+	}
+	revokedSession := uuid.NewString()
+	setSessionState(revokedSession, sessionState{active: false, checkedAt: time.Now()})
+	accountCache.Lock()
+	accountCache.entries["user-active"] = accountState{status: AccountActive, checkedAt: time.Now()}
+	accountCache.entries["user-suspended"] = accountState{status: AccountSuspended, checkedAt: time.Now()}
+	accountCache.Unlock()
+	defer func() {
+		forgetAccountState("user-active")
+		forgetAccountState("user-suspended")
+	}()
+
+	router := gin.New()
+	router.GET("/report", OptionalAuthMiddleware(), func(c *gin.Context) { c.String(http.StatusOK, GetUserID(c)) })
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"active", sign("user-active", uuid.NewString()), "user-active"},
+		{"revoked session", sign("user-active", revokedSession), ""},
+		{"suspended account", sign("user-suspended", uuid.NewString()), ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/report", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Body.String() != tt.want {
+			t.Errorf("%s: status %d, user %q, want %q", tt.name, rec.Code, rec.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestHS256TokenWithoutSecretFallsBackToIntrospection(t *testing.T) {
+	introspected := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/auth/v1/user" {
+			http.NotFound(w, r)
+			return
+		}
+		introspected = true
+		json.NewEncoder(w).Encode(map[string]string{"id": "user-123", "email": "analyst@example.com"})
+	}))
+	defer server.Close()
+
+	v, err := newTokenVerifier(JWTConfig{JWKSURL: server.URL + "/jwks", Issuer: testIssuer, Audience: testAudience, RemoteFallback: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier = v
+	supabaseClient = supa.CreateClient(server.URL, "service-key")
+	defer func() { verifier, supabaseClient = nil, nil }()
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims(time.Hour)).SignedString([]byte("project-secret"))
+	if _, err := v.verify(context.Background(), token); !errors.Is(err, errVerifierUnavailable) {
+		t.Fatalf("HS256 token without a secret: %v, want errVerifierUnavailable", err)
+	}
+
+	user, err := authenticateToken(context.Background(), token)
+	if err != nil {
+		t.Fatalf("expected introspection, got %v", err)
+	}
+	if !introspected || user.ID != "user-123" || user.Claims != nil {
+		t.Errorf("introspected %v, user %+v", introspected, user)
+	}
+}
@@ -1,7 +1,8 @@
 package auth
 
 import (
-	"context"
+	"crypto/hkdf"
+	"crypto/sha256"
 	"fmt"
 	"log"
 	"net/http"
@@ -20,41 +21,70 @@ func InitAuth(supabaseURL, supabaseKey string) error {
 		return fmt.Errorf("failed to create Supabase client for auth")
 	}
 	supabaseClient = client
+	var err error
+	if phoneCodeKey, err = deriveKey(supabaseKey, "phone-code"); err != nil {
+		return fmt.Errorf("failed to derive phone code key: %v", err)
+	}
+	if twoFactorKey, err = deriveKey(supabaseKey, "two-factor"); err != nil {
+		return fmt.Errorf("failed to derive two-factor key: %v", err)
+	}
+	if impersonationKey, err = deriveKey(supabaseKey, "impersonation"); err != nil {
+		return fmt.Errorf("failed to derive impersonation key: %v", err)
+	}
+	startAuditWriter()
+	startScheduler()
 	return nil
 }
 
-// AuthMiddleware validates JWT tokens and extracts user context
-func AuthMiddleware() gin.HandlerFunc {
+// deriveKey derives a key for one use from the service key with HKDF. Each
+// use has its own label so no key is shared between purposes and the
+// service key itself never signs anything.
+func deriveKey(secret, label string) ([]byte, error) {
+	return hkdf.Key(sha256.New, []byte(secret), nil, label, sha256.Size)
+}
+
+// AuthMiddleware validates JWT tokens and extracts user context. Route groups
+// that list scopes also accept API keys (Bearer qf_... or X-API-Key) granted
+// one of those scopes; groups without scopes only accept user JWTs.
+func AuthMiddleware(scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get Authorization header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			log.Printf("❌ AuthMiddleware: Missing Authorization header")
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Authorization header required",
-				"code":  "MISSING_AUTH_HEADER",
-			})
-			c.Abort()
-			return
+		token := c.GetHeader("X-API-Key")
+		if token == "" {
+			// Get Authorization header
+			authHeader := c.GetHeader("Authorization")
+			if authHeader == "" {
+				log.Printf("❌ AuthMiddleware: Missing Authorization header")
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Authorization header required",
+					"code":  "MISSING_AUTH_HEADER",
+				})
+				c.Abort()
+				return
+			}
+
+			// Extract Bearer token
+			tokenParts := strings.Split(authHeader, " ")
+			if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+				log.Printf("❌ AuthMiddleware: Invalid auth header format for path %s", c.Request.URL.Path)
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Invalid authorization header format",
+					"code":  "INVALID_AUTH_FORMAT",
+				})
+				c.Abort()
+				return
+			}
+			token = tokenParts[1]
 		}
 
-		// Extract Bearer token
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			log.Printf("❌ AuthMiddleware: Invalid auth header format: %s", authHeader)
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid authorization header format",
-				"code":  "INVALID_AUTH_FORMAT",
-			})
-			c.Abort()
+		if isAPIKey(token) {
+			authenticateAPIKeyRequest(c, token, scopes)
 			return
 		}
 
-		token := tokenParts[1]
 		log.Printf("🔑 AuthMiddleware: Validating token for path %s", c.Request.URL.Path)
 
-		// Validate token with Supabase
-		user, err := supabaseClient.Auth.User(context.Background(), token)
+		// Validate token locally (or with Supabase when configured as fallback)
+		user, err := authenticateToken(c.Request.Context(), token)
 		if err != nil {
 			log.Printf("❌ AuthMiddleware: Token validation failed: %v", err)
 			c.JSON(http.StatusUnauthorized, gin.H{
@@ -65,17 +95,120 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		sessionID, err := trackSession(c, token, user)
+		if err == errSessionRevoked {
+			log.Printf("❌ AuthMiddleware: Session %s of user %s has been revoked", sessionID, user.ID)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "This session has been signed out",
+				"code":  "SESSION_REVOKED",
+			})
+			c.Abort()
+			return
+		}
+
+		if rejectSuspendedAccount(c, user.ID) {
+			return
+		}
+
 		log.Printf("✅ AuthMiddleware: Token valid for user %s (%s)", user.Email, user.ID)
 
 		// Set user context
-		c.Set("user_id", user.ID)
-		c.Set("user_email", user.Email)
-		c.Set("user", user)
+		setUserContext(c, user)
+		c.Set("session_id", sessionID)
+		c.Set("access_token", token)
+
+		// Platform admins may read as another user (see applyImpersonation)
+		if impersonation := c.GetHeader(ImpersonationHeader); impersonation != "" {
+			if !applyImpersonation(c, user, impersonation) {
+				return
+			}
+		}
 
 		c.Next()
 	}
 }
 
+// authenticateAPIKeyRequest authenticates an API key for a route group
+// accepting scopes, aborting with 401/403 when the key may not be used
+func authenticateAPIKeyRequest(c *gin.Context, token string, scopes []string) {
+	if len(scopes) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "API keys cannot be used for this endpoint",
+			"code":  "API_KEY_NOT_ALLOWED",
+		})
+		c.Abort()
+		return
+	}
+
+	user, key, err := authenticateAPIKey(token, c.ClientIP(), scopes)
+	if err != nil {
+		log.Printf("❌ AuthMiddleware: API key rejected for path %s: %v", c.Request.URL.Path, err)
+		status, code := http.StatusUnauthorized, "INVALID_API_KEY"
+		switch err {
+		case errAPIKeyRevoked:
+			code = "API_KEY_REVOKED"
+		case errAPIKeyExpired:
+			code = "API_KEY_EXPIRED"
+		case errAPIAccessNotInPlan:
+			status, code = http.StatusForbidden, "FEATURE_NOT_AVAILABLE"
+		case errAPIKeyScopeMismatch:
+			status, code = http.StatusForbidden, "INSUFFICIENT_SCOPE"
+		case errInvalidAPIKey:
+		default:
+			status, code = http.StatusServiceUnavailable, "AUTH_SERVICE_UNAVAILABLE"
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+			"code":  code,
+		})
+		c.Abort()
+		return
+	}
+
+	if rejectSuspendedAccount(c, user.ID) {
+		return
+	}
+
+	log.Printf("✅ AuthMiddleware: API key %s valid for user %s", key.Prefix, user.ID)
+
+	setUserContext(c, user)
+	c.Set("api_key", key)
+
+	c.Next()
+}
+
+// setUserContext stores the authenticated identity on the request context
+func setUserContext(c *gin.Context, user *authenticatedUser) {
+	c.Set("user_id", user.ID)
+	c.Set("user_email", user.Email)
+	if user.Claims != nil {
+		c.Set("auth_claims", user.Claims)
+	}
+}
+
+// GetClaims returns the verified token claims, or nil when the request was
+// authenticated through remote introspection
+func GetClaims(c *gin.Context) *Claims {
+	if claims, exists := c.Get("auth_claims"); exists {
+		return claims.(*Claims)
+	}
+	return nil
+}
+
+// logAuthFallback records that a token was sent to Supabase for introspection
+func logAuthFallback(err error) {
+	log.Printf("⚠️ AuthMiddleware: Falling back to remote token introspection: %v", err)
+}
+
+// GetAPIKey returns the API key that authenticated the request, or nil for
+// requests authenticated with a user JWT
+func GetAPIKey(c *gin.Context) *APIKey {
+	if key, exists := c.Get("api_key"); exists {
+		return key.(*APIKey)
+	}
+	return nil
+}
+
 // GetUserID extracts user ID from context
 func GetUserID(c *gin.Context) string {
 	if userID, exists := c.Get("user_id"); exists {
@@ -92,7 +225,8 @@ func GetUserEmail(c *gin.Context) string {
 	return ""
 }
 
-// OptionalAuthMiddleware - for endpoints that work with or without auth
+// OptionalAuthMiddleware - for endpoints that work with or without auth.
+// Tokens of revoked sessions or suspended accounts are treated as anonymous.
 func OptionalAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -100,10 +234,18 @@ func OptionalAuthMiddleware() gin.HandlerFunc {
 			tokenParts := strings.Split(authHeader, " ")
 			if len(tokenParts) == 2 && tokenParts[0] == "Bearer" {
 				token := tokenParts[1]
-				if user, err := supabaseClient.Auth.User(context.Background(), token); err == nil {
-					c.Set("user_id", user.ID)
-					c.Set("user_email", user.Email)
-					c.Set("user", user)
+				if user, err := authenticateToken(c.Request.Context(), token); err == nil {
+					sessionID, err := trackSession(c, token, user)
+					switch {
+					case err == errSessionRevoked:
+						log.Printf("⚠️ OptionalAuthMiddleware: Session %s of user %s has been revoked, continuing anonymously", sessionID, user.ID)
+					case checkAccountActive(user.ID) == errAccountSuspended:
+						log.Printf("⚠️ OptionalAuthMiddleware: Account %s is suspended, continuing anonymously", user.ID)
+					default:
+						setUserContext(c, user)
+						c.Set("session_id", sessionID)
+						c.Set("access_token", token)
+					}
 				}
 			}
 		}
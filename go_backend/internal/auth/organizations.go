@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	postgrest "github.com/nedpals/supabase-go/postgrest/pkg"
+)
+
+// OrganizationHeader selects the organization a request acts for. Without it
+// requests act on the caller's personal workspace.
+const OrganizationHeader = "X-Organization-ID"
+
+// ErrNotOrganizationMember is returned when a user does not belong to an organization
+var ErrNotOrganizationMember = errors.New("not a member of this organization")
+
+// Organization is a team sharing assessments and a credit pool
+type Organization struct {
+	ID               string    `json:"id" db:"id"`
+	Name             string    `json:"name" db:"name"`
+	RequireTwoFactor bool      `json:"require_two_factor" db:"require_two_factor"`
+	CreatedBy        string    `json:"created_by" db:"created_by"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// OrganizationMember links a user to an organization with a role
+type OrganizationMember struct {
+	OrgID     string    `json:"org_id" db:"org_id"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	Role      string    `json:"role" db:"role"`
+	InvitedBy *string   `json:"invited_by" db:"invited_by"`
+	JoinedAt  time.Time `json:"joined_at" db:"joined_at"`
+}
+
+// GetOrganizationMembership returns the user's membership in an organization
+func GetOrganizationMembership(orgID, userID string) (*OrganizationMember, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var members []OrganizationMember
+	err := supabaseClient.DB.From("organization_members").
+		Select("*").
+		Eq("org_id", orgID).
+		Eq("user_id", userID).
+		Execute(&members)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch organization membership: %v", err)
+	}
+	if len(members) == 0 {
+		return nil, ErrNotOrganizationMember
+	}
+	return &members[0], nil
+}
+
+// listOrganizationAccess returns the user's role and permissions in each
+// organization they belong to
+func listOrganizationAccess(userID string) ([]WorkspaceAccess, error) {
+	result := []WorkspaceAccess{}
+	if supabaseClient == nil {
+		return result, nil
+	}
+
+	var members []OrganizationMember
+	err := supabaseClient.DB.From("organization_members").
+		Select("*").
+		Eq("user_id", userID).
+		Execute(&members)
+	if err != nil {
+		return result, fmt.Errorf("failed to fetch organization memberships: %v", err)
+	}
+
+	for _, m := range members {
+		permissions, err := OrganizationRolePermissions(m.OrgID, m.Role)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, WorkspaceAccess{OrgID: m.OrgID, Role: m.Role, Permissions: permissions})
+	}
+	return result, nil
+}
+
+// GetOrganizationCredits fetches the shared credit pool of an organization
+func GetOrganizationCredits(orgID string) (*UserCredits, error) {
+	return getWalletCredits("org_id", orgID)
+}
+
+// ConsumeOrganizationCredits deducts credits from an organization's pool on
+// behalf of one of its members
+func ConsumeOrganizationCredits(orgID, userID string, creditsToConsume int, assessmentID *int64, description string) error {
+	return consumeWalletCredits("org_id", orgID, userID, creditsToConsume, "assessment_usage", assessmentID, description)
+}
+
+// TransferCreditsToOrganization moves credits from a user's wallet into an
+// organization's shared pool in one ledger transaction
+func TransferCreditsToOrganization(userID, orgID string, credits int) error {
+	if supabaseClient == nil {
+		return fmt.Errorf("supabase client not initialized")
+	}
+
+	var transaction CreditTransaction
+	err := supabaseClient.DB.Rpc("transfer_organization_credits", map[string]interface{}{
+		"p_user_id": userID,
+		"p_org_id":  orgID,
+		"p_credits": credits,
+	}).Execute(&transaction)
+	if err != nil {
+		return ledgerError(err)
+	}
+
+	RecordAuditEvent(AuditEvent{
+		UserID:       userID,
+		OrgID:        orgID,
+		Action:       AuditCreditsTransferred,
+		ResourceType: "organization",
+		ResourceID:   orgID,
+		Metadata: map[string]interface{}{
+			"credits":       credits,
+			"balance_after": transaction.BalanceAfter,
+		},
+	})
+	return nil
+}
+
+// Workspace is the tenant a request reads and writes assessments for: the
+// caller's personal workspace or an organization they belong to
+type Workspace struct {
+	UserID      string
+	OrgID       string   // empty for the personal workspace
+	Role        string   // organization role, empty for the personal workspace
+	Permissions []string // effective permissions of the user in the workspace
+}
+
+// IsOrganization reports whether the workspace belongs to an organization
+func (w Workspace) IsOrganization() bool {
+	return w.OrgID != ""
+}
+
+// Can reports whether the user holds permission in the workspace
+func (w Workspace) Can(permission string) bool {
+	return containsString(w.Permissions, permission)
+}
+
+// OrgIDPtr returns the organization ID as stored in org_id columns (nil when personal)
+func (w Workspace) OrgIDPtr() *string {
+	if w.OrgID == "" {
+		return nil
+	}
+	orgID := w.OrgID
+	return &orgID
+}
+
+// Scope filters a query on a table with user_id and org_id columns to the
+// workspace: the organization's rows, or the user's personal rows
+func (w Workspace) Scope(query *postgrest.SelectRequestBuilder) *postgrest.FilterRequestBuilder {
+	if w.IsOrganization() {
+		return query.Eq("org_id", w.OrgID)
+	}
+	return query.Eq("user_id", w.UserID).IsNull("org_id")
+}
+
+// Contains reports whether a record owned by ownerID in orgID (nil when
+// personal) belongs to the workspace
+func (w Workspace) Contains(ownerID string, orgID *string) bool {
+	if w.IsOrganization() {
+		return orgID != nil && *orgID == w.OrgID
+	}
+	return orgID == nil && ownerID == w.UserID
+}
+
+// ConsumeCredits deducts credits from the workspace's wallet: the
+// organization pool or the user's own balance
+func (w Workspace) ConsumeCredits(credits int, assessmentID *int64, description string) error {
+	if w.IsOrganization() {
+		return ConsumeOrganizationCredits(w.OrgID, w.UserID, credits, assessmentID, description)
+	}
+	return ConsumeCredits(w.UserID, credits, assessmentID, description)
+}
+
+// Credits fetches the workspace's wallet: the organization pool or the
+// user's own balance
+func (w Workspace) Credits() (*UserCredits, error) {
+	if w.IsOrganization() {
+		return GetOrganizationCredits(w.OrgID)
+	}
+	return GetUserCredits(w.UserID)
+}
+
+// ReserveCredits holds credits in the workspace's wallet until they are
+// captured or released (see CaptureCredits and ReleaseCredits)
+func (w Workspace) ReserveCredits(credits int, idempotencyKey, description string) (*CreditReservation, error) {
+	return ReserveCredits(w.UserID, w.OrgID, credits, idempotencyKey, description)
+}
+
+// ResolveWorkspace determines the workspace for the request from the
+// X-Organization-ID header (or the organization an API key belongs to) and
+// checks membership. On failure the error response has been written.
+func ResolveWorkspace(c *gin.Context) (Workspace, bool) {
+	if cached, exists := c.Get("workspace"); exists {
+		return cached.(Workspace), true
+	}
+
+	userID := GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return Workspace{}, false
+	}
+
+	orgID := c.GetHeader(OrganizationHeader)
+	if key := GetAPIKey(c); key != nil && key.OrgID != nil {
+		if orgID != "" && orgID != *key.OrgID {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "API key belongs to a different organization",
+				"code":  "ORGANIZATION_MISMATCH",
+			})
+			return Workspace{}, false
+		}
+		orgID = *key.OrgID
+	}
+
+	workspace := Workspace{UserID: userID, Permissions: AllPermissions}
+	if orgID != "" {
+		if _, err := uuid.Parse(orgID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid organization ID",
+				"code":  "INVALID_ORGANIZATION_ID",
+			})
+			return Workspace{}, false
+		}
+
+		member, err := GetOrganizationMembership(orgID, userID)
+		if errors.Is(err, ErrNotOrganizationMember) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "You are not a member of this organization",
+				"code":  "ORGANIZATION_ACCESS_DENIED",
+			})
+			return Workspace{}, false
+		}
+		if err != nil {
+			log.Printf("❌ ResolveWorkspace: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to verify organization membership",
+				"code":  "DATABASE_FETCH_ERROR",
+			})
+			return Workspace{}, false
+		}
+		if !RequireOrganizationTwoFactor(c, member.OrgID, userID) {
+			return Workspace{}, false
+		}
+		permissions, err := OrganizationRolePermissions(member.OrgID, member.Role)
+		if err != nil {
+			log.Printf("❌ ResolveWorkspace: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to load organization permissions",
+				"code":  "DATABASE_FETCH_ERROR",
+			})
+			return Workspace{}, false
+		}
+		workspace.OrgID = member.OrgID
+		workspace.Role = member.Role
+		workspace.Permissions = permissions
+	}
+
+	c.Set("workspace", workspace)
+	return workspace, true
+}
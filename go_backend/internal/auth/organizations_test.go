@@ -0,0 +1,29 @@
+package auth
+
+import "testing"
+
+func TestWorkspaceContains(t *testing.T) {
+	orgA, orgB := "org-a", "org-b"
+	personal := Workspace{UserID: "user-1"}
+	org := Workspace{UserID: "user-1", OrgID: orgA, Role: RoleAnalyst}
+
+	tests := []struct {
+		name      string
+		workspace Workspace
+		ownerID   string
+		orgID     *string
+		want      bool
+	}{
+		{"own personal record", personal, "user-1", nil, true},
+		{"other user's personal record", personal, "user-2", nil, false},
+		{"own org record from personal workspace", personal, "user-1", &orgA, false},
+		{"teammate's org record", org, "user-2", &orgA, true},
+		{"other org record", org, "user-1", &orgB, false},
+		{"own personal record from org workspace", org, "user-1", nil, false},
+	}
+	for _, tt := range tests {
+		if got := tt.workspace.Contains(tt.ownerID, tt.orgID); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PhoneNumber is a phone number normalized to E.164 with its country
+type PhoneNumber struct {
+	E164           string `json:"e164"`
+	CountryCode    string `json:"country_code,omitempty"` // ISO 3166-1 alpha-2, empty when unknown
+	CountryName    string `json:"country_name,omitempty"`
+	CallingCode    string `json:"calling_code,omitempty"`
+	NationalNumber string `json:"national_number,omitempty"`
+}
+
+// phoneCountry describes the numbering plan of a country
+type phoneCountry struct {
+	ISO         string
+	Name        string
+	CallingCode string
+	MinLength   int // national significant number length
+	MaxLength   int
+	TrunkPrefix string // dialled before national numbers, dropped in E.164
+}
+
+// phoneCountries lists the countries whose numbers are validated by length.
+// Numbers for other calling codes are accepted if they are valid E.164.
+var phoneCountries = []phoneCountry{
+	{"US", "United States", "1", 10, 10, "1"},
+	{"CA", "Canada", "1", 10, 10, "1"},
+	{"GB", "United Kingdom", "44", 9, 10, "0"},
+	{"IE", "Ireland", "353", 7, 9, "0"},
+	{"DE", "Germany", "49", 6, 13, "0"},
+	{"FR", "France", "33", 9, 9, "0"},
+	{"ES", "Spain", "34", 9, 9, ""},
+	{"IT", "Italy", "39", 6, 11, ""},
+	{"NL", "Netherlands", "31", 9, 9, "0"},
+	{"BE", "Belgium", "32", 8, 9, "0"},
+	{"CH", "Switzerland", "41", 9, 9, "0"},
+	{"AT", "Austria", "43", 4, 13, "0"},
+	{"PT", "Portugal", "351", 9, 9, ""},
+	{"PL", "Poland", "48", 9, 9, ""},
+	{"SE", "Sweden", "46", 7, 13, "0"},
+	{"NO", "Norway", "47", 8, 8, ""},
+	{"DK", "Denmark", "45", 8, 8, ""},
+	{"FI", "Finland", "358", 5, 12, "0"},
+	{"IN", "India", "91", 10, 10, "0"},
+	{"PK", "Pakistan", "92", 10, 10, "0"},
+	{"BD", "Bangladesh", "880", 10, 10, "0"},
+	{"SG", "Singapore", "65", 8, 8, ""},
+	{"MY", "Malaysia", "60", 9, 10, "0"},
+	{"ID", "Indonesia", "62", 8, 12, "0"},
+	{"PH", "Philippines", "63", 10, 10, "0"},
+	{"HK", "Hong Kong", "852", 8, 8, ""},
+	{"CN", "China", "86", 11, 11, "0"},
+	{"JP", "Japan", "81", 9, 10, "0"},
+	{"AU", "Australia", "61", 9, 9, "0"},
+	{"NZ", "New Zealand", "64", 8, 10, "0"},
+	{"AE", "United Arab Emirates", "971", 8, 9, "0"},
+	{"SA", "Saudi Arabia", "966", 9, 9, "0"},
+	{"IL", "Israel", "972", 8, 9, "0"},
+	{"ZA", "South Africa", "27", 9, 9, "0"},
+	{"NG", "Nigeria", "234", 8, 10, "0"},
+	{"KE", "Kenya", "254", 9, 9, "0"},
+	{"BR", "Brazil", "55", 10, 11, "0"},
+	{"MX", "Mexico", "52", 10, 10, ""},
+}
+
+// phoneCountryByISO returns the numbering plan for an ISO country code
+func phoneCountryByISO(iso string) (phoneCountry, bool) {
+	iso = strings.ToUpper(strings.TrimSpace(iso))
+	for _, country := range phoneCountries {
+		if country.ISO == iso {
+			return country, true
+		}
+	}
+	return phoneCountry{}, false
+}
+
+// phoneCountryByNumber returns the first country whose calling code prefixes
+// digits (an E.164 number without "+"). Shared codes resolve to the first
+// listed country (+1 to the United States) unless preferISO shares the code.
+func phoneCountryByNumber(digits, preferISO string) (phoneCountry, bool) {
+	if preferred, ok := phoneCountryByISO(preferISO); ok && strings.HasPrefix(digits, preferred.CallingCode) {
+		return preferred, true
+	}
+	for length := 1; length <= 3 && length < len(digits); length++ {
+		for _, country := range phoneCountries {
+			if country.CallingCode == digits[:length] {
+				return country, true
+			}
+		}
+	}
+	return phoneCountry{}, false
+}
+
+// normalizePhoneNumber converts a phone number to E.164. Numbers in
+// international form ("+44 20 7946 0958" or "0044...") are parsed directly;
+// national numbers ("020 7946 0958") need defaultCountry.
+func normalizePhoneNumber(raw, defaultCountry string) (*PhoneNumber, error) {
+	cleaned := strings.TrimSpace(raw)
+	for _, char := range []string{" ", "-", "(", ")", ".", "/", " "} {
+		cleaned = strings.ReplaceAll(cleaned, char, "")
+	}
+	if cleaned == "" {
+		return nil, fmt.Errorf("phone number is required")
+	}
+
+	international := false
+	switch {
+	case strings.HasPrefix(cleaned, "+"):
+		cleaned = cleaned[1:]
+		international = true
+	case strings.HasPrefix(cleaned, "00"):
+		cleaned = cleaned[2:]
+		international = true
+	}
+	for _, char := range cleaned {
+		if char < '0' || char > '9' {
+			return nil, fmt.Errorf("phone number contains invalid characters")
+		}
+	}
+
+	var country phoneCountry
+	var national string
+	if international {
+		found := false
+		country, found = phoneCountryByNumber(cleaned, defaultCountry)
+		if !found {
+			// Unknown calling code: accept any valid E.164 length
+			if len(cleaned) < 8 || len(cleaned) > 15 {
+				return nil, fmt.Errorf("phone number must have 8-15 digits including the country code")
+			}
+			return &PhoneNumber{E164: "+" + cleaned}, nil
+		}
+		national = cleaned[len(country.CallingCode):]
+	} else {
+		found := false
+		country, found = phoneCountryByISO(defaultCountry)
+		if !found {
+			return nil, fmt.Errorf("phone number must include the country code (e.g. +14155550123)")
+		}
+		national = cleaned
+		if country.TrunkPrefix != "" && len(national) > country.MinLength && strings.HasPrefix(national, country.TrunkPrefix) {
+			national = national[len(country.TrunkPrefix):]
+		}
+	}
+
+	if len(national) < country.MinLength || len(national) > country.MaxLength {
+		if country.MinLength == country.MaxLength {
+			return nil, fmt.Errorf("%s phone numbers must have %d digits after +%s", country.Name, country.MinLength, country.CallingCode)
+		}
+		return nil, fmt.Errorf("%s phone numbers must have %d-%d digits after +%s", country.Name, country.MinLength, country.MaxLength, country.CallingCode)
+	}
+	if country.CallingCode == "1" && (national[0] == '0' || national[0] == '1') {
+		return nil, fmt.Errorf("invalid area code")
+	}
+
+	return &PhoneNumber{
+		E164:           "+" + country.CallingCode + national,
+		CountryCode:    country.ISO,
+		CountryName:    country.Name,
+		CallingCode:    country.CallingCode,
+		NationalNumber: national,
+	}, nil
+}
@@ -1,35 +1,127 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
-	"math/rand"
+	"math"
+	"math/big"
 	"net/http"
 	"strconv"
 	"time"
 
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/sms"
 	"github.com/gin-gonic/gin"
 )
 
+// Phone verification limits
+const (
+	verificationCodeDigits  = 6
+	verificationCodeTTL     = 10 * time.Minute
+	resendCooldown          = time.Minute // between codes for the same user or phone
+	maxSendsPerUserPerHour  = 5
+	maxSendsPerPhonePerHour = 5
+	maxSendsPerPhonePerDay  = 10
+	maxCodeAttempts         = 5                // wrong guesses before the code is locked
+	verificationLockout     = 30 * time.Minute // no sends or checks after too many wrong guesses
+)
+
+// Verification delivery channels
+const (
+	ChannelSMS   = "sms"
+	ChannelVoice = "voice"
+)
+
+// phoneCodeKey keys the HMAC used to store verification codes. InitAuth
+// derives it from the service key so codes cannot be brute forced from a
+// database dump alone.
+var phoneCodeKey []byte
+
 // PhoneVerificationRequest represents phone verification request
 type PhoneVerificationRequest struct {
-	Phone string `json:"phone" binding:"required"`
+	Phone   string `json:"phone" binding:"required"`
+	Country string `json:"country" binding:"omitempty,len=2"`           // ISO country for national-format numbers
+	Channel string `json:"channel" binding:"omitempty,oneof=sms voice"` // defaults to sms
+	Locale  string `json:"locale" binding:"omitempty,max=10"`           // message language, defaults to the phone's country
 }
 
-// VerifyPhoneCodeRequest represents phone code verification request  
+// VerifyPhoneCodeRequest represents phone code verification request
 type VerifyPhoneCodeRequest struct {
-	Phone string `json:"phone" binding:"required"`
-	Code  string `json:"code" binding:"required"`
+	Phone   string `json:"phone" binding:"required"`
+	Country string `json:"country" binding:"omitempty,len=2"`
+	Code    string `json:"code" binding:"required"`
 }
 
 // PhoneVerificationResponse represents phone verification response
 type PhoneVerificationResponse struct {
-	Message   string `json:"message"`
-	CodeSent  bool   `json:"code_sent"`
-	ExpiresAt string `json:"expires_at"`
+	Message        string       `json:"message"`
+	CodeSent       bool         `json:"code_sent"`
+	Channel        string       `json:"channel"`
+	Phone          *PhoneNumber `json:"phone"`
+	ExpiresAt      string       `json:"expires_at"`
+	ResendAfter    int          `json:"resend_after_seconds"`
+	VoiceAvailable bool         `json:"voice_available"`
+	DeliveryID     string       `json:"delivery_id,omitempty"` // poll /api/auth/phone-verification/deliveries/:id
+}
+
+// phoneVerification is a row of phone_verifications. Only the HMAC of the
+// code is stored.
+type phoneVerification struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Phone       string     `json:"phone"`
+	CountryCode *string    `json:"country_code"`
+	Channel     string     `json:"channel"`
+	CodeHash    string     `json:"code_hash"`
+	Attempts    int        `json:"attempts"`
+	VerifiedAt  *time.Time `json:"verified_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	LockedUntil *time.Time `json:"locked_until"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// phoneVerificationError is a verification failure with its HTTP response
+type phoneVerificationError struct {
+	status     int
+	code       string
+	message    string
+	retryAfter time.Duration
+	details    gin.H
+}
+
+func (e *phoneVerificationError) Error() string {
+	return e.message
 }
 
-// SendPhoneVerificationHandler handles POST /api/auth/send-phone-verification
+// writePhoneVerificationError writes err as a JSON error response
+func writePhoneVerificationError(c *gin.Context, err error) {
+	verr, ok := err.(*phoneVerificationError)
+	if !ok {
+		log.Printf("❌ Phone verification error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Phone verification is temporarily unavailable",
+			"code":  "VERIFICATION_STORAGE_ERROR",
+		})
+		return
+	}
+
+	body := gin.H{"error": verr.message, "code": verr.code}
+	for k, v := range verr.details {
+		body[k] = v
+	}
+	if verr.retryAfter > 0 {
+		seconds := int(math.Ceil(verr.retryAfter.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		body["retry_after"] = seconds
+	}
+	c.JSON(verr.status, body)
+}
+
+// SendPhoneVerificationHandler handles POST /api/auth/send-phone-verification.
+// Send "channel": "voice" to receive the code by phone call instead of SMS.
 func SendPhoneVerificationHandler(c *gin.Context) {
 	userID := GetUserID(c)
 	if userID == "" {
@@ -48,9 +140,19 @@ func SendPhoneVerificationHandler(c *gin.Context) {
 		})
 		return
 	}
+	if req.Channel == "" {
+		req.Channel = ChannelSMS
+	}
+	if req.Channel == ChannelVoice && !voiceAvailable() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Voice verification is not available",
+			"code":  "VOICE_NOT_AVAILABLE",
+		})
+		return
+	}
 
-	// Validate phone number format
-	if err := validatePhoneNumber(req.Phone); err != nil {
+	phone, err := normalizePhoneNumber(req.Phone, defaultPhoneCountry(userID, req.Country))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 			"code":  "INVALID_PHONE_FORMAT",
@@ -59,7 +161,7 @@ func SendPhoneVerificationHandler(c *gin.Context) {
 	}
 
 	// Check if phone number is already verified by another user
-	if err := validatePhoneNotInUse(req.Phone, userID); err != nil {
+	if err := validatePhoneNotInUse(phone.E164, userID); err != nil {
 		c.JSON(http.StatusConflict, gin.H{
 			"error": err.Error(),
 			"code":  "PHONE_ALREADY_IN_USE",
@@ -67,38 +169,61 @@ func SendPhoneVerificationHandler(c *gin.Context) {
 		return
 	}
 
-	// Generate 6-digit verification code
-	verificationCode := generateVerificationCode()
-	expiresAt := time.Now().Add(10 * time.Minute) // 10 minutes expiry
+	if err := checkVerificationSendAllowed(userID, phone.E164, time.Now()); err != nil {
+		writePhoneVerificationError(c, err)
+		return
+	}
 
-	// Store verification code in database
-	if err := storePhoneVerification(userID, req.Phone, verificationCode, expiresAt); err != nil {
-		log.Printf("❌ SendPhoneVerificationHandler: Failed to store verification: %v", err)
+	verificationCode, err := generateVerificationCode()
+	if err != nil {
+		log.Printf("❌ SendPhoneVerificationHandler: Failed to generate code: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to send verification code",
 			"code":  "VERIFICATION_STORAGE_ERROR",
 		})
 		return
 	}
+	expiresAt := time.Now().Add(verificationCodeTTL)
 
-	// Send SMS using AWS SNS
-	err := sendSMSCode(req.Phone, verificationCode)
-	if err != nil {
-		log.Printf("❌ SendPhoneVerificationHandler: Failed to send SMS: %v", err)
+	if err := storePhoneVerification(userID, phone, req.Channel, verificationCode, expiresAt); err != nil {
+		log.Printf("❌ SendPhoneVerificationHandler: Failed to store verification: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to send verification code",
-			"code":  "SMS_SEND_ERROR",
+			"code":  "VERIFICATION_STORAGE_ERROR",
 		})
 		return
 	}
 
-	response := PhoneVerificationResponse{
-		Message:   "Verification code sent to your phone",
-		CodeSent:  true,
-		ExpiresAt: expiresAt.Format(time.RFC3339),
+	var delivery *sms.Message
+	if req.Channel == ChannelVoice {
+		delivery, err = sendVoiceCode(c.Request.Context(), userID, phone, verificationCode, req.Locale)
+	} else {
+		delivery, err = sendSMSCode(c.Request.Context(), userID, phone, verificationCode, req.Locale)
+	}
+	if err != nil {
+		log.Printf("❌ SendPhoneVerificationHandler: Failed to send %s code: %v", req.Channel, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":           "Failed to send verification code",
+			"code":            "SMS_SEND_ERROR",
+			"voice_available": req.Channel == ChannelSMS && voiceAvailable(),
+		})
+		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	message := "Verification code sent to your phone"
+	if req.Channel == ChannelVoice {
+		message = "You will receive a call with your verification code"
+	}
+	c.JSON(http.StatusOK, PhoneVerificationResponse{
+		Message:        message,
+		CodeSent:       true,
+		Channel:        req.Channel,
+		Phone:          phone,
+		ExpiresAt:      expiresAt.Format(time.RFC3339),
+		ResendAfter:    int(resendCooldown.Seconds()),
+		VoiceAvailable: voiceAvailable(),
+		DeliveryID:     delivery.ID,
+	})
 }
 
 // VerifyPhoneCodeHandler handles POST /api/auth/verify-phone-code
@@ -121,27 +246,22 @@ func VerifyPhoneCodeHandler(c *gin.Context) {
 		return
 	}
 
-	// Verify the code
-	verified, err := verifyPhoneCode(userID, req.Phone, req.Code)
+	phone, err := normalizePhoneNumber(req.Phone, defaultPhoneCountry(userID, req.Country))
 	if err != nil {
-		log.Printf("❌ VerifyPhoneCodeHandler: Verification failed: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
-			"code":  "VERIFICATION_FAILED",
+			"code":  "INVALID_PHONE_FORMAT",
 		})
 		return
 	}
 
-	if !verified {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid or expired verification code",
-			"code":  "INVALID_CODE",
-		})
+	if err := verifyPhoneCode(userID, phone.E164, req.Code, time.Now()); err != nil {
+		writePhoneVerificationError(c, err)
 		return
 	}
 
 	// Update user profile with verified phone and completed onboarding
-	if err := updatePhoneVerificationStatus(userID, req.Phone); err != nil {
+	if err := updatePhoneVerificationStatus(userID, phone.E164); err != nil {
 		log.Printf("❌ VerifyPhoneCodeHandler: Failed to update profile: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to update phone verification status",
@@ -151,10 +271,11 @@ func VerifyPhoneCodeHandler(c *gin.Context) {
 	}
 
 	log.Printf("✅ Phone verification successful for user %s", userID)
-	
+
 	c.JSON(http.StatusOK, gin.H{
-		"message":             "Phone number verified successfully",
-		"phone_verified":      true,
+		"message":              "Phone number verified successfully",
+		"phone":                phone,
+		"phone_verified":       true,
 		"onboarding_completed": true,
 	})
 }
@@ -179,8 +300,17 @@ func UpdatePhoneHandler(c *gin.Context) {
 		return
 	}
 
+	phone, err := normalizePhoneNumber(req.Phone, defaultPhoneCountry(userID, req.Country))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+			"code":  "INVALID_PHONE_FORMAT",
+		})
+		return
+	}
+
 	// Check if phone number is already in use
-	if err := validatePhoneNotInUse(req.Phone, userID); err != nil {
+	if err := validatePhoneNotInUse(phone.E164, userID); err != nil {
 		c.JSON(http.StatusConflict, gin.H{
 			"error": err.Error(),
 			"code":  "PHONE_ALREADY_IN_USE",
@@ -190,14 +320,14 @@ func UpdatePhoneHandler(c *gin.Context) {
 
 	// Update phone number (will require verification)
 	updateData := map[string]interface{}{
-		"phone":          req.Phone,
-		"phone_verified": false, // Reset verification status
+		"phone":                phone.E164,
+		"phone_verified":       false, // Reset verification status
 		"onboarding_completed": false, // Reset onboarding
-		"updated_at":     time.Now().Format(time.RFC3339),
+		"updated_at":           time.Now().Format(time.RFC3339),
 	}
 
 	var result []map[string]interface{}
-	err := supabaseClient.DB.From("user_profiles").
+	err = supabaseClient.DB.From("user_profiles").
 		Update(updateData).
 		Eq("id", userID).
 		Execute(&result)
@@ -211,163 +341,322 @@ func UpdatePhoneHandler(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":      "Phone number updated. Please verify your new number.",
-		"phone":        req.Phone,
+		"message":        "Phone number updated. Please verify your new number.",
+		"phone":          phone,
 		"phone_verified": false,
 	})
 }
 
-// Helper functions
-
-// generateVerificationCode generates a 6-digit verification code
-func generateVerificationCode() string {
-	rand.Seed(time.Now().UnixNano())
-	code := rand.Intn(900000) + 100000 // 100000 to 999999
-	return strconv.Itoa(code)
-}
-
-// storePhoneVerification stores verification code in database
-func storePhoneVerification(userID, phone, code string, expiresAt time.Time) error {
-	if supabaseClient == nil {
-		return fmt.Errorf("supabase client not initialized")
+// GetPhoneVerificationDeliveryHandler handles GET
+// /api/auth/phone-verification/deliveries/:id. Clients poll it after sending a
+// code to offer a voice call when the SMS is reported undelivered.
+func GetPhoneVerificationDeliveryHandler(c *gin.Context) {
+	userID := GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
 	}
 
-	log.Printf("📱 Storing verification for user %s, phone %s, code %s", userID, phone, code)
-
-	// Clear any existing verifications for this user/phone
-	var deleteResult []map[string]interface{}
-	err := supabaseClient.DB.From("phone_verifications").
-		Delete().
-		Eq("user_id", userID).
-		Execute(&deleteResult)
-
+	delivery, err := sms.GetMessage(c.Param("id"))
 	if err != nil {
-		log.Printf("⚠️ Failed to clear old verifications: %v", err)
-		// Don't fail, just log warning
+		log.Printf("❌ GetPhoneVerificationDeliveryHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch delivery status",
+			"code":  "DATABASE_ERROR",
+		})
+		return
 	}
-
-	// Insert new verification
-	verification := map[string]interface{}{
-		"user_id":           userID,
-		"phone":             phone,
-		"verification_code": code,
-		"attempts":          0,
-		"expires_at":        expiresAt.Format(time.RFC3339),
+	if delivery == nil || delivery.UserID == nil || *delivery.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Delivery not found",
+			"code":  "DELIVERY_NOT_FOUND",
+		})
+		return
 	}
 
-	log.Printf("📱 Inserting verification record: %+v", verification)
+	c.JSON(http.StatusOK, gin.H{
+		"id":              delivery.ID,
+		"channel":         delivery.Channel,
+		"status":          delivery.Status,
+		"provider":        delivery.Provider,
+		"locale":          delivery.Locale,
+		"created_at":      delivery.CreatedAt,
+		"updated_at":      delivery.UpdatedAt,
+		"voice_available": delivery.Channel == ChannelSMS && voiceAvailable(),
+	})
+}
 
-	var result []map[string]interface{}
-	err = supabaseClient.DB.From("phone_verifications").
-		Insert(verification).
-		Execute(&result)
+// Helper functions
 
+// generateVerificationCode generates a uniformly random 6-digit code
+func generateVerificationCode() (string, error) {
+	max := big.NewInt(int64(math.Pow10(verificationCodeDigits)))
+	n, err := rand.Int(rand.Reader, max)
 	if err != nil {
-		log.Printf("❌ Failed to insert verification: %v", err)
-		return fmt.Errorf("failed to store verification: %v", err)
+		return "", err
 	}
+	return fmt.Sprintf("%0*d", verificationCodeDigits, n.Int64()), nil
+}
 
-	log.Printf("✅ Verification stored successfully: %+v", result)
-	return nil
+// hashVerificationCode binds a code to the user and phone it was sent for
+func hashVerificationCode(userID, phone, code string) string {
+	mac := hmac.New(sha256.New, phoneCodeKey)
+	mac.Write([]byte(userID + "|" + phone + "|" + code))
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
-// verifyPhoneCode verifies the provided code
-func verifyPhoneCode(userID, phone, code string) (bool, error) {
-	if supabaseClient == nil {
-		return false, fmt.Errorf("supabase client not initialized")
+// fallbackPhoneCountry is assumed for national-format numbers when neither
+// the request nor the profile names a country. The web client sends only
+// the number, as the API accepted 10-digit US numbers before E.164.
+const fallbackPhoneCountry = "US"
+
+// defaultPhoneCountry is the country used for national-format numbers: the
+// one in the request, else the one on the user's profile, else
+// fallbackPhoneCountry
+func defaultPhoneCountry(userID, requested string) string {
+	if requested != "" {
+		return requested
 	}
+	if profile, err := GetUserProfile(userID); err == nil && profile.Country != nil && *profile.Country != "" {
+		return *profile.Country
+	}
+	return fallbackPhoneCountry
+}
 
-	log.Printf("🔍 Looking for verification: user=%s, phone=%s, code=%s", userID, phone, code)
+// recentPhoneVerifications returns verifications created since the given
+// time for a user_id or phone, newest first
+func recentPhoneVerifications(column, value string, since time.Time) ([]phoneVerification, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
 
-	// Get verification record
-	var verifications []map[string]interface{}
+	var rows []phoneVerification
 	err := supabaseClient.DB.From("phone_verifications").
 		Select("*").
-		Eq("user_id", userID).
-		Eq("phone", phone).
-		Execute(&verifications)
+		OrderBy("created_at", "desc").
+		Eq(column, value).
+		Gte("created_at", since.UTC().Format(time.RFC3339)).
+		Execute(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch verifications: %v", err)
+	}
+	return rows, nil
+}
 
+// checkVerificationSendAllowed enforces lockouts, the resend cooldown and the
+// hourly and daily send limits for both the user and the phone number
+func checkVerificationSendAllowed(userID, phone string, now time.Time) error {
+	since := now.Add(-24 * time.Hour)
+	userRows, err := recentPhoneVerifications("user_id", userID, since)
+	if err != nil {
+		return err
+	}
+	phoneRows, err := recentPhoneVerifications("phone", phone, since)
 	if err != nil {
-		log.Printf("❌ Failed to fetch verification: %v", err)
-		return false, fmt.Errorf("failed to fetch verification: %v", err)
+		return err
 	}
+	return sendAllowed(userRows, phoneRows, now)
+}
 
-	log.Printf("📊 Found %d verification records", len(verifications))
+// sendAllowed applies the send limits to recent verifications (newest first)
+func sendAllowed(userRows, phoneRows []phoneVerification, now time.Time) error {
+	if until := lockedUntil(userRows, now); until != nil {
+		return lockedError(*until, now)
+	}
+	if until := lockedUntil(phoneRows, now); until != nil {
+		return lockedError(*until, now)
+	}
+
+	for _, rows := range [][]phoneVerification{userRows, phoneRows} {
+		if len(rows) > 0 {
+			if wait := rows[0].CreatedAt.Add(resendCooldown).Sub(now); wait > 0 {
+				return &phoneVerificationError{
+					status:     http.StatusTooManyRequests,
+					code:       "RESEND_COOLDOWN",
+					message:    "Please wait before requesting another code",
+					retryAfter: wait,
+				}
+			}
+		}
+	}
 
-	if len(verifications) == 0 {
-		return false, fmt.Errorf("no verification found for this phone number")
+	if wait := windowWait(userRows, now, time.Hour, maxSendsPerUserPerHour); wait > 0 {
+		return sendLimitError(wait)
+	}
+	if wait := windowWait(phoneRows, now, time.Hour, maxSendsPerPhonePerHour); wait > 0 {
+		return sendLimitError(wait)
+	}
+	if wait := windowWait(phoneRows, now, 24*time.Hour, maxSendsPerPhonePerDay); wait > 0 {
+		return sendLimitError(wait)
 	}
+	return nil
+}
 
-	verification := verifications[0]
+// windowWait returns how long until fewer than max of rows fall within window
+func windowWait(rows []phoneVerification, now time.Time, window time.Duration, max int) time.Duration {
+	var inWindow []phoneVerification
+	for _, row := range rows {
+		if now.Sub(row.CreatedAt) < window {
+			inWindow = append(inWindow, row)
+		}
+	}
+	if len(inWindow) < max {
+		return 0
+	}
+	// The oldest send that keeps the count at max has to age out
+	return inWindow[max-1].CreatedAt.Add(window).Sub(now)
+}
 
-	// Check if expired
-	expiresAtStr, ok := verification["expires_at"].(string)
-	if !ok {
-		return false, fmt.Errorf("invalid expiry time format")
+func lockedUntil(rows []phoneVerification, now time.Time) *time.Time {
+	for _, row := range rows {
+		if row.LockedUntil != nil && row.LockedUntil.After(now) {
+			return row.LockedUntil
+		}
 	}
+	return nil
+}
 
-	expiresAt, err := time.Parse(time.RFC3339, expiresAtStr)
-	if err != nil {
-		return false, fmt.Errorf("failed to parse expiry time")
+func lockedError(until, now time.Time) error {
+	return &phoneVerificationError{
+		status:     http.StatusTooManyRequests,
+		code:       "PHONE_VERIFICATION_LOCKED",
+		message:    "Too many incorrect codes. Phone verification is temporarily locked",
+		retryAfter: until.Sub(now),
 	}
+}
 
-	if time.Now().After(expiresAt) {
-		return false, fmt.Errorf("verification code has expired")
+func sendLimitError(wait time.Duration) error {
+	return &phoneVerificationError{
+		status:     http.StatusTooManyRequests,
+		code:       "VERIFICATION_SEND_LIMIT",
+		message:    "Too many verification codes requested. Please try again later",
+		retryAfter: wait,
 	}
+}
 
-	// Check attempts (max 3)
-	attempts, ok := verification["attempts"].(float64)
-	if !ok {
-		attempts = 0
+// storePhoneVerification records a new code. Earlier codes stay in the table
+// for the send limits but only the newest one can be verified.
+func storePhoneVerification(userID string, phone *PhoneNumber, channel, code string, expiresAt time.Time) error {
+	if supabaseClient == nil {
+		return fmt.Errorf("supabase client not initialized")
 	}
 
-	if attempts >= 3 {
-		return false, fmt.Errorf("maximum verification attempts exceeded")
+	verification := map[string]interface{}{
+		"user_id":    userID,
+		"phone":      phone.E164,
+		"channel":    channel,
+		"code_hash":  hashVerificationCode(userID, phone.E164, code),
+		"attempts":   0,
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+	}
+	if phone.CountryCode != "" {
+		verification["country_code"] = phone.CountryCode
 	}
 
-	// Check code
-	storedCode, ok := verification["verification_code"].(string)
-	if !ok {
-		return false, fmt.Errorf("invalid stored verification code")
+	var result []map[string]interface{}
+	err := supabaseClient.DB.From("phone_verifications").
+		Insert(verification).
+		Execute(&result)
+	if err != nil {
+		return fmt.Errorf("failed to store verification: %v", err)
 	}
 
-	if storedCode != code {
-		// Increment attempts
-		updateData := map[string]interface{}{
-			"attempts": int(attempts) + 1,
-		}
+	log.Printf("📱 Stored %s verification for user %s, phone %s", channel, userID, phone.E164)
+	return nil
+}
 
-		var updateResult []map[string]interface{}
-		err = supabaseClient.DB.From("phone_verifications").
-			Update(updateData).
-			Eq("user_id", userID).
-			Eq("phone", phone).
-			Execute(&updateResult)
+// verifyPhoneCode checks a code against the newest verification for the
+// user and phone, counting wrong guesses and locking after maxCodeAttempts
+func verifyPhoneCode(userID, phone, code string, now time.Time) error {
+	rows, err := recentPhoneVerifications("user_id", userID, now.Add(-24*time.Hour))
+	if err != nil {
+		return err
+	}
+	if until := lockedUntil(rows, now); until != nil {
+		return lockedError(*until, now)
+	}
 
-		if err != nil {
-			log.Printf("⚠️ Failed to update attempts: %v", err)
+	var verification *phoneVerification
+	for i := range rows {
+		if rows[i].Phone == phone {
+			verification = &rows[i]
+			break
+		}
+	}
+	if verification == nil || verification.VerifiedAt != nil {
+		return &phoneVerificationError{
+			status:  http.StatusBadRequest,
+			code:    "VERIFICATION_NOT_FOUND",
+			message: "No pending verification for this phone number",
+		}
+	}
+	if now.After(verification.ExpiresAt) {
+		return &phoneVerificationError{
+			status:  http.StatusBadRequest,
+			code:    "CODE_EXPIRED",
+			message: "Verification code has expired",
 		}
+	}
+	if verification.Attempts >= maxCodeAttempts {
+		return &phoneVerificationError{
+			status:  http.StatusBadRequest,
+			code:    "MAX_ATTEMPTS_EXCEEDED",
+			message: "Maximum verification attempts exceeded. Request a new code",
+		}
+	}
 
-		return false, fmt.Errorf("incorrect verification code")
+	// The guess is counted before the code is compared, so concurrent
+	// guesses cannot get past maxCodeAttempts
+	var attempts int
+	err = supabaseClient.DB.Rpc("claim_phone_verification_attempt", map[string]interface{}{
+		"p_verification_id": verification.ID,
+		"p_max_attempts":    maxCodeAttempts,
+	}).Execute(&attempts)
+	if err != nil {
+		return fmt.Errorf("failed to count verification attempt: %v", err)
+	}
+	if attempts == 0 {
+		return &phoneVerificationError{
+			status:  http.StatusBadRequest,
+			code:    "MAX_ATTEMPTS_EXCEEDED",
+			message: "Maximum verification attempts exceeded. Request a new code",
+		}
 	}
 
-	// Mark as verified
-	updateData := map[string]interface{}{
-		"verified_at": time.Now().Format(time.RFC3339),
+	expected := hashVerificationCode(userID, phone, code)
+	if !hmac.Equal([]byte(expected), []byte(verification.CodeHash)) {
+		if attempts >= maxCodeAttempts {
+			log.Printf("🔒 Phone verification locked for user %s after %d wrong codes", userID, attempts)
+			var updateResult []map[string]interface{}
+			if err := supabaseClient.DB.From("phone_verifications").
+				Update(map[string]interface{}{"locked_until": now.Add(verificationLockout).UTC().Format(time.RFC3339)}).
+				Eq("id", verification.ID).
+				Execute(&updateResult); err != nil {
+				log.Printf("⚠️ Failed to lock verification: %v", err)
+			}
+			return lockedError(now.Add(verificationLockout), now)
+		}
+		return &phoneVerificationError{
+			status:  http.StatusBadRequest,
+			code:    "INVALID_CODE",
+			message: "Incorrect verification code",
+			details: gin.H{"attempts_remaining": maxCodeAttempts - attempts},
+		}
 	}
 
 	var updateResult []map[string]interface{}
 	err = supabaseClient.DB.From("phone_verifications").
-		Update(updateData).
-		Eq("user_id", userID).
-		Eq("phone", phone).
+		Update(map[string]interface{}{"verified_at": now.UTC().Format(time.RFC3339)}).
+		Eq("id", verification.ID).
 		Execute(&updateResult)
-
 	if err != nil {
 		log.Printf("⚠️ Failed to mark verification as completed: %v", err)
 	}
 
-	return true, nil
+	return nil
 }
 
 // updatePhoneVerificationStatus updates user profile after successful verification
@@ -377,10 +666,10 @@ func updatePhoneVerificationStatus(userID, phone string) error {
 	}
 
 	updateData := map[string]interface{}{
-		"phone":               phone,
-		"phone_verified":      true,
+		"phone":                phone,
+		"phone_verified":       true,
 		"onboarding_completed": true,
-		"updated_at":          time.Now().Format(time.RFC3339),
+		"updated_at":           time.Now().Format(time.RFC3339),
 	}
 
 	var result []map[string]interface{}
@@ -422,4 +711,3 @@ func validatePhoneNotInUse(phone, currentUserID string) error {
 
 	return nil
 }
-
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	supa "github.com/nedpals/supabase-go"
+)
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := []struct {
+		raw, country string
+		want         string
+		iso          string
+	}{
+		{"+1 (415) 555-2671", "", "+14155552671", "US"},
+		{"415-555-2671", "US", "+14155552671", "US"},
+		{"+1 416 555 0100", "CA", "+14165550100", "CA"},
+		{"020 7946 0958", "GB", "+442079460958", "GB"},
+		{"0044 20 7946 0958", "", "+442079460958", "GB"},
+		{"+91 98765 43210", "", "+919876543210", "IN"},
+		{"098765 43210", "in", "+919876543210", "IN"},
+		{"+7 912 345 6789", "", "+79123456789", ""}, // unlisted calling code
+	}
+	for _, tt := range tests {
+		got, err := normalizePhoneNumber(tt.raw, tt.country)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.raw, err)
+			continue
+		}
+		if got.E164 != tt.want || got.CountryCode != tt.iso {
+			t.Errorf("%q: expected %s (%s), got %s (%s)", tt.raw, tt.want, tt.iso, got.E164, got.CountryCode)
+		}
+	}
+
+	for _, invalid := range []struct{ raw, country string }{
+		{"4155552671", ""},         // national number without a country
+		{"+1 415 555 267", ""},     // too short
+		{"+1 015 555 2671", ""},    // invalid area code
+		{"+44 20 7946 0958 1", ""}, // too long
+		{"+1 415 555 CALL", ""},
+		{"+123", ""},
+	} {
+		if _, err := normalizePhoneNumber(invalid.raw, invalid.country); err == nil {
+			t.Errorf("%q: expected an error", invalid.raw)
+		}
+	}
+}
+
+func TestDefaultPhoneCountry(t *testing.T) {
+	// Without a profile, national numbers fall back to the US as the web client sends no country
+	if got := defaultPhoneCountry("user-1", ""); got != fallbackPhoneCountry {
+		t.Errorf("expected %s, got %q", fallbackPhoneCountry, got)
+	}
+	if got := defaultPhoneCountry("user-1", "GB"); got != "GB" {
+		t.Errorf("expected the requested country, got %q", got)
+	}
+	phone, err := normalizePhoneNumber("415-555-2671", defaultPhoneCountry("user-1", ""))
+	if err != nil || phone.E164 != "+14155552671" {
+		t.Errorf("normalizePhoneNumber = %v, %v", phone, err)
+	}
+}
+
+func TestGenerateVerificationCode(t *testing.T) {
+	code, err := generateVerificationCode()
+	if err != nil || len(code) != verificationCodeDigits {
+		t.Fatalf("expected a %d digit code, got %q (%v)", verificationCodeDigits, code, err)
+	}
+	if hashVerificationCode("u", "+14155552671", code) == hashVerificationCode("u", "+14155552672", code) {
+		t.Error("code hashes should be bound to the phone number")
+	}
+}
+
+func TestSendAllowed(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	sent := func(ago ...time.Duration) []phoneVerification {
+		rows := make([]phoneVerification, len(ago))
+		for i, d := range ago {
+			rows[i] = phoneVerification{CreatedAt: now.Add(-d)}
+		}
+		return rows
+	}
+	locked := now.Add(10 * time.Minute)
+
+	tests := []struct {
+		name      string
+		userRows  []phoneVerification
+		phoneRows []phoneVerification
+		code      string
+	}{
+		{"first code", nil, nil, ""},
+		{"cooldown", sent(30 * time.Second), nil, "RESEND_COOLDOWN"},
+		{"phone cooldown from another account", nil, sent(30 * time.Second), "RESEND_COOLDOWN"},
+		{"hourly user limit", sent(2*time.Minute, 10*time.Minute, 20*time.Minute, 30*time.Minute, 40*time.Minute), nil, "VERIFICATION_SEND_LIMIT"},
+		{"older sends age out", sent(2*time.Minute, 10*time.Minute, 20*time.Minute, 30*time.Minute, 61*time.Minute), nil, ""},
+		{"locked", []phoneVerification{{CreatedAt: now.Add(-time.Hour), LockedUntil: &locked}}, nil, "PHONE_VERIFICATION_LOCKED"},
+	}
+	for _, tt := range tests {
+		err := sendAllowed(tt.userRows, tt.phoneRows, now)
+		got := ""
+		if verr, ok := err.(*phoneVerificationError); ok {
+			got = verr.code
+			if verr.retryAfter <= 0 {
+				t.Errorf("%s: expected a retry delay", tt.name)
+			}
+		}
+		if got != tt.code {
+			t.Errorf("%s: expected %q, got %q (%v)", tt.name, tt.code, got, err)
+		}
+	}
+}
+
+func TestVerifyPhoneCodeLimitsConcurrentGuesses(t *testing.T) {
+	now := time.Now()
+	var mu sync.Mutex
+	attempts := 0
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/rest/v1/phone_verifications":
+			if r.Method != http.MethodGet {
+				w.Write([]byte("[]"))
+				return
+			}
+			// Every guess reads the verification before any attempt is counted
+			json.NewEncoder(w).Encode([]phoneVerification{{
+				ID: "v1", UserID: "user-1", Phone: "+14155550123", Channel: ChannelSMS,
+				CodeHash: hashVerificationCode("user-1", "+14155550123", "123456"), ExpiresAt: now.Add(time.Minute), CreatedAt: now,
+			}})
+		case "/rest/v1/rpc/claim_phone_verification_attempt":
+			claimed := 0
+			if attempts < maxCodeAttempts {
+				attempts++
+				claimed = attempts
+			}
+			json.NewEncoder(w).Encode(claimed)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer storage.Close()
+	supabaseClient = supa.CreateClient(storage.URL, "service-key")
+	defer func() { supabaseClient = nil }()
+
+	var wg sync.WaitGroup
+	var compared, refused int
+	var resultsMu sync.Mutex
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := verifyPhoneCode("user-1", "+14155550123", "000000", now)
+			var verificationErr *phoneVerificationError
+			if !errors.As(err, &verificationErr) {
+				t.Errorf("unexpected error %v", err)
+				return
+			}
+			resultsMu.Lock()
+			defer resultsMu.Unlock()
+			if verificationErr.code == "MAX_ATTEMPTS_EXCEEDED" {
+				refused++
+			} else {
+				compared++
+			}
+		}()
+	}
+	wg.Wait()
+	if compared != maxCodeAttempts || refused != 20-maxCodeAttempts {
+		t.Errorf("%d guesses compared and %d refused, want %d compared", compared, refused, maxCodeAttempts)
+	}
+}
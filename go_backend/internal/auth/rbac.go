@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Organization roles. Owners always hold every permission; the permissions of
+// the other roles default to DefaultRolePermissions and can be changed per
+// organization.
+const (
+	RoleOwner    = "owner"
+	RoleAdmin    = "admin"
+	RoleReviewer = "reviewer"
+	RoleAnalyst  = "analyst"
+	RoleViewer   = "viewer"
+	RoleBilling  = "billing"
+)
+
+// Roles lists the roles a member can hold
+var Roles = []string{RoleOwner, RoleAdmin, RoleReviewer, RoleAnalyst, RoleViewer, RoleBilling}
+
+// Permissions checked by RequirePermission and the organization handlers
+const (
+	PermAssessmentsRead    = "assessments:read"
+	PermAssessmentsCreate  = "assessments:create"
+	PermAssessmentsUpdate  = "assessments:update"
+	PermAssessmentsDelete  = "assessments:delete"
+	PermAssessmentsQualify = "assessments:qualify"
+	PermAssessmentsExport  = "assessments:export"
+	PermBillingRead        = "billing:read"
+	PermBillingManage      = "billing:manage"
+	PermMembersManage      = "members:manage"
+	PermOrganizationManage = "organization:manage"
+	PermAuditRead          = "audit:read"
+	PermIntegrationsManage = "integrations:manage"
+)
+
+// AllPermissions lists every permission
+var AllPermissions = []string{
+	PermAssessmentsRead,
+	PermAssessmentsCreate,
+	PermAssessmentsUpdate,
+	PermAssessmentsDelete,
+	PermAssessmentsQualify,
+	PermAssessmentsExport,
+	PermBillingRead,
+	PermBillingManage,
+	PermMembersManage,
+	PermOrganizationManage,
+	PermAuditRead,
+	PermIntegrationsManage,
+}
+
+// DefaultRolePermissions is used for organizations that have not configured a role
+var DefaultRolePermissions = map[string][]string{
+	RoleAdmin: AllPermissions,
+	RoleReviewer: {
+		PermAssessmentsRead, PermAssessmentsCreate, PermAssessmentsUpdate,
+		PermAssessmentsQualify, PermAssessmentsExport, PermBillingRead,
+	},
+	RoleAnalyst: {
+		PermAssessmentsRead, PermAssessmentsCreate, PermAssessmentsUpdate, PermAssessmentsExport,
+	},
+	RoleViewer: {
+		PermAssessmentsRead, PermAssessmentsExport,
+	},
+	RoleBilling: {
+		PermAssessmentsRead, PermBillingRead, PermBillingManage,
+	},
+}
+
+// ConfigurableRoles lists the roles whose permissions an organization may change
+var ConfigurableRoles = []string{RoleAdmin, RoleReviewer, RoleAnalyst, RoleViewer, RoleBilling}
+
+const rolePermissionCacheTTL = time.Minute
+
+// OrganizationRole is a per-organization override of a role's permissions
+type OrganizationRole struct {
+	OrgID       string    `json:"org_id" db:"org_id"`
+	Role        string    `json:"role" db:"role"`
+	Permissions []string  `json:"permissions" db:"permissions"`
+	UpdatedBy   *string   `json:"updated_by" db:"updated_by"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// rolePermissionCache holds each organization's role overrides. Changes made
+// on another instance take effect once the entry expires.
+var rolePermissionCache = struct {
+	sync.Mutex
+	entries map[string]*cachedRolePermissions
+}{entries: map[string]*cachedRolePermissions{}}
+
+type cachedRolePermissions struct {
+	roles    map[string][]string
+	loadedAt time.Time
+}
+
+// OrganizationRolePermissions returns the permissions role holds in an organization
+func OrganizationRolePermissions(orgID, role string) ([]string, error) {
+	if role == RoleOwner {
+		return AllPermissions, nil
+	}
+
+	overrides, err := loadRolePermissions(orgID)
+	if err != nil {
+		return nil, err
+	}
+	if permissions, ok := overrides[role]; ok {
+		return permissions, nil
+	}
+	return DefaultRolePermissions[role], nil
+}
+
+// HasOrganizationPermission reports whether a user holds permission in an
+// organization. Non-members hold no permissions.
+func HasOrganizationPermission(orgID, userID, permission string) (bool, error) {
+	member, err := GetOrganizationMembership(orgID, userID)
+	if err == ErrNotOrganizationMember {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	permissions, err := OrganizationRolePermissions(orgID, member.Role)
+	if err != nil {
+		return false, err
+	}
+	return containsString(permissions, permission), nil
+}
+
+// SetOrganizationRolePermissions overrides the permissions of a role in an organization
+func SetOrganizationRolePermissions(orgID, role string, permissions []string, updatedBy string) (*OrganizationRole, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+	if !containsString(ConfigurableRoles, role) {
+		return nil, fmt.Errorf("role %q cannot be configured", role)
+	}
+	for _, permission := range permissions {
+		if !containsString(AllPermissions, permission) {
+			return nil, fmt.Errorf("unknown permission %q", permission)
+		}
+	}
+
+	var results []OrganizationRole
+	err := supabaseClient.DB.From("organization_roles").
+		Upsert(map[string]interface{}{
+			"org_id":      orgID,
+			"role":        role,
+			"permissions": normalizePermissions(permissions),
+			"updated_by":  updatedBy,
+			"updated_at":  time.Now().UTC().Format(time.RFC3339),
+		}).
+		Execute(&results)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save role permissions: %v", err)
+	}
+	forgetRolePermissions(orgID)
+	if len(results) == 0 {
+		return nil, fmt.Errorf("failed to save role permissions: no rows returned")
+	}
+	return &results[0], nil
+}
+
+// ResetOrganizationRolePermissions restores the default permissions of a role
+func ResetOrganizationRolePermissions(orgID, role string) error {
+	if supabaseClient == nil {
+		return fmt.Errorf("supabase client not initialized")
+	}
+
+	var results []map[string]interface{}
+	err := supabaseClient.DB.From("organization_roles").
+		Delete().
+		Eq("org_id", orgID).
+		Eq("role", role).
+		Execute(&results)
+	if err != nil {
+		return fmt.Errorf("failed to reset role permissions: %v", err)
+	}
+	forgetRolePermissions(orgID)
+	return nil
+}
+
+// loadRolePermissions returns an organization's role overrides from the cache or database
+func loadRolePermissions(orgID string) (map[string][]string, error) {
+	rolePermissionCache.Lock()
+	entry, found := rolePermissionCache.entries[orgID]
+	rolePermissionCache.Unlock()
+	if found && time.Since(entry.loadedAt) < rolePermissionCacheTTL {
+		return entry.roles, nil
+	}
+
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var rows []OrganizationRole
+	err := supabaseClient.DB.From("organization_roles").
+		Select("*").
+		Eq("org_id", orgID).
+		Execute(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch role permissions: %v", err)
+	}
+
+	roles := make(map[string][]string, len(rows))
+	for _, row := range rows {
+		roles[row.Role] = row.Permissions
+	}
+
+	rolePermissionCache.Lock()
+	rolePermissionCache.entries[orgID] = &cachedRolePermissions{roles: roles, loadedAt: time.Now()}
+	rolePermissionCache.Unlock()
+	return roles, nil
+}
+
+func forgetRolePermissions(orgID string) {
+	rolePermissionCache.Lock()
+	delete(rolePermissionCache.entries, orgID)
+	rolePermissionCache.Unlock()
+}
+
+// normalizePermissions sorts permissions and drops duplicates
+func normalizePermissions(permissions []string) []string {
+	result := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		if !containsString(result, permission) {
+			result = append(result, permission)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// RequirePermission rejects requests whose workspace does not grant
+// permission. In the personal workspace users hold every permission; in an
+// organization their role decides. Use after AuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workspace, ok := ResolveWorkspace(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		if !workspace.Can(permission) {
+			log.Printf("🚫 RequirePermission: User %s (%s) lacks %s in organization %s", workspace.UserID, workspace.Role, permission, workspace.OrgID)
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":      "Your role does not allow this action",
+				"code":       "PERMISSION_DENIED",
+				"permission": permission,
+				"role":       workspace.Role,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
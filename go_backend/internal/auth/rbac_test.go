@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOrganizationRolePermissions(t *testing.T) {
+	rolePermissionCache.Lock()
+	rolePermissionCache.entries["org-1"] = &cachedRolePermissions{
+		roles:    map[string][]string{RoleViewer: {PermAssessmentsRead}},
+		loadedAt: time.Now(),
+	}
+	rolePermissionCache.Unlock()
+	t.Cleanup(func() { forgetRolePermissions("org-1") })
+
+	tests := []struct {
+		role       string
+		permission string
+		want       bool
+	}{
+		{RoleOwner, PermOrganizationManage, true},
+		{RoleReviewer, PermAssessmentsQualify, true},
+		{RoleAnalyst, PermAssessmentsQualify, false},
+		{RoleBilling, PermBillingManage, true},
+		{RoleViewer, PermAssessmentsRead, true},
+		{RoleViewer, PermAssessmentsExport, false}, // removed by the organization
+	}
+	for _, tt := range tests {
+		permissions, err := OrganizationRolePermissions("org-1", tt.role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := containsString(permissions, tt.permission); got != tt.want {
+			t.Errorf("%s %s: expected %v, got %v", tt.role, tt.permission, tt.want, got)
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	withWorkspace := func(workspace Workspace) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("user_id", workspace.UserID)
+			c.Set("workspace", workspace)
+		}
+	}
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+
+	tests := []struct {
+		name      string
+		workspace Workspace
+		status    int
+	}{
+		{"personal workspace", Workspace{UserID: "user-1", Permissions: AllPermissions}, http.StatusOK},
+		{"reviewer", Workspace{UserID: "user-1", OrgID: "org-1", Role: RoleReviewer, Permissions: DefaultRolePermissions[RoleReviewer]}, http.StatusOK},
+		{"analyst", Workspace{UserID: "user-1", OrgID: "org-1", Role: RoleAnalyst, Permissions: DefaultRolePermissions[RoleAnalyst]}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		router := gin.New()
+		router.POST("/qualify", withWorkspace(tt.workspace), RequirePermission(PermAssessmentsQualify), ok)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/qualify", nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d (%s)", tt.name, tt.status, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// scheduledJob is background work run every interval. Each run is claimed in
+// scheduled_jobs first, so with several API instances only one of them runs it.
+type scheduledJob struct {
+	name     string
+	interval time.Duration
+	run      func() (interface{}, error)
+}
+
+var (
+	schedulerOnce       sync.Once
+	schedulerInstanceID = newSchedulerInstanceID()
+)
+
+// newSchedulerInstanceID names this process in scheduled_jobs.locked_by
+func newSchedulerInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// startScheduler starts the background jobs of the auth package
+func startScheduler() {
+	schedulerOnce.Do(func() {
+		for _, job := range []scheduledJob{
+			{name: "release_expired_credit_reservations", interval: reservationSweepInterval, run: releaseExpiredReservationsJob},
+			{name: "subscription_cycle", interval: subscriptionCycleInterval, run: subscriptionCycleJob},
+			{name: "expired_idempotency_keys", interval: idempotencySweepInterval, run: deleteExpiredIdempotencyKeys},
+		} {
+			go runScheduledJob(job)
+		}
+		log.Printf("⏰ Scheduler started as %s", schedulerInstanceID)
+	})
+}
+
+// ScheduleJob runs run now and then every interval on one API instance at a
+// time, like the jobs of the auth package. Call it after InitAuth.
+func ScheduleJob(name string, interval time.Duration, run func() (interface{}, error)) {
+	go runScheduledJob(scheduledJob{name: name, interval: interval, run: run})
+}
+
+// runScheduledJob runs a job now and then every interval
+func runScheduledJob(job scheduledJob) {
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+	for {
+		runScheduledJobOnce(job)
+		<-ticker.C
+	}
+}
+
+func runScheduledJobOnce(job scheduledJob) {
+	// The lease ends a little before the next tick so the instance that ran
+	// the job can claim it again despite timer drift
+	claimed, err := claimScheduledJob(job.name, job.interval*9/10)
+	if err != nil {
+		log.Printf("⚠️ Scheduler: Failed to claim %s: %v", job.name, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	result, err := job.run()
+	errorText := ""
+	if err != nil {
+		errorText = err.Error()
+		log.Printf("❌ Scheduler: %s failed: %v", job.name, err)
+	}
+	if err := finishScheduledJob(job.name, result, errorText); err != nil {
+		log.Printf("⚠️ Scheduler: Failed to record %s run: %v", job.name, err)
+	}
+}
+
+func claimScheduledJob(name string, lease time.Duration) (bool, error) {
+	if supabaseClient == nil {
+		return false, fmt.Errorf("supabase client not initialized")
+	}
+
+	var claimed bool
+	err := supabaseClient.DB.Rpc("claim_scheduled_job", map[string]interface{}{
+		"p_job_name":      name,
+		"p_instance_id":   schedulerInstanceID,
+		"p_lease_seconds": int(lease.Seconds()),
+	}).Execute(&claimed)
+	return claimed, err
+}
+
+func finishScheduledJob(name string, result interface{}, errorText string) error {
+	if supabaseClient == nil {
+		return fmt.Errorf("supabase client not initialized")
+	}
+
+	var recorded bool
+	return supabaseClient.DB.Rpc("finish_scheduled_job", map[string]interface{}{
+		"p_job_name":    name,
+		"p_instance_id": schedulerInstanceID,
+		"p_result":      result,
+		"p_error":       optionalString(errorText),
+	}).Execute(&recorded)
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionResponse is a session as shown to its user
+type SessionResponse struct {
+	UserSession
+	Current bool `json:"current"`
+}
+
+// ListSessionsHandler handles GET /api/auth/sessions
+func ListSessionsHandler(c *gin.Context) {
+	userID := GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireAuthDatabase(c) {
+		return
+	}
+
+	sessions, err := ListActiveSessions(userID)
+	if err != nil {
+		log.Printf("❌ ListSessionsHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch sessions",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	current := GetSessionID(c)
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{UserSession: session, Current: session.ID == current})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": response,
+		"total":    len(response),
+	})
+}
+
+// RevokeSessionHandler handles DELETE /api/auth/sessions/:id
+func RevokeSessionHandler(c *gin.Context) {
+	userID := GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireAuthDatabase(c) {
+		return
+	}
+
+	sessionID := c.Param("id")
+	revoked, err := RevokeSession(userID, sessionID)
+	if err != nil {
+		log.Printf("❌ RevokeSessionHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke session",
+			"code":  "SESSION_REVOKE_ERROR",
+		})
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Session not found",
+			"code":  "SESSION_NOT_FOUND",
+		})
+		return
+	}
+
+	log.Printf("🔒 RevokeSessionHandler: User %s revoked session %s", userID, sessionID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Session revoked",
+		"id":      sessionID,
+		"current": sessionID == GetSessionID(c),
+	})
+}
+
+// RevokeOtherSessionsHandler handles DELETE /api/auth/sessions, signing out
+// every other device. Pass ?include_current=true to sign out everywhere.
+func RevokeOtherSessionsHandler(c *gin.Context) {
+	userID := GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireAuthDatabase(c) {
+		return
+	}
+
+	keep := GetSessionID(c)
+	if c.Query("include_current") == "true" {
+		keep = ""
+	}
+
+	count, err := RevokeOtherSessions(userID, keep)
+	if err != nil {
+		log.Printf("❌ RevokeOtherSessionsHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke sessions",
+			"code":  "SESSION_REVOKE_ERROR",
+		})
+		return
+	}
+
+	log.Printf("🔒 RevokeOtherSessionsHandler: User %s revoked %d sessions", userID, count)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Sessions revoked",
+		"revoked": count,
+	})
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// sessionCheckInterval is how often a session's revocation state is
+	// re-read, and so how long a revocation takes to reach other instances
+	sessionCheckInterval = 30 * time.Second
+	// sessionActivityInterval limits last_activity_at writes per session
+	sessionActivityInterval = time.Minute
+	sessionCacheSweep       = 10 * time.Minute
+)
+
+var errSessionRevoked = errors.New("session has been revoked")
+
+// UserSession is a row of user_sessions: one signed-in device
+type UserSession struct {
+	ID             string     `json:"id"`
+	UserID         string     `json:"user_id"`
+	IPAddress      *string    `json:"ip_address"`
+	UserAgent      *string    `json:"user_agent"`
+	DeviceType     *string    `json:"device_type"`
+	Browser        *string    `json:"browser"`
+	OS             *string    `json:"os"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	IsActive       bool       `json:"is_active"`
+	LastActivityAt time.Time  `json:"last_activity_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+}
+
+// sessionState caches what the middleware last saw of a session
+type sessionState struct {
+	active        bool
+	checkedAt     time.Time
+	activityWrite time.Time
+}
+
+var sessionCache = struct {
+	sync.Mutex
+	entries   map[string]sessionState
+	lastSweep time.Time
+}{entries: map[string]sessionState{}}
+
+func cachedSessionState(id string) (sessionState, bool) {
+	sessionCache.Lock()
+	defer sessionCache.Unlock()
+	state, found := sessionCache.entries[id]
+	return state, found
+}
+
+func setSessionState(id string, state sessionState) {
+	sessionCache.Lock()
+	defer sessionCache.Unlock()
+	now := time.Now()
+	if now.Sub(sessionCache.lastSweep) > sessionCacheSweep {
+		for key, entry := range sessionCache.entries {
+			if now.Sub(entry.checkedAt) > sessionCacheSweep {
+				delete(sessionCache.entries, key)
+			}
+		}
+		sessionCache.lastSweep = now
+	}
+	sessionCache.entries[id] = state
+}
+
+// sessionIDFor identifies the session a token belongs to. Supabase tokens
+// carry a session_id that survives refreshes; tokens resolved without claims
+// are tracked individually.
+func sessionIDFor(token string, user *authenticatedUser) string {
+	if user.Claims != nil {
+		if _, err := uuid.Parse(user.Claims.SessionID); err == nil {
+			return user.Claims.SessionID
+		}
+	}
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(token)).String()
+}
+
+// trackSession records the request's session and rejects revoked ones.
+// Storage errors are logged and the request allowed.
+func trackSession(c *gin.Context, token string, user *authenticatedUser) (string, error) {
+	id := sessionIDFor(token, user)
+	if supabaseClient == nil {
+		return id, nil
+	}
+
+	now := time.Now()
+	state, found := cachedSessionState(id)
+	if found && now.Sub(state.checkedAt) < sessionCheckInterval {
+		if !state.active {
+			return id, errSessionRevoked
+		}
+		if now.Sub(state.activityWrite) < sessionActivityInterval {
+			return id, nil
+		}
+	}
+
+	session, err := getSession(id)
+	if err != nil {
+		log.Printf("⚠️ trackSession: %v", err)
+		return id, nil
+	}
+	if session != nil && (!session.IsActive || session.UserID != user.ID) {
+		setSessionState(id, sessionState{active: false, checkedAt: now})
+		return id, errSessionRevoked
+	}
+
+	row := map[string]interface{}{
+		"ip_address":       c.ClientIP(),
+		"last_activity_at": now.UTC().Format(time.RFC3339),
+	}
+	if user.Claims != nil && user.Claims.ExpiresAt != nil {
+		row["expires_at"] = user.Claims.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+
+	var results []UserSession
+	if session == nil {
+		userAgent := c.Request.UserAgent()
+		info := parseUserAgent(userAgent)
+		row["id"] = id
+		row["user_id"] = user.ID
+		row["user_agent"] = userAgent
+		row["device_type"] = info.DeviceType
+		row["browser"] = info.Browser
+		row["os"] = info.OS
+		row["is_active"] = true
+		err = supabaseClient.DB.From("user_sessions").Insert(row).Execute(&results)
+		if err == nil {
+			log.Printf("🆕 trackSession: New %s session %s for user %s (%s on %s)", info.DeviceType, id, user.ID, info.Browser, info.OS)
+
+			// A session is first seen on the first request after signing in
+			event := NewAuditEvent(c, AuditLogin, "session", id)
+			event.UserID = user.ID
+			event.SessionID = id
+			event.Metadata["device_type"] = info.DeviceType
+			event.Metadata["browser"] = info.Browser
+			event.Metadata["os"] = info.OS
+			RecordAuditEvent(event)
+		}
+	} else if now.Sub(session.LastActivityAt) >= sessionActivityInterval {
+		err = supabaseClient.DB.From("user_sessions").Update(row).Eq("id", id).Execute(&results)
+	}
+	if err != nil {
+		log.Printf("⚠️ trackSession: Failed to record session %s: %v", id, err)
+	}
+
+	setSessionState(id, sessionState{active: true, checkedAt: now, activityWrite: now})
+	return id, nil
+}
+
+// getSession returns a session by ID, or nil if it is not recorded
+func getSession(id string) (*UserSession, error) {
+	var sessions []UserSession
+	err := supabaseClient.DB.From("user_sessions").
+		Select("*").
+		Eq("id", id).
+		Execute(&sessions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch session: %v", err)
+	}
+	if len(sessions) == 0 {
+		return nil, nil
+	}
+	return &sessions[0], nil
+}
+
+// ListActiveSessions returns the user's signed-in sessions, most recently used first
+func ListActiveSessions(userID string) ([]UserSession, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var sessions []UserSession
+	err := supabaseClient.DB.From("user_sessions").
+		Select("*").
+		OrderBy("last_activity_at", "desc").
+		Eq("user_id", userID).
+		Eq("is_active", "true").
+		Execute(&sessions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sessions: %v", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession signs out one of the user's sessions. Tokens of the session
+// are rejected from then on. It returns false if no active session matched.
+func RevokeSession(userID, sessionID string) (bool, error) {
+	if supabaseClient == nil {
+		return false, fmt.Errorf("supabase client not initialized")
+	}
+
+	var revoked []UserSession
+	err := supabaseClient.DB.From("user_sessions").
+		Update(revokedSessionUpdate()).
+		Eq("id", sessionID).
+		Eq("user_id", userID).
+		Eq("is_active", "true").
+		Execute(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke session: %v", err)
+	}
+	setSessionState(sessionID, sessionState{active: false, checkedAt: time.Now()})
+	return len(revoked) > 0, nil
+}
+
+// RevokeOtherSessions signs out every session of the user except keepID
+// (which may be empty to sign out everywhere) and returns how many were revoked
+func RevokeOtherSessions(userID, keepID string) (int, error) {
+	if supabaseClient == nil {
+		return 0, fmt.Errorf("supabase client not initialized")
+	}
+
+	query := supabaseClient.DB.From("user_sessions").
+		Update(revokedSessionUpdate()).
+		Eq("user_id", userID).
+		Eq("is_active", "true")
+	if keepID != "" {
+		query = query.Neq("id", keepID)
+	}
+
+	var revoked []UserSession
+	if err := query.Execute(&revoked); err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+	now := time.Now()
+	for _, session := range revoked {
+		setSessionState(session.ID, sessionState{active: false, checkedAt: now})
+	}
+	return len(revoked), nil
+}
+
+func revokedSessionUpdate() map[string]interface{} {
+	return map[string]interface{}{
+		"is_active":  false,
+		"revoked_at": time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// GetSessionID returns the session of the request, empty for API key requests
+func GetSessionID(c *gin.Context) string {
+	return c.GetString("session_id")
+}
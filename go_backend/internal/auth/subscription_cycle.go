@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// subscriptionCycleInterval is how often subscriptions and credit periods
+// are checked. Resets and expiries therefore happen up to this long after
+// they are due.
+const subscriptionCycleInterval = 15 * time.Minute
+
+// subscriptionCycleBatchSize is how many rows run_subscription_cycle handles
+// per step in one call; each call is one database transaction
+const subscriptionCycleBatchSize = 200
+
+// maxSubscriptionCyclePasses bounds the calls of one scheduled run; anything
+// left waits for the next run
+const maxSubscriptionCyclePasses = 50
+
+// SubscriptionCycleResult counts the rows handled by run_subscription_cycle
+type SubscriptionCycleResult struct {
+	Expired int `json:"expired"` // subscriptions ended (cancelled or not renewing)
+	Renewed int `json:"renewed"` // subscriptions moved to their next billing date
+	Reset   int `json:"reset"`   // wallets that started a new credit period
+}
+
+func (r SubscriptionCycleResult) add(other SubscriptionCycleResult) SubscriptionCycleResult {
+	return SubscriptionCycleResult{
+		Expired: r.Expired + other.Expired,
+		Renewed: r.Renewed + other.Renewed,
+		Reset:   r.Reset + other.Reset,
+	}
+}
+
+// RunSubscriptionCycle runs one pass of subscription billing: expiring
+// cancelled subscriptions, renewing the others and resetting monthly credits.
+// It is safe to call from several instances at once.
+func RunSubscriptionCycle() (*SubscriptionCycleResult, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var result SubscriptionCycleResult
+	err := supabaseClient.DB.Rpc("run_subscription_cycle", map[string]interface{}{
+		"p_batch_size": subscriptionCycleBatchSize,
+	}).Execute(&result)
+	if err != nil {
+		return nil, ledgerError(err)
+	}
+	return &result, nil
+}
+
+// subscriptionCycleJob is the scheduled job running subscription billing
+// until no step has a full batch left
+func subscriptionCycleJob() (interface{}, error) {
+	var total SubscriptionCycleResult
+	for pass := 0; pass < maxSubscriptionCyclePasses; pass++ {
+		result, err := RunSubscriptionCycle()
+		if err != nil {
+			return total, err
+		}
+		total = total.add(*result)
+		if result.Expired < subscriptionCycleBatchSize && result.Renewed < subscriptionCycleBatchSize && result.Reset < subscriptionCycleBatchSize {
+			break
+		}
+	}
+
+	if total != (SubscriptionCycleResult{}) {
+		log.Printf("🔁 RunSubscriptionCycle: Expired %d, renewed %d subscriptions and reset credits of %d wallets",
+			total.Expired, total.Renewed, total.Reset)
+	}
+	return total, nil
+}
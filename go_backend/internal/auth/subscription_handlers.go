@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ChangeSubscriptionRequest is the body of PUT /api/auth/subscription
+type ChangeSubscriptionRequest struct {
+	PlanID       int    `json:"plan_id" binding:"required"`
+	BillingCycle string `json:"billing_cycle"` // monthly (default) or yearly
+}
+
+// CancelSubscriptionRequest is the body of POST /api/auth/subscription/cancel
+type CancelSubscriptionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// UpdateOverageRequest is the body of PUT /api/auth/subscription/overage
+type UpdateOverageRequest struct {
+	Enabled  *bool `json:"enabled" binding:"required"`
+	CapCents *int  `json:"cap_cents"` // spending cap per credit period, required when enabling
+}
+
+// GetSubscriptionHandler handles GET /api/auth/subscription
+func GetSubscriptionHandler(c *gin.Context) {
+	userID := GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireAuthDatabase(c) {
+		return
+	}
+
+	subscription, plan, err := GetUserSubscription(userID)
+	if err != nil {
+		log.Printf("❌ GetSubscriptionHandler: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Subscription not found",
+			"code":  "SUBSCRIPTION_NOT_FOUND",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subscription": subscription,
+		"plan":         plan,
+	})
+}
+
+// BindChangeSubscriptionRequest binds the body of a plan change, defaulting
+// to the monthly cycle, and writes the 400 response when it is invalid
+func BindChangeSubscriptionRequest(c *gin.Context) (*ChangeSubscriptionRequest, bool) {
+	var req ChangeSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "plan_id is required",
+			"code":  "INVALID_REQUEST",
+		})
+		return nil, false
+	}
+	if req.BillingCycle == "" {
+		req.BillingCycle = BillingMonthly
+	}
+	if req.BillingCycle != BillingMonthly && req.BillingCycle != BillingYearly {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "billing_cycle must be monthly or yearly",
+			"code":  "INVALID_BILLING_CYCLE",
+		})
+		return nil, false
+	}
+	return &req, true
+}
+
+// CancelSubscriptionHandler handles POST /api/auth/subscription/cancel. The
+// plan stays in effect until the end of the paid period.
+func CancelSubscriptionHandler(c *gin.Context) {
+	userID := GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	var req CancelSubscriptionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request body",
+				"code":  "INVALID_REQUEST",
+			})
+			return
+		}
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if len(reason) > maxCancellationReasonLength {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "reason is too long",
+			"code":  "INVALID_REQUEST",
+		})
+		return
+	}
+
+	if !requireAuthDatabase(c) {
+		return
+	}
+
+	subscription, err := CancelSubscription(userID, reason)
+	if err != nil {
+		SubscriptionErrorResponse(c, "CancelSubscriptionHandler", err)
+		return
+	}
+
+	SetAuditResource(c, subscription.ID)
+	SetAuditMetadata(c, "reason", reason)
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Subscription cancelled; your plan stays active until the end of the billing period",
+		"subscription": subscription,
+	})
+}
+
+// UpdateOverageHandler handles PUT /api/auth/subscription/overage. With
+// overage on, assessments continue when the credits run out and the extra
+// credits are invoiced at the plan's overage price, up to the cap.
+func UpdateOverageHandler(c *gin.Context) {
+	userID := GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	var req UpdateOverageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "enabled is required",
+			"code":  "INVALID_REQUEST",
+		})
+		return
+	}
+	if *req.Enabled && (req.CapCents == nil || *req.CapCents <= 0) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "cap_cents must be a positive spending cap when enabling overage",
+			"code":  "INVALID_OVERAGE_CAP",
+		})
+		return
+	}
+	if !*req.Enabled {
+		req.CapCents = nil
+	}
+
+	if !requireAuthDatabase(c) {
+		return
+	}
+
+	subscription, err := SetSubscriptionOverage(userID, *req.Enabled, req.CapCents)
+	if err != nil {
+		SubscriptionErrorResponse(c, "UpdateOverageHandler", err)
+		return
+	}
+
+	SetAuditResource(c, subscription.ID)
+	SetAuditMetadata(c, "overage_enabled", subscription.OverageEnabled)
+	SetAuditMetadata(c, "overage_cap_cents", subscription.OverageCapCents)
+	c.JSON(http.StatusOK, subscription)
+}
+
+// SubscriptionErrorResponse writes the response for an error of
+// ChangeSubscriptionPlan, CancelSubscription or SetSubscriptionOverage
+func SubscriptionErrorResponse(c *gin.Context, handler string, err error) {
+	switch {
+	case errors.Is(err, ErrSubscriptionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found", "code": "SUBSCRIPTION_NOT_FOUND"})
+	case errors.Is(err, ErrSubscriptionPlanNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription plan not found", "code": "PLAN_NOT_FOUND"})
+	case errors.Is(err, ErrSubscriptionCancelled):
+		c.JSON(http.StatusConflict, gin.H{"error": "Subscription is cancelled", "code": "SUBSCRIPTION_CANCELLED"})
+	case errors.Is(err, ErrInvalidBillingCycle), errors.Is(err, ErrBillingCycleUnavailable):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Billing cycle not available for this plan", "code": "INVALID_BILLING_CYCLE"})
+	case errors.Is(err, ErrFreePlanNotCancellable):
+		c.JSON(http.StatusConflict, gin.H{"error": "The Free plan cannot be cancelled", "code": "FREE_PLAN"})
+	case errors.Is(err, ErrOverageUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Overage is not available on the Free plan", "code": "OVERAGE_NOT_AVAILABLE"})
+	default:
+		log.Printf("❌ %s: %v", handler, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update subscription",
+			"code":  "SUBSCRIPTION_UPDATE_ERROR",
+		})
+	}
+}
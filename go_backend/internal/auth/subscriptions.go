@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Subscription errors. Their messages match the exceptions raised by
+// change_subscription_plan and cancel_subscription in the database.
+var (
+	ErrSubscriptionNotFound     = errors.New("no active subscription found")
+	ErrSubscriptionPlanNotFound = errors.New("subscription plan not found")
+	ErrSubscriptionCancelled    = errors.New("subscription is cancelled")
+	ErrInvalidBillingCycle      = errors.New("invalid billing cycle")
+	ErrBillingCycleUnavailable  = errors.New("billing cycle not available for plan")
+	ErrFreePlanNotCancellable   = errors.New("free plan cannot be cancelled")
+	ErrOverageUnavailable       = errors.New("overage is not available on the free plan")
+)
+
+// Billing cycles of a subscription
+const (
+	BillingMonthly = "monthly"
+	BillingYearly  = "yearly"
+)
+
+// Outcomes of a plan change
+const (
+	PlanChangeUpgraded           = "upgraded"            // applied now, credits prorated
+	PlanChangePaymentRequired    = "payment_required"    // nothing applied until the charge is paid at checkout
+	PlanChangeDowngradeScheduled = "downgrade_scheduled" // applied at the end of the paid period
+	PlanChangeScheduledCancelled = "scheduled_change_cancelled"
+	PlanChangeUnchanged          = "unchanged"
+)
+
+// maxCancellationReasonLength bounds the reason users give when cancelling
+const maxCancellationReasonLength = 500
+
+// SubscriptionChange is the result of ChangeSubscriptionPlan
+type SubscriptionChange struct {
+	Change          string           `json:"change"`
+	Subscription    UserSubscription `json:"subscription"`
+	PlanName        string           `json:"plan_name"`        // the plan asked for
+	ProratedCredits int              `json:"prorated_credits"` // credits granted for the rest of the credit period
+	ChargeCents     int64            `json:"charge_cents"`     // price of an upgrade
+	EffectiveAt     time.Time        `json:"effective_at"`
+}
+
+// subscriptionError maps the exceptions raised by the subscription functions
+// to errors callers can check with errors.Is
+func subscriptionError(err error) error {
+	return raisedError(err, "subscription", ErrSubscriptionNotFound, ErrSubscriptionPlanNotFound,
+		ErrSubscriptionCancelled, ErrInvalidBillingCycle, ErrBillingCycleUnavailable, ErrFreePlanNotCancellable,
+		ErrCreditWalletNotFound)
+}
+
+// ChangeSubscriptionPlan moves a user's subscription to planID. Upgrades
+// with a charge are not applied: they return PlanChangePaymentRequired with
+// ChargeCents, and apply once the charge is paid at checkout. Free upgrades
+// apply immediately and grant the extra credits for the rest of the credit
+// period; downgrades apply at the end of the paid period. Asking for the
+// current plan and cycle drops a scheduled downgrade.
+func ChangeSubscriptionPlan(userID string, planID int, billingCycle string) (*SubscriptionChange, error) {
+	return changeSubscriptionPlan(userID, planID, billingCycle, false)
+}
+
+// changeSubscriptionPlan is ChangeSubscriptionPlan; with invoiceCharge
+// upgrades apply immediately and their charge goes on the monthly invoice
+func changeSubscriptionPlan(userID string, planID int, billingCycle string, invoiceCharge bool) (*SubscriptionChange, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+	if billingCycle != BillingMonthly && billingCycle != BillingYearly {
+		return nil, ErrInvalidBillingCycle
+	}
+
+	var change SubscriptionChange
+	err := supabaseClient.DB.Rpc("change_subscription_plan", map[string]interface{}{
+		"p_user_id":        userID,
+		"p_plan_id":        planID,
+		"p_billing_cycle":  billingCycle,
+		"p_invoice_charge": invoiceCharge,
+	}).Execute(&change)
+	if err != nil {
+		return nil, subscriptionError(err)
+	}
+
+	log.Printf("📦 ChangeSubscriptionPlan: User %s subscription %s: %s to plan %d (%s), %d prorated credits, %d cents",
+		userID, change.Subscription.ID, change.Change, planID, billingCycle, change.ProratedCredits, change.ChargeCents)
+	return &change, nil
+}
+
+// CancelSubscription cancels a user's paid subscription at the end of its
+// paid period, after which the user falls back to the Free plan
+func CancelSubscription(userID, reason string) (*UserSubscription, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var subscription UserSubscription
+	err := supabaseClient.DB.Rpc("cancel_subscription", map[string]interface{}{
+		"p_user_id": userID,
+		"p_reason":  optionalString(reason),
+	}).Execute(&subscription)
+	if err != nil {
+		return nil, subscriptionError(err)
+	}
+
+	log.Printf("📦 CancelSubscription: User %s cancelled subscription %s", userID, subscription.ID)
+	return &subscription, nil
+}
+
+// SetSubscriptionOverage turns overage on or off for a user's subscription.
+// With overage on, usage beyond the credits is billed at the plan's overage
+// price up to capCents per credit period (nil for no cap).
+func SetSubscriptionOverage(userID string, enabled bool, capCents *int) (*UserSubscription, error) {
+	subscription, plan, err := GetUserSubscription(userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled && plan.PlanType == "free" {
+		return nil, ErrOverageUnavailable
+	}
+
+	var updated []UserSubscription
+	err = supabaseClient.DB.From("user_subscriptions").
+		Update(map[string]interface{}{
+			"overage_enabled":   enabled,
+			"overage_cap_cents": capCents,
+			"updated_at":        time.Now().UTC(),
+		}).
+		Eq("id", subscription.ID).
+		Execute(&updated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update overage: %v", err)
+	}
+	if len(updated) == 0 {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	log.Printf("📦 SetSubscriptionOverage: User %s overage enabled=%t cap=%v", userID, enabled, capCents)
+	return &updated[0], nil
+}
+
+// FeatureForbidden writes the response for a plan lacking feature
+func FeatureForbidden(c *gin.Context, feature string) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":   "This feature is not included in your plan",
+		"code":    "FEATURE_NOT_AVAILABLE",
+		"feature": feature,
+	})
+}
+
+// RequireFeature rejects requests from users whose plan does not include
+// feature. Use after AuthMiddleware.
+func RequireFeature(feature string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := GetUserID(c)
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "User authentication required",
+				"code":  "AUTHENTICATION_REQUIRED",
+			})
+			c.Abort()
+			return
+		}
+
+		allowed, err := UserHasFeature(userID, feature)
+		if err != nil {
+			log.Printf("❌ RequireFeature: Failed to check plan for user %s: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check subscription plan",
+				"code":  "SUBSCRIPTION_FETCH_ERROR",
+			})
+			c.Abort()
+			return
+		}
+		if !allowed {
+			log.Printf("🚫 RequireFeature: Plan of user %s lacks %s", userID, feature)
+			FeatureForbidden(c, feature)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	postgrest "github.com/nedpals/supabase-go/postgrest/pkg"
+)
+
+func TestSubscriptionError(t *testing.T) {
+	tests := []struct {
+		message string
+		want    error
+	}{
+		{"no active subscription found", ErrSubscriptionNotFound},
+		{"subscription plan not found", ErrSubscriptionPlanNotFound},
+		{"subscription is cancelled", ErrSubscriptionCancelled},
+		{"billing cycle not available for plan", ErrBillingCycleUnavailable},
+		{"free plan cannot be cancelled", ErrFreePlanNotCancellable},
+	}
+	for _, tt := range tests {
+		err := subscriptionError(&postgrest.RequestError{Code: "P0001", Message: tt.message})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("subscriptionError(%q) = %v, want %v", tt.message, err, tt.want)
+		}
+	}
+}
+
+func TestBindChangeSubscriptionRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		body string
+		code string
+	}{
+		{`{}`, "INVALID_REQUEST"},
+		{`{"plan_id": 2, "billing_cycle": "weekly"}`, "INVALID_BILLING_CYCLE"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPut, "/api/auth/subscription", strings.NewReader(tt.body))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		if _, ok := BindChangeSubscriptionRequest(c); ok || w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.code) {
+			t.Errorf("body %s: got %d %s, want 400 %s", tt.body, w.Code, w.Body.String(), tt.code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPut, "/api/auth/subscription", strings.NewReader(`{"plan_id": 2}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	if req, ok := BindChangeSubscriptionRequest(c); !ok || req.PlanID != 2 || req.BillingCycle != BillingMonthly {
+		t.Errorf("valid body rejected or not defaulted to monthly: %+v", req)
+	}
+}
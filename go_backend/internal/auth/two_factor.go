@@ -0,0 +1,427 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TOTP parameters (RFC 6238 defaults understood by every authenticator app)
+const (
+	totpDigits      = 6
+	totpPeriod      = 30 // seconds
+	totpSkew        = 1  // periods accepted before and after the current one
+	totpSecretBytes = 20
+	totpIssuer      = "QuarkfinAI"
+
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 6 // 10 base32 characters
+
+	stepUpTTL = 10 * time.Minute
+)
+
+// Headers carrying step-up verification on sensitive requests: a token from
+// POST /api/auth/2fa/verify, or a current code
+const (
+	StepUpTokenHeader = "X-Step-Up-Token"
+	StepUpCodeHeader  = "X-TOTP-Code"
+)
+
+var (
+	errTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	errInvalidTwoFactorCode = errors.New("invalid or expired two-factor code")
+)
+
+// twoFactorKey encrypts TOTP secrets and signs step-up tokens. InitAuth
+// derives it from the service key with HKDF.
+var twoFactorKey []byte
+
+// userTwoFactor is a row of user_two_factor. The secret is stored encrypted
+// and recovery codes only as HMACs.
+type userTwoFactor struct {
+	UserID             string     `json:"user_id"`
+	SecretCiphertext   string     `json:"secret_ciphertext"`
+	Enabled            bool       `json:"enabled"`
+	EnabledAt          *time.Time `json:"enabled_at"`
+	LastUsedStep       int64      `json:"last_used_step"`
+	RecoveryCodeHashes []string   `json:"recovery_code_hashes"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// generateTOTPSecret returns a random base32 secret
+func generateTOTPSecret() (string, error) {
+	raw := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw), nil
+}
+
+// totpCode computes the code for a time step (RFC 4226 dynamic truncation)
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// validateTOTP checks code against the steps around now and returns the
+// matching step. Steps at or before lastUsedStep are rejected so a code
+// cannot be replayed.
+func validateTOTP(secret, code string, now time.Time, lastUsedStep int64) (int64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpProvisioningURI returns the otpauth:// URI encoded in enrollment QR codes
+func totpProvisioningURI(secret, accountName string) string {
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {strconv.Itoa(totpDigits)},
+		"period":    {strconv.Itoa(totpPeriod)},
+	}
+	label := url.PathEscape(totpIssuer + ":" + accountName)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// generateRecoveryCodes returns single-use recovery codes and their hashes
+func generateRecoveryCodes(userID string) ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(raw))
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(userID, code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+func hashRecoveryCode(userID, code string) string {
+	mac := hmac.New(sha256.New, twoFactorKey)
+	mac.Write([]byte(userID + "|" + normalizeRecoveryCode(code)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encryptTwoFactorSecret seals a TOTP secret with AES-GCM
+func encryptTwoFactorSecret(secret string) (string, error) {
+	block, err := aes.NewCipher(twoFactorKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+// decryptTwoFactorSecret opens a secret sealed by encryptTwoFactorSecret
+func decryptTwoFactorSecret(ciphertext string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(twoFactorKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("two-factor secret is truncated")
+	}
+	secret, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// issueStepUpToken returns a token proving the user passed a second factor,
+// valid until the returned time
+func issueStepUpToken(userID string, now time.Time) (string, time.Time) {
+	expiresAt := now.Add(stepUpTTL)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(userID + "|" + strconv.FormatInt(expiresAt.Unix(), 10)))
+	return payload + "." + signStepUpPayload(payload), expiresAt
+}
+
+// verifyStepUpToken checks a step-up token was issued to userID and has not expired
+func verifyStepUpToken(token, userID string, now time.Time) bool {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(signStepUpPayload(payload))) {
+		return false
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return false
+	}
+	tokenUserID, expiry, found := strings.Cut(string(decoded), "|")
+	if !found || tokenUserID != userID {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	return err == nil && now.Unix() < expiresAt
+}
+
+func signStepUpPayload(payload string) string {
+	mac := hmac.New(sha256.New, twoFactorKey)
+	mac.Write([]byte("step-up|" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// getTwoFactor returns the user's two-factor settings, or nil if never enrolled
+func getTwoFactor(userID string) (*userTwoFactor, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var rows []userTwoFactor
+	err := supabaseClient.DB.From("user_two_factor").
+		Select("*").
+		Eq("user_id", userID).
+		Execute(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch two-factor settings: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return &rows[0], nil
+}
+
+// TwoFactorEnabled reports whether the user has confirmed TOTP enrollment
+func TwoFactorEnabled(userID string) (bool, error) {
+	settings, err := getTwoFactor(userID)
+	if err != nil {
+		return false, err
+	}
+	return settings != nil && settings.Enabled, nil
+}
+
+// verifySecondFactor accepts a current TOTP code or an unused recovery code.
+// Used codes are recorded so they cannot be accepted again. It returns
+// whether a recovery code was used.
+func verifySecondFactor(userID, code string, now time.Time) (bool, error) {
+	settings, err := getTwoFactor(userID)
+	if err != nil {
+		return false, err
+	}
+	if settings == nil || !settings.Enabled {
+		return false, errTwoFactorNotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		secret, err := decryptTwoFactorSecret(settings.SecretCiphertext)
+		if err != nil {
+			return false, fmt.Errorf("failed to decrypt two-factor secret: %v", err)
+		}
+		step, ok := validateTOTP(secret, code, now, settings.LastUsedStep)
+		if !ok {
+			return false, errInvalidTwoFactorCode
+		}
+
+		// Conditional on the previous step so concurrent requests cannot both use the code
+		var updated []userTwoFactor
+		err = supabaseClient.DB.From("user_two_factor").
+			Update(map[string]interface{}{
+				"last_used_step": step,
+				"updated_at":     now.UTC().Format(time.RFC3339),
+			}).
+			Eq("user_id", userID).
+			Lt("last_used_step", strconv.FormatInt(step, 10)).
+			Execute(&updated)
+		if err != nil {
+			return false, fmt.Errorf("failed to record two-factor code use: %v", err)
+		}
+		if len(updated) == 0 {
+			return false, errInvalidTwoFactorCode
+		}
+		return false, nil
+	}
+
+	hash := hashRecoveryCode(userID, code)
+	remaining := make([]string, 0, len(settings.RecoveryCodeHashes))
+	found := false
+	for _, stored := range settings.RecoveryCodeHashes {
+		if !found && hmac.Equal([]byte(stored), []byte(hash)) {
+			found = true
+			continue
+		}
+		remaining = append(remaining, stored)
+	}
+	if !found {
+		return false, errInvalidTwoFactorCode
+	}
+
+	var updated []userTwoFactor
+	err = supabaseClient.DB.From("user_two_factor").
+		Update(map[string]interface{}{
+			"recovery_code_hashes": remaining,
+			"updated_at":           now.UTC().Format(time.RFC3339),
+		}).
+		Eq("user_id", userID).
+		Execute(&updated)
+	if err != nil {
+		return false, fmt.Errorf("failed to record recovery code use: %v", err)
+	}
+	log.Printf("🔑 verifySecondFactor: User %s used a recovery code (%d left)", userID, len(remaining))
+	return true, nil
+}
+
+// organizationRequiresTwoFactor reports whether an organization enforces 2FA for its members
+func organizationRequiresTwoFactor(orgID string) (bool, error) {
+	if supabaseClient == nil {
+		return false, fmt.Errorf("supabase client not initialized")
+	}
+
+	var orgs []Organization
+	err := supabaseClient.DB.From("organizations").
+		Select("id,require_two_factor").
+		Eq("id", orgID).
+		Execute(&orgs)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch organization settings: %v", err)
+	}
+	return len(orgs) > 0 && orgs[0].RequireTwoFactor, nil
+}
+
+// RequireOrganizationTwoFactor rejects members without 2FA when the
+// organization enforces it. API key requests are not affected. On failure
+// the error response has been written.
+func RequireOrganizationTwoFactor(c *gin.Context, orgID, userID string) bool {
+	if GetAPIKey(c) != nil {
+		return true
+	}
+
+	required, err := organizationRequiresTwoFactor(orgID)
+	if err == nil && required {
+		var enabled bool
+		enabled, err = TwoFactorEnabled(userID)
+		if err == nil && !enabled {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":  "This organization requires two-factor authentication. Enable it to continue.",
+				"code":   "TWO_FACTOR_REQUIRED",
+				"enroll": "/api/auth/2fa/enroll",
+			})
+			return false
+		}
+	}
+	if err != nil {
+		log.Printf("❌ RequireOrganizationTwoFactor: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to verify two-factor requirements",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return false
+	}
+	return true
+}
+
+// RequireStepUp protects sensitive actions. Users with 2FA enabled must send
+// a step-up token (X-Step-Up-Token) or a current code (X-TOTP-Code); users
+// without 2FA and API key requests pass. Use after AuthMiddleware.
+func RequireStepUp() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := GetUserID(c)
+		if userID == "" || GetAPIKey(c) != nil {
+			c.Next()
+			return
+		}
+
+		enabled, err := TwoFactorEnabled(userID)
+		if err != nil {
+			log.Printf("❌ RequireStepUp: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Failed to verify two-factor status",
+				"code":  "AUTH_SERVICE_UNAVAILABLE",
+			})
+			c.Abort()
+			return
+		}
+		if !enabled {
+			c.Next()
+			return
+		}
+
+		now := time.Now()
+		if token := c.GetHeader(StepUpTokenHeader); token != "" && verifyStepUpToken(token, userID, now) {
+			c.Next()
+			return
+		}
+		if code := c.GetHeader(StepUpCodeHeader); code != "" {
+			if _, err := verifySecondFactor(userID, code, now); err == nil {
+				c.Next()
+				return
+			} else if err != errInvalidTwoFactorCode {
+				log.Printf("❌ RequireStepUp: %v", err)
+			}
+		}
+
+		log.Printf("🔐 RequireStepUp: Step-up verification required for user %s on %s", userID, c.Request.URL.Path)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "This action requires two-factor verification",
+			"code":    "STEP_UP_REQUIRED",
+			"verify":  "/api/auth/2fa/verify",
+			"headers": []string{StepUpTokenHeader, StepUpCodeHeader},
+		})
+		c.Abort()
+	}
+}
@@ -0,0 +1,411 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TwoFactorCodeRequest carries a TOTP code or a recovery code
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required,max=20"`
+}
+
+// TwoFactorStatusResponse describes the user's 2FA settings
+type TwoFactorStatusResponse struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+	RequiredByOrganization bool       `json:"required_by_organization"`
+}
+
+// TwoFactorEnrollmentResponse is returned when enrollment starts. The secret
+// is shown once; apps scan provisioning_uri as a QR code.
+type TwoFactorEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+	Issuer          string `json:"issuer"`
+	Digits          int    `json:"digits"`
+	Period          int    `json:"period"`
+}
+
+// StepUpResponse carries a step-up token for sensitive actions
+type StepUpResponse struct {
+	StepUpToken            string   `json:"step_up_token"`
+	ExpiresAt              string   `json:"expires_at"`
+	RecoveryCodes          []string `json:"recovery_codes,omitempty"` // only on confirmation and regeneration
+	RecoveryCodeUsed       bool     `json:"recovery_code_used,omitempty"`
+	RecoveryCodesRemaining int      `json:"recovery_codes_remaining"`
+}
+
+// GetTwoFactorStatusHandler handles GET /api/auth/2fa
+func GetTwoFactorStatusHandler(c *gin.Context) {
+	userID, settings, ok := loadTwoFactor(c)
+	if !ok {
+		return
+	}
+
+	requiredBy, err := organizationsRequiringTwoFactor(userID)
+	if err != nil {
+		log.Printf("⚠️ GetTwoFactorStatusHandler: %v", err)
+	}
+
+	response := TwoFactorStatusResponse{RequiredByOrganization: len(requiredBy) > 0}
+	if settings != nil && settings.Enabled {
+		response.Enabled = true
+		response.EnabledAt = settings.EnabledAt
+		response.RecoveryCodesRemaining = len(settings.RecoveryCodeHashes)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// EnrollTwoFactorHandler handles POST /api/auth/2fa/enroll. It creates a new
+// secret that becomes active once confirmed with a code from the app.
+func EnrollTwoFactorHandler(c *gin.Context) {
+	userID, settings, ok := loadTwoFactor(c)
+	if !ok {
+		return
+	}
+	if settings != nil && settings.Enabled {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Two-factor authentication is already enabled",
+			"code":  "TWO_FACTOR_ALREADY_ENABLED",
+		})
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err == nil {
+		var ciphertext string
+		ciphertext, err = encryptTwoFactorSecret(secret)
+		if err == nil {
+			var results []userTwoFactor
+			err = supabaseClient.DB.From("user_two_factor").
+				Upsert(map[string]interface{}{
+					"user_id":              userID,
+					"secret_ciphertext":    ciphertext,
+					"enabled":              false,
+					"last_used_step":       0,
+					"recovery_code_hashes": []string{},
+					"updated_at":           time.Now().UTC().Format(time.RFC3339),
+				}).
+				Execute(&results)
+		}
+	}
+	if err != nil {
+		log.Printf("❌ EnrollTwoFactorHandler: Failed to start enrollment for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start two-factor enrollment",
+			"code":  "TWO_FACTOR_ENROLL_ERROR",
+		})
+		return
+	}
+
+	account := GetUserEmail(c)
+	if account == "" {
+		account = userID
+	}
+	OmitIdempotentResponse(c)
+	c.JSON(http.StatusOK, TwoFactorEnrollmentResponse{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(secret, account),
+		Issuer:          totpIssuer,
+		Digits:          totpDigits,
+		Period:          totpPeriod,
+	})
+}
+
+// ConfirmTwoFactorHandler handles POST /api/auth/2fa/confirm. A valid code
+// enables 2FA and returns the recovery codes, shown only once.
+func ConfirmTwoFactorHandler(c *gin.Context) {
+	userID, settings, ok := loadTwoFactor(c)
+	if !ok {
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "A verification code is required",
+			"code":  "INVALID_REQUEST",
+		})
+		return
+	}
+	if settings == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Start enrollment before confirming",
+			"code":  "TWO_FACTOR_NOT_ENROLLED",
+		})
+		return
+	}
+	if settings.Enabled {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Two-factor authentication is already enabled",
+			"code":  "TWO_FACTOR_ALREADY_ENABLED",
+		})
+		return
+	}
+
+	secret, err := decryptTwoFactorSecret(settings.SecretCiphertext)
+	if err != nil {
+		log.Printf("❌ ConfirmTwoFactorHandler: Failed to decrypt secret for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to confirm two-factor authentication",
+			"code":  "TWO_FACTOR_ENROLL_ERROR",
+		})
+		return
+	}
+	now := time.Now()
+	step, valid := validateTOTP(secret, req.Code, now, settings.LastUsedStep)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": errInvalidTwoFactorCode.Error(),
+			"code":  "INVALID_TWO_FACTOR_CODE",
+		})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes(userID)
+	if err == nil {
+		var results []userTwoFactor
+		err = supabaseClient.DB.From("user_two_factor").
+			Update(map[string]interface{}{
+				"enabled":              true,
+				"enabled_at":           now.UTC().Format(time.RFC3339),
+				"last_used_step":       step,
+				"recovery_code_hashes": hashes,
+				"updated_at":           now.UTC().Format(time.RFC3339),
+			}).
+			Eq("user_id", userID).
+			Execute(&results)
+	}
+	if err != nil {
+		log.Printf("❌ ConfirmTwoFactorHandler: Failed to enable 2FA for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to confirm two-factor authentication",
+			"code":  "TWO_FACTOR_ENROLL_ERROR",
+		})
+		return
+	}
+
+	log.Printf("🔐 ConfirmTwoFactorHandler: Two-factor authentication enabled for user %s", userID)
+	token, expiresAt := issueStepUpToken(userID, now)
+	OmitIdempotentResponse(c)
+	c.JSON(http.StatusOK, StepUpResponse{
+		StepUpToken:            token,
+		ExpiresAt:              expiresAt.UTC().Format(time.RFC3339),
+		RecoveryCodes:          codes,
+		RecoveryCodesRemaining: len(codes),
+	})
+}
+
+// VerifyTwoFactorHandler handles POST /api/auth/2fa/verify. A valid TOTP or
+// recovery code returns a step-up token for sensitive actions.
+func VerifyTwoFactorHandler(c *gin.Context) {
+	userID, settings, ok := loadTwoFactor(c)
+	if !ok {
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "A verification code is required",
+			"code":  "INVALID_REQUEST",
+		})
+		return
+	}
+
+	now := time.Now()
+	usedRecovery, err := verifySecondFactor(userID, req.Code, now)
+	switch err {
+	case nil:
+	case errTwoFactorNotEnabled:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+			"code":  "TWO_FACTOR_NOT_ENABLED",
+		})
+		return
+	case errInvalidTwoFactorCode:
+		log.Printf("🚫 VerifyTwoFactorHandler: Invalid code for user %s", userID)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+			"code":  "INVALID_TWO_FACTOR_CODE",
+		})
+		return
+	default:
+		log.Printf("❌ VerifyTwoFactorHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to verify code",
+			"code":  "TWO_FACTOR_VERIFY_ERROR",
+		})
+		return
+	}
+
+	remaining := len(settings.RecoveryCodeHashes)
+	if usedRecovery {
+		remaining--
+	}
+	token, expiresAt := issueStepUpToken(userID, now)
+	OmitIdempotentResponse(c)
+	c.JSON(http.StatusOK, StepUpResponse{
+		StepUpToken:            token,
+		ExpiresAt:              expiresAt.UTC().Format(time.RFC3339),
+		RecoveryCodeUsed:       usedRecovery,
+		RecoveryCodesRemaining: remaining,
+	})
+}
+
+// RegenerateRecoveryCodesHandler handles POST /api/auth/2fa/recovery-codes.
+// Previous codes stop working. Requires step-up verification.
+func RegenerateRecoveryCodesHandler(c *gin.Context) {
+	userID, settings, ok := loadTwoFactor(c)
+	if !ok {
+		return
+	}
+	if settings == nil || !settings.Enabled {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": errTwoFactorNotEnabled.Error(),
+			"code":  "TWO_FACTOR_NOT_ENABLED",
+		})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes(userID)
+	if err == nil {
+		var results []userTwoFactor
+		err = supabaseClient.DB.From("user_two_factor").
+			Update(map[string]interface{}{
+				"recovery_code_hashes": hashes,
+				"updated_at":           time.Now().UTC().Format(time.RFC3339),
+			}).
+			Eq("user_id", userID).
+			Execute(&results)
+	}
+	if err != nil {
+		log.Printf("❌ RegenerateRecoveryCodesHandler: Failed for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to regenerate recovery codes",
+			"code":  "TWO_FACTOR_UPDATE_ERROR",
+		})
+		return
+	}
+
+	OmitIdempotentResponse(c)
+	c.JSON(http.StatusOK, gin.H{
+		"recovery_codes":           codes,
+		"recovery_codes_remaining": len(codes),
+	})
+}
+
+// DisableTwoFactorHandler handles DELETE /api/auth/2fa. Requires step-up
+// verification and is refused while an organization enforces 2FA.
+func DisableTwoFactorHandler(c *gin.Context) {
+	userID, settings, ok := loadTwoFactor(c)
+	if !ok {
+		return
+	}
+	if settings == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	requiredBy, err := organizationsRequiringTwoFactor(userID)
+	if err != nil {
+		log.Printf("❌ DisableTwoFactorHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to verify two-factor requirements",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+	if settings.Enabled && len(requiredBy) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":         "An organization you belong to requires two-factor authentication",
+			"code":          "TWO_FACTOR_REQUIRED",
+			"organizations": requiredBy,
+		})
+		return
+	}
+
+	var results []userTwoFactor
+	err = supabaseClient.DB.From("user_two_factor").
+		Delete().
+		Eq("user_id", userID).
+		Execute(&results)
+	if err != nil {
+		log.Printf("❌ DisableTwoFactorHandler: Failed for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to disable two-factor authentication",
+			"code":  "TWO_FACTOR_UPDATE_ERROR",
+		})
+		return
+	}
+
+	log.Printf("🔓 DisableTwoFactorHandler: Two-factor authentication disabled for user %s", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// loadTwoFactor authenticates the request and loads the user's 2FA settings
+// (nil if never enrolled). On failure the error response has been written.
+func loadTwoFactor(c *gin.Context) (string, *userTwoFactor, bool) {
+	userID := GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return "", nil, false
+	}
+	if !requireAuthDatabase(c) {
+		return "", nil, false
+	}
+
+	settings, err := getTwoFactor(userID)
+	if err != nil {
+		log.Printf("❌ loadTwoFactor: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load two-factor settings",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return "", nil, false
+	}
+	return userID, settings, true
+}
+
+// organizationsRequiringTwoFactor returns the IDs of the user's organizations that enforce 2FA
+func organizationsRequiringTwoFactor(userID string) ([]string, error) {
+	var members []OrganizationMember
+	err := supabaseClient.DB.From("organization_members").
+		Select("*").
+		Eq("user_id", userID).
+		Execute(&members)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.OrgID)
+	}
+	var orgs []Organization
+	err = supabaseClient.DB.From("organizations").
+		Select("id,require_two_factor").
+		In("id", ids).
+		Eq("require_two_factor", "true").
+		Execute(&orgs)
+	if err != nil {
+		return nil, err
+	}
+
+	required := make([]string, 0, len(orgs))
+	for _, org := range orgs {
+		required = append(required, org.ID)
+	}
+	return required, nil
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	// RFC 6238 appendix B test secret, truncated to 6 digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+	}
+	for _, tt := range tests {
+		step, ok := validateTOTP(secret, tt.code, time.Unix(tt.unix, 0), 0)
+		if !ok {
+			t.Errorf("validateTOTP at %d rejected %s", tt.unix, tt.code)
+			continue
+		}
+		if _, ok := validateTOTP(secret, tt.code, time.Unix(tt.unix, 0), step); ok {
+			t.Errorf("validateTOTP at %d accepted a replayed code", tt.unix)
+		}
+	}
+
+	// One period of clock skew is tolerated, two are not
+	if _, ok := validateTOTP(secret, "287082", time.Unix(59+totpPeriod, 0), 0); !ok {
+		t.Error("expected a code from the previous period to be accepted")
+	}
+	if _, ok := validateTOTP(secret, "287082", time.Unix(59+2*totpPeriod, 0), 0); ok {
+		t.Error("expected a code from two periods ago to be rejected")
+	}
+}
+
+func TestTwoFactorSecrets(t *testing.T) {
+	twoFactorKey, _ = deriveKey("service-key", "two-factor")
+	defer func() { twoFactorKey = nil }()
+
+	ciphertext, err := encryptTwoFactorSecret("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(ciphertext, "JBSWY3DPEHPK3PXP") {
+		t.Error("secret stored in plain text")
+	}
+	if secret, err := decryptTwoFactorSecret(ciphertext); err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("decryptTwoFactorSecret = %q, %v", secret, err)
+	}
+
+	codes, hashes, err := generateRecoveryCodes("user-1")
+	if err != nil || len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("generateRecoveryCodes = %d codes, %d hashes, %v", len(codes), len(hashes), err)
+	}
+	if hashRecoveryCode("user-1", strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))) != hashes[0] {
+		t.Error("recovery codes should match regardless of case and separators")
+	}
+	if hashRecoveryCode("user-2", codes[0]) == hashes[0] {
+		t.Error("recovery code hashes should be bound to the user")
+	}
+}
+
+func TestStepUpToken(t *testing.T) {
+	twoFactorKey = []byte("test-key")
+	defer func() { twoFactorKey = nil }()
+
+	now := time.Now()
+	token, expiresAt := issueStepUpToken("user-1", now)
+	if !expiresAt.After(now) {
+		t.Fatal("token should expire in the future")
+	}
+	if !verifyStepUpToken(token, "user-1", now) {
+		t.Error("expected token to be valid")
+	}
+	if verifyStepUpToken(token, "user-2", now) {
+		t.Error("token accepted for another user")
+	}
+	if verifyStepUpToken(token, "user-1", expiresAt.Add(time.Second)) {
+		t.Error("expired token accepted")
+	}
+	if verifyStepUpToken(token+"x", "user-1", now) {
+		t.Error("tampered token accepted")
+	}
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"strings"
+)
+
+// Device types recorded on sessions
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	DeviceOther   = "other" // API clients and command line tools
+)
+
+// UserAgentInfo is what sessions record about the client
+type UserAgentInfo struct {
+	DeviceType string `json:"device_type"`
+	Browser    string `json:"browser"`
+	OS         string `json:"os"`
+}
+
+// browserTokens are checked in order because most browsers also claim to be
+// Chrome, Safari or Mozilla. Tokens are followed by "/" and the version.
+var browserTokens = []struct {
+	token string
+	name  string
+}{
+	{"Edg", "Edge"},
+	{"EdgA", "Edge"},
+	{"EdgiOS", "Edge"},
+	{"OPR", "Opera"},
+	{"SamsungBrowser", "Samsung Internet"},
+	{"FxiOS", "Firefox"},
+	{"Firefox", "Firefox"},
+	{"CriOS", "Chrome"},
+	{"Chrome", "Chrome"},
+	{"Version", "Safari"}, // Safari reports its version as Version/x
+	{"curl", "curl"},
+	{"PostmanRuntime", "Postman"},
+	{"python-requests", "Python Requests"},
+	{"Go-http-client", "Go HTTP Client"},
+	{"okhttp", "OkHttp"},
+}
+
+// osTokens are checked in order; iOS user agents also contain "Mac OS X"
+var osTokens = []struct {
+	token string
+	name  string
+}{
+	{"Windows NT", "Windows"},
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"CrOS", "ChromeOS"},
+	{"Android", "Android"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"Linux", "Linux"},
+}
+
+var botTokens = []string{"bot", "spider", "crawl", "slurp"}
+
+var clientTokens = []string{"curl/", "PostmanRuntime/", "python-requests/", "Go-http-client/", "okhttp/"}
+
+// parseUserAgent extracts the device type, browser (with major version) and
+// operating system from a User-Agent header
+func parseUserAgent(ua string) UserAgentInfo {
+	info := UserAgentInfo{DeviceType: DeviceDesktop, Browser: "Other", OS: "Other"}
+	if ua == "" {
+		info.DeviceType = DeviceOther
+		return info
+	}
+
+	for _, b := range browserTokens {
+		if version, ok := tokenVersion(ua, b.token); ok {
+			info.Browser = b.name
+			if version != "" {
+				info.Browser += " " + version
+			}
+			break
+		}
+	}
+
+	for _, o := range osTokens {
+		if strings.Contains(ua, o.token) {
+			info.OS = o.name
+			break
+		}
+	}
+
+	lower := strings.ToLower(ua)
+	switch {
+	case containsAny(lower, botTokens):
+		info.DeviceType = DeviceBot
+	case containsAny(ua, clientTokens):
+		info.DeviceType = DeviceOther
+	case strings.Contains(ua, "iPad") || strings.Contains(lower, "tablet") ||
+		(strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile")):
+		info.DeviceType = DeviceTablet
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "Android"):
+		info.DeviceType = DeviceMobile
+	}
+	return info
+}
+
+// tokenVersion finds "token/version" in ua and returns the major version
+func tokenVersion(ua, token string) (string, bool) {
+	index := strings.Index(ua, token+"/")
+	for index > 0 && !isTokenBoundary(ua[index-1]) {
+		next := strings.Index(ua[index+1:], token+"/")
+		if next < 0 {
+			return "", false
+		}
+		index += next + 1
+	}
+	if index < 0 {
+		return "", false
+	}
+
+	version := ua[index+len(token)+1:]
+	if end := strings.IndexAny(version, " ;)"); end >= 0 {
+		version = version[:end]
+	}
+	major, _, _ := strings.Cut(version, ".")
+	return major, true
+}
+
+func isTokenBoundary(b byte) bool {
+	return b == ' ' || b == '(' || b == ';' || b == ')'
+}
+
+func containsAny(s string, tokens []string) bool {
+	for _, token := range tokens {
+		if strings.Contains(s, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package auth
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want UserAgentInfo
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			UserAgentInfo{DeviceDesktop, "Chrome 120", "Windows"},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			UserAgentInfo{DeviceDesktop, "Edge 120", "Windows"},
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_2) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+			UserAgentInfo{DeviceDesktop, "Safari 17", "macOS"},
+		},
+		{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+			UserAgentInfo{DeviceMobile, "Safari 17", "iOS"},
+		},
+		{
+			"Mozilla/5.0 (iPad; CPU OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1",
+			UserAgentInfo{DeviceTablet, "Chrome 120", "iPadOS"},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+			UserAgentInfo{DeviceMobile, "Chrome 120", "Android"},
+		},
+		{
+			"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			UserAgentInfo{DeviceDesktop, "Firefox 121", "Linux"},
+		},
+		{
+			"curl/8.4.0",
+			UserAgentInfo{DeviceOther, "curl 8", "Other"},
+		},
+		{
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			UserAgentInfo{DeviceBot, "Other", "Other"},
+		},
+		{
+			"",
+			UserAgentInfo{DeviceOther, "Other", "Other"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := parseUserAgent(tt.ua); got != tt.want {
+			t.Errorf("parseUserAgent(%q) = %+v, want %+v", tt.ua, got, tt.want)
+		}
+	}
+}
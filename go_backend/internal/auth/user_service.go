@@ -3,6 +3,7 @@ package auth
 import (
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -35,7 +36,8 @@ func initializeUserCreditsAndSubscription(userID, email string) error {
 	freePlan := plans[0]
 	log.Printf("✅ initializeUserCreditsAndSubscription: Found Free plan (ID: %d)", freePlan.ID)
 
-	// 2. Create subscription if it doesn't exist
+	// 2. Create subscription if it doesn't exist. The subscription cycle sets
+	// its billing and credit reset dates from the start date.
 	subscriptionData := map[string]interface{}{
 		"user_id":           userID,
 		"plan_id":           freePlan.ID,
@@ -129,16 +131,21 @@ type UserProfile struct {
 	UpdatedAt           time.Time `json:"updated_at" db:"updated_at"`
 	LastLoginAt         *time.Time `json:"last_login_at" db:"last_login_at"`
 	Status              string    `json:"status" db:"status"`
+	SuspendedAt         *time.Time `json:"suspended_at" db:"suspended_at"`
+	SuspensionReason    *string   `json:"suspension_reason" db:"suspension_reason"`
+	IsPlatformAdmin     bool      `json:"is_platform_admin" db:"is_platform_admin"`
 }
 
 // UserCredits represents user credit balance
 type UserCredits struct {
 	ID                 string    `json:"id" db:"id"`
 	UserID             string    `json:"user_id" db:"user_id"`
+	OrgID              *string   `json:"org_id,omitempty" db:"org_id"` // set on organization wallets
 	MonthlyAllocation  int       `json:"monthly_allocation" db:"monthly_allocation"`
 	SubscriptionCredits int      `json:"subscription_credits" db:"subscription_credits"`
 	RechargedCredits   int       `json:"recharged_credits" db:"recharged_credits"`
 	BonusCredits       int       `json:"bonus_credits" db:"bonus_credits"`
+	OverageCredits     int       `json:"overage_credits" db:"overage_credits"` // billed as overage this credit period
 	UsedCredits        int       `json:"used_credits" db:"used_credits"`
 	TotalCredits       int       `json:"total_credits" db:"total_credits"`
 	AvailableCredits   int       `json:"available_credits" db:"available_credits"`
@@ -159,6 +166,8 @@ type SubscriptionPlan struct {
 	YearlyPrice          *float64 `json:"yearly_price" db:"yearly_price"`
 	OveragePricePerCredit float64 `json:"overage_price_per_credit" db:"overage_price_per_credit"`
 	Features             []string `json:"features" db:"features"`
+	RequestsPerMinute    *int     `json:"requests_per_minute" db:"requests_per_minute"`
+	AssessmentDiscountPercent int `json:"assessment_discount_percent" db:"assessment_discount_percent"`
 	IsActive             bool    `json:"is_active" db:"is_active"`
 	CreatedAt            time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt            time.Time `json:"updated_at" db:"updated_at"`
@@ -177,6 +186,11 @@ type UserSubscription struct {
 	AutoRenew          bool      `json:"auto_renew" db:"auto_renew"`
 	CancellationReason *string   `json:"cancellation_reason" db:"cancellation_reason"`
 	CancelledAt        *time.Time `json:"cancelled_at" db:"cancelled_at"`
+	ScheduledPlanID       *int       `json:"scheduled_plan_id" db:"scheduled_plan_id"` // downgrade taking effect at ScheduledChangeAt
+	ScheduledBillingCycle *string    `json:"scheduled_billing_cycle" db:"scheduled_billing_cycle"`
+	ScheduledChangeAt     *time.Time `json:"scheduled_change_at" db:"scheduled_change_at"`
+	OverageEnabled        bool       `json:"overage_enabled" db:"overage_enabled"`
+	OverageCapCents       *int       `json:"overage_cap_cents" db:"overage_cap_cents"` // spending cap per credit period
 	CreatedAt          time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt          time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -206,6 +220,12 @@ func GetUserProfile(userID string) (*UserProfile, error) {
 
 // GetUserCredits fetches user credit balance
 func GetUserCredits(userID string) (*UserCredits, error) {
+	return getWalletCredits("user_id", userID)
+}
+
+// getWalletCredits fetches the credit wallet of a user (column user_id) or an
+// organization (column org_id)
+func getWalletCredits(column, ownerID string) (*UserCredits, error) {
 	if supabaseClient == nil {
 		return nil, fmt.Errorf("supabase client not initialized")
 	}
@@ -213,7 +233,7 @@ func GetUserCredits(userID string) (*UserCredits, error) {
 	var creditsList []UserCredits
 	err := supabaseClient.DB.From("user_credits").
 		Select("*").
-		Eq("user_id", userID).
+		Eq(column, ownerID).
 		Execute(&creditsList)
 
 	if err != nil {
@@ -227,7 +247,8 @@ func GetUserCredits(userID string) (*UserCredits, error) {
 	return &creditsList[0], nil
 }
 
-// GetUserSubscription fetches user's active subscription
+// GetUserSubscription fetches user's active subscription. A cancelled
+// subscription counts until the end of its paid period.
 func GetUserSubscription(userID string) (*UserSubscription, *SubscriptionPlan, error) {
 	if supabaseClient == nil {
 		return nil, nil, fmt.Errorf("supabase client not initialized")
@@ -238,7 +259,7 @@ func GetUserSubscription(userID string) (*UserSubscription, *SubscriptionPlan, e
 	err := supabaseClient.DB.From("user_subscriptions").
 		Select("*").
 		Eq("user_id", userID).
-		Eq("status", "active").
+		In("status", []string{"active", "cancelled"}).
 		Execute(&subscriptions)
 
 	if err != nil {
@@ -246,10 +267,16 @@ func GetUserSubscription(userID string) (*UserSubscription, *SubscriptionPlan, e
 	}
 
 	if len(subscriptions) == 0 {
-		return nil, nil, fmt.Errorf("no active subscription found")
+		return nil, nil, ErrSubscriptionNotFound
 	}
 
 	subscription := &subscriptions[0]
+	for i := range subscriptions {
+		if subscriptions[i].Status == "active" {
+			subscription = &subscriptions[i]
+			break
+		}
+	}
 
 	// Get plan details
 	var plans []SubscriptionPlan
@@ -269,74 +296,77 @@ func GetUserSubscription(userID string) (*UserSubscription, *SubscriptionPlan, e
 	return subscription, &plans[0], nil
 }
 
-// ConsumeCredits deducts credits from user balance
-func ConsumeCredits(userID string, creditsToConsume int, assessmentID *int64, description string) error {
-	if supabaseClient == nil {
-		return fmt.Errorf("supabase client not initialized")
-	}
+// Plan features referenced by the API
+const (
+	FeatureBasicReports       = "basic_reports"
+	FeatureAPIAccess          = "api_access"
+	FeaturePrioritySupport    = "priority_support"
+	FeatureCustomIntegrations = "custom_integrations"
+	FeatureDedicatedSupport   = "dedicated_support"
+	FeatureCustomDeployment   = "custom_deployment"
+	FeatureAllFeatures        = "all_features" // Enterprise plans include every feature
+)
 
-	if creditsToConsume == 0 {
-		return nil // No credits to consume
+// HasFeature reports whether the plan includes a feature
+func (p *SubscriptionPlan) HasFeature(feature string) bool {
+	for _, f := range p.Features {
+		if f == feature || f == FeatureAllFeatures {
+			return true
+		}
 	}
+	return false
+}
 
-	log.Printf("💳 ConsumeCredits: Attempting to consume %d credits for user %s", creditsToConsume, userID)
-
-	// Get current credits
-	credits, err := GetUserCredits(userID)
+// UserHasFeature reports whether the user's active plan includes a feature
+func UserHasFeature(userID, feature string) (bool, error) {
+	_, plan, err := GetUserSubscription(userID)
 	if err != nil {
-		return fmt.Errorf("failed to get user credits: %v", err)
-	}
-
-	// Check if sufficient credits
-	if credits.AvailableCredits < creditsToConsume {
-		return fmt.Errorf("insufficient credits")
+		return false, err
 	}
+	return plan.HasFeature(feature), nil
+}
 
-	// Calculate new values
-	newUsedCredits := credits.UsedCredits + creditsToConsume
-	newAvailableCredits := credits.AvailableCredits - creditsToConsume
+// ConsumeCredits deducts credits from user balance
+func ConsumeCredits(userID string, creditsToConsume int, assessmentID *int64, description string) error {
+	return consumeWalletCredits("user_id", userID, userID, creditsToConsume, "assessment_usage", assessmentID, description)
+}
 
-	// Update credits
-	updateData := map[string]interface{}{
-		"used_credits": newUsedCredits,
-		"updated_at":   time.Now().Format(time.RFC3339),
+// consumeWalletCredits deducts credits from the wallet owned by ownerID and
+// records the transaction against the acting user. The ledger checks and
+// updates the balance atomically.
+func consumeWalletCredits(column, ownerID, userID string, creditsToConsume int, transactionType string, assessmentID *int64, description string) error {
+	if creditsToConsume == 0 {
+		return nil // No credits to consume
 	}
 
-	var updateResults []map[string]interface{}
-	err = supabaseClient.DB.From("user_credits").
-		Update(updateData).
-		Eq("user_id", userID).
-		Execute(&updateResults)
+	log.Printf("💳 ConsumeCredits: Attempting to consume %d credits from %s %s", creditsToConsume, column, ownerID)
 
+	orgID := ""
+	if column == "org_id" {
+		orgID = ownerID
+	}
+	transaction, err := consumeCreditsNow(userID, orgID, creditsToConsume, transactionType, assessmentID, description)
 	if err != nil {
-		return fmt.Errorf("failed to update user credits: %v", err)
+		return err
 	}
 
-	// Log the transaction
-	transactionData := map[string]interface{}{
-		"user_id":         userID,
-		"transaction_type": "assessment_usage",
-		"credit_change":    -creditsToConsume,
-		"balance_before":   credits.AvailableCredits,
-		"balance_after":    newAvailableCredits,
-		"description":      description,
+	event := AuditEvent{
+		UserID:          userID,
+		OrgID:           orgID,
+		Action:          AuditCreditsConsumed,
+		CreditsConsumed: creditsToConsume,
+		Metadata: map[string]interface{}{
+			"transaction_type": transactionType,
+			"balance_after":    transaction.BalanceAfter,
+		},
 	}
-
 	if assessmentID != nil {
-		transactionData["assessment_id"] = *assessmentID
-	}
-
-	var transactionResults []map[string]interface{}
-	err = supabaseClient.DB.From("credit_transactions").
-		Insert(transactionData).
-		Execute(&transactionResults)
-
-	if err != nil {
-		log.Printf("⚠️ ConsumeCredits: Failed to log transaction: %v", err)
-		// Don't fail the whole operation for logging issues
+		event.ResourceType = "assessment"
+		event.ResourceID = strconv.FormatInt(*assessmentID, 10)
 	}
+	RecordAuditEvent(event)
 
-	log.Printf("✅ ConsumeCredits: Successfully consumed %d credits. New balance: %d", creditsToConsume, newAvailableCredits)
+	log.Printf("✅ ConsumeCredits: Successfully consumed %d credits. New balance: %d", creditsToConsume, transaction.BalanceAfter)
 	return nil
 }
 
@@ -0,0 +1,256 @@
+package billing
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxAlertRules bounds the alert rules of a wallet
+const maxAlertRules = 10
+
+// ListAlertRulesHandler handles GET /api/billing/alerts, the low-balance
+// alert rules of the workspace's wallet
+func ListAlertRulesHandler(c *gin.Context) {
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	if !requireBillingDatabase(c) {
+		return
+	}
+
+	rules, err := listAlertRules(workspace)
+	if err != nil {
+		log.Printf("❌ ListAlertRulesHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch alert rules",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+	if rules == nil {
+		rules = []AlertRule{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// CreateAlertRuleHandler handles POST /api/billing/alerts
+func CreateAlertRuleHandler(c *gin.Context) {
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	if !requireBillingDatabase(c) {
+		return
+	}
+
+	record, ok := bindAlertRuleRequest(c)
+	if !ok {
+		return
+	}
+
+	rules, err := listAlertRules(workspace)
+	if err != nil {
+		log.Printf("❌ CreateAlertRuleHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch alert rules",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+	if len(rules) >= maxAlertRules {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Alert rule limit reached",
+			"code":  "ALERT_RULE_LIMIT_REACHED",
+			"limit": maxAlertRules,
+		})
+		return
+	}
+
+	record["id"] = uuid.NewString()
+	record["user_id"] = workspace.UserID
+	record["org_id"] = workspace.OrgIDPtr()
+
+	var results []AlertRule
+	if err := supabaseClient.DB.From("credit_alert_rules").Insert(record).Execute(&results); err != nil || len(results) == 0 {
+		log.Printf("❌ CreateAlertRuleHandler: Failed to insert rule for user %s: %v", workspace.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create alert rule",
+			"code":  "ALERT_RULE_CREATE_ERROR",
+		})
+		return
+	}
+
+	auth.SetAuditResource(c, results[0].ID)
+
+	// Alert right away when the balance is already below the threshold
+	go CheckCreditAlerts(workspace)
+	c.JSON(http.StatusCreated, results[0])
+}
+
+// UpdateAlertRuleHandler handles PUT /api/billing/alerts/:id, replacing the
+// rule. A changed rule may trigger again in the current credit period.
+func UpdateAlertRuleHandler(c *gin.Context) {
+	workspace, rule, ok := alertRuleForRequest(c)
+	if !ok {
+		return
+	}
+
+	record, ok := bindAlertRuleRequest(c)
+	if !ok {
+		return
+	}
+	record["last_triggered_at"] = nil
+	record["updated_at"] = time.Now().UTC().Format(time.RFC3339)
+
+	var results []AlertRule
+	err := supabaseClient.DB.From("credit_alert_rules").
+		Update(record).
+		Eq("id", rule.ID).
+		Execute(&results)
+	if err != nil || len(results) == 0 {
+		log.Printf("❌ UpdateAlertRuleHandler: Failed to update rule %s: %v", rule.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update alert rule",
+			"code":  "ALERT_RULE_UPDATE_ERROR",
+		})
+		return
+	}
+
+	go CheckCreditAlerts(workspace)
+	c.JSON(http.StatusOK, results[0])
+}
+
+// DeleteAlertRuleHandler handles DELETE /api/billing/alerts/:id
+func DeleteAlertRuleHandler(c *gin.Context) {
+	_, rule, ok := alertRuleForRequest(c)
+	if !ok {
+		return
+	}
+
+	var results []map[string]interface{}
+	if err := supabaseClient.DB.From("credit_alert_rules").Delete().Eq("id", rule.ID).Execute(&results); err != nil {
+		log.Printf("❌ DeleteAlertRuleHandler: Failed to delete rule %s: %v", rule.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete alert rule",
+			"code":  "ALERT_RULE_DELETE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Alert rule deleted",
+		"id":      rule.ID,
+	})
+}
+
+// bindAlertRuleRequest validates an alert rule request and returns the
+// database record. It writes the 400 response and returns false on failure.
+func bindAlertRuleRequest(c *gin.Context) (map[string]interface{}, bool) {
+	var req AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"code":    "INVALID_REQUEST",
+			"details": err.Error(),
+		})
+		return nil, false
+	}
+
+	if req.ThresholdType == ThresholdPercent && *req.Threshold > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "A percent threshold must be between 0 and 100",
+			"code":  "INVALID_THRESHOLD",
+		})
+		return nil, false
+	}
+
+	if req.AutoRechargePackageID != nil {
+		pkg, err := getPackage(*req.AutoRechargePackageID)
+		if err != nil {
+			log.Printf("❌ bindAlertRuleRequest: Failed to fetch package %d: %v", *req.AutoRechargePackageID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to fetch credit package",
+				"code":  "DATABASE_FETCH_ERROR",
+			})
+			return nil, false
+		}
+		if pkg == nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Credit package not found",
+				"code":  "PACKAGE_NOT_FOUND",
+			})
+			return nil, false
+		}
+	}
+
+	boolOr := func(value *bool, fallback bool) bool {
+		if value == nil {
+			return fallback
+		}
+		return *value
+	}
+	var email *string
+	if req.Email != nil && *req.Email != "" {
+		email = req.Email
+	}
+
+	return map[string]interface{}{
+		"threshold_type":           req.ThresholdType,
+		"threshold":                *req.Threshold,
+		"notify_email":             boolOr(req.NotifyEmail, true),
+		"email":                    email,
+		"notify_webhook":           boolOr(req.NotifyWebhook, true),
+		"auto_recharge_package_id": req.AutoRechargePackageID,
+		"is_active":                boolOr(req.IsActive, true),
+	}, true
+}
+
+// alertRuleForRequest loads the alert rule named by the :id parameter. It
+// writes the error response when the rule is not in the caller's workspace.
+func alertRuleForRequest(c *gin.Context) (auth.Workspace, *AlertRule, bool) {
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return workspace, nil, false
+	}
+
+	if !requireBillingDatabase(c) {
+		return workspace, nil, false
+	}
+
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid alert rule ID",
+			"code":  "INVALID_ALERT_RULE_ID",
+		})
+		return workspace, nil, false
+	}
+
+	var rules []AlertRule
+	if err := supabaseClient.DB.From("credit_alert_rules").Select("*").Eq("id", id).Execute(&rules); err != nil {
+		log.Printf("❌ alertRuleForRequest: Failed to fetch rule %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch alert rule",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return workspace, nil, false
+	}
+	if len(rules) == 0 || !workspace.Contains(rules[0].UserID, rules[0].OrgID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Alert rule not found",
+			"code":  "ALERT_RULE_NOT_FOUND",
+		})
+		return workspace, nil, false
+	}
+
+	return workspace, &rules[0], true
+}
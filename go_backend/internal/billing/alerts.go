@@ -0,0 +1,349 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/notifications"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+// Alert threshold types
+const (
+	ThresholdCredits = "credits"
+	ThresholdPercent = "percent" // of the wallet's monthly allocation
+)
+
+// Headers set on API responses while the workspace's balance is low
+const (
+	CreditsRemainingHeader = "X-Credits-Remaining"
+	CreditsWarningHeader   = "X-Credits-Warning"
+)
+
+// defaultLowBalancePercent of the monthly allocation marks a balance low for
+// the warning header when the wallet has no alert rules
+const defaultLowBalancePercent = 10
+
+// balanceCacheTTL is how long the warning header reuses a wallet's balance
+const balanceCacheTTL = time.Minute
+
+const alertTimeout = 30 * time.Second
+
+var errNoSavedPaymentMethod = errors.New("no saved payment method; buy a credit package at checkout first")
+
+// AlertRule is a row of credit_alert_rules
+type AlertRule struct {
+	ID                    string     `json:"id" db:"id"`
+	UserID                string     `json:"user_id" db:"user_id"`
+	OrgID                 *string    `json:"org_id" db:"org_id"`
+	ThresholdType         string     `json:"threshold_type" db:"threshold_type"`
+	Threshold             int        `json:"threshold" db:"threshold"`
+	NotifyEmail           bool       `json:"notify_email" db:"notify_email"`
+	Email                 *string    `json:"email" db:"email"` // the rule's user when nil
+	NotifyWebhook         bool       `json:"notify_webhook" db:"notify_webhook"`
+	AutoRechargePackageID *int       `json:"auto_recharge_package_id" db:"auto_recharge_package_id"`
+	IsActive              bool       `json:"is_active" db:"is_active"`
+	LastTriggeredAt       *time.Time `json:"last_triggered_at" db:"last_triggered_at"`
+	CreatedAt             time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// AlertRuleRequest is the body of POST and PUT /api/billing/alerts
+type AlertRuleRequest struct {
+	ThresholdType         string  `json:"threshold_type" binding:"required,oneof=credits percent"`
+	Threshold             *int    `json:"threshold" binding:"required,min=0"`
+	NotifyEmail           *bool   `json:"notify_email"`
+	Email                 *string `json:"email" binding:"omitempty,email"`
+	NotifyWebhook         *bool   `json:"notify_webhook"`
+	AutoRechargePackageID *int    `json:"auto_recharge_package_id"`
+	IsActive              *bool   `json:"is_active"`
+}
+
+// thresholdCredits returns the rule's threshold in credits. Percent rules
+// need a monthly allocation.
+func (r *AlertRule) thresholdCredits(monthlyAllocation int) (int, bool) {
+	if r.ThresholdType == ThresholdPercent {
+		if monthlyAllocation <= 0 {
+			return 0, false
+		}
+		return monthlyAllocation * r.Threshold / 100, true
+	}
+	return r.Threshold, true
+}
+
+// reached reports whether the wallet's available credits are at or below the
+// rule's threshold
+func (r *AlertRule) reached(wallet *auth.UserCredits) bool {
+	threshold, ok := r.thresholdCredits(wallet.MonthlyAllocation)
+	return r.IsActive && ok && wallet.AvailableCredits <= threshold
+}
+
+// lowBalance reports whether a wallet's balance is low: an active alert rule
+// is reached or, without any, defaultLowBalancePercent of the allocation is
+// left
+func lowBalance(wallet *auth.UserCredits, rules []AlertRule) bool {
+	active := false
+	for i := range rules {
+		if rules[i].reached(wallet) {
+			return true
+		}
+		active = active || rules[i].IsActive
+	}
+	return !active && wallet.AvailableCredits <= wallet.MonthlyAllocation*defaultLowBalancePercent/100
+}
+
+// listAlertRules returns the alert rules of the workspace's wallet
+func listAlertRules(workspace auth.Workspace) ([]AlertRule, error) {
+	var rules []AlertRule
+	err := workspace.Scope(supabaseClient.DB.From("credit_alert_rules").
+		Select("*").
+		OrderBy("created_at", "asc")).
+		Execute(&rules)
+	return rules, err
+}
+
+// balanceStatus is a wallet's balance as reported by the warning header
+type balanceStatus struct {
+	available int
+	low       bool
+	checkedAt time.Time
+}
+
+var (
+	balanceMu    sync.Mutex
+	balanceCache = map[string]balanceStatus{}
+)
+
+func walletKey(workspace auth.Workspace) string {
+	if workspace.IsOrganization() {
+		return "org:" + workspace.OrgID
+	}
+	return "user:" + workspace.UserID
+}
+
+func cacheBalance(workspace auth.Workspace, wallet *auth.UserCredits, rules []AlertRule) balanceStatus {
+	status := balanceStatus{available: wallet.AvailableCredits, low: lowBalance(wallet, rules), checkedAt: time.Now()}
+	balanceMu.Lock()
+	balanceCache[walletKey(workspace)] = status
+	balanceMu.Unlock()
+	return status
+}
+
+// currentBalance returns the workspace's balance status, loading it when the
+// cached one is older than balanceCacheTTL
+func currentBalance(workspace auth.Workspace) (balanceStatus, error) {
+	balanceMu.Lock()
+	status, ok := balanceCache[walletKey(workspace)]
+	balanceMu.Unlock()
+	if ok && time.Since(status.checkedAt) < balanceCacheTTL {
+		return status, nil
+	}
+
+	wallet, err := workspace.Credits()
+	if err != nil {
+		return balanceStatus{}, err
+	}
+	rules, err := listAlertRules(workspace)
+	if err != nil {
+		return balanceStatus{}, err
+	}
+	return cacheBalance(workspace, wallet, rules), nil
+}
+
+// LowBalanceWarning sets CreditsRemainingHeader and CreditsWarningHeader on
+// responses while the workspace's balance is low, so API clients can top up
+// before requests fail with INSUFFICIENT_CREDITS
+func LowBalanceWarning() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if supabaseClient == nil {
+			c.Next()
+			return
+		}
+
+		workspace, ok := auth.ResolveWorkspace(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		// Wallets not set up yet have nothing to warn about
+		if status, err := currentBalance(workspace); err == nil && status.low {
+			c.Header(CreditsRemainingHeader, strconv.Itoa(status.available))
+			c.Header(CreditsWarningHeader, "low_balance")
+		}
+		c.Next()
+	}
+}
+
+// CheckCreditAlerts triggers the workspace's alert rules its balance has
+// reached, each at most once per credit period. Call it after credits are
+// consumed or reserved.
+func CheckCreditAlerts(workspace auth.Workspace) {
+	if supabaseClient == nil {
+		return
+	}
+
+	wallet, err := workspace.Credits()
+	if err != nil {
+		return
+	}
+	rules, err := listAlertRules(workspace)
+	if err != nil {
+		log.Printf("⚠️ CheckCreditAlerts: Failed to load alert rules for %s: %v", walletKey(workspace), err)
+		return
+	}
+	cacheBalance(workspace, wallet, rules)
+
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.reached(wallet) {
+			continue
+		}
+
+		var claimed bool
+		if err := supabaseClient.DB.Rpc("claim_credit_alert", map[string]interface{}{"p_rule_id": rule.ID}).Execute(&claimed); err != nil {
+			log.Printf("⚠️ CheckCreditAlerts: Failed to claim alert rule %s: %v", rule.ID, err)
+			continue
+		}
+		if claimed {
+			triggerAlert(workspace, rule, wallet)
+		}
+	}
+}
+
+// triggerAlert recharges the wallet when the rule asks for it, then sends the
+// rule's email and a webhook to the workspace that owns the wallet
+func triggerAlert(workspace auth.Workspace, rule *AlertRule, wallet *auth.UserCredits) {
+	log.Printf("🔔 triggerAlert: Rule %s reached with %d credits available", rule.ID, wallet.AvailableCredits)
+
+	data := map[string]interface{}{
+		"rule_id":            rule.ID,
+		"org_id":             rule.OrgID,
+		"available_credits":  wallet.AvailableCredits,
+		"monthly_allocation": wallet.MonthlyAllocation,
+		"threshold_type":     rule.ThresholdType,
+		"threshold":          rule.Threshold,
+		"next_reset_date":    wallet.NextResetDate,
+	}
+
+	rechargeNote := ""
+	if rule.AutoRechargePackageID != nil {
+		purchase, err := autoRecharge(rule)
+		if err != nil {
+			log.Printf("❌ triggerAlert: Auto-recharge for rule %s failed: %v", rule.ID, err)
+			data["auto_recharge"] = map[string]interface{}{"status": PurchaseFailed, "error": err.Error()}
+			rechargeNote = fmt.Sprintf("\n\nAuto-recharge failed: %v", err)
+		} else {
+			data["auto_recharge"] = map[string]interface{}{"status": PurchaseCompleted, "purchase_id": purchase.ID, "credits": purchase.Credits}
+			rechargeNote = fmt.Sprintf("\n\n%d credits were added by auto-recharge.", purchase.Credits)
+		}
+	}
+
+	if rule.NotifyWebhook {
+		webhooks.PublishToWorkspace(workspace, webhooks.EventCreditsLowBalance, data)
+	}
+
+	if rule.NotifyEmail {
+		to := stringValue(rule.Email)
+		if to == "" {
+			profile, err := auth.GetUserProfile(rule.UserID)
+			if err != nil {
+				log.Printf("⚠️ triggerAlert: No email for user %s: %v", rule.UserID, err)
+				return
+			}
+			to = profile.Email
+		}
+
+		mu.RLock()
+		link := appBaseURL + "/billing"
+		mu.RUnlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+		defer cancel()
+		err := notifications.SendEmail(ctx, to, notifications.Message{
+			Subject: fmt.Sprintf("Your QuarkFin credit balance is low (%d left)", wallet.AvailableCredits),
+			Text: fmt.Sprintf("Your credit balance has fallen to %d credits, below your alert threshold of %s.%s",
+				wallet.AvailableCredits, describeThreshold(rule), rechargeNote),
+			Link: link,
+		})
+		if err != nil {
+			log.Printf("❌ triggerAlert: Failed to email %s for rule %s: %v", to, rule.ID, err)
+		}
+	}
+}
+
+func describeThreshold(rule *AlertRule) string {
+	if rule.ThresholdType == ThresholdPercent {
+		return fmt.Sprintf("%d%% of your monthly allocation", rule.Threshold)
+	}
+	return fmt.Sprintf("%d credits", rule.Threshold)
+}
+
+// autoRecharge buys the rule's credit package for its wallet with the payment
+// method saved at an earlier checkout
+func autoRecharge(rule *AlertRule) (*CreditPurchase, error) {
+	p := currentProvider()
+	if p == nil {
+		return nil, fmt.Errorf("billing not initialized")
+	}
+
+	pkg, err := getPackage(*rule.AutoRechargePackageID)
+	if err != nil {
+		return nil, err
+	}
+	if pkg == nil {
+		return nil, fmt.Errorf("credit package %d is no longer available", *rule.AutoRechargePackageID)
+	}
+
+	customerID, err := savedCustomer(rule.UserID, rule.OrgID, p.Name())
+	if err != nil {
+		return nil, err
+	}
+	if customerID == "" {
+		return nil, errNoSavedPaymentMethod
+	}
+
+	purchase, err := insertPurchase(map[string]interface{}{
+		"user_id":              rule.UserID,
+		"org_id":               rule.OrgID,
+		"package_id":           pkg.ID,
+		"credits":              pkg.CreditAmount,
+		"amount_cents":         pkg.amountCents(),
+		"currency":             "usd",
+		"provider":             p.Name(),
+		"provider_customer_id": customerID,
+		"source":               SourceAutoRecharge,
+		"status":               PurchasePending,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create purchase: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), providerTimeout)
+	defer cancel()
+	event, err := p.ChargeSaved(ctx, ChargeRequest{
+		PurchaseID:  purchase.ID,
+		Description: fmt.Sprintf("%s (%d credits, auto-recharge)", pkg.PackageName, pkg.CreditAmount),
+		AmountCents: purchase.AmountCents,
+		Currency:    purchase.Currency,
+		CustomerID:  customerID,
+	})
+	if err != nil {
+		if err := updatePendingPurchase(purchase.ID, map[string]interface{}{"status": PurchaseFailed}); err != nil {
+			log.Printf("⚠️ autoRecharge: Failed to mark purchase %s failed: %v", purchase.ID, err)
+		}
+		return nil, err
+	}
+
+	if err := processPaymentEvent(p.Name(), event); err != nil {
+		return nil, fmt.Errorf("payment %s succeeded but granting credits failed: %v", event.PaymentID, err)
+	}
+	return purchase, nil
+}
@@ -0,0 +1,134 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+)
+
+func TestAlertRuleReached(t *testing.T) {
+	wallet := &auth.UserCredits{MonthlyAllocation: 200, AvailableCredits: 30}
+	tests := []struct {
+		rule AlertRule
+		want bool
+	}{
+		{AlertRule{ThresholdType: ThresholdCredits, Threshold: 50, IsActive: true}, true},
+		{AlertRule{ThresholdType: ThresholdCredits, Threshold: 30, IsActive: true}, true},
+		{AlertRule{ThresholdType: ThresholdCredits, Threshold: 29, IsActive: true}, false},
+		{AlertRule{ThresholdType: ThresholdPercent, Threshold: 20, IsActive: true}, true}, // 40 credits
+		{AlertRule{ThresholdType: ThresholdPercent, Threshold: 10, IsActive: true}, false},
+		{AlertRule{ThresholdType: ThresholdCredits, Threshold: 50, IsActive: false}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.reached(wallet); got != tt.want {
+			t.Errorf("%s %d (active %v) reached = %v, want %v", tt.rule.ThresholdType, tt.rule.Threshold, tt.rule.IsActive, got, tt.want)
+		}
+	}
+
+	noAllocation := &auth.UserCredits{AvailableCredits: 0}
+	percent := AlertRule{ThresholdType: ThresholdPercent, Threshold: 50, IsActive: true}
+	if percent.reached(noAllocation) {
+		t.Error("percent rule reached on a wallet without a monthly allocation")
+	}
+}
+
+func TestLowBalance(t *testing.T) {
+	wallet := &auth.UserCredits{MonthlyAllocation: 200, AvailableCredits: 15}
+	if !lowBalance(wallet, nil) {
+		t.Error("15 of 200 credits is below the default 10% and should be low")
+	}
+	if lowBalance(&auth.UserCredits{MonthlyAllocation: 200, AvailableCredits: 25}, nil) {
+		t.Error("25 of 200 credits should not be low by default")
+	}
+
+	rules := []AlertRule{{ThresholdType: ThresholdCredits, Threshold: 5, IsActive: true}}
+	if lowBalance(wallet, rules) {
+		t.Error("configured rules replace the default threshold")
+	}
+	rules = append(rules, AlertRule{ThresholdType: ThresholdCredits, Threshold: 20, IsActive: true})
+	if !lowBalance(wallet, rules) {
+		t.Error("balance at a rule's threshold should be low")
+	}
+}
+
+func TestStripeChargeSaved(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/payment_methods":
+			if r.URL.Query().Get("customer") != "cus_1" {
+				t.Errorf("payment methods of %q", r.URL.Query().Get("customer"))
+			}
+			fmt.Fprint(w, `{"data":[{"id":"pm_1"}]}`)
+		case "/payment_intents":
+			r.ParseForm()
+			if r.Form.Get("payment_method") != "pm_1" || r.Form.Get("off_session") != "true" || r.Form.Get("metadata[purchase_id]") != "p1" {
+				t.Errorf("unexpected form %v", r.Form)
+			}
+			if r.Header.Get("Idempotency-Key") != "charge-p1" {
+				t.Errorf("Idempotency-Key = %q", r.Header.Get("Idempotency-Key"))
+			}
+			fmt.Fprint(w, `{"id":"pi_1","status":"succeeded","amount":200,"currency":"usd"}`)
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	stripe := NewStripeProvider("sk_test", "whsec_test", server.Client())
+	stripe.baseURL = server.URL
+	event, err := stripe.ChargeSaved(context.Background(), ChargeRequest{
+		PurchaseID: "p1", Description: "Starter", AmountCents: 200, Currency: "usd", CustomerID: "cus_1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Kind != EventPaid || event.PurchaseID != "p1" || event.PaymentID != "pi_1" || event.AmountCents != 200 {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestStripeChargeSavedDeclined(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/payment_methods" {
+			fmt.Fprint(w, `{"data":[{"id":"pm_1"}]}`)
+			return
+		}
+		w.WriteHeader(http.StatusPaymentRequired)
+		fmt.Fprint(w, `{"error":{"message":"Your card was declined.","type":"card_error"}}`)
+	}))
+	defer server.Close()
+
+	stripe := NewStripeProvider("sk_test", "whsec_test", server.Client())
+	stripe.baseURL = server.URL
+	if _, err := stripe.ChargeSaved(context.Background(), ChargeRequest{PurchaseID: "p1", CustomerID: "cus_1"}); err == nil {
+		t.Error("declined charge reported as paid")
+	}
+}
+
+func TestStripePaymentIntentWebhook(t *testing.T) {
+	stripe := NewStripeProvider("sk_test", "whsec_test", http.DefaultClient)
+
+	payload := []byte(`{"id":"evt_2","type":"payment_intent.succeeded","data":{"object":{"id":"pi_1","amount":200,"currency":"usd","customer":"cus_1","metadata":{"purchase_id":"p1"}}}}`)
+	event, err := stripe.ParseWebhook(payload, stripeHeader("whsec_test", time.Now().Unix(), payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Kind != EventPaid || event.PurchaseID != "p1" || event.PaymentID != "pi_1" || event.AmountCents != 200 || event.CustomerID != "cus_1" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	// Payments of checkout sessions are handled by the session events
+	checkout := []byte(`{"id":"evt_3","type":"payment_intent.succeeded","data":{"object":{"id":"pi_2","amount":200,"currency":"usd"}}}`)
+	event, err = stripe.ParseWebhook(checkout, stripeHeader("whsec_test", time.Now().Unix(), checkout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Kind != "" {
+		t.Errorf("checkout payment intent kind = %q, want none", event.Kind)
+	}
+}
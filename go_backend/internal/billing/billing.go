@@ -0,0 +1,415 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	supa "github.com/nedpals/supabase-go"
+)
+
+const providerTimeout = 20 * time.Second
+
+// Purchase statuses
+const (
+	PurchasePending   = "pending"
+	PurchaseCompleted = "completed"
+	PurchaseFailed    = "failed"
+	PurchaseExpired   = "expired"
+)
+
+// Purchase sources
+const (
+	SourceCheckout     = "checkout"
+	SourceAutoRecharge = "auto_recharge"
+	SourcePlanUpgrade  = "plan_upgrade"
+)
+
+// Config selects and configures the payment provider
+type Config struct {
+	Provider            string // stripe or fake
+	StripeSecretKey     string
+	StripeWebhookSecret string
+	// FakeWebhookSecret signs the fake provider's webhooks; a random secret
+	// is used when empty, which only works with a single instance
+	FakeWebhookSecret string
+	// AppBaseURL is the web app the checkout returns to
+	AppBaseURL string
+	Production bool
+}
+
+var (
+	supabaseClient *supa.Client
+
+	mu         sync.RWMutex
+	provider   PaymentProvider
+	appBaseURL string
+)
+
+var errPurchaseNotFound = errors.New("purchase not found")
+
+// InitBilling initializes purchase storage and the payment provider
+func InitBilling(url, key string, cfg Config) error {
+	client := supa.CreateClient(url, key)
+	if client == nil {
+		return fmt.Errorf("failed to create Supabase client for billing")
+	}
+	supabaseClient = client
+	auth.ScheduleJob("monthly_invoices", invoiceJobInterval, invoiceJob)
+
+	p, err := buildProvider(cfg)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	provider, appBaseURL = p, strings.TrimRight(cfg.AppBaseURL, "/")
+	mu.Unlock()
+
+	log.Printf("💳 Payment provider: %s", p.Name())
+	return nil
+}
+
+// SetProvider replaces the payment provider, mainly for tests
+func SetProvider(p PaymentProvider) {
+	mu.Lock()
+	provider = p
+	mu.Unlock()
+}
+
+func buildProvider(cfg Config) (PaymentProvider, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Provider)) {
+	case ProviderStripe:
+		if cfg.StripeSecretKey == "" || cfg.StripeWebhookSecret == "" {
+			return nil, fmt.Errorf("STRIPE_SECRET_KEY and STRIPE_WEBHOOK_SECRET are required for the stripe provider")
+		}
+		return NewStripeProvider(cfg.StripeSecretKey, cfg.StripeWebhookSecret, &http.Client{Timeout: providerTimeout}), nil
+	case ProviderFake, "":
+		if cfg.Production {
+			return nil, fmt.Errorf("the fake payment provider cannot be used in production")
+		}
+		return NewFakeProvider(cfg.FakeWebhookSecret), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", cfg.Provider)
+	}
+}
+
+func currentProvider() PaymentProvider {
+	mu.RLock()
+	defer mu.RUnlock()
+	return provider
+}
+
+// CreditPackage is a pay-as-you-go credit bundle
+type CreditPackage struct {
+	ID              int     `json:"id" db:"id"`
+	PackageName     string  `json:"package_name" db:"package_name"`
+	CreditAmount    int     `json:"credit_amount" db:"credit_amount"`
+	PriceUSD        float64 `json:"price_usd" db:"price_usd"`
+	DiscountPercent float64 `json:"discount_percent" db:"discount_percent"`
+	IsPopular       bool    `json:"is_popular" db:"is_popular"`
+	IsActive        bool    `json:"is_active" db:"is_active"`
+}
+
+// amountCents is the package price in cents
+func (p CreditPackage) amountCents() int64 {
+	return int64(math.Round(p.PriceUSD * 100))
+}
+
+// CreditPurchase is a checkout for a credit package or a plan upgrade and,
+// once paid, its receipt
+type CreditPurchase struct {
+	ID                 string     `json:"id" db:"id"`
+	UserID             string     `json:"user_id" db:"user_id"`
+	OrgID              *string    `json:"org_id" db:"org_id"`               // credited organization pool, nil for the personal wallet
+	PackageID          *int       `json:"package_id" db:"package_id"`       // nil for plan upgrades
+	PlanID             *int       `json:"plan_id" db:"plan_id"`             // plan upgrades: the new plan
+	BillingCycle       *string    `json:"billing_cycle" db:"billing_cycle"` // plan upgrades: the new billing cycle
+	Credits            int        `json:"credits" db:"credits"`             // plan upgrades: prorated credits
+	AmountCents        int64      `json:"amount_cents" db:"amount_cents"`
+	Currency           string     `json:"currency" db:"currency"`
+	Provider           string     `json:"provider" db:"provider"`
+	ProviderSessionID  *string    `json:"provider_session_id" db:"provider_session_id"`
+	ProviderPaymentID  *string    `json:"provider_payment_id" db:"provider_payment_id"`
+	ProviderCustomerID *string    `json:"provider_customer_id" db:"provider_customer_id"`
+	Source             string     `json:"source" db:"source"` // checkout, auto_recharge or plan_upgrade
+	Status             string     `json:"status" db:"status"`
+	CheckoutURL        *string    `json:"checkout_url" db:"checkout_url"`
+	ReceiptNumber      *string    `json:"receipt_number" db:"receipt_number"`
+	TransactionID      *string    `json:"transaction_id" db:"transaction_id"`
+	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at" db:"updated_at"`
+	CompletedAt        *time.Time `json:"completed_at" db:"completed_at"`
+}
+
+// ListPackages returns the active credit packages, smallest first
+func ListPackages() ([]CreditPackage, error) {
+	var packages []CreditPackage
+	err := supabaseClient.DB.From("credit_packages").
+		Select("*").
+		OrderBy("credit_amount", "asc").
+		Eq("is_active", "true").
+		Execute(&packages)
+	return packages, err
+}
+
+// getPackage returns an active credit package
+func getPackage(id int) (*CreditPackage, error) {
+	var packages []CreditPackage
+	err := supabaseClient.DB.From("credit_packages").
+		Select("*").
+		Eq("id", fmt.Sprintf("%d", id)).
+		Eq("is_active", "true").
+		Execute(&packages)
+	if err != nil {
+		return nil, err
+	}
+	if len(packages) == 0 {
+		return nil, nil
+	}
+	return &packages[0], nil
+}
+
+func insertPurchase(record map[string]interface{}) (*CreditPurchase, error) {
+	var purchases []CreditPurchase
+	if err := supabaseClient.DB.From("credit_purchases").Insert(record).Execute(&purchases); err != nil {
+		return nil, err
+	}
+	if len(purchases) == 0 {
+		return nil, fmt.Errorf("no rows returned")
+	}
+	return &purchases[0], nil
+}
+
+func getPurchase(id string) (*CreditPurchase, error) {
+	var purchases []CreditPurchase
+	err := supabaseClient.DB.From("credit_purchases").Select("*").Eq("id", id).Execute(&purchases)
+	if err != nil {
+		return nil, err
+	}
+	if len(purchases) == 0 {
+		return nil, errPurchaseNotFound
+	}
+	return &purchases[0], nil
+}
+
+func getPurchaseBySession(providerName, sessionID string) (*CreditPurchase, error) {
+	var purchases []CreditPurchase
+	err := supabaseClient.DB.From("credit_purchases").
+		Select("*").
+		Eq("provider", providerName).
+		Eq("provider_session_id", sessionID).
+		Execute(&purchases)
+	if err != nil {
+		return nil, err
+	}
+	if len(purchases) == 0 {
+		return nil, errPurchaseNotFound
+	}
+	return &purchases[0], nil
+}
+
+// updatePendingPurchase changes a purchase that has not been paid yet
+func updatePendingPurchase(id string, fields map[string]interface{}) error {
+	fields["updated_at"] = time.Now().UTC().Format(time.RFC3339)
+	var results []map[string]interface{}
+	return supabaseClient.DB.From("credit_purchases").
+		Update(fields).
+		Eq("id", id).
+		Eq("status", PurchasePending).
+		Execute(&results)
+}
+
+// savedCustomer returns the provider's customer of the wallet's latest paid
+// purchase, or "" when none saved a payment method
+func savedCustomer(userID string, orgID *string, providerName string) (string, error) {
+	workspace := auth.Workspace{UserID: userID}
+	if orgID != nil {
+		workspace.OrgID = *orgID
+	}
+
+	var purchases []CreditPurchase
+	err := workspace.Scope(supabaseClient.DB.From("credit_purchases").
+		Select("*").
+		OrderBy("completed_at", "desc").
+		Limit(1)).
+		Eq("provider", providerName).
+		Eq("status", PurchaseCompleted).
+		Not().IsNull("provider_customer_id").
+		Execute(&purchases)
+	if err != nil || len(purchases) == 0 {
+		return "", err
+	}
+	return stringValue(purchases[0].ProviderCustomerID), nil
+}
+
+// completePurchase grants the credits of a paid purchase and records its
+// receipt. Repeated calls return the first transaction without granting again.
+func completePurchase(purchase *CreditPurchase, event *PaymentEvent) (*auth.CreditTransaction, error) {
+	var transaction auth.CreditTransaction
+	err := supabaseClient.DB.Rpc("complete_credit_purchase", map[string]interface{}{
+		"p_purchase_id":         purchase.ID,
+		"p_provider_payment_id": event.PaymentID,
+		"p_amount_cents":        event.AmountCents,
+		"p_currency":            strings.ToLower(event.Currency),
+	}).Execute(&transaction)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// completePlanUpgrade applies the plan upgrade of a paid purchase and records
+// its receipt. Repeated calls return the completed purchase without applying
+// the upgrade again.
+func completePlanUpgrade(purchase *CreditPurchase, event *PaymentEvent) (*CreditPurchase, error) {
+	var completed CreditPurchase
+	err := supabaseClient.DB.Rpc("complete_plan_upgrade", map[string]interface{}{
+		"p_purchase_id":         purchase.ID,
+		"p_provider_payment_id": event.PaymentID,
+		"p_amount_cents":        event.AmountCents,
+		"p_currency":            strings.ToLower(event.Currency),
+	}).Execute(&completed)
+	if err != nil {
+		return nil, err
+	}
+	return &completed, nil
+}
+
+// recordPaymentEvent logs a processed webhook event; duplicates are ignored
+func recordPaymentEvent(providerName string, event *PaymentEvent, purchaseID string) {
+	record := map[string]interface{}{
+		"provider":   providerName,
+		"event_id":   event.ID,
+		"event_type": event.Type,
+		"payload":    event,
+	}
+	if purchaseID != "" {
+		record["purchase_id"] = purchaseID
+	}
+	var results []map[string]interface{}
+	err := supabaseClient.DB.From("payment_events").Insert(record).Execute(&results)
+	if err != nil && !strings.Contains(err.Error(), "duplicate") {
+		log.Printf("⚠️ recordPaymentEvent: Failed to record %s event %s: %v", providerName, event.ID, err)
+	}
+}
+
+// processPaymentEvent applies a verified webhook event to its purchase
+func processPaymentEvent(providerName string, event *PaymentEvent) error {
+	if event.Kind == "" {
+		recordPaymentEvent(providerName, event, "")
+		return nil
+	}
+
+	var purchase *CreditPurchase
+	var err error
+	if event.PurchaseID != "" {
+		purchase, err = getPurchase(event.PurchaseID)
+	} else {
+		purchase, err = getPurchaseBySession(providerName, event.SessionID)
+	}
+	if errors.Is(err, errPurchaseNotFound) {
+		log.Printf("⚠️ processPaymentEvent: No purchase for %s event %s (session %s)", providerName, event.ID, event.SessionID)
+		recordPaymentEvent(providerName, event, "")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if purchase.Provider != providerName {
+		return fmt.Errorf("purchase %s was made with %s, not %s", purchase.ID, purchase.Provider, providerName)
+	}
+
+	switch {
+	case event.Kind == EventPaid && purchase.Source == SourcePlanUpgrade:
+		completed, err := completePlanUpgrade(purchase, event)
+		if err != nil {
+			return err
+		}
+		log.Printf("✅ processPaymentEvent: Purchase %s paid, upgraded user %s to plan %d (%s) with %d prorated credits",
+			purchase.ID, purchase.UserID, intValue(purchase.PlanID), stringValue(purchase.BillingCycle), completed.Credits)
+		saveCustomer(purchase, event)
+		auth.RecordAuditEvent(auth.AuditEvent{
+			UserID:       purchase.UserID,
+			Action:       auth.AuditSubscriptionChanged,
+			ResourceType: "subscription",
+			ResourceID:   purchase.ID,
+			Metadata: map[string]interface{}{
+				"change":           auth.PlanChangeUpgraded,
+				"plan_id":          intValue(purchase.PlanID),
+				"billing_cycle":    stringValue(purchase.BillingCycle),
+				"prorated_credits": completed.Credits,
+				"amount_cents":     purchase.AmountCents,
+				"currency":         purchase.Currency,
+				"provider":         providerName,
+			},
+		})
+	case event.Kind == EventPaid:
+		transaction, err := completePurchase(purchase, event)
+		if err != nil {
+			return err
+		}
+		log.Printf("✅ processPaymentEvent: Purchase %s paid, granted %d credits (transaction %s)", purchase.ID, purchase.Credits, transaction.ID)
+		saveCustomer(purchase, event)
+		auth.RecordAuditEvent(auth.AuditEvent{
+			UserID:       purchase.UserID,
+			OrgID:        stringValue(purchase.OrgID),
+			Action:       auth.AuditCreditsPurchased,
+			ResourceType: "credit_purchase",
+			ResourceID:   purchase.ID,
+			Metadata: map[string]interface{}{
+				"credits":      purchase.Credits,
+				"amount_cents": purchase.AmountCents,
+				"currency":     purchase.Currency,
+				"provider":     providerName,
+			},
+		})
+	case event.Kind == EventFailed, event.Kind == EventExpired:
+		status := PurchaseFailed
+		if event.Kind == EventExpired {
+			status = PurchaseExpired
+		}
+		if err := updatePendingPurchase(purchase.ID, map[string]interface{}{"status": status}); err != nil {
+			return err
+		}
+		log.Printf("⚠️ processPaymentEvent: Purchase %s %s", purchase.ID, status)
+	}
+
+	recordPaymentEvent(providerName, event, purchase.ID)
+	return nil
+}
+
+// saveCustomer keeps the customer of a paid purchase, whose saved payment
+// method auto-recharge charges
+func saveCustomer(purchase *CreditPurchase, event *PaymentEvent) {
+	if event.CustomerID == "" || stringValue(purchase.ProviderCustomerID) == event.CustomerID {
+		return
+	}
+	var results []map[string]interface{}
+	if err := supabaseClient.DB.From("credit_purchases").
+		Update(map[string]interface{}{"provider_customer_id": event.CustomerID}).
+		Eq("id", purchase.ID).
+		Execute(&results); err != nil {
+		log.Printf("⚠️ processPaymentEvent: Failed to save customer of purchase %s: %v", purchase.ID, err)
+	}
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func intValue(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
@@ -0,0 +1,140 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func stripeHeader(secret string, timestamp int64, payload []byte) http.Header {
+	header := http.Header{}
+	header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", timestamp, signPayload(secret, timestamp, payload)))
+	return header
+}
+
+func TestStripeWebhookSignature(t *testing.T) {
+	stripe := NewStripeProvider("sk_test", "whsec_test", http.DefaultClient)
+	payload := []byte(`{"id":"evt_1","type":"checkout.session.completed","data":{"object":{"id":"cs_1","client_reference_id":"p1","payment_status":"paid","payment_intent":"pi_1","amount_total":1999,"currency":"usd"}}}`)
+	now := time.Now().Unix()
+
+	event, err := stripe.ParseWebhook(payload, stripeHeader("whsec_test", now, payload))
+	if err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if event.Kind != EventPaid || event.PurchaseID != "p1" || event.PaymentID != "pi_1" || event.AmountCents != 1999 {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	tests := map[string]http.Header{
+		"wrong secret": stripeHeader("whsec_other", now, payload),
+		"stale":        stripeHeader("whsec_test", now-int64(time.Hour.Seconds()), payload),
+		"missing":      {},
+	}
+	for name, header := range tests {
+		if _, err := stripe.ParseWebhook(payload, header); err == nil {
+			t.Errorf("%s: signature accepted", name)
+		}
+	}
+
+	tampered := []byte(`{"id":"evt_1","type":"checkout.session.completed","data":{"object":{"id":"cs_1","client_reference_id":"p2","payment_status":"paid"}}}`)
+	if _, err := stripe.ParseWebhook(tampered, stripeHeader("whsec_test", now, payload)); err == nil {
+		t.Error("tampered payload accepted")
+	}
+}
+
+func TestStripeWebhookKinds(t *testing.T) {
+	stripe := NewStripeProvider("sk_test", "whsec_test", http.DefaultClient)
+	tests := []struct {
+		eventType     string
+		paymentStatus string
+		want          string
+	}{
+		{"checkout.session.completed", "paid", EventPaid},
+		{"checkout.session.completed", "unpaid", ""},
+		{"checkout.session.async_payment_succeeded", "paid", EventPaid},
+		{"checkout.session.async_payment_failed", "unpaid", EventFailed},
+		{"checkout.session.expired", "unpaid", EventExpired},
+		{"customer.created", "", ""},
+	}
+	for _, tt := range tests {
+		payload := []byte(fmt.Sprintf(`{"id":"evt","type":%q,"data":{"object":{"id":"cs_1","metadata":{"purchase_id":"p1"},"payment_intent":{"id":"pi_2"},"payment_status":%q}}}`,
+			tt.eventType, tt.paymentStatus))
+		event, err := stripe.ParseWebhook(payload, stripeHeader("whsec_test", time.Now().Unix(), payload))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.eventType, err)
+		}
+		if event.Kind != tt.want {
+			t.Errorf("%s (%s) kind = %q, want %q", tt.eventType, tt.paymentStatus, event.Kind, tt.want)
+		}
+		if event.PurchaseID != "p1" || event.PaymentID != "pi_2" {
+			t.Errorf("%s: purchase %q payment %q, want metadata and expanded intent", tt.eventType, event.PurchaseID, event.PaymentID)
+		}
+	}
+}
+
+func TestStripeCreateCheckout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/checkout/sessions" || r.Header.Get("Authorization") != "Bearer sk_test" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("Idempotency-Key") != "checkout-p1" {
+			t.Errorf("Idempotency-Key = %q", r.Header.Get("Idempotency-Key"))
+		}
+		r.ParseForm()
+		if r.Form.Get("client_reference_id") != "p1" || r.Form.Get("line_items[0][price_data][unit_amount]") != "1999" {
+			t.Errorf("unexpected form %v", r.Form)
+		}
+		if r.Form.Get("payment_intent_data[setup_future_usage]") != "off_session" || r.Form.Get("customer") != "cus_1" {
+			t.Errorf("payment method not saved for the customer: %v", r.Form)
+		}
+		fmt.Fprint(w, `{"id":"cs_1","url":"https://checkout.stripe.com/c/cs_1","expires_at":1700000000}`)
+	}))
+	defer server.Close()
+
+	stripe := NewStripeProvider("sk_test", "whsec_test", server.Client())
+	stripe.baseURL = server.URL
+	session, err := stripe.CreateCheckout(context.Background(), CheckoutRequest{
+		PurchaseID: "p1", Description: "Starter", AmountCents: 1999, Currency: "usd", CustomerID: "cus_1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ID != "cs_1" || session.URL == "" || session.ExpiresAt == nil {
+		t.Errorf("unexpected session %+v", session)
+	}
+}
+
+func TestFakeProviderRoundTrip(t *testing.T) {
+	fake := NewFakeProvider("secret")
+	payload, header, err := fake.SignedEvent(PaymentEvent{ID: "evt", Kind: EventPaid, PurchaseID: "p1", AmountCents: 500, Currency: "usd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := fake.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Kind != EventPaid || event.PurchaseID != "p1" || event.AmountCents != 500 {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	if _, err := NewFakeProvider("other").ParseWebhook(payload, header); err == nil {
+		t.Error("event signed with another secret accepted")
+	}
+}
+
+func TestBuildProvider(t *testing.T) {
+	if _, err := buildProvider(Config{Provider: ProviderFake, Production: true}); err == nil {
+		t.Error("fake provider allowed in production")
+	}
+	if _, err := buildProvider(Config{Provider: ProviderStripe}); err == nil {
+		t.Error("stripe provider allowed without keys")
+	}
+	if p, err := buildProvider(Config{Provider: "Stripe", StripeSecretKey: "sk", StripeWebhookSecret: "whsec"}); err != nil || p.Name() != ProviderStripe {
+		t.Errorf("buildProvider(stripe) = %v, %v", p, err)
+	}
+}
@@ -0,0 +1,447 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxWebhookBody bounds the size of provider webhook requests
+const maxWebhookBody = 1 << 20
+
+// CheckoutRequestBody is the body of POST /api/billing/checkout
+type CheckoutRequestBody struct {
+	PackageID int `json:"package_id" binding:"required"`
+}
+
+// ListPackagesHandler handles GET /api/billing/packages
+func ListPackagesHandler(c *gin.Context) {
+	if !requireBillingDatabase(c) {
+		return
+	}
+
+	packages, err := ListPackages()
+	if err != nil {
+		log.Printf("❌ ListPackagesHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch credit packages",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+	if packages == nil {
+		packages = []CreditPackage{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"packages": packages})
+}
+
+// CreateCheckoutHandler handles POST /api/billing/checkout. Credits go to the
+// workspace's wallet: the caller's, or with X-Organization-ID the
+// organization's pool.
+func CreateCheckoutHandler(c *gin.Context) {
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	if !requireBillingDatabase(c) {
+		return
+	}
+
+	var req CheckoutRequestBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"code":    "INVALID_REQUEST",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	pkg, err := getPackage(req.PackageID)
+	if err != nil {
+		log.Printf("❌ CreateCheckoutHandler: Failed to fetch package %d: %v", req.PackageID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch credit package",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+	if pkg == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Credit package not found",
+			"code":  "PACKAGE_NOT_FOUND",
+		})
+		return
+	}
+
+	p := currentProvider()
+	customerID, err := savedCustomer(workspace.UserID, workspace.OrgIDPtr(), p.Name())
+	if err != nil {
+		// A new customer is created at checkout
+		log.Printf("⚠️ CreateCheckoutHandler: Failed to look up saved customer for user %s: %v", workspace.UserID, err)
+	}
+	purchase, err := insertPurchase(map[string]interface{}{
+		"user_id":      workspace.UserID,
+		"org_id":       workspace.OrgIDPtr(),
+		"package_id":   pkg.ID,
+		"credits":      pkg.CreditAmount,
+		"amount_cents": pkg.amountCents(),
+		"currency":     "usd",
+		"provider":     p.Name(),
+		"source":       SourceCheckout,
+		"status":       PurchasePending,
+	})
+	if err != nil {
+		log.Printf("❌ CreateCheckoutHandler: Failed to create purchase: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create purchase",
+			"code":  "DATABASE_ERROR",
+		})
+		return
+	}
+
+	session, ok := startCheckout(c, "CreateCheckoutHandler", p, purchase,
+		fmt.Sprintf("%s (%d credits)", pkg.PackageName, pkg.CreditAmount), customerID)
+	if !ok {
+		return
+	}
+
+	log.Printf("🛒 CreateCheckoutHandler: User %s started purchase %s of %s via %s", workspace.UserID, purchase.ID, pkg.PackageName, p.Name())
+	auth.SetAuditResource(c, purchase.ID)
+	c.JSON(http.StatusCreated, gin.H{
+		"purchase_id":  purchase.ID,
+		"provider":     p.Name(),
+		"session_id":   session.ID,
+		"checkout_url": session.URL,
+		"expires_at":   session.ExpiresAt,
+		"credits":      purchase.Credits,
+		"amount_cents": purchase.AmountCents,
+		"currency":     purchase.Currency,
+	})
+}
+
+// startCheckout opens the provider's checkout for a pending purchase and
+// links the session to it, writing the error response when either fails
+func startCheckout(c *gin.Context, handler string, p PaymentProvider, purchase *CreditPurchase, description, customerID string) (*CheckoutSession, bool) {
+	mu.RLock()
+	returnURL := fmt.Sprintf("%s/billing?purchase=%s", appBaseURL, purchase.ID)
+	mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), providerTimeout)
+	defer cancel()
+	session, err := p.CreateCheckout(ctx, CheckoutRequest{
+		PurchaseID:    purchase.ID,
+		Description:   description,
+		AmountCents:   purchase.AmountCents,
+		Currency:      purchase.Currency,
+		CustomerEmail: auth.GetUserEmail(c),
+		CustomerID:    customerID,
+		SuccessURL:    returnURL + "&status=success",
+		CancelURL:     returnURL + "&status=cancelled",
+	})
+	if err != nil {
+		log.Printf("❌ %s: %s checkout for purchase %s failed: %v", handler, p.Name(), purchase.ID, err)
+		if err := updatePendingPurchase(purchase.ID, map[string]interface{}{"status": PurchaseFailed}); err != nil {
+			log.Printf("⚠️ %s: Failed to mark purchase %s failed: %v", handler, purchase.ID, err)
+		}
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Payment provider is unavailable",
+			"code":  "PAYMENT_PROVIDER_ERROR",
+		})
+		return nil, false
+	}
+
+	if err := updatePendingPurchase(purchase.ID, map[string]interface{}{
+		"provider_session_id": session.ID,
+		"checkout_url":        session.URL,
+	}); err != nil {
+		log.Printf("❌ %s: Failed to link session %s to purchase %s: %v", handler, session.ID, purchase.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create purchase",
+			"code":  "DATABASE_ERROR",
+		})
+		return nil, false
+	}
+	return session, true
+}
+
+// ListPurchasesHandler handles GET /api/billing/purchases, newest first.
+// Completed purchases carry their receipt number and ledger transaction.
+func ListPurchasesHandler(c *gin.Context) {
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	if !requireBillingDatabase(c) {
+		return
+	}
+
+	var purchases []CreditPurchase
+	err := workspace.Scope(supabaseClient.DB.From("credit_purchases").
+		Select("*").
+		OrderBy("created_at", "desc").
+		Limit(100)).
+		Execute(&purchases)
+	if err != nil {
+		log.Printf("❌ ListPurchasesHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch purchases",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+	if purchases == nil {
+		purchases = []CreditPurchase{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purchases": purchases})
+}
+
+// GetUsageHandler handles GET /api/billing/usage?months=N, the workspace
+// wallet's credit usage per UTC month, the current month included
+func GetUsageHandler(c *gin.Context) {
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	months := 6
+	if value := c.Query("months"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxUsageMonths {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("months must be between 1 and %d", maxUsageMonths),
+				"code":  "INVALID_REQUEST",
+			})
+			return
+		}
+		months = n
+	}
+
+	if !requireBillingDatabase(c) {
+		return
+	}
+
+	usage, err := GetMonthlyUsage(workspace, months)
+	if err != nil {
+		log.Printf("❌ GetUsageHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch usage",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"usage": usage})
+}
+
+// ListInvoicesHandler handles GET /api/billing/invoices, newest first
+func ListInvoicesHandler(c *gin.Context) {
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	if !requireBillingDatabase(c) {
+		return
+	}
+
+	invoices, err := ListInvoices(workspace)
+	if err != nil {
+		log.Printf("❌ ListInvoicesHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch invoices",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+	if invoices == nil {
+		invoices = []Invoice{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invoices": invoices})
+}
+
+// GetInvoiceHandler handles GET /api/billing/invoices/:id
+func GetInvoiceHandler(c *gin.Context) {
+	invoice, ok := invoiceForRequest(c, "GetInvoiceHandler")
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+}
+
+// DownloadInvoiceHandler handles GET /api/billing/invoices/:id/pdf
+func DownloadInvoiceHandler(c *gin.Context) {
+	invoice, ok := invoiceForRequest(c, "DownloadInvoiceHandler")
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, invoice.InvoiceNumber))
+	c.Data(http.StatusOK, "application/pdf", renderInvoicePDF(invoice))
+}
+
+// invoiceForRequest loads the invoice named by the :id parameter, writing the
+// error response when it is not in the caller's workspace
+func invoiceForRequest(c *gin.Context, handler string) (*Invoice, bool) {
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return nil, false
+	}
+
+	if !requireBillingDatabase(c) {
+		return nil, false
+	}
+
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid invoice ID",
+			"code":  "INVALID_INVOICE_ID",
+		})
+		return nil, false
+	}
+
+	invoice, err := GetInvoice(workspace, id)
+	if errors.Is(err, errInvoiceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Invoice not found",
+			"code":  "INVOICE_NOT_FOUND",
+		})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("❌ %s: %v", handler, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch invoice",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return nil, false
+	}
+	return invoice, true
+}
+
+// WebhookHandler handles POST /api/billing/webhooks/:provider. Events are
+// verified by the provider's signature; failures return 5xx so the provider
+// retries, and retried events never grant credits twice.
+func WebhookHandler(c *gin.Context) {
+	p := currentProvider()
+	if p == nil || supabaseClient == nil || c.Param("provider") != p.Name() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Payment provider is not configured",
+			"code":  "NOT_CONFIGURED",
+		})
+		return
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid webhook body",
+			"code":  "INVALID_REQUEST",
+		})
+		return
+	}
+
+	handlePaymentWebhook(c, p, payload, c.Request.Header)
+}
+
+// FakeCheckoutHandler handles POST /api/billing/fake-checkout/:session_id,
+// the fake provider's checkout page. It pays the purchase by sending a
+// signed webhook through WebhookHandler's path.
+func FakeCheckoutHandler(c *gin.Context) {
+	fake, ok := currentProvider().(*FakeProvider)
+	if !ok || supabaseClient == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Fake payments are not enabled",
+			"code":  "NOT_CONFIGURED",
+		})
+		return
+	}
+
+	purchase, err := getPurchaseBySession(ProviderFake, c.Param("session_id"))
+	if errors.Is(err, errPurchaseNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Checkout session not found",
+			"code":  "PURCHASE_NOT_FOUND",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ FakeCheckoutHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch purchase",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	payload, header, err := fake.SignedEvent(PaymentEvent{
+		ID:          "fake_evt_" + randomHex(12),
+		Kind:        EventPaid,
+		Type:        "checkout.completed",
+		SessionID:   stringValue(purchase.ProviderSessionID),
+		PurchaseID:  purchase.ID,
+		PaymentID:   fmt.Sprintf("fake_pay_%d", time.Now().UnixNano()),
+		AmountCents: purchase.AmountCents,
+		Currency:    purchase.Currency,
+		CustomerID:  "fake_cus_" + randomHex(8),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to sign payment event",
+			"code":  "INTERNAL_ERROR",
+		})
+		return
+	}
+
+	handlePaymentWebhook(c, fake, payload, header)
+}
+
+func handlePaymentWebhook(c *gin.Context, p PaymentProvider, payload []byte, header http.Header) {
+	event, err := p.ParseWebhook(payload, header)
+	if err != nil {
+		log.Printf("🚫 WebhookHandler: Rejected %s webhook from %s: %v", p.Name(), c.ClientIP(), err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid signature",
+			"code":  "INVALID_SIGNATURE",
+		})
+		return
+	}
+
+	if err := processPaymentEvent(p.Name(), event); err != nil {
+		log.Printf("❌ WebhookHandler: Failed to process %s event %s: %v", p.Name(), event.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to process payment event",
+			"code":  "PAYMENT_PROCESSING_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+func requireBillingDatabase(c *gin.Context) bool {
+	if supabaseClient == nil || currentProvider() == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Billing is not available",
+			"code":  "DATABASE_CONNECTION_ERROR",
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package billing
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// PDF page layout in points (A4)
+const (
+	pdfPageWidth   = 595
+	pdfPageHeight  = 842
+	pdfMargin      = 50
+	pdfLineHeight  = 16
+	pdfFontSize    = 10
+	pdfTitleSize   = 18
+	pdfDescColumn  = pdfMargin
+	pdfQtyColumn   = 360
+	pdfPriceColumn = 410
+	pdfTotalColumn = 490
+	maxDescRunes   = 58
+)
+
+// pdfText is a piece of text placed on a page
+type pdfText struct {
+	x, y int
+	size int
+	text string
+}
+
+// renderInvoicePDF renders an invoice as a PDF document using the standard
+// Helvetica font, so no font files are embedded
+func renderInvoicePDF(invoice *Invoice) []byte {
+	var pages [][]pdfText
+	var page []pdfText
+	y := pdfPageHeight - pdfMargin
+
+	add := func(x, size int, text string) {
+		page = append(page, pdfText{x: x, y: y, size: size, text: text})
+	}
+	newline := func(lines int) {
+		y -= lines * pdfLineHeight
+		if y < pdfMargin {
+			pages = append(pages, page)
+			page, y = nil, pdfPageHeight-pdfMargin
+		}
+	}
+
+	add(pdfMargin, pdfTitleSize, "QuarkfinAI Invoice")
+	newline(2)
+	add(pdfMargin, pdfFontSize, "Invoice number: "+invoice.InvoiceNumber)
+	newline(1)
+	add(pdfMargin, pdfFontSize, fmt.Sprintf("Billing period: %s to %s",
+		invoice.PeriodStart.UTC().Format("2006-01-02"), invoice.PeriodEnd.UTC().AddDate(0, 0, -1).Format("2006-01-02")))
+	newline(1)
+	add(pdfMargin, pdfFontSize, "Issued: "+invoice.CreatedAt.UTC().Format("2006-01-02"))
+	newline(1)
+	add(pdfMargin, pdfFontSize, "Status: "+strings.ToUpper(invoice.Status))
+	newline(2)
+
+	add(pdfDescColumn, pdfFontSize, "Description")
+	add(pdfQtyColumn, pdfFontSize, "Qty")
+	add(pdfPriceColumn, pdfFontSize, "Unit price")
+	add(pdfTotalColumn, pdfFontSize, "Amount")
+	newline(1)
+	add(pdfDescColumn, pdfFontSize, strings.Repeat("_", 95))
+	newline(1)
+
+	for _, line := range invoice.LineItems {
+		add(pdfDescColumn, pdfFontSize, truncateRunes(line.Description, maxDescRunes))
+		add(pdfQtyColumn, pdfFontSize, fmt.Sprintf("%d", line.Quantity))
+		add(pdfPriceColumn, pdfFontSize, formatUnitPrice(line.UnitAmountCents, invoice.Currency))
+		add(pdfTotalColumn, pdfFontSize, formatCents(line.AmountCents, invoice.Currency))
+		newline(1)
+	}
+
+	newline(1)
+	for _, total := range []struct {
+		label string
+		cents int64
+	}{
+		{"Total", invoice.TotalCents},
+		{"Paid at checkout", invoice.AmountPaidCents},
+		{"Amount due", invoice.AmountDueCents},
+	} {
+		add(pdfPriceColumn, pdfFontSize, total.label)
+		add(pdfTotalColumn, pdfFontSize, formatCents(total.cents, invoice.Currency))
+		newline(1)
+	}
+	if len(page) > 0 {
+		pages = append(pages, page)
+	}
+
+	return buildPDF(pages)
+}
+
+// buildPDF writes a PDF with one page per entry of pages
+func buildPDF(pages [][]pdfText) []byte {
+	// Objects: 1 catalog, 2 page tree, 3 font, then a page and its content
+	// stream for every page
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+	kids := make([]string, 0, len(pages))
+	for i, texts := range pages {
+		pageObj := 4 + 2*i
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObj))
+
+		var content bytes.Buffer
+		for _, t := range texts {
+			fmt.Fprintf(&content, "BT /F1 %d Tf %d %d Td (%s) Tj ET\n", t.size, t.x, t.y, escapePDFText(t.text))
+		}
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, pageObj+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
+
+// escapePDFText escapes a PDF string literal; characters outside ASCII are
+// replaced since the standard fonts are not Unicode
+func escapePDFText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func truncateRunes(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-3]) + "..."
+}
+
+// formatCents formats an amount in cents, such as $12.34 or -$0.50 for USD
+func formatCents(cents int64, currency string) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	amount := fmt.Sprintf("%d.%02d", cents/100, cents%100)
+	if strings.EqualFold(currency, "usd") {
+		return sign + "$" + amount
+	}
+	return sign + amount + " " + strings.ToUpper(currency)
+}
+
+// formatUnitPrice formats a unit price in cents, keeping fractions of a cent
+// such as the $0.009 overage price
+func formatUnitPrice(cents float64, currency string) string {
+	if cents == float64(int64(cents)) {
+		return formatCents(int64(cents), currency)
+	}
+	amount := strings.TrimRight(fmt.Sprintf("%.4f", cents/100), "0")
+	if strings.EqualFold(currency, "usd") {
+		return "$" + amount
+	}
+	return amount + " " + strings.ToUpper(currency)
+}
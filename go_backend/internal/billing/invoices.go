@@ -0,0 +1,218 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+)
+
+// Invoice statuses
+const (
+	InvoiceOpen = "open" // amount due
+	InvoicePaid = "paid" // everything was paid at checkout
+)
+
+// Invoice line types
+const (
+	LinePlan          = "plan"
+	LineProration     = "proration"
+	LineCreditPackage = "credit_package"
+	LinePlanUpgrade   = "plan_upgrade" // paid at checkout
+	LineOverage       = "overage"
+)
+
+// invoiceJobInterval is how often last month's invoices are generated; runs
+// after the first create what is missing, so a month is invoiced once
+const invoiceJobInterval = 6 * time.Hour
+
+// invoiceBatchSize is how many wallets generate_monthly_invoices handles per call
+const invoiceBatchSize = 200
+
+// maxInvoicePasses bounds the calls of one scheduled run
+const maxInvoicePasses = 50
+
+// maxUsageMonths bounds the history returned by the usage endpoint
+const maxUsageMonths = 24
+
+var errInvoiceNotFound = errors.New("invoice not found")
+
+// InvoiceLine is a line of an invoice
+type InvoiceLine struct {
+	Type            string     `json:"type"`
+	Description     string     `json:"description"`
+	Quantity        int        `json:"quantity"`
+	UnitAmountCents float64    `json:"unit_amount_cents"` // overage prices are fractions of a cent
+	AmountCents     int64      `json:"amount_cents"`
+	Date            *time.Time `json:"date,omitempty"`
+	Reference       *string    `json:"reference,omitempty"` // ledger transaction or receipt number
+}
+
+// Invoice is a row of invoices: a wallet's charges for one UTC month
+type Invoice struct {
+	ID              string        `json:"id" db:"id"`
+	InvoiceNumber   string        `json:"invoice_number" db:"invoice_number"`
+	WalletID        string        `json:"wallet_id" db:"wallet_id"`
+	UserID          *string       `json:"user_id" db:"user_id"`
+	OrgID           *string       `json:"org_id" db:"org_id"`
+	PeriodStart     time.Time     `json:"period_start" db:"period_start"`
+	PeriodEnd       time.Time     `json:"period_end" db:"period_end"`
+	Currency        string        `json:"currency" db:"currency"`
+	LineItems       []InvoiceLine `json:"line_items" db:"line_items"`
+	TotalCents      int64         `json:"total_cents" db:"total_cents"`
+	AmountPaidCents int64         `json:"amount_paid_cents" db:"amount_paid_cents"` // credit packages paid at checkout
+	AmountDueCents  int64         `json:"amount_due_cents" db:"amount_due_cents"`
+	Status          string        `json:"status" db:"status"`
+	CreatedAt       time.Time     `json:"created_at" db:"created_at"`
+}
+
+// usageRow is a row of credit_usage_by_month
+type usageRow struct {
+	Month           time.Time `json:"month"`
+	TransactionType string    `json:"transaction_type"`
+	Transactions    int       `json:"transactions"`
+	Credits         int       `json:"credits"`
+	AmountCents     float64   `json:"amount_cents"`
+}
+
+// MonthlyUsage sums a wallet's credit movements in one UTC month
+type MonthlyUsage struct {
+	Month              time.Time      `json:"month"`
+	CreditsConsumed    int            `json:"credits_consumed"`
+	CreditsGranted     int            `json:"credits_granted"` // plan allocations, prorations and bonuses
+	CreditsPurchased   int            `json:"credits_purchased"`
+	CreditsExpired     int            `json:"credits_expired"`
+	OverageCredits     int            `json:"overage_credits"` // net of unused overage credits
+	OverageAmountCents int64          `json:"overage_amount_cents"`
+	PlanChargesCents   int64          `json:"plan_charges_cents"`
+	PurchasesCents     int64          `json:"purchases_cents"`
+	Transactions       int            `json:"transactions"`
+	CreditsByType      map[string]int `json:"credits_by_type"`
+}
+
+// summarizeUsage folds rows of credit_usage_by_month into one entry per
+// month, oldest first
+func summarizeUsage(rows []usageRow) []MonthlyUsage {
+	byMonth := map[time.Time]*MonthlyUsage{}
+	for _, row := range rows {
+		month := row.Month.UTC()
+		usage, ok := byMonth[month]
+		if !ok {
+			usage = &MonthlyUsage{Month: month, CreditsByType: map[string]int{}}
+			byMonth[month] = usage
+		}
+
+		usage.Transactions += row.Transactions
+		usage.CreditsByType[row.TransactionType] += row.Credits
+		switch row.TransactionType {
+		case "assessment_usage":
+			usage.CreditsConsumed -= row.Credits
+		case "subscription_allocation", "subscription_proration":
+			usage.CreditsGranted += row.Credits
+			usage.PlanChargesCents += int64(math.Round(row.AmountCents))
+		case "bonus":
+			usage.CreditsGranted += row.Credits
+		case "recharge_purchase":
+			usage.CreditsPurchased += row.Credits
+			usage.PurchasesCents += int64(math.Round(row.AmountCents))
+		case "monthly_reset":
+			usage.CreditsExpired -= row.Credits
+		case "overage_accrual", "overage_reversal":
+			usage.OverageCredits += row.Credits
+			usage.OverageAmountCents += int64(math.Round(row.AmountCents))
+		}
+	}
+
+	months := make([]MonthlyUsage, 0, len(byMonth))
+	for _, usage := range byMonth {
+		months = append(months, *usage)
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Month.Before(months[j].Month) })
+	return months
+}
+
+// monthStart returns the start of the UTC month months before t's month
+func monthStart(t time.Time, monthsBack int) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()-time.Month(monthsBack), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// GetMonthlyUsage returns the workspace wallet's usage for the last months
+// UTC months, the current one included
+func GetMonthlyUsage(workspace auth.Workspace, months int) ([]MonthlyUsage, error) {
+	var rows []usageRow
+	err := supabaseClient.DB.Rpc("credit_usage_by_month", map[string]interface{}{
+		"p_user_id": workspace.UserID,
+		"p_org_id":  workspace.OrgIDPtr(),
+		"p_from":    monthStart(time.Now(), months-1).Format(time.RFC3339),
+	}).Execute(&rows)
+	if err != nil {
+		return nil, err
+	}
+	return summarizeUsage(rows), nil
+}
+
+// ListInvoices returns the workspace's invoices, newest first
+func ListInvoices(workspace auth.Workspace) ([]Invoice, error) {
+	var invoices []Invoice
+	err := workspace.Scope(supabaseClient.DB.From("invoices").
+		Select("*").
+		OrderBy("period_start", "desc").
+		Limit(100)).
+		Execute(&invoices)
+	return invoices, err
+}
+
+// GetInvoice returns an invoice of the workspace
+func GetInvoice(workspace auth.Workspace, id string) (*Invoice, error) {
+	var invoices []Invoice
+	err := supabaseClient.DB.From("invoices").Select("*").Eq("id", id).Execute(&invoices)
+	if err != nil {
+		return nil, err
+	}
+	if len(invoices) == 0 || !workspace.Contains(stringValue(invoices[0].UserID), invoices[0].OrgID) {
+		return nil, errInvoiceNotFound
+	}
+	return &invoices[0], nil
+}
+
+// GenerateMonthlyInvoices invoices up to invoiceBatchSize wallets with
+// billable activity in the UTC month starting periodStart and returns how
+// many were handled. Months already invoiced are skipped.
+func GenerateMonthlyInvoices(periodStart time.Time) (int, error) {
+	if supabaseClient == nil {
+		return 0, fmt.Errorf("billing not initialized")
+	}
+
+	var handled int
+	err := supabaseClient.DB.Rpc("generate_monthly_invoices", map[string]interface{}{
+		"p_period_start": periodStart.UTC().Format(time.RFC3339),
+		"p_batch_size":   invoiceBatchSize,
+	}).Execute(&handled)
+	return handled, err
+}
+
+// invoiceJob is the scheduled job invoicing last month
+func invoiceJob() (interface{}, error) {
+	periodStart := monthStart(time.Now(), 1)
+	total := 0
+	for pass := 0; pass < maxInvoicePasses; pass++ {
+		handled, err := GenerateMonthlyInvoices(periodStart)
+		if err != nil {
+			return map[string]int{"handled": total}, err
+		}
+		total += handled
+		if handled < invoiceBatchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		log.Printf("🧾 GenerateMonthlyInvoices: Invoiced %d wallets for %s", total, periodStart.Format("2006-01"))
+	}
+	return map[string]int{"handled": total}, nil
+}
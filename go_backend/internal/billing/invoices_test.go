@@ -0,0 +1,126 @@
+package billing
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummarizeUsage(t *testing.T) {
+	march := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	rows := []usageRow{
+		{Month: april, TransactionType: "assessment_usage", Transactions: 3, Credits: -30},
+		{Month: march, TransactionType: "subscription_allocation", Transactions: 1, Credits: 500, AmountCents: 4900},
+		{Month: march, TransactionType: "assessment_usage", Transactions: 60, Credits: -600},
+		{Month: march, TransactionType: "recharge_purchase", Transactions: 1, Credits: 100, AmountCents: 1999},
+		{Month: march, TransactionType: "overage_accrual", Transactions: 2, Credits: 30, AmountCents: 27},
+		{Month: march, TransactionType: "overage_reversal", Transactions: 1, Credits: -10, AmountCents: -9},
+	}
+
+	usage := summarizeUsage(rows)
+	if len(usage) != 2 || !usage[0].Month.Equal(march) || !usage[1].Month.Equal(april) {
+		t.Fatalf("unexpected months %+v", usage)
+	}
+
+	got := usage[0]
+	if got.CreditsConsumed != 600 || got.CreditsGranted != 500 || got.CreditsPurchased != 100 {
+		t.Errorf("unexpected credits %+v", got)
+	}
+	if got.OverageCredits != 20 || got.OverageAmountCents != 18 {
+		t.Errorf("overage = %d credits, %d cents; want 20 credits, 18 cents", got.OverageCredits, got.OverageAmountCents)
+	}
+	if got.PlanChargesCents != 4900 || got.PurchasesCents != 1999 || got.Transactions != 65 {
+		t.Errorf("unexpected charges %+v", got)
+	}
+	if got.CreditsByType["assessment_usage"] != -600 {
+		t.Errorf("credits_by_type = %v", got.CreditsByType)
+	}
+	if usage[1].CreditsConsumed != 30 {
+		t.Errorf("april consumed = %d, want 30", usage[1].CreditsConsumed)
+	}
+}
+
+func TestMonthStart(t *testing.T) {
+	now := time.Date(2026, 1, 15, 13, 45, 0, 0, time.UTC)
+	tests := map[int]time.Time{
+		0: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		1: time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC),
+		5: time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for back, want := range tests {
+		if got := monthStart(now, back); !got.Equal(want) {
+			t.Errorf("monthStart(%d) = %v, want %v", back, got, want)
+		}
+	}
+}
+
+func TestFormatCents(t *testing.T) {
+	tests := []struct {
+		cents    int64
+		currency string
+		want     string
+	}{
+		{1234, "usd", "$12.34"},
+		{5, "usd", "$0.05"},
+		{-50, "usd", "-$0.50"},
+		{1000, "eur", "10.00 EUR"},
+	}
+	for _, tt := range tests {
+		if got := formatCents(tt.cents, tt.currency); got != tt.want {
+			t.Errorf("formatCents(%d, %s) = %q, want %q", tt.cents, tt.currency, got, tt.want)
+		}
+	}
+
+	if got := formatUnitPrice(0.9, "usd"); got != "$0.009" {
+		t.Errorf("formatUnitPrice(0.9) = %q, want $0.009", got)
+	}
+	if got := formatUnitPrice(4900, "usd"); got != "$49.00" {
+		t.Errorf("formatUnitPrice(4900) = %q, want $49.00", got)
+	}
+}
+
+func TestRenderInvoicePDF(t *testing.T) {
+	invoice := &Invoice{
+		InvoiceNumber: "INV-2026-000042",
+		PeriodStart:   time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:     time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+		Currency:      "usd",
+		Status:        InvoiceOpen,
+		CreatedAt:     time.Date(2026, 4, 1, 6, 0, 0, 0, time.UTC),
+		TotalCents:    4927,
+	}
+	for i := 0; i < 60; i++ {
+		invoice.LineItems = append(invoice.LineItems, InvoiceLine{
+			Type: LineOverage, Description: "Overage (credits) — café", Quantity: 3, UnitAmountCents: 0.9, AmountCents: 3,
+		})
+	}
+
+	pdf := renderInvoicePDF(invoice)
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("output is not a PDF document")
+	}
+	if !bytes.Contains(pdf, []byte("(Invoice number: INV-2026-000042)")) {
+		t.Error("invoice number missing")
+	}
+	if !bytes.Contains(pdf, []byte(`(Overage \(credits\) ? caf?)`)) {
+		t.Error("description not escaped")
+	}
+	if !bytes.Contains(pdf, []byte("/Count 2")) {
+		t.Error("long invoice should span two pages")
+	}
+
+	// The xref table must point at the objects
+	xref := bytes.LastIndex(pdf, []byte("\nxref\n")) + 1
+	for i, line := range strings.Split(string(pdf[xref:]), "\n")[3:5] {
+		var offset int
+		if _, err := fmt.Sscanf(line, "%d", &offset); err != nil {
+			t.Fatalf("bad xref entry %q", line)
+		}
+		if want := []byte(fmt.Sprintf("%d 0 obj", i+1)); !bytes.HasPrefix(pdf[offset:], want) {
+			t.Errorf("xref entry %d points at %q", i+1, pdf[offset:offset+8])
+		}
+	}
+}
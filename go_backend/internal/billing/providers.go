@@ -0,0 +1,482 @@
+package billing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Provider names used in PAYMENT_PROVIDER and recorded on each purchase
+const (
+	ProviderStripe = "stripe"
+	ProviderFake   = "fake"
+)
+
+// Payment event kinds. Provider events that do not change a purchase have
+// an empty kind and are acknowledged without action.
+const (
+	EventPaid    = "paid"
+	EventFailed  = "failed"
+	EventExpired = "expired"
+)
+
+// webhookTolerance is how old a signed webhook may be before it is rejected as a replay
+const webhookTolerance = 5 * time.Minute
+
+// PaymentProvider takes payments through a hosted checkout page and reports
+// their outcome through signed webhooks
+type PaymentProvider interface {
+	Name() string
+	// CreateCheckout starts a hosted checkout for a purchase
+	CreateCheckout(ctx context.Context, req CheckoutRequest) (*CheckoutSession, error)
+	// ParseWebhook verifies the signature of a webhook request and returns its event
+	ParseWebhook(payload []byte, header http.Header) (*PaymentEvent, error)
+	// ChargeSaved charges the payment method a customer saved at checkout,
+	// without them present, and returns the paid event
+	ChargeSaved(ctx context.Context, req ChargeRequest) (*PaymentEvent, error)
+}
+
+// CheckoutRequest describes what the customer pays for
+type CheckoutRequest struct {
+	PurchaseID    string
+	Description   string // product name shown at checkout
+	AmountCents   int64
+	Currency      string
+	CustomerEmail string
+	CustomerID    string // customer of an earlier purchase; a new one is created when empty
+	SuccessURL    string
+	CancelURL     string
+}
+
+// ChargeRequest describes an off-session payment for a purchase
+type ChargeRequest struct {
+	PurchaseID  string
+	Description string
+	AmountCents int64
+	Currency    string
+	CustomerID  string
+}
+
+// CheckoutSession is a checkout started with a provider
+type CheckoutSession struct {
+	ID        string
+	URL       string
+	ExpiresAt *time.Time
+}
+
+// PaymentEvent is a verified webhook event
+type PaymentEvent struct {
+	ID          string `json:"id"`
+	Kind        string `json:"kind"` // EventPaid, EventFailed, EventExpired or empty
+	Type        string `json:"type"` // provider event type
+	SessionID   string `json:"session_id"`
+	PurchaseID  string `json:"purchase_id"`
+	PaymentID   string `json:"payment_id"`
+	AmountCents int64  `json:"amount_cents"`
+	Currency    string `json:"currency"`
+	CustomerID  string `json:"customer_id"` // customer the payment method is saved for
+}
+
+// signPayload returns the hex HMAC-SHA256 of "timestamp.payload", the
+// scheme of Stripe webhook signatures
+func signPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignatureHeader checks a "t=<unix time>,v1=<signature>" header. Any
+// v1 entry may match, so secrets can be rotated.
+func verifySignatureHeader(secret, header string, payload []byte, now time.Time) error {
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return fmt.Errorf("malformed signature header")
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return fmt.Errorf("signature timestamp outside tolerance")
+	}
+
+	expected := signPayload(secret, timestamp, payload)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature mismatch")
+}
+
+const stripeAPIBaseURL = "https://api.stripe.com/v1"
+
+// StripeProvider takes payments through Stripe Checkout
+type StripeProvider struct {
+	secretKey     string
+	webhookSecret string
+	baseURL       string
+	client        *http.Client
+}
+
+// NewStripeProvider creates a Stripe provider. webhookSecret is the signing
+// secret (whsec_...) of the webhook endpoint.
+func NewStripeProvider(secretKey, webhookSecret string, client *http.Client) *StripeProvider {
+	return &StripeProvider{secretKey: secretKey, webhookSecret: webhookSecret, baseURL: stripeAPIBaseURL, client: client}
+}
+
+// Name implements PaymentProvider
+func (s *StripeProvider) Name() string { return ProviderStripe }
+
+// stripeCheckoutSession is the subset of a Stripe Checkout Session we use.
+// payment_intent events decode their PaymentIntent into it too.
+type stripeCheckoutSession struct {
+	ID                string            `json:"id"`
+	URL               string            `json:"url"`
+	ExpiresAt         int64             `json:"expires_at"`
+	ClientReferenceID string            `json:"client_reference_id"`
+	Metadata          map[string]string `json:"metadata"`
+	PaymentIntent     json.RawMessage   `json:"payment_intent"` // ID, or the object when expanded
+	Customer          json.RawMessage   `json:"customer"`       // ID, or the object when expanded
+	PaymentStatus     string            `json:"payment_status"`
+	AmountTotal       int64             `json:"amount_total"`
+	Amount            int64             `json:"amount"` // PaymentIntent amount
+	Currency          string            `json:"currency"`
+	Error             *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// CreateCheckout implements PaymentProvider
+func (s *StripeProvider) CreateCheckout(ctx context.Context, req CheckoutRequest) (*CheckoutSession, error) {
+	form := url.Values{
+		"mode":                                   {"payment"},
+		"success_url":                            {req.SuccessURL},
+		"cancel_url":                             {req.CancelURL},
+		"client_reference_id":                    {req.PurchaseID},
+		"metadata[purchase_id]":                  {req.PurchaseID},
+		"line_items[0][quantity]":                {"1"},
+		"line_items[0][price_data][currency]":    {req.Currency},
+		"line_items[0][price_data][unit_amount]": {strconv.FormatInt(req.AmountCents, 10)},
+		"line_items[0][price_data][product_data][name]": {req.Description},
+	}
+	// Save the payment method so auto-recharge can charge it later
+	form.Set("payment_intent_data[setup_future_usage]", "off_session")
+	if req.CustomerID != "" {
+		form.Set("customer", req.CustomerID)
+	} else {
+		form.Set("customer_creation", "always")
+		if req.CustomerEmail != "" {
+			form.Set("customer_email", req.CustomerEmail)
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+s.secretKey)
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// Retried requests for the same purchase return the same session
+	httpReq.Header.Set("Idempotency-Key", "checkout-"+req.PurchaseID)
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var session stripeCheckoutSession
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, fmt.Errorf("stripe returned status %d with an unreadable body: %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode >= 300 {
+		if session.Error != nil {
+			return nil, fmt.Errorf("stripe returned status %d: %s (%s)", resp.StatusCode, session.Error.Message, session.Error.Type)
+		}
+		return nil, fmt.Errorf("stripe returned status %d", resp.StatusCode)
+	}
+
+	checkout := &CheckoutSession{ID: session.ID, URL: session.URL}
+	if session.ExpiresAt > 0 {
+		expiresAt := time.Unix(session.ExpiresAt, 0).UTC()
+		checkout.ExpiresAt = &expiresAt
+	}
+	return checkout, nil
+}
+
+// ParseWebhook implements PaymentProvider for Checkout Session events
+func (s *StripeProvider) ParseWebhook(payload []byte, header http.Header) (*PaymentEvent, error) {
+	if err := verifySignatureHeader(s.webhookSecret, header.Get("Stripe-Signature"), payload, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var event struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object stripeCheckoutSession `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid event: %v", err)
+	}
+
+	session := event.Data.Object
+	result := &PaymentEvent{
+		ID:          event.ID,
+		Type:        event.Type,
+		SessionID:   session.ID,
+		PurchaseID:  session.ClientReferenceID,
+		PaymentID:   expandableID(session.PaymentIntent),
+		AmountCents: session.AmountTotal,
+		Currency:    session.Currency,
+		CustomerID:  expandableID(session.Customer),
+	}
+	if result.PurchaseID == "" {
+		result.PurchaseID = session.Metadata["purchase_id"]
+	}
+
+	switch event.Type {
+	case "checkout.session.completed":
+		// Delayed payment methods complete the session before the money arrives
+		if session.PaymentStatus == "paid" || session.PaymentStatus == "no_payment_required" {
+			result.Kind = EventPaid
+		}
+	case "checkout.session.async_payment_succeeded":
+		result.Kind = EventPaid
+	case "checkout.session.async_payment_failed":
+		result.Kind = EventFailed
+	case "checkout.session.expired":
+		result.Kind = EventExpired
+	case "payment_intent.succeeded", "payment_intent.payment_failed":
+		// Only auto-recharge charges carry their purchase on the PaymentIntent;
+		// checkout payments are handled by the session events
+		if session.Metadata["purchase_id"] == "" {
+			break
+		}
+		result.SessionID, result.PaymentID, result.AmountCents = "", session.ID, session.Amount
+		result.Kind = EventPaid
+		if event.Type == "payment_intent.payment_failed" {
+			result.Kind = EventFailed
+		}
+	}
+	return result, nil
+}
+
+// expandableID returns the ID of a Stripe field holding an ID, or the object
+// when expanded
+func expandableID(raw json.RawMessage) string {
+	var id string
+	if json.Unmarshal(raw, &id) == nil {
+		return id
+	}
+	var object struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(raw, &object)
+	return object.ID
+}
+
+// stripePaymentIntent is the subset of a Stripe PaymentIntent we use
+type stripePaymentIntent struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Error    *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// stripeRequest sends a form-encoded request to the Stripe API and decodes
+// the response into out
+func (s *StripeProvider) stripeRequest(ctx context.Context, method, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	var body io.Reader
+	if method != http.MethodGet {
+		body = strings.NewReader(form.Encode())
+	} else if len(form) > 0 {
+		path += "?" + form.Encode()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+s.secretKey)
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		httpReq.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+				Type    string `json:"type"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(raw, &failure) == nil && failure.Error.Message != "" {
+			return fmt.Errorf("stripe returned status %d: %s (%s)", resp.StatusCode, failure.Error.Message, failure.Error.Type)
+		}
+		return fmt.Errorf("stripe returned status %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("stripe returned an unreadable body: %v", err)
+	}
+	return nil
+}
+
+// ChargeSaved implements PaymentProvider with an off-session PaymentIntent on
+// the customer's most recent card
+func (s *StripeProvider) ChargeSaved(ctx context.Context, req ChargeRequest) (*PaymentEvent, error) {
+	var methods struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	err := s.stripeRequest(ctx, http.MethodGet, "/payment_methods", url.Values{
+		"customer": {req.CustomerID},
+		"type":     {"card"},
+		"limit":    {"1"},
+	}, "", &methods)
+	if err != nil {
+		return nil, err
+	}
+	if len(methods.Data) == 0 {
+		return nil, fmt.Errorf("customer %s has no saved card", req.CustomerID)
+	}
+
+	var intent stripePaymentIntent
+	err = s.stripeRequest(ctx, http.MethodPost, "/payment_intents", url.Values{
+		"amount":                {strconv.FormatInt(req.AmountCents, 10)},
+		"currency":              {req.Currency},
+		"customer":              {req.CustomerID},
+		"payment_method":        {methods.Data[0].ID},
+		"off_session":           {"true"},
+		"confirm":               {"true"},
+		"description":           {req.Description},
+		"metadata[purchase_id]": {req.PurchaseID},
+	}, "charge-"+req.PurchaseID, &intent)
+	if err != nil {
+		return nil, err
+	}
+	if intent.Status != "succeeded" {
+		return nil, fmt.Errorf("payment %s is %s", intent.ID, intent.Status)
+	}
+
+	return &PaymentEvent{
+		ID:          "charge_" + intent.ID,
+		Kind:        EventPaid,
+		Type:        "payment_intent.succeeded",
+		PurchaseID:  req.PurchaseID,
+		PaymentID:   intent.ID,
+		AmountCents: intent.Amount,
+		Currency:    intent.Currency,
+		CustomerID:  req.CustomerID,
+	}, nil
+}
+
+// FakePaymentHeader carries the signature of fake provider webhooks
+const FakePaymentHeader = "X-Fake-Payment-Signature"
+
+// FakeProvider stands in for a payment provider in development. Its checkout
+// URL points at FakeCheckoutHandler, which pays the purchase by sending a
+// signed webhook through the same path as real providers.
+type FakeProvider struct {
+	secret string
+}
+
+// NewFakeProvider creates a fake provider signing webhooks with secret, or a
+// random secret when empty
+func NewFakeProvider(secret string) *FakeProvider {
+	if secret == "" {
+		secret = randomHex(32)
+	}
+	return &FakeProvider{secret: secret}
+}
+
+// Name implements PaymentProvider
+func (f *FakeProvider) Name() string { return ProviderFake }
+
+// CreateCheckout implements PaymentProvider
+func (f *FakeProvider) CreateCheckout(ctx context.Context, req CheckoutRequest) (*CheckoutSession, error) {
+	id := "fake_cs_" + randomHex(12)
+	expiresAt := time.Now().Add(24 * time.Hour).UTC()
+	return &CheckoutSession{ID: id, URL: "/api/billing/fake-checkout/" + id, ExpiresAt: &expiresAt}, nil
+}
+
+// ParseWebhook implements PaymentProvider
+func (f *FakeProvider) ParseWebhook(payload []byte, header http.Header) (*PaymentEvent, error) {
+	if err := verifySignatureHeader(f.secret, header.Get(FakePaymentHeader), payload, time.Now()); err != nil {
+		return nil, err
+	}
+	var event PaymentEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid event: %v", err)
+	}
+	return &event, nil
+}
+
+// ChargeSaved implements PaymentProvider; fake charges always succeed
+func (f *FakeProvider) ChargeSaved(ctx context.Context, req ChargeRequest) (*PaymentEvent, error) {
+	return &PaymentEvent{
+		ID:          "fake_evt_" + randomHex(12),
+		Kind:        EventPaid,
+		Type:        "charge.succeeded",
+		PurchaseID:  req.PurchaseID,
+		PaymentID:   "fake_pay_" + randomHex(8),
+		AmountCents: req.AmountCents,
+		Currency:    req.Currency,
+		CustomerID:  req.CustomerID,
+	}, nil
+}
+
+// SignedEvent encodes and signs an event as the fake provider's webhook would
+func (f *FakeProvider) SignedEvent(event PaymentEvent) ([]byte, http.Header, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, nil, err
+	}
+	timestamp := time.Now().Unix()
+	header := http.Header{}
+	header.Set(FakePaymentHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, signPayload(f.secret, timestamp, payload)))
+	return payload, header, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
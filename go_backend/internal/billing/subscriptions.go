@@ -0,0 +1,100 @@
+package billing
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// ChangeSubscriptionHandler handles PUT /api/auth/subscription. Downgrades
+// are scheduled for the end of the paid period right away; upgrades with a
+// charge open a checkout for it and apply once the provider's webhook
+// reports the payment (202 with the checkout_url).
+func ChangeSubscriptionHandler(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	req, ok := auth.BindChangeSubscriptionRequest(c)
+	if !ok {
+		return
+	}
+
+	if !requireBillingDatabase(c) {
+		return
+	}
+
+	change, err := auth.ChangeSubscriptionPlan(userID, req.PlanID, req.BillingCycle)
+	if err != nil {
+		auth.SubscriptionErrorResponse(c, "ChangeSubscriptionHandler", err)
+		return
+	}
+
+	auth.SetAuditResource(c, change.Subscription.ID)
+	auth.SetAuditMetadata(c, "change", change.Change)
+	auth.SetAuditMetadata(c, "plan_id", req.PlanID)
+	auth.SetAuditMetadata(c, "billing_cycle", req.BillingCycle)
+	auth.SetAuditMetadata(c, "prorated_credits", change.ProratedCredits)
+	if change.Change != auth.PlanChangePaymentRequired {
+		c.JSON(http.StatusOK, change)
+		return
+	}
+
+	p := currentProvider()
+	customerID, err := savedCustomer(userID, nil, p.Name())
+	if err != nil {
+		// A new customer is created at checkout
+		log.Printf("⚠️ ChangeSubscriptionHandler: Failed to look up saved customer for user %s: %v", userID, err)
+	}
+	purchase, err := insertPurchase(map[string]interface{}{
+		"user_id":       userID,
+		"plan_id":       req.PlanID,
+		"billing_cycle": req.BillingCycle,
+		"credits":       change.ProratedCredits,
+		"amount_cents":  change.ChargeCents,
+		"currency":      "usd",
+		"provider":      p.Name(),
+		"source":        SourcePlanUpgrade,
+		"status":        PurchasePending,
+	})
+	if err != nil {
+		log.Printf("❌ ChangeSubscriptionHandler: Failed to create purchase: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create purchase",
+			"code":  "DATABASE_ERROR",
+		})
+		return
+	}
+
+	session, ok := startCheckout(c, "ChangeSubscriptionHandler", p, purchase,
+		fmt.Sprintf("Upgrade to the %s plan (%s)", change.PlanName, req.BillingCycle), customerID)
+	if !ok {
+		return
+	}
+
+	log.Printf("🛒 ChangeSubscriptionHandler: User %s started purchase %s of the upgrade to plan %d (%s) via %s",
+		userID, purchase.ID, req.PlanID, req.BillingCycle, p.Name())
+	auth.SetAuditMetadata(c, "purchase_id", purchase.ID)
+	auth.SetAuditMetadata(c, "charge_cents", purchase.AmountCents)
+	c.JSON(http.StatusAccepted, gin.H{
+		"change":           change.Change,
+		"subscription":     change.Subscription,
+		"plan_name":        change.PlanName,
+		"prorated_credits": change.ProratedCredits,
+		"charge_cents":     purchase.AmountCents,
+		"currency":         purchase.Currency,
+		"purchase_id":      purchase.ID,
+		"provider":         p.Name(),
+		"session_id":       session.ID,
+		"checkout_url":     session.URL,
+		"expires_at":       session.ExpiresAt,
+	})
+}
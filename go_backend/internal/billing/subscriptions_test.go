@@ -0,0 +1,44 @@
+package billing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestChangeSubscriptionHandlerValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		body string
+		code string
+	}{
+		{`{}`, "INVALID_REQUEST"},
+		{`{"plan_id": 2, "billing_cycle": "weekly"}`, "INVALID_BILLING_CYCLE"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPut, "/api/auth/subscription", strings.NewReader(tt.body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Set("user_id", "user-1")
+
+		ChangeSubscriptionHandler(c)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.code) {
+			t.Errorf("body %s: got %d %s, want 400 %s", tt.body, w.Code, w.Body.String(), tt.code)
+		}
+	}
+
+	// Without billing, plan changes are refused rather than applied unpaid
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPut, "/api/auth/subscription", strings.NewReader(`{"plan_id": 3}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", "user-1")
+	ChangeSubscriptionHandler(c)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d %s without billing, want 503", w.Code, w.Body.String())
+	}
+}
@@ -1,12 +1,17 @@
 package business_risk
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/notifications"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/pricing"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/webhooks"
 	"github.com/gin-gonic/gin"
 	supa "github.com/nedpals/supabase-go"
 )
@@ -42,6 +47,7 @@ type BusinessRiskRequest struct {
 	Industry       string `json:"industry"`
 	Geography      string `json:"geography"`
 	AssessmentType string `json:"assessment_type"`
+	Checks         []string `json:"checks"` // optional checks not included in the assessment type
 }
 
 // BusinessRiskInsights represents aggregated insights about business risk assessments
@@ -69,6 +75,9 @@ type RiskCategory struct {
 // Database connection
 var supabaseClient *supa.Client
 
+// errAssessmentNotFound is returned when no assessment row matches the requested ID
+var errAssessmentNotFound = errors.New("assessment not found")
+
 // InitDatabase initializes the Supabase connection for Business Risk assessments
 func InitDatabase(url, key string) error {
 	client := supa.CreateClient(url, key)
@@ -95,6 +104,7 @@ type SupabaseAssessment struct {
 	CreatedBy             string                 `json:"created_by" db:"created_by"`
 	UpdatedBy             string                 `json:"updated_by" db:"updated_by"`
 	UserID                string                 `json:"user_id" db:"user_id"`
+	OrgID                 *string                `json:"org_id" db:"org_id"` // owning organization, nil for personal assessments
 	CreditsConsumed       int                    `json:"credits_consumed" db:"credits_consumed"`
 	AssessmentCost        *float64               `json:"assessment_cost" db:"assessment_cost"`
 	AssessmentType        string                 `json:"assessment_type" db:"assessment_type"`
@@ -122,6 +132,11 @@ func CreateBusinessRiskAssessmentHandler(c *gin.Context) {
 		return
 	}
 
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
 	// Ensure database connection exists
 	if supabaseClient == nil {
 		log.Printf("❌ No database connection available")
@@ -143,6 +158,13 @@ func CreateBusinessRiskAssessmentHandler(c *gin.Context) {
 		req.AssessmentType = "Quick Scan"
 	}
 
+	quote, err := pricing.QuoteForWorkspace(workspace, pricing.ProductBusinessRisk, req.AssessmentType, req.Checks)
+	if err != nil {
+		pricing.RespondQuoteError(c, "CreateBusinessRiskAssessmentHandler", err)
+		return
+	}
+	req.AssessmentType = quote.AssessmentName
+
 	// Create assessment data for Supabase - structure that matches the assessments table
 	assessmentData := map[string]interface{}{
 		"website":              req.Domain,
@@ -150,23 +172,25 @@ func CreateBusinessRiskAssessmentHandler(c *gin.Context) {
 		"status":               "processing",
 		"assessment_type":      req.AssessmentType,
 		"user_id":              userID,
+		"org_id":               workspace.OrgIDPtr(),
 		"created_by":           userID,
 		"updated_by":           userID,
-		"credits_consumed":     getCreditsForAssessmentType(req.AssessmentType),
-		"assessment_cost":      getAssessmentCost(req.AssessmentType),
+		"credits_consumed":     quote.Credits,
+		"assessment_cost":      quote.Cost,
 		"assessment_data":      map[string]interface{}{
 			"business_name":   req.BusinessName,
 			"domain":          req.Domain,
 			"industry":        req.Industry,
 			"geography":       req.Geography,
 			"assessment_type": req.AssessmentType,
+			"pricing":         quote,
 			"request_time":    time.Now().UTC(),
 		},
 	}
 
 	// Insert into Supabase assessments table
 	var insertedAssessment []SupabaseAssessment
-	err := supabaseClient.DB.From("assessments").Insert(assessmentData).Execute(&insertedAssessment)
+	err = supabaseClient.DB.From("assessments").Insert(assessmentData).Execute(&insertedAssessment)
 	if err != nil {
 		log.Printf("❌ Failed to create assessment in Supabase: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -195,34 +219,11 @@ func CreateBusinessRiskAssessmentHandler(c *gin.Context) {
 
 	// Start background processing based on assessment type
 	go processAssessmentInBackground(insertedAssessment[0].ID, req.AssessmentType, req.Domain, userID)
+	auth.SetAuditResource(c, strconv.FormatInt(insertedAssessment[0].ID, 10))
 
 	c.JSON(http.StatusCreated, response)
 }
 
-// Helper function to get credits required for assessment type
-func getCreditsForAssessmentType(assessmentType string) int {
-	switch assessmentType {
-	case "Comprehensive":
-		return 3
-	case "Quick Scan":
-		return 1
-	default:
-		return 1
-	}
-}
-
-// Helper function to get cost for assessment type
-func getAssessmentCost(assessmentType string) float64 {
-	switch assessmentType {
-	case "Comprehensive":
-		return 0.030 // 3 credits at $0.01 each
-	case "Quick Scan":
-		return 0.010 // 1 credit at $0.01 each
-	default:
-		return 0.010
-	}
-}
-
 // Background processing function
 func processAssessmentInBackground(assessmentID int64, assessmentType, domain, userID string) {
 	log.Printf("🔄 Starting background processing for assessment %d (type: %s)", assessmentID, assessmentType)
@@ -265,11 +266,52 @@ func processAssessmentInBackground(assessmentID int64, assessmentType, domain, u
 	err := supabaseClient.DB.From("assessments").Update(updateData).Eq("id", fmt.Sprintf("%d", assessmentID)).Execute(&updatedAssessment)
 	if err != nil {
 		log.Printf("❌ Failed to update assessment %d: %v", assessmentID, err)
+		webhooks.Publish(userID, webhooks.EventAssessmentFailed, map[string]interface{}{
+			"assessment_id":   assessmentID,
+			"source":          "business_risk_prevention",
+			"domain":          domain,
+			"assessment_type": assessmentType,
+			"status":          "failed",
+			"error_message":   err.Error(),
+		})
+		notifications.Notify(notifications.Event{
+			Type:         notifications.EventAssessmentFailed,
+			UserID:       userID,
+			AssessmentID: assessmentID,
+			Source:       "business_risk_prevention",
+			Website:      domain,
+			Status:       "failed",
+			ErrorMessage: err.Error(),
+			LinkPath:     fmt.Sprintf("/business-risk-prevention/assessments/%d", assessmentID),
+		})
 		return
 	}
 	
 	log.Printf("✅ Assessment %d completed - Type: %s, Score: %.1f, Category: %s", 
 		assessmentID, assessmentType, riskScore, riskCategory)
+
+	webhooks.Publish(userID, webhooks.EventAssessmentCompleted, map[string]interface{}{
+		"assessment_id":   assessmentID,
+		"source":          "business_risk_prevention",
+		"domain":          domain,
+		"assessment_type": assessmentType,
+		"status":          "completed",
+		"risk_score":      int(riskScore),
+		"risk_category":   riskCategory,
+	})
+
+	score := int(riskScore)
+	notifications.Notify(notifications.Event{
+		Type:         notifications.EventAssessmentCompleted,
+		UserID:       userID,
+		AssessmentID: assessmentID,
+		Source:       "business_risk_prevention",
+		Website:      domain,
+		Status:       "completed",
+		RiskScore:    &score,
+		RiskCategory: riskCategory,
+		LinkPath:     fmt.Sprintf("/business-risk-prevention/assessments/%d", assessmentID),
+	})
 }
 
 // Generate realistic risk score based on domain and assessment type
@@ -375,8 +417,13 @@ func ListBusinessRiskAssessmentsHandler(c *gin.Context) {
 		return
 	}
 
-	// Fetch from Supabase for this user only
-	assessments, err := fetchAssessmentsFromSupabase(userID)
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	// Fetch from Supabase for this workspace only
+	assessments, err := fetchAssessmentsFromSupabase(workspace)
 	if err != nil {
 		log.Printf("❌ Failed to fetch assessments from Supabase: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -392,39 +439,12 @@ func ListBusinessRiskAssessmentsHandler(c *gin.Context) {
 
 // GetBusinessRiskAssessmentHandler handles GET /api/business-risk-prevention/assessments/:id
 func GetBusinessRiskAssessmentHandler(c *gin.Context) {
-	id := c.Param("id")
-	
-	// Ensure database connection exists
-	if supabaseClient == nil {
-		log.Printf("❌ No database connection available")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Database connection not available",
-			"code":  "DATABASE_CONNECTION_ERROR",
-		})
+	assessment, ok := loadAuthorizedAssessment(c, auth.PermAssessmentsRead)
+	if !ok {
 		return
 	}
 
-	// Fetch from Supabase
-	assessments, err := fetchAssessmentsFromSupabase("")
-	if err != nil {
-		log.Printf("❌ Failed to fetch assessments from Supabase: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch assessment from database",
-			"code":  "DATABASE_FETCH_ERROR",
-			"details": err.Error(),
-		})
-		return
-	}
-
-	// Find the specific assessment
-	for _, assessment := range assessments {
-		if assessment.ID == id {
-			c.JSON(http.StatusOK, assessment)
-			return
-		}
-	}
-	
-	c.JSON(http.StatusNotFound, gin.H{"error": "Assessment not found"})
+	c.JSON(http.StatusOK, convertSupabaseToBusinessRisk(*assessment))
 }
 
 // UpdateBusinessRiskAssessmentHandler handles PUT /api/business-risk-prevention/assessments/:id
@@ -473,8 +493,13 @@ func GetBusinessRiskInsightsHandler(c *gin.Context) {
 		return
 	}
 
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
 	// Fetch real assessments for insights
-	assessments, err := fetchAssessmentsFromSupabase(userID)
+	assessments, err := fetchAssessmentsFromSupabase(workspace)
 	if err != nil {
 		log.Printf("❌ Failed to fetch assessments for insights: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -524,8 +549,23 @@ func ExportBusinessRiskAssessmentsCSVHandler(c *gin.Context) {
 		return
 	}
 
-	// Fetch real assessments
-	assessments, err := fetchAssessmentsFromSupabase("")
+	// Get authenticated user ID
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	// Fetch this workspace's assessments only
+	assessments, err := fetchAssessmentsFromSupabase(workspace)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to fetch assessment data for export",
@@ -563,79 +603,123 @@ func ExportBusinessRiskAssessmentsCSVHandler(c *gin.Context) {
 
 // ExportBusinessRiskAssessmentPDFHandler handles GET /api/business-risk-prevention/assessments/:id/export/pdf
 func ExportBusinessRiskAssessmentPDFHandler(c *gin.Context) {
+	record, ok := loadAuthorizedAssessment(c, auth.PermAssessmentsExport)
+	if !ok {
+		return
+	}
+
+	assessment := convertSupabaseToBusinessRisk(*record)
+
+	// Generate PDF content
+	pdfContent := fmt.Sprintf("Business Risk Assessment Report\n\nBusiness: %s\nDomain: %s\nRisk Score: %.1f\nRisk Level: %s\nStatus: %s\nDate Created: %s\nIndustry: %s\nGeography: %s\nAssessment Type: %s\n\nFindings:\nCritical Issues: %d\nWarnings: %d\nRecommendations: %d",
+		assessment.BusinessName,
+		assessment.Domain,
+		assessment.RiskScore,
+		assessment.RiskLevel,
+		assessment.Status,
+		assessment.DateCreated.Format("2006-01-02"),
+		assessment.Industry,
+		assessment.Geography,
+		assessment.AssessmentType,
+		assessment.Findings.CriticalIssues,
+		assessment.Findings.Warnings,
+		assessment.Findings.Recommendations,
+	)
+
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-risk-assessment.pdf", assessment.BusinessName))
+	c.String(http.StatusOK, pdfContent)
+}
+
+// RerunBusinessRiskAssessmentHandler handles POST /api/business-risk-prevention/assessments/:id/rerun
+func RerunBusinessRiskAssessmentHandler(c *gin.Context) {
+	c.JSON(http.StatusNotImplemented, gin.H{
+		"error": "Assessment rerun not yet implemented",
+		"message": "Use the website risk assessment API to rerun assessments",
+	})
+}
+
+// loadAuthorizedAssessment fetches the assessment named by the :id path parameter
+// and checks that the caller may access it with permission. On failure the
+// error response has already been written and false is returned.
+func loadAuthorizedAssessment(c *gin.Context, permission string) (*SupabaseAssessment, bool) {
 	id := c.Param("id")
-	
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid assessment ID",
+			"code":  "INVALID_ASSESSMENT_ID",
+		})
+		return nil, false
+	}
+
 	// Ensure database connection exists
 	if supabaseClient == nil {
+		log.Printf("❌ No database connection available")
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Database connection not available",
 			"code":  "DATABASE_CONNECTION_ERROR",
 		})
-		return
+		return nil, false
 	}
 
-	// Fetch assessments
-	assessments, err := fetchAssessmentsFromSupabase("")
+	assessment, err := fetchAssessmentByID(id)
+	if errors.Is(err, errAssessmentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Assessment not found",
+			"code":  "ASSESSMENT_NOT_FOUND",
+		})
+		return nil, false
+	}
 	if err != nil {
+		log.Printf("❌ Failed to fetch assessment %s from Supabase: %v", id, err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch assessment data for PDF export",
+			"error": "Failed to fetch assessment from database",
 			"code":  "DATABASE_FETCH_ERROR",
-			"details": err.Error(),
 		})
-		return
+		return nil, false
 	}
 
-	// Find the specific assessment
-	for _, assessment := range assessments {
-		if assessment.ID == id {
-			// Generate PDF content
-			pdfContent := fmt.Sprintf("Business Risk Assessment Report\n\nBusiness: %s\nDomain: %s\nRisk Score: %.1f\nRisk Level: %s\nStatus: %s\nDate Created: %s\nIndustry: %s\nGeography: %s\nAssessment Type: %s\n\nFindings:\nCritical Issues: %d\nWarnings: %d\nRecommendations: %d",
-				assessment.BusinessName,
-				assessment.Domain,
-				assessment.RiskScore,
-				assessment.RiskLevel,
-				assessment.Status,
-				assessment.DateCreated.Format("2006-01-02"),
-				assessment.Industry,
-				assessment.Geography,
-				assessment.AssessmentType,
-				assessment.Findings.CriticalIssues,
-				assessment.Findings.Warnings,
-				assessment.Findings.Recommendations,
-			)
-			
-			c.Header("Content-Type", "application/pdf")
-			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-risk-assessment.pdf", assessment.BusinessName))
-			c.String(http.StatusOK, pdfContent)
-			return
-		}
+	orgID := ""
+	if assessment.OrgID != nil {
+		orgID = *assessment.OrgID
 	}
-	
-	c.JSON(http.StatusNotFound, gin.H{"error": "Assessment not found"})
+	if !auth.AuthorizeAssessmentPermission(c, assessment.UserID, orgID, permission) {
+		return nil, false
+	}
+
+	return assessment, true
 }
 
-// RerunBusinessRiskAssessmentHandler handles POST /api/business-risk-prevention/assessments/:id/rerun
-func RerunBusinessRiskAssessmentHandler(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{
-		"error": "Assessment rerun not yet implemented",
-		"message": "Use the website risk assessment API to rerun assessments",
-	})
+// fetchAssessmentByID fetches a single assessment row by primary key
+func fetchAssessmentByID(id string) (*SupabaseAssessment, error) {
+	var results []SupabaseAssessment
+	err := supabaseClient.DB.From("assessments").
+		Select("*").
+		Eq("id", id).
+		Execute(&results)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch assessment: %v", err)
+	}
+
+	if len(results) == 0 {
+		return nil, errAssessmentNotFound
+	}
+
+	return &results[0], nil
 }
 
-// fetchAssessmentsFromSupabase fetches all assessments from Supabase and converts them
-func fetchAssessmentsFromSupabase(userID string) ([]BusinessRiskAssessment, error) {
-	var supabaseAssessments []SupabaseAssessment
-	
-	// Fetch only assessments for the authenticated user
-	var query = supabaseClient.DB.From("assessments").Select("*").Eq("user_id", userID)
-	
-	// Only add user filter if userID is provided
-	if userID != "" {
-		query = query.Eq("user_id", userID)
+// fetchAssessmentsFromSupabase fetches a workspace's assessments from Supabase and converts them
+func fetchAssessmentsFromSupabase(workspace auth.Workspace) ([]BusinessRiskAssessment, error) {
+	userID := workspace.UserID
+	if userID == "" {
+		return nil, fmt.Errorf("user ID is required to fetch assessments")
 	}
-	
-	err := query.Execute(&supabaseAssessments)
-		
+
+	var supabaseAssessments []SupabaseAssessment
+	err := workspace.Scope(supabaseClient.DB.From("assessments").Select("*")).
+		Execute(&supabaseAssessments)
+
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch assessments: %v", err)
 	}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -34,6 +35,14 @@ type Config struct {
 	SupabaseURL        string
 	SupabaseServiceKey string
 	SupabaseAnonKey    string
+	SupabaseJWTSecret  string
+	SupabaseJWKSURL    string
+
+	// Access token verification
+	AuthJWTIssuer      string
+	AuthJWTAudience    string
+	AuthRemoteFallback bool
+	AuthJWKSCacheTTL   int // seconds
 
 	// AWS configuration
 	AWSRegion          string
@@ -41,10 +50,54 @@ type Config struct {
 	AWSSecretAccessKey string
 	EnableSMS          bool
 
+	// SMS delivery
+	SMSProviders            []string // failover order: sns, twilio, file, http, log
+	SMSSenderID             string
+	SMSSinkFile             string // development sink used when ENABLE_SMS is false
+	SMSSinkURL              string
+	TwilioAccountSID        string
+	TwilioAuthToken         string
+	TwilioFrom              string
+	TwilioStatusCallbackURL string
+
 	// Redis configuration
 	RedisHost string
 	RedisPort string
 
+	// Rate limiting
+	RateLimitEnabled bool
+	RateLimitStore   string // memory or redis
+
+	// Salesforce configuration
+	SalesforceAuthFlow     string
+	SalesforceLoginURL     string
+	SalesforceInstanceURL  string
+	SalesforceAPIVersion   string
+	SalesforceClientID     string
+	SalesforceClientSecret string
+	SalesforceUsername     string
+	SalesforcePrivateKey   string
+	SalesforceObjectType   string
+	SalesforceFieldMap     map[string]string
+	SalesforceReportURL    string
+
+	// Notification configuration
+	AppBaseURL   string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// Payments
+	PaymentProvider          string // stripe or fake
+	StripeSecretKey          string
+	StripeWebhookSecret      string
+	PaymentFakeWebhookSecret string
+
+	// Assessment pricing
+	AssessmentCreditValueCents float64 // cost recorded per credit consumed
+
 	// Environment
 	Environment string
 }
@@ -69,8 +122,53 @@ func LoadConfig() (*Config, error) {
 		AWSRegion: getEnv("AWS_REGION", "us-east-1"),
 		EnableSMS: getEnvBool("ENABLE_SMS", false),
 
+		SMSProviders:            getEnvList("SMS_PROVIDERS", "sns"),
+		SMSSenderID:             getEnv("SMS_SENDER_ID", "QuarkfinAI"),
+		SMSSinkFile:             getEnv("SMS_SINK_FILE", ""),
+		SMSSinkURL:              getEnv("SMS_SINK_URL", ""),
+		TwilioAccountSID:        getEnv("TWILIO_ACCOUNT_SID", ""),
+		TwilioFrom:              getEnv("TWILIO_FROM", ""),
+		TwilioStatusCallbackURL: getEnv("TWILIO_STATUS_CALLBACK_URL", ""),
+
+		// Auth defaults
+		SupabaseJWKSURL:    getEnv("SUPABASE_JWKS_URL", ""),
+		AuthJWTIssuer:      getEnv("AUTH_JWT_ISSUER", ""),
+		AuthJWTAudience:    getEnv("AUTH_JWT_AUDIENCE", "authenticated"),
+		AuthRemoteFallback: getEnvBool("AUTH_REMOTE_FALLBACK", false),
+		AuthJWKSCacheTTL:   getEnvInt("AUTH_JWKS_CACHE_TTL_SECONDS", 600),
+
 		// Redis defaults
 		RedisPort: getEnv("REDIS_PORT", "6379"),
+
+		// Rate limiting defaults
+		RateLimitEnabled: getEnvBool("RATE_LIMIT_ENABLED", true),
+		RateLimitStore:   getEnv("RATE_LIMIT_STORE", "memory"),
+
+		// Salesforce defaults
+		SalesforceAuthFlow:    getEnv("SALESFORCE_AUTH_FLOW", "client_credentials"),
+		SalesforceLoginURL:    getEnv("SALESFORCE_LOGIN_URL", "https://login.salesforce.com"),
+		SalesforceInstanceURL: getEnv("SALESFORCE_INSTANCE_URL", ""),
+		SalesforceAPIVersion:  getEnv("SALESFORCE_API_VERSION", "v59.0"),
+		SalesforceClientID:    getEnv("SALESFORCE_CLIENT_ID", ""),
+		SalesforceUsername:    getEnv("SALESFORCE_USERNAME", ""),
+		SalesforceObjectType:  getEnv("SALESFORCE_OBJECT", "Opportunity"),
+		SalesforceFieldMap: getEnvMap("SALESFORCE_FIELD_MAP",
+			"qualification_status=Qualification_Status__c,risk_score=Risk_Score__c,risk_category=Risk_Category__c,report_link=Risk_Report_URL__c"),
+		SalesforceReportURL: getEnv("SALESFORCE_REPORT_URL", "https://app.quarkfinai.com/website-risk-assessment/assessments/{id}"),
+
+		// Notification defaults
+		AppBaseURL:   getEnv("APP_BASE_URL", "https://app.quarkfinai.com"),
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPFrom:     getEnv("SMTP_FROM", "QuarkFin Alerts <alerts@quarkfinai.com>"),
+
+		// Payment defaults
+		PaymentProvider:          getEnv("PAYMENT_PROVIDER", "fake"),
+		PaymentFakeWebhookSecret: getEnv("PAYMENT_FAKE_WEBHOOK_SECRET", ""),
+
+		// Pricing defaults
+		AssessmentCreditValueCents: getEnvFloat("ASSESSMENT_CREDIT_VALUE_CENTS", 1),
 	}
 
 	// Load configuration based on environment
@@ -103,11 +201,22 @@ func (c *Config) loadFromEnv() {
 	c.SupabaseURL = getEnv("SUPABASE_URL", "")
 	c.SupabaseServiceKey = getEnv("SUPABASE_SERVICE_KEY", "")
 	c.SupabaseAnonKey = getEnv("SUPABASE_ANON_KEY", "")
+	c.SupabaseJWTSecret = getEnv("SUPABASE_JWT_SECRET", "")
 
 	c.AWSAccessKeyID = getEnv("AWS_ACCESS_KEY_ID", "")
 	c.AWSSecretAccessKey = getEnv("AWS_SECRET_ACCESS_KEY", "")
 
 	c.RedisHost = getEnv("REDIS_HOST", "localhost")
+
+	c.SalesforceClientSecret = getEnv("SALESFORCE_CLIENT_SECRET", "")
+	c.SalesforcePrivateKey = getEnv("SALESFORCE_PRIVATE_KEY", "")
+
+	c.SMTPPassword = getEnv("SMTP_PASSWORD", "")
+
+	c.TwilioAuthToken = getEnv("TWILIO_AUTH_TOKEN", "")
+
+	c.StripeSecretKey = getEnv("STRIPE_SECRET_KEY", "")
+	c.StripeWebhookSecret = getEnv("STRIPE_WEBHOOK_SECRET", "")
 }
 
 func (c *Config) loadFromSSM() error {
@@ -125,12 +234,19 @@ func (c *Config) loadFromSSM() error {
 	// Define parameters to fetch
 	params := map[string]*string{
 		fmt.Sprintf("%s/database/postgres/password", paramPrefix): &c.PostgresPassword,
-		fmt.Sprintf("%s/database/mongodb/password", paramPrefix): &c.MongoPassword,
-		fmt.Sprintf("%s/supabase/url", paramPrefix):              &c.SupabaseURL,
-		fmt.Sprintf("%s/supabase/service_key", paramPrefix):      &c.SupabaseServiceKey,
-		fmt.Sprintf("%s/supabase/anon_key", paramPrefix):         &c.SupabaseAnonKey,
-		fmt.Sprintf("%s/aws/access_key_id", paramPrefix):         &c.AWSAccessKeyID,
-		fmt.Sprintf("%s/aws/secret_access_key", paramPrefix):     &c.AWSSecretAccessKey,
+		fmt.Sprintf("%s/database/mongodb/password", paramPrefix):  &c.MongoPassword,
+		fmt.Sprintf("%s/supabase/url", paramPrefix):               &c.SupabaseURL,
+		fmt.Sprintf("%s/supabase/service_key", paramPrefix):       &c.SupabaseServiceKey,
+		fmt.Sprintf("%s/supabase/anon_key", paramPrefix):          &c.SupabaseAnonKey,
+		fmt.Sprintf("%s/supabase/jwt_secret", paramPrefix):        &c.SupabaseJWTSecret,
+		fmt.Sprintf("%s/aws/access_key_id", paramPrefix):          &c.AWSAccessKeyID,
+		fmt.Sprintf("%s/aws/secret_access_key", paramPrefix):      &c.AWSSecretAccessKey,
+		fmt.Sprintf("%s/salesforce/client_secret", paramPrefix):   &c.SalesforceClientSecret,
+		fmt.Sprintf("%s/salesforce/private_key", paramPrefix):     &c.SalesforcePrivateKey,
+		fmt.Sprintf("%s/smtp/password", paramPrefix):              &c.SMTPPassword,
+		fmt.Sprintf("%s/twilio/auth_token", paramPrefix):          &c.TwilioAuthToken,
+		fmt.Sprintf("%s/stripe/secret_key", paramPrefix):          &c.StripeSecretKey,
+		fmt.Sprintf("%s/stripe/webhook_secret", paramPrefix):      &c.StripeWebhookSecret,
 	}
 
 	// Fetch parameters
@@ -217,3 +333,44 @@ func getEnvBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// getEnvMap parses a comma separated list of key=value pairs
+func getEnvMap(key, defaultValue string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(getEnv(key, defaultValue), ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) == 2 && parts[0] != "" {
+			result[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return result
+}
+
+// getEnvList parses a comma separated list
+func getEnvList(key, defaultValue string) []string {
+	var result []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
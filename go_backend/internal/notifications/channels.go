@@ -0,0 +1,186 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Channel delivers a rendered message to a target (an address or webhook URL)
+type Channel interface {
+	Name() string
+	ValidateTarget(target string) error
+	Send(ctx context.Context, target string, msg Message) error
+}
+
+// SMTPConfig holds the outbound mail server settings
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Enabled reports whether enough settings are present to send mail
+func (c SMTPConfig) Enabled() bool {
+	return c.Host != "" && c.From != ""
+}
+
+// EmailChannel sends plain text email over SMTP
+type EmailChannel struct {
+	cfg SMTPConfig
+}
+
+// NewEmailChannel creates an SMTP email channel
+func NewEmailChannel(cfg SMTPConfig) *EmailChannel {
+	if cfg.Port == "" {
+		cfg.Port = "587"
+	}
+	return &EmailChannel{cfg: cfg}
+}
+
+func (e *EmailChannel) Name() string { return ChannelEmail }
+
+func (e *EmailChannel) ValidateTarget(target string) error {
+	if _, err := mail.ParseAddress(target); err != nil {
+		return fmt.Errorf("invalid email address: %s", target)
+	}
+	return nil
+}
+
+func (e *EmailChannel) Send(ctx context.Context, target string, msg Message) error {
+	body := msg.Text
+	if msg.Link != "" {
+		body += "\n\nView assessment: " + msg.Link
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", e.cfg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", target)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if e.cfg.Username != "" {
+		auth = smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.cfg.Host)
+	}
+
+	// net/smtp has no context support; run it so cancellation is still honoured
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(e.cfg.Host+":"+e.cfg.Port, auth, e.cfg.From, []string{target}, buf.Bytes())
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SlackChannel posts to a Slack incoming webhook
+type SlackChannel struct {
+	httpClient *http.Client
+}
+
+// NewSlackChannel creates a Slack incoming webhook channel
+func NewSlackChannel(httpClient *http.Client) *SlackChannel {
+	return &SlackChannel{httpClient: httpClient}
+}
+
+func (s *SlackChannel) Name() string { return ChannelSlack }
+
+func (s *SlackChannel) ValidateTarget(target string) error {
+	return validateWebhookURL(target)
+}
+
+func (s *SlackChannel) Send(ctx context.Context, target string, msg Message) error {
+	text := fmt.Sprintf("*%s*\n%s", msg.Subject, msg.Text)
+	if msg.Link != "" {
+		text += fmt.Sprintf("\n<%s|View assessment>", msg.Link)
+	}
+	return postJSON(ctx, s.httpClient, target, map[string]interface{}{"text": text})
+}
+
+// TeamsChannel posts a MessageCard to a Microsoft Teams incoming webhook
+type TeamsChannel struct {
+	httpClient *http.Client
+}
+
+// NewTeamsChannel creates a Microsoft Teams incoming webhook channel
+func NewTeamsChannel(httpClient *http.Client) *TeamsChannel {
+	return &TeamsChannel{httpClient: httpClient}
+}
+
+func (t *TeamsChannel) Name() string { return ChannelTeams }
+
+func (t *TeamsChannel) ValidateTarget(target string) error {
+	return validateWebhookURL(target)
+}
+
+func (t *TeamsChannel) Send(ctx context.Context, target string, msg Message) error {
+	card := map[string]interface{}{
+		"@type":    "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary":  msg.Subject,
+		"title":    msg.Subject,
+		"text":     strings.ReplaceAll(msg.Text, "\n", "<br>"),
+	}
+	if msg.Link != "" {
+		card["potentialAction"] = []map[string]interface{}{{
+			"@type": "OpenUri",
+			"name":  "View assessment",
+			"targets": []map[string]string{
+				{"os": "default", "uri": msg.Link},
+			},
+		}}
+	}
+	return postJSON(ctx, t.httpClient, target, card)
+}
+
+// postJSON posts a JSON body and treats any non-2xx response as an error
+func postJSON(ctx context.Context, client *http.Client, target string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook responded with HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// validateWebhookURL requires an absolute https incoming webhook URL
+func validateWebhookURL(target string) error {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" || u.Scheme != "https" {
+		return fmt.Errorf("incoming webhook URL must be an absolute https URL")
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	supa "github.com/nedpals/supabase-go"
+)
+
+const (
+	sendTimeout         = 30 * time.Second
+	digestCheckInterval = 5 * time.Minute
+	maxDigestItems      = 200
+)
+
+// Settings configures the notification subsystem
+type Settings struct {
+	AppBaseURL string // base URL of the web app used for assessment links
+	SMTP       SMTPConfig
+}
+
+var (
+	supabaseClient *supa.Client
+	settings       Settings
+	channels       = map[string]Channel{}
+)
+
+// InitNotifications initializes storage and the available channels
+func InitNotifications(url, key string, s Settings) error {
+	client := supa.CreateClient(url, key)
+	if client == nil {
+		return fmt.Errorf("failed to create Supabase client for notifications")
+	}
+	supabaseClient = client
+	settings = s
+
+	httpClient := &http.Client{Timeout: sendTimeout}
+	channels = map[string]Channel{
+		ChannelSlack: NewSlackChannel(httpClient),
+		ChannelTeams: NewTeamsChannel(httpClient),
+	}
+	if s.SMTP.Enabled() {
+		channels[ChannelEmail] = NewEmailChannel(s.SMTP)
+	} else {
+		log.Printf("⚠️ SMTP not configured, email notifications are disabled")
+	}
+	return nil
+}
+
+// Notify routes an event to the owner's matching rules. Immediate rules are
+// sent right away; digest rules queue the event for the next digest.
+func Notify(event Event) {
+	if supabaseClient == nil || event.UserID == "" {
+		return
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now().UTC()
+	}
+
+	rules, err := listRules(event.UserID)
+	if err != nil {
+		log.Printf("⚠️ notifications.Notify: Failed to load rules for user %s: %v", event.UserID, err)
+		return
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.matches(event) {
+			continue
+		}
+
+		if rule.DeliveryMode == ModeDigest {
+			if err := enqueueDigestItem(rule, event); err != nil {
+				log.Printf("⚠️ notifications.Notify: Failed to queue digest item for rule %s: %v", rule.ID, err)
+			}
+			continue
+		}
+
+		msg, err := renderEvent(event, settings.AppBaseURL)
+		if err != nil {
+			log.Printf("⚠️ notifications.Notify: %v", err)
+			continue
+		}
+		if err := send(rule, msg); err != nil {
+			log.Printf("❌ Notification via %s for rule %s failed: %v", rule.Channel, rule.ID, err)
+		}
+	}
+}
+
+// SendEmail sends a one-off email (such as an invitation) through the
+// configured SMTP server. It fails when email is not configured.
+func SendEmail(ctx context.Context, to string, msg Message) error {
+	channel, ok := channels[ChannelEmail]
+	if !ok {
+		return fmt.Errorf("email is not configured")
+	}
+	return channel.Send(ctx, to, msg)
+}
+
+// StartDigestWorker periodically sends due digests until ctx is cancelled
+func StartDigestWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(digestCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				flushDigests(time.Now().UTC())
+			}
+		}
+	}()
+}
+
+// send delivers a message through the rule's channel
+func send(rule *Rule, msg Message) error {
+	channel, ok := channels[rule.Channel]
+	if !ok {
+		return fmt.Errorf("channel %s is not available", rule.Channel)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	if err := channel.Send(ctx, rule.Target, msg); err != nil {
+		return err
+	}
+	log.Printf("📢 Notification sent via %s: %s", rule.Channel, msg.Subject)
+	return nil
+}
+
+// flushDigests sends every digest rule whose interval has elapsed
+func flushDigests(now time.Time) {
+	if supabaseClient == nil {
+		return
+	}
+
+	var rules []Rule
+	err := supabaseClient.DB.From("notification_rules").
+		Select("*").
+		Eq("delivery_mode", ModeDigest).
+		Eq("is_active", "true").
+		Execute(&rules)
+	if err != nil {
+		log.Printf("⚠️ flushDigests: Failed to load digest rules: %v", err)
+		return
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.LastDigestAt != nil && now.Sub(*rule.LastDigestAt) < rule.digestPeriod() {
+			continue
+		}
+		if err := flushRuleDigest(rule, now); err != nil {
+			log.Printf("❌ flushDigests: Digest for rule %s failed: %v", rule.ID, err)
+		}
+	}
+}
+
+// flushRuleDigest sends the pending items of one rule and marks them sent
+func flushRuleDigest(rule *Rule, now time.Time) error {
+	var items []DigestItem
+	err := supabaseClient.DB.From("notification_digest_items").
+		Select("*").
+		OrderBy("created_at", "asc").
+		Limit(maxDigestItems).
+		Eq("rule_id", rule.ID).
+		IsNull("sent_at").
+		Execute(&items)
+	if err != nil {
+		return fmt.Errorf("failed to load digest items: %v", err)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	events := make([]Event, 0, len(items))
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		events = append(events, item.Event)
+		ids = append(ids, item.ID)
+	}
+
+	if err := send(rule, renderDigest(rule, events, settings.AppBaseURL)); err != nil {
+		return err
+	}
+
+	sentAt := now.Format(time.RFC3339)
+	var results []map[string]interface{}
+	if err := supabaseClient.DB.From("notification_digest_items").
+		Update(map[string]interface{}{"sent_at": sentAt}).
+		In("id", ids).
+		Execute(&results); err != nil {
+		log.Printf("⚠️ flushRuleDigest: Failed to mark digest items sent for rule %s: %v", rule.ID, err)
+	}
+	if err := supabaseClient.DB.From("notification_rules").
+		Update(map[string]interface{}{"last_digest_at": sentAt}).
+		Eq("id", rule.ID).
+		Execute(&results); err != nil {
+		log.Printf("⚠️ flushRuleDigest: Failed to update last_digest_at for rule %s: %v", rule.ID, err)
+	}
+	return nil
+}
+
+// enqueueDigestItem stores an event for the rule's next digest
+func enqueueDigestItem(rule *Rule, event Event) error {
+	record := map[string]interface{}{
+		"id":      uuid.NewString(),
+		"rule_id": rule.ID,
+		"user_id": rule.UserID,
+		"event":   event,
+	}
+	var results []map[string]interface{}
+	return supabaseClient.DB.From("notification_digest_items").Insert(record).Execute(&results)
+}
+
+// listRules fetches the notification rules of a user
+func listRules(userID string) ([]Rule, error) {
+	var rules []Rule
+	err := supabaseClient.DB.From("notification_rules").
+		Select("*").
+		OrderBy("created_at", "asc").
+		Eq("user_id", userID).
+		Execute(&rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch notification rules: %v", err)
+	}
+	return rules, nil
+}
@@ -0,0 +1,337 @@
+package notifications
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ListChannelsHandler returns the channels, events and risk categories rules can use
+func ListChannelsHandler(c *gin.Context) {
+	available := []string{}
+	for _, name := range []string{ChannelEmail, ChannelSlack, ChannelTeams} {
+		if _, ok := channels[name]; ok {
+			available = append(available, name)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"channels":         available,
+		"event_types":      SupportedEventTypes,
+		"risk_categories":  SupportedRiskCategories,
+		"delivery_modes":   []string{ModeImmediate, ModeDigest},
+		"digest_intervals": []string{DigestHourly, DigestDaily},
+	})
+}
+
+// ListRulesHandler returns the authenticated user's notification rules
+func ListRulesHandler(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireDatabase(c) {
+		return
+	}
+
+	rules, err := listRules(userID)
+	if err != nil {
+		log.Printf("❌ ListRulesHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch notification rules",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rules": rules,
+		"total": len(rules),
+	})
+}
+
+// CreateRuleHandler creates a notification routing rule
+func CreateRuleHandler(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireDatabase(c) {
+		return
+	}
+
+	record, ok := bindRuleRequest(c)
+	if !ok {
+		return
+	}
+	record["id"] = uuid.NewString()
+	record["user_id"] = userID
+
+	var results []Rule
+	if err := supabaseClient.DB.From("notification_rules").Insert(record).Execute(&results); err != nil || len(results) == 0 {
+		log.Printf("❌ CreateRuleHandler: Failed to insert rule for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create notification rule",
+			"code":  "NOTIFICATION_RULE_CREATE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, results[0])
+}
+
+// GetRuleHandler returns a single notification rule
+func GetRuleHandler(c *gin.Context) {
+	rule, ok := loadAuthorizedRule(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// UpdateRuleHandler replaces a notification rule
+func UpdateRuleHandler(c *gin.Context) {
+	rule, ok := loadAuthorizedRule(c)
+	if !ok {
+		return
+	}
+
+	record, ok := bindRuleRequest(c)
+	if !ok {
+		return
+	}
+	record["updated_at"] = time.Now().UTC().Format(time.RFC3339)
+
+	var results []Rule
+	err := supabaseClient.DB.From("notification_rules").
+		Update(record).
+		Eq("id", rule.ID).
+		Execute(&results)
+	if err != nil || len(results) == 0 {
+		log.Printf("❌ UpdateRuleHandler: Failed to update rule %s: %v", rule.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update notification rule",
+			"code":  "NOTIFICATION_RULE_UPDATE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, results[0])
+}
+
+// DeleteRuleHandler removes a notification rule and its pending digest items
+func DeleteRuleHandler(c *gin.Context) {
+	rule, ok := loadAuthorizedRule(c)
+	if !ok {
+		return
+	}
+
+	var results []map[string]interface{}
+	if err := supabaseClient.DB.From("notification_rules").Delete().Eq("id", rule.ID).Execute(&results); err != nil {
+		log.Printf("❌ DeleteRuleHandler: Failed to delete rule %s: %v", rule.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete notification rule",
+			"code":  "NOTIFICATION_RULE_DELETE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Notification rule deleted",
+		"id":      rule.ID,
+	})
+}
+
+// TestRuleHandler sends a sample notification through the rule's channel
+func TestRuleHandler(c *gin.Context) {
+	rule, ok := loadAuthorizedRule(c)
+	if !ok {
+		return
+	}
+
+	score := 82
+	sample := Event{
+		Type:         EventAssessmentCompleted,
+		UserID:       rule.UserID,
+		Source:       "website_risk_assessment",
+		Website:      "example.com",
+		Status:       "completed",
+		RiskScore:    &score,
+		RiskCategory: "high_risk",
+		LinkPath:     "/website-risk-assessment",
+		OccurredAt:   time.Now().UTC(),
+	}
+	msg, err := renderEvent(sample, settings.AppBaseURL)
+	if err == nil {
+		msg.Subject = "[Test] " + msg.Subject
+		err = send(rule, msg)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":   "Failed to send test notification",
+			"code":    "NOTIFICATION_SEND_FAILED",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Test notification sent",
+	})
+}
+
+// bindRuleRequest validates a rule request and returns the database record.
+// It writes the 400 response and returns false on failure.
+func bindRuleRequest(c *gin.Context) (map[string]interface{}, bool) {
+	var req RuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"code":    "INVALID_REQUEST",
+			"details": err.Error(),
+		})
+		return nil, false
+	}
+
+	channel, ok := channels[req.Channel]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Notification channel is not available: " + req.Channel,
+			"code":  "CHANNEL_UNAVAILABLE",
+		})
+		return nil, false
+	}
+	if err := channel.ValidateTarget(req.Target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+			"code":  "INVALID_NOTIFICATION_TARGET",
+		})
+		return nil, false
+	}
+
+	for _, t := range req.EventTypes {
+		if !contains(SupportedEventTypes, t) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":       "Unsupported event type: " + t,
+				"code":        "INVALID_EVENT_TYPES",
+				"event_types": SupportedEventTypes,
+			})
+			return nil, false
+		}
+	}
+	for _, rc := range req.RiskCategories {
+		if !contains(SupportedRiskCategories, rc) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":           "Unsupported risk category: " + rc,
+				"code":            "INVALID_RISK_CATEGORIES",
+				"risk_categories": SupportedRiskCategories,
+			})
+			return nil, false
+		}
+	}
+
+	if req.DeliveryMode == "" {
+		req.DeliveryMode = ModeImmediate
+	}
+	if req.DigestInterval == "" {
+		req.DigestInterval = DigestDaily
+	}
+	if req.EventTypes == nil {
+		req.EventTypes = []string{}
+	}
+	if req.RiskCategories == nil {
+		req.RiskCategories = []string{}
+	}
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	return map[string]interface{}{
+		"name":            req.Name,
+		"channel":         req.Channel,
+		"target":          req.Target,
+		"event_types":     req.EventTypes,
+		"risk_categories": req.RiskCategories,
+		"delivery_mode":   req.DeliveryMode,
+		"digest_interval": req.DigestInterval,
+		"is_active":       isActive,
+	}, true
+}
+
+// loadAuthorizedRule fetches the :id rule owned by the authenticated user.
+// It writes the error response and returns false on failure.
+func loadAuthorizedRule(c *gin.Context) (*Rule, bool) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return nil, false
+	}
+
+	if !requireDatabase(c) {
+		return nil, false
+	}
+
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid notification rule ID",
+			"code":  "INVALID_NOTIFICATION_RULE_ID",
+		})
+		return nil, false
+	}
+
+	var rules []Rule
+	err := supabaseClient.DB.From("notification_rules").
+		Select("*").
+		Eq("id", id).
+		Execute(&rules)
+	if err != nil {
+		log.Printf("❌ loadAuthorizedRule: Failed to fetch rule %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch notification rule",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return nil, false
+	}
+
+	if len(rules) == 0 || rules[0].UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Notification rule not found",
+			"code":  "NOTIFICATION_RULE_NOT_FOUND",
+		})
+		return nil, false
+	}
+
+	return &rules[0], true
+}
+
+// requireDatabase writes a 503 when notification storage is not configured
+func requireDatabase(c *gin.Context) bool {
+	if supabaseClient == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Notifications are not available",
+			"code":  "DATABASE_CONNECTION_ERROR",
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRuleMatches(t *testing.T) {
+	rule := &Rule{
+		IsActive:       true,
+		EventTypes:     []string{EventAssessmentCompleted},
+		RiskCategories: []string{"high_risk"},
+	}
+
+	if !rule.matches(Event{Type: EventAssessmentCompleted, RiskCategory: "high_risk"}) {
+		t.Error("expected high risk completion to match")
+	}
+	if rule.matches(Event{Type: EventAssessmentCompleted, RiskCategory: "low_risk"}) {
+		t.Error("low risk completion should not match")
+	}
+	if rule.matches(Event{Type: EventAssessmentFailed, RiskCategory: "high_risk"}) {
+		t.Error("unsubscribed event type should not match")
+	}
+
+	catchAll := &Rule{IsActive: true}
+	if !catchAll.matches(Event{Type: EventAssessmentManualReview}) {
+		t.Error("rule without filters should match every event")
+	}
+	catchAll.IsActive = false
+	if catchAll.matches(Event{Type: EventAssessmentManualReview}) {
+		t.Error("inactive rule should not match")
+	}
+}
+
+func TestRenderEventIncludesLink(t *testing.T) {
+	score := 91
+	msg, err := renderEvent(Event{
+		Type:         EventAssessmentCompleted,
+		Website:      "shop.example.com",
+		RiskScore:    &score,
+		RiskCategory: "high_risk",
+		LinkPath:     "/website-risk-assessment/assessments/12",
+	}, "https://app.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Subject != "[High Risk] Assessment completed for shop.example.com" {
+		t.Errorf("unexpected subject: %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "Risk score: 91") {
+		t.Errorf("unexpected body: %q", msg.Text)
+	}
+	if msg.Link != "https://app.example.com/website-risk-assessment/assessments/12" {
+		t.Errorf("unexpected link: %q", msg.Link)
+	}
+}
+
+func TestRenderDigest(t *testing.T) {
+	rule := &Rule{Name: "Risk team", DigestInterval: DigestDaily}
+	events := []Event{
+		{Type: EventAssessmentFailed, Website: "a.example.com", OccurredAt: time.Now()},
+		{Type: EventAssessmentManualReview, Website: "b.example.com", OccurredAt: time.Now()},
+	}
+
+	msg := renderDigest(rule, events, "https://app.example.com")
+	if !strings.Contains(msg.Subject, "2 assessment update(s)") {
+		t.Errorf("unexpected subject: %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "Assessment failed for a.example.com") || !strings.Contains(msg.Text, "Manual review required for b.example.com") {
+		t.Errorf("digest should list every event: %q", msg.Text)
+	}
+}
+
+func TestWebhookChannelsPostPayloads(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	msg := Message{Subject: "Subject", Text: "Body", Link: "https://app.example.com/a/1"}
+
+	slack := NewSlackChannel(server.Client())
+	if err := slack.Send(context.Background(), server.URL, msg); err != nil {
+		t.Fatalf("slack send: %v", err)
+	}
+	if text, _ := received["text"].(string); !strings.Contains(text, "*Subject*") || !strings.Contains(text, "<https://app.example.com/a/1|View assessment>") {
+		t.Errorf("unexpected slack payload: %v", received)
+	}
+
+	teams := NewTeamsChannel(server.Client())
+	if err := teams.Send(context.Background(), server.URL, msg); err != nil {
+		t.Fatalf("teams send: %v", err)
+	}
+	if received["@type"] != "MessageCard" || received["title"] != "Subject" || received["potentialAction"] == nil {
+		t.Errorf("unexpected teams payload: %v", received)
+	}
+
+	if err := slack.ValidateTarget("http://hooks.slack.com/services/x"); err == nil {
+		t.Error("plain http webhook URL should be rejected")
+	}
+}
@@ -0,0 +1,111 @@
+package notifications
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// messageTemplate holds the subject and body templates for an event type
+type messageTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+var templates = map[string]messageTemplate{
+	EventAssessmentCompleted: newMessageTemplate(
+		`[{{riskLabel .RiskCategory}}] Assessment completed for {{.Website}}`,
+		`The risk assessment for {{.Website}} has completed.
+Risk score: {{if .RiskScore}}{{deref .RiskScore}}{{else}}n/a{{end}}
+Risk category: {{riskLabel .RiskCategory}}`,
+	),
+	EventAssessmentFailed: newMessageTemplate(
+		`Assessment failed for {{.Website}}`,
+		`The risk assessment for {{.Website}} could not be completed.
+{{if .ErrorMessage}}Error: {{.ErrorMessage}}{{end}}`,
+	),
+	EventAssessmentManualReview: newMessageTemplate(
+		`Manual review required for {{.Website}}`,
+		`{{.Website}} operates in a restricted merchant category and needs a manual qualification decision.
+Risk score: {{if .RiskScore}}{{deref .RiskScore}}{{else}}n/a{{end}}`,
+	),
+}
+
+var templateFuncs = template.FuncMap{
+	"riskLabel": riskLabel,
+	"deref":     func(v *int) int { return *v },
+}
+
+func newMessageTemplate(subject, body string) messageTemplate {
+	return messageTemplate{
+		subject: template.Must(template.New("subject").Funcs(templateFuncs).Parse(subject)),
+		body:    template.Must(template.New("body").Funcs(templateFuncs).Parse(body)),
+	}
+}
+
+// renderEvent renders the message for a single event
+func renderEvent(event Event, baseURL string) (Message, error) {
+	tmpl, ok := templates[event.Type]
+	if !ok {
+		return Message{}, fmt.Errorf("no template for event type %s", event.Type)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, event); err != nil {
+		return Message{}, err
+	}
+	if err := tmpl.body.Execute(&body, event); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: subject.String(),
+		Text:    strings.TrimSpace(body.String()),
+		Link:    eventLink(event, baseURL),
+	}, nil
+}
+
+// renderDigest renders one message summarising several events
+func renderDigest(rule *Rule, events []Event, baseURL string) Message {
+	var body strings.Builder
+	for _, event := range events {
+		msg, err := renderEvent(event, baseURL)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&body, "• %s (%s)", msg.Subject, event.OccurredAt.Format("Jan 2 15:04 MST"))
+		if msg.Link != "" {
+			fmt.Fprintf(&body, "\n  %s", msg.Link)
+		}
+		body.WriteString("\n")
+	}
+
+	return Message{
+		Subject: fmt.Sprintf("QuarkFin %s digest: %d assessment update(s) for %s", rule.DigestInterval, len(events), rule.Name),
+		Text:    strings.TrimSpace(body.String()),
+		Link:    strings.TrimRight(baseURL, "/") + "/website-risk-assessment",
+	}
+}
+
+// eventLink builds the web app link to the assessment
+func eventLink(event Event, baseURL string) string {
+	if baseURL == "" || event.LinkPath == "" {
+		return ""
+	}
+	return strings.TrimRight(baseURL, "/") + "/" + strings.TrimLeft(event.LinkPath, "/")
+}
+
+// riskLabel turns a risk category such as "high_risk" into "High Risk"
+func riskLabel(category string) string {
+	if category == "" {
+		return "Unrated"
+	}
+	words := strings.Split(category, "_")
+	for i, w := range words {
+		if w != "" {
+			words[i] = strings.ToUpper(w[:1]) + w[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
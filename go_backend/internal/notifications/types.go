@@ -0,0 +1,133 @@
+package notifications
+
+import (
+	"time"
+)
+
+// Channel types
+const (
+	ChannelEmail = "email"
+	ChannelSlack = "slack"
+	ChannelTeams = "teams"
+)
+
+// Delivery modes
+const (
+	ModeImmediate = "immediate"
+	ModeDigest    = "digest"
+)
+
+// Digest intervals
+const (
+	DigestHourly = "hourly"
+	DigestDaily  = "daily"
+)
+
+// Event types routed to notification rules (same names as the webhook events)
+const (
+	EventAssessmentCompleted    = "assessment.completed"
+	EventAssessmentFailed       = "assessment.failed"
+	EventAssessmentManualReview = "assessment.manual_review_required"
+)
+
+// SupportedEventTypes lists the event types rules can filter on
+var SupportedEventTypes = []string{
+	EventAssessmentCompleted,
+	EventAssessmentFailed,
+	EventAssessmentManualReview,
+}
+
+// SupportedRiskCategories lists the risk categories rules can filter on
+var SupportedRiskCategories = []string{"low_risk", "med_risk", "high_risk"}
+
+// Event describes an assessment lifecycle change to notify about
+type Event struct {
+	Type         string    `json:"type"`
+	UserID       string    `json:"user_id"`
+	AssessmentID int64     `json:"assessment_id"`
+	Source       string    `json:"source"` // website_risk_assessment, business_risk_prevention
+	Website      string    `json:"website"`
+	Status       string    `json:"status"`
+	RiskScore    *int      `json:"risk_score,omitempty"`
+	RiskCategory string    `json:"risk_category,omitempty"`
+	ErrorMessage string    `json:"error_message,omitempty"`
+	LinkPath     string    `json:"link_path"` // path of the assessment in the web app
+	OccurredAt   time.Time `json:"occurred_at"`
+}
+
+// Rule routes matching events of a user to a channel target
+type Rule struct {
+	ID             string     `json:"id" db:"id"`
+	UserID         string     `json:"user_id" db:"user_id"`
+	Name           string     `json:"name" db:"name"`
+	Channel        string     `json:"channel" db:"channel"`
+	Target         string     `json:"target" db:"target"` // email address or incoming webhook URL
+	EventTypes     []string   `json:"event_types" db:"event_types"`
+	RiskCategories []string   `json:"risk_categories" db:"risk_categories"`
+	DeliveryMode   string     `json:"delivery_mode" db:"delivery_mode"`
+	DigestInterval string     `json:"digest_interval" db:"digest_interval"`
+	IsActive       bool       `json:"is_active" db:"is_active"`
+	LastDigestAt   *time.Time `json:"last_digest_at" db:"last_digest_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// DigestItem is an event queued for a digest rule
+type DigestItem struct {
+	ID        string     `json:"id" db:"id"`
+	RuleID    string     `json:"rule_id" db:"rule_id"`
+	UserID    string     `json:"user_id" db:"user_id"`
+	Event     Event      `json:"event" db:"event"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	SentAt    *time.Time `json:"sent_at" db:"sent_at"`
+}
+
+// Message is a rendered notification ready to send on any channel
+type Message struct {
+	Subject string
+	Text    string
+	Link    string
+}
+
+// RuleRequest represents the request to create or replace a notification rule
+type RuleRequest struct {
+	Name           string   `json:"name" binding:"required"`
+	Channel        string   `json:"channel" binding:"required,oneof=email slack teams"`
+	Target         string   `json:"target" binding:"required"`
+	EventTypes     []string `json:"event_types"`
+	RiskCategories []string `json:"risk_categories"`
+	DeliveryMode   string   `json:"delivery_mode" binding:"omitempty,oneof=immediate digest"`
+	DigestInterval string   `json:"digest_interval" binding:"omitempty,oneof=hourly daily"`
+	IsActive       *bool    `json:"is_active"`
+}
+
+// matches reports whether the rule wants the event. Empty filters match everything.
+func (r *Rule) matches(event Event) bool {
+	if !r.IsActive {
+		return false
+	}
+	if len(r.EventTypes) > 0 && !contains(r.EventTypes, event.Type) {
+		return false
+	}
+	if len(r.RiskCategories) > 0 && !contains(r.RiskCategories, event.RiskCategory) {
+		return false
+	}
+	return true
+}
+
+// digestPeriod returns how often a digest rule is flushed
+func (r *Rule) digestPeriod() time.Duration {
+	if r.DigestInterval == DigestDaily {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
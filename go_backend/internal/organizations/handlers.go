@@ -0,0 +1,823 @@
+package organizations
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// CreateOrganizationHandler handles POST /api/organizations. The creator
+// becomes the owner and an empty shared credit pool is created.
+func CreateOrganizationHandler(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireDatabase(c) {
+		return
+	}
+
+	var req CreateOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"code":    "INVALID_REQUEST",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	orgID := uuid.NewString()
+	var orgs []auth.Organization
+	err := supabaseClient.DB.From("organizations").
+		Insert(map[string]interface{}{
+			"id":         orgID,
+			"name":       strings.TrimSpace(req.Name),
+			"created_by": userID,
+		}).
+		Execute(&orgs)
+	if err != nil || len(orgs) == 0 {
+		log.Printf("❌ CreateOrganizationHandler: Failed to create organization for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create organization",
+			"code":  "ORGANIZATION_CREATE_ERROR",
+		})
+		return
+	}
+
+	var members []map[string]interface{}
+	err = supabaseClient.DB.From("organization_members").
+		Insert(map[string]interface{}{
+			"org_id":  orgID,
+			"user_id": userID,
+			"role":    auth.RoleOwner,
+		}).
+		Execute(&members)
+	if err != nil {
+		log.Printf("❌ CreateOrganizationHandler: Failed to add owner to organization %s: %v", orgID, err)
+		var deleted []map[string]interface{}
+		supabaseClient.DB.From("organizations").Delete().Eq("id", orgID).Execute(&deleted)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create organization",
+			"code":  "ORGANIZATION_CREATE_ERROR",
+		})
+		return
+	}
+
+	var pools []map[string]interface{}
+	err = supabaseClient.DB.From("user_credits").
+		Insert(map[string]interface{}{
+			"org_id":               orgID,
+			"monthly_allocation":   0,
+			"subscription_credits": 0,
+			"recharged_credits":    0,
+			"bonus_credits":        0,
+			"used_credits":         0,
+		}).
+		Execute(&pools)
+	if err != nil {
+		log.Printf("⚠️ CreateOrganizationHandler: Failed to create credit pool for organization %s: %v", orgID, err)
+	}
+
+	log.Printf("🏢 CreateOrganizationHandler: User %s created organization %s", userID, orgID)
+	c.JSON(http.StatusCreated, OrganizationResponse{Organization: orgs[0], Role: auth.RoleOwner})
+}
+
+// ListOrganizationsHandler handles GET /api/organizations
+func ListOrganizationsHandler(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireDatabase(c) {
+		return
+	}
+
+	var memberships []auth.OrganizationMember
+	err := supabaseClient.DB.From("organization_members").
+		Select("*").
+		Eq("user_id", userID).
+		Execute(&memberships)
+	if err != nil {
+		log.Printf("❌ ListOrganizationsHandler: Failed to fetch memberships for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch organizations",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	result := []OrganizationResponse{}
+	if len(memberships) > 0 {
+		roles := map[string]string{}
+		ids := make([]string, 0, len(memberships))
+		for _, m := range memberships {
+			roles[m.OrgID] = m.Role
+			ids = append(ids, m.OrgID)
+		}
+
+		var orgs []auth.Organization
+		err := supabaseClient.DB.From("organizations").
+			Select("*").
+			OrderBy("name", "asc").
+			In("id", ids).
+			Execute(&orgs)
+		if err != nil {
+			log.Printf("❌ ListOrganizationsHandler: Failed to fetch organizations for user %s: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to fetch organizations",
+				"code":  "DATABASE_FETCH_ERROR",
+			})
+			return
+		}
+		for _, org := range orgs {
+			result = append(result, OrganizationResponse{Organization: org, Role: roles[org.ID]})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"organizations": result,
+		"total":         len(result),
+	})
+}
+
+// GetOrganizationHandler handles GET /api/organizations/:id
+func GetOrganizationHandler(c *gin.Context) {
+	member, ok := loadMembership(c, "")
+	if !ok {
+		return
+	}
+
+	org, err := getOrganization(member.OrgID)
+	if err != nil {
+		log.Printf("❌ GetOrganizationHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch organization",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	members, err := listMembers(member.OrgID)
+	if err != nil {
+		log.Printf("❌ GetOrganizationHandler: Failed to fetch members of %s: %v", member.OrgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch organization members",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"organization": OrganizationResponse{Organization: *org, Role: member.Role},
+		"members":      members,
+		"permissions":  member.permissions,
+	})
+}
+
+// UpdateOrganizationHandler handles PUT /api/organizations/:id
+func UpdateOrganizationHandler(c *gin.Context) {
+	member, ok := loadMembership(c, auth.PermOrganizationManage)
+	if !ok {
+		return
+	}
+
+	var req UpdateOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"code":    "INVALID_REQUEST",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	update := map[string]interface{}{
+		"updated_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	if req.Name != nil {
+		update["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.RequireTwoFactor != nil {
+		// Enforcing 2FA without having it would lock the caller out
+		if *req.RequireTwoFactor {
+			enabled, err := auth.TwoFactorEnabled(member.UserID)
+			if err != nil {
+				log.Printf("❌ UpdateOrganizationHandler: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to verify two-factor status",
+					"code":  "DATABASE_FETCH_ERROR",
+				})
+				return
+			}
+			if !enabled {
+				c.JSON(http.StatusConflict, gin.H{
+					"error": "Enable two-factor authentication on your account before requiring it",
+					"code":  "TWO_FACTOR_NOT_ENABLED",
+				})
+				return
+			}
+		}
+		update["require_two_factor"] = *req.RequireTwoFactor
+	}
+
+	var orgs []auth.Organization
+	err := supabaseClient.DB.From("organizations").
+		Update(update).
+		Eq("id", member.OrgID).
+		Execute(&orgs)
+	if err != nil || len(orgs) == 0 {
+		log.Printf("❌ UpdateOrganizationHandler: Failed to update organization %s: %v", member.OrgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update organization",
+			"code":  "ORGANIZATION_UPDATE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, OrganizationResponse{Organization: orgs[0], Role: member.Role})
+}
+
+// UpdateMemberHandler handles PUT /api/organizations/:id/members/:user_id
+func UpdateMemberHandler(c *gin.Context) {
+	member, ok := loadMembership(c, auth.PermMembersManage)
+	if !ok {
+		return
+	}
+
+	var req UpdateMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"code":    "INVALID_REQUEST",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	target, ok := loadTargetMember(c, member.OrgID)
+	if !ok {
+		return
+	}
+
+	// Only owners may grant or take away ownership
+	if (req.Role == auth.RoleOwner || target.Role == auth.RoleOwner) && member.Role != auth.RoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Only owners can change ownership",
+			"code":  "ORGANIZATION_ACCESS_DENIED",
+		})
+		return
+	}
+	if target.Role == auth.RoleOwner && req.Role != auth.RoleOwner && !ensureAnotherOwner(c, member.OrgID) {
+		return
+	}
+
+	var results []auth.OrganizationMember
+	err := supabaseClient.DB.From("organization_members").
+		Update(map[string]interface{}{"role": req.Role}).
+		Eq("org_id", member.OrgID).
+		Eq("user_id", target.UserID).
+		Execute(&results)
+	if err != nil || len(results) == 0 {
+		log.Printf("❌ UpdateMemberHandler: Failed to update member %s of %s: %v", target.UserID, member.OrgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update member",
+			"code":  "MEMBER_UPDATE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, results[0])
+}
+
+// RemoveMemberHandler handles DELETE /api/organizations/:id/members/:user_id.
+// Members may remove themselves; owners and admins may remove others.
+func RemoveMemberHandler(c *gin.Context) {
+	member, ok := loadMembership(c, "")
+	if !ok {
+		return
+	}
+
+	leaving := c.Param("user_id") == member.UserID
+	if !leaving && !member.can(auth.PermMembersManage) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":      "Your role does not allow removing members",
+			"code":       "PERMISSION_DENIED",
+			"permission": auth.PermMembersManage,
+		})
+		return
+	}
+
+	target, ok := loadTargetMember(c, member.OrgID)
+	if !ok {
+		return
+	}
+	if target.Role == auth.RoleOwner {
+		if !leaving && member.Role != auth.RoleOwner {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Only owners can remove an owner",
+				"code":  "ORGANIZATION_ACCESS_DENIED",
+			})
+			return
+		}
+		if !ensureAnotherOwner(c, member.OrgID) {
+			return
+		}
+	}
+
+	var results []map[string]interface{}
+	err := supabaseClient.DB.From("organization_members").
+		Delete().
+		Eq("org_id", member.OrgID).
+		Eq("user_id", target.UserID).
+		Execute(&results)
+	if err != nil {
+		log.Printf("❌ RemoveMemberHandler: Failed to remove member %s from %s: %v", target.UserID, member.OrgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to remove member",
+			"code":  "MEMBER_REMOVE_ERROR",
+		})
+		return
+	}
+
+	log.Printf("👋 RemoveMemberHandler: User %s removed from organization %s by %s", target.UserID, member.OrgID, member.UserID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Member removed",
+		"user_id": target.UserID,
+	})
+}
+
+// CreateInviteHandler handles POST /api/organizations/:id/invites. The invite
+// token is only returned in this response (and in the invitation email).
+func CreateInviteHandler(c *gin.Context) {
+	member, ok := loadMembership(c, auth.PermMembersManage)
+	if !ok {
+		return
+	}
+
+	var req CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"code":    "INVALID_REQUEST",
+			"details": err.Error(),
+		})
+		return
+	}
+	if req.Role == "" {
+		req.Role = auth.RoleAnalyst
+	}
+	if req.Role == auth.RoleOwner && member.Role != auth.RoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Only owners can invite owners",
+			"code":  "ORGANIZATION_ACCESS_DENIED",
+		})
+		return
+	}
+
+	org, err := getOrganization(member.OrgID)
+	if err != nil {
+		log.Printf("❌ CreateInviteHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch organization",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	token, hash, err := generateInviteToken()
+	if err != nil {
+		log.Printf("❌ CreateInviteHandler: Failed to generate token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create invite",
+			"code":  "INVITE_CREATE_ERROR",
+		})
+		return
+	}
+
+	var invites []Invite
+	err = supabaseClient.DB.From("organization_invites").
+		Insert(map[string]interface{}{
+			"id":         uuid.NewString(),
+			"org_id":     member.OrgID,
+			"email":      strings.ToLower(strings.TrimSpace(req.Email)),
+			"role":       req.Role,
+			"token_hash": hash,
+			"invited_by": member.UserID,
+			"expires_at": time.Now().UTC().Add(inviteLifetime).Format(time.RFC3339),
+		}).
+		Execute(&invites)
+	if err != nil || len(invites) == 0 {
+		log.Printf("❌ CreateInviteHandler: Failed to insert invite for %s: %v", member.OrgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create invite",
+			"code":  "INVITE_CREATE_ERROR",
+		})
+		return
+	}
+
+	invite := invites[0]
+	go sendInviteEmail(org, &invite, token)
+
+	auth.OmitIdempotentResponse(c)
+	c.JSON(http.StatusCreated, gin.H{
+		"invite":     invite,
+		"token":      token,
+		"accept_url": inviteURL(token),
+	})
+}
+
+// ListInvitesHandler handles GET /api/organizations/:id/invites
+func ListInvitesHandler(c *gin.Context) {
+	member, ok := loadMembership(c, auth.PermMembersManage)
+	if !ok {
+		return
+	}
+
+	var invites []Invite
+	err := supabaseClient.DB.From("organization_invites").
+		Select("*").
+		OrderBy("created_at", "desc").
+		Eq("org_id", member.OrgID).
+		IsNull("accepted_at").
+		IsNull("revoked_at").
+		Execute(&invites)
+	if err != nil {
+		log.Printf("❌ ListInvitesHandler: Failed to fetch invites for %s: %v", member.OrgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch invites",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invites": invites,
+		"total":   len(invites),
+	})
+}
+
+// RevokeInviteHandler handles DELETE /api/organizations/:id/invites/:invite_id
+func RevokeInviteHandler(c *gin.Context) {
+	member, ok := loadMembership(c, auth.PermMembersManage)
+	if !ok {
+		return
+	}
+
+	inviteID := c.Param("invite_id")
+	if _, err := uuid.Parse(inviteID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid invite ID",
+			"code":  "INVALID_INVITE_ID",
+		})
+		return
+	}
+
+	var invites []Invite
+	err := supabaseClient.DB.From("organization_invites").
+		Update(map[string]interface{}{"revoked_at": time.Now().UTC().Format(time.RFC3339)}).
+		Eq("id", inviteID).
+		Eq("org_id", member.OrgID).
+		Execute(&invites)
+	if err != nil {
+		log.Printf("❌ RevokeInviteHandler: Failed to revoke invite %s: %v", inviteID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke invite",
+			"code":  "INVITE_REVOKE_ERROR",
+		})
+		return
+	}
+	if len(invites) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Invite not found",
+			"code":  "INVITE_NOT_FOUND",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Invite revoked",
+		"invite":  invites[0],
+	})
+}
+
+// AcceptInviteHandler handles POST /api/organizations/invites/accept. The
+// invite must be addressed to the authenticated user's email.
+func AcceptInviteHandler(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireDatabase(c) {
+		return
+	}
+
+	var req AcceptInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"code":    "INVALID_REQUEST",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	var invites []Invite
+	err := supabaseClient.DB.From("organization_invites").
+		Select("*").
+		Eq("token_hash", hashInviteToken(req.Token)).
+		Execute(&invites)
+	if err != nil {
+		log.Printf("❌ AcceptInviteHandler: Failed to fetch invite: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch invite",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+	if len(invites) == 0 || !invites[0].pending(time.Now()) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Invite not found or no longer valid",
+			"code":  "INVITE_NOT_FOUND",
+		})
+		return
+	}
+	invite := invites[0]
+
+	email := auth.GetUserEmail(c)
+	if email == "" {
+		if profile, err := auth.GetUserProfile(userID); err == nil {
+			email = profile.Email
+		}
+	}
+	if !strings.EqualFold(email, invite.Email) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "This invite was sent to a different email address",
+			"code":  "INVITE_EMAIL_MISMATCH",
+		})
+		return
+	}
+
+	if _, err := auth.GetOrganizationMembership(invite.OrgID, userID); err == nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "You are already a member of this organization",
+			"code":  "ALREADY_MEMBER",
+		})
+		return
+	}
+
+	var members []auth.OrganizationMember
+	err = supabaseClient.DB.From("organization_members").
+		Insert(map[string]interface{}{
+			"org_id":     invite.OrgID,
+			"user_id":    userID,
+			"role":       invite.Role,
+			"invited_by": invite.InvitedBy,
+		}).
+		Execute(&members)
+	if err != nil || len(members) == 0 {
+		log.Printf("❌ AcceptInviteHandler: Failed to add user %s to %s: %v", userID, invite.OrgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to join organization",
+			"code":  "INVITE_ACCEPT_ERROR",
+		})
+		return
+	}
+
+	var updated []Invite
+	err = supabaseClient.DB.From("organization_invites").
+		Update(map[string]interface{}{
+			"accepted_at": time.Now().UTC().Format(time.RFC3339),
+			"accepted_by": userID,
+		}).
+		Eq("id", invite.ID).
+		Execute(&updated)
+	if err != nil {
+		log.Printf("⚠️ AcceptInviteHandler: Failed to mark invite %s accepted: %v", invite.ID, err)
+	}
+
+	log.Printf("🤝 AcceptInviteHandler: User %s joined organization %s as %s", userID, invite.OrgID, invite.Role)
+	c.JSON(http.StatusOK, members[0])
+}
+
+// GetCreditsHandler handles GET /api/organizations/:id/credits
+func GetCreditsHandler(c *gin.Context) {
+	member, ok := loadMembership(c, auth.PermBillingRead)
+	if !ok {
+		return
+	}
+
+	credits, err := auth.GetOrganizationCredits(member.OrgID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Organization credits not found",
+			"code":  "CREDITS_NOT_FOUND",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, credits)
+}
+
+// TransferCreditsHandler handles POST /api/organizations/:id/credits/transfer,
+// moving credits from the caller's own balance into the shared pool
+func TransferCreditsHandler(c *gin.Context) {
+	member, ok := loadMembership(c, auth.PermBillingManage)
+	if !ok {
+		return
+	}
+
+	var req TransferCreditsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"code":    "INVALID_REQUEST",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := auth.TransferCreditsToOrganization(member.UserID, member.OrgID, req.Credits); err != nil {
+		log.Printf("❌ TransferCreditsHandler: Transfer from %s to %s failed: %v", member.UserID, member.OrgID, err)
+		if errors.Is(err, auth.ErrInsufficientCredits) {
+			c.JSON(http.StatusPaymentRequired, gin.H{
+				"error":            "Insufficient credits",
+				"code":             "INSUFFICIENT_CREDITS",
+				"credits_required": req.Credits,
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to transfer credits",
+			"code":  "CREDIT_TRANSACTION_ERROR",
+		})
+		return
+	}
+
+	credits, err := auth.GetOrganizationCredits(member.OrgID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Credits transferred"})
+		return
+	}
+	c.JSON(http.StatusOK, credits)
+}
+
+// membership is the caller's membership with its effective permissions
+type membership struct {
+	auth.OrganizationMember
+	permissions []string
+}
+
+func (m *membership) can(permission string) bool {
+	return contains(m.permissions, permission)
+}
+
+// loadMembership checks that the caller belongs to the :id organization and,
+// unless permission is empty, holds permission there. Non-members get a 404
+// so organization IDs are not disclosed. It writes the error response and
+// returns false on failure.
+func loadMembership(c *gin.Context, permission string) (*membership, bool) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return nil, false
+	}
+
+	if !requireDatabase(c) {
+		return nil, false
+	}
+
+	orgID := c.Param("id")
+	if _, err := uuid.Parse(orgID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid organization ID",
+			"code":  "INVALID_ORGANIZATION_ID",
+		})
+		return nil, false
+	}
+
+	member, err := auth.GetOrganizationMembership(orgID, userID)
+	if errors.Is(err, auth.ErrNotOrganizationMember) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Organization not found",
+			"code":  "ORGANIZATION_NOT_FOUND",
+		})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("❌ loadMembership: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to verify organization membership",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return nil, false
+	}
+
+	permissions, err := auth.OrganizationRolePermissions(orgID, member.Role)
+	if err != nil {
+		log.Printf("❌ loadMembership: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load organization permissions",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return nil, false
+	}
+
+	// Members without 2FA may still view an organization that requires it
+	if permission != "" && !auth.RequireOrganizationTwoFactor(c, orgID, userID) {
+		return nil, false
+	}
+
+	result := &membership{OrganizationMember: *member, permissions: permissions}
+	if permission != "" && !result.can(permission) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":      "Your role does not allow this action",
+			"code":       "PERMISSION_DENIED",
+			"permission": permission,
+			"role":       member.Role,
+		})
+		return nil, false
+	}
+
+	return result, true
+}
+
+// loadTargetMember fetches the :user_id member of the caller's organization.
+// It writes the error response and returns false on failure.
+func loadTargetMember(c *gin.Context, orgID string) (*auth.OrganizationMember, bool) {
+	target, err := auth.GetOrganizationMembership(orgID, c.Param("user_id"))
+	if errors.Is(err, auth.ErrNotOrganizationMember) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Member not found",
+			"code":  "MEMBER_NOT_FOUND",
+		})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("❌ loadTargetMember: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch member",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return nil, false
+	}
+	return target, true
+}
+
+// ensureAnotherOwner writes a 409 and returns false when removing or demoting
+// an owner would leave the organization without one
+func ensureAnotherOwner(c *gin.Context, orgID string) bool {
+	owners, err := countOwners(orgID)
+	if err != nil {
+		log.Printf("❌ ensureAnotherOwner: Failed to count owners of %s: %v", orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch organization members",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return false
+	}
+	if owners <= 1 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "An organization must keep at least one owner",
+			"code":  "LAST_OWNER",
+		})
+		return false
+	}
+	return true
+}
+
+// requireDatabase writes a 503 when organization storage is not configured
+func requireDatabase(c *gin.Context) bool {
+	if supabaseClient == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Organizations are not available",
+			"code":  "DATABASE_CONNECTION_ERROR",
+		})
+		return false
+	}
+	return true
+}
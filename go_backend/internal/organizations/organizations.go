@@ -0,0 +1,205 @@
+package organizations
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/notifications"
+	supa "github.com/nedpals/supabase-go"
+)
+
+const (
+	inviteTokenPrefix = "qfi_"
+	inviteTokenBytes  = 24
+	inviteLifetime    = 7 * 24 * time.Hour
+	inviteSendTimeout = 30 * time.Second
+)
+
+var (
+	supabaseClient *supa.Client
+	appBaseURL     string
+)
+
+// InitOrganizations initializes organization storage. appURL is the web app
+// base URL used to build invitation links.
+func InitOrganizations(url, key, appURL string) error {
+	client := supa.CreateClient(url, key)
+	if client == nil {
+		return fmt.Errorf("failed to create Supabase client for organizations")
+	}
+	supabaseClient = client
+	appBaseURL = strings.TrimRight(appURL, "/")
+	return nil
+}
+
+// Invite is an invitation for an email address to join an organization
+type Invite struct {
+	ID         string     `json:"id" db:"id"`
+	OrgID      string     `json:"org_id" db:"org_id"`
+	Email      string     `json:"email" db:"email"`
+	Role       string     `json:"role" db:"role"`
+	TokenHash  string     `json:"-" db:"token_hash"`
+	InvitedBy  string     `json:"invited_by" db:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at" db:"accepted_at"`
+	AcceptedBy *string    `json:"accepted_by" db:"accepted_by"`
+	RevokedAt  *time.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// pending reports whether the invite can still be accepted
+func (i *Invite) pending(now time.Time) bool {
+	return i.AcceptedAt == nil && i.RevokedAt == nil && now.Before(i.ExpiresAt)
+}
+
+// MemberResponse is a member with their profile details
+type MemberResponse struct {
+	auth.OrganizationMember
+	Email    string `json:"email"`
+	FullName string `json:"full_name"`
+}
+
+// OrganizationResponse is an organization with the caller's role
+type OrganizationResponse struct {
+	auth.Organization
+	Role string `json:"role"`
+}
+
+// CreateOrganizationRequest represents the request to create an organization
+type CreateOrganizationRequest struct {
+	Name string `json:"name" binding:"required,max=255"`
+}
+
+// UpdateOrganizationRequest represents the request to change an organization's settings
+type UpdateOrganizationRequest struct {
+	Name             *string `json:"name" binding:"omitempty,min=1,max=255"`
+	RequireTwoFactor *bool   `json:"require_two_factor"` // members must enable 2FA to act for the organization
+}
+
+// UpdateMemberRequest represents the request to change a member's role
+type UpdateMemberRequest struct {
+	Role string `json:"role" binding:"required,oneof=owner admin reviewer analyst viewer billing"`
+}
+
+// CreateInviteRequest represents the request to invite someone by email
+type CreateInviteRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"omitempty,oneof=owner admin reviewer analyst viewer billing"`
+}
+
+// AcceptInviteRequest represents the request to accept an invitation
+type AcceptInviteRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// TransferCreditsRequest represents the request to move credits into the shared pool
+type TransferCreditsRequest struct {
+	Credits int `json:"credits" binding:"required,min=1"`
+}
+
+// generateInviteToken returns a new invite token and the hash stored at rest
+func generateInviteToken() (token, hash string, err error) {
+	raw := make([]byte, inviteTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token = inviteTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashInviteToken(token), nil
+}
+
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// inviteURL returns the web app link that accepts an invite
+func inviteURL(token string) string {
+	return appBaseURL + "/invites/accept?token=" + url.QueryEscape(token)
+}
+
+// sendInviteEmail emails the invitation link. Failures are logged; the link is
+// also returned to the inviter so it can be shared another way.
+func sendInviteEmail(org *auth.Organization, invite *Invite, token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), inviteSendTimeout)
+	defer cancel()
+
+	msg := notifications.Message{
+		Subject: fmt.Sprintf("You have been invited to join %s on QuarkFin", org.Name),
+		Text: fmt.Sprintf("You have been invited to join the %s organization as %s.\n\nAccept the invitation: %s\n\nThis invitation expires on %s.",
+			org.Name, invite.Role, inviteURL(token), invite.ExpiresAt.Format("January 2, 2006")),
+	}
+	if err := notifications.SendEmail(ctx, invite.Email, msg); err != nil {
+		log.Printf("⚠️ sendInviteEmail: Invite %s was not emailed: %v", invite.ID, err)
+	}
+}
+
+// getOrganization fetches an organization by ID
+func getOrganization(id string) (*auth.Organization, error) {
+	var orgs []auth.Organization
+	err := supabaseClient.DB.From("organizations").
+		Select("*").
+		Eq("id", id).
+		Execute(&orgs)
+	if err != nil {
+		return nil, err
+	}
+	if len(orgs) == 0 {
+		return nil, fmt.Errorf("organization %s not found", id)
+	}
+	return &orgs[0], nil
+}
+
+// listMembers returns the members of an organization with profile details
+func listMembers(orgID string) ([]MemberResponse, error) {
+	var members []auth.OrganizationMember
+	err := supabaseClient.DB.From("organization_members").
+		Select("*").
+		OrderBy("joined_at", "asc").
+		Eq("org_id", orgID).
+		Execute(&members)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := map[string]auth.UserProfile{}
+	if len(members) > 0 {
+		ids := make([]string, 0, len(members))
+		for _, m := range members {
+			ids = append(ids, m.UserID)
+		}
+		var rows []auth.UserProfile
+		if err := supabaseClient.DB.From("user_profiles").Select("*").In("id", ids).Execute(&rows); err != nil {
+			log.Printf("⚠️ listMembers: Failed to fetch member profiles for org %s: %v", orgID, err)
+		}
+		for _, p := range rows {
+			profiles[p.ID] = p
+		}
+	}
+
+	result := make([]MemberResponse, 0, len(members))
+	for _, m := range members {
+		profile := profiles[m.UserID]
+		result = append(result, MemberResponse{OrganizationMember: m, Email: profile.Email, FullName: profile.FullName})
+	}
+	return result, nil
+}
+
+// countOwners returns how many owners an organization has
+func countOwners(orgID string) (int, error) {
+	var owners []auth.OrganizationMember
+	err := supabaseClient.DB.From("organization_members").
+		Select("*").
+		Eq("org_id", orgID).
+		Eq("role", auth.RoleOwner).
+		Execute(&owners)
+	return len(owners), err
+}
@@ -0,0 +1,156 @@
+package organizations
+
+import (
+	"log"
+	"net/http"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// RoleResponse describes a role and the permissions it holds in an organization
+type RoleResponse struct {
+	Role         string   `json:"role"`
+	Permissions  []string `json:"permissions"`
+	Configurable bool     `json:"configurable"`
+}
+
+// UpdateRoleRequest represents the request to change a role's permissions
+type UpdateRoleRequest struct {
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// ListRolesHandler handles GET /api/organizations/:id/roles
+func ListRolesHandler(c *gin.Context) {
+	member, ok := loadMembership(c, "")
+	if !ok {
+		return
+	}
+
+	roles := make([]RoleResponse, 0, len(auth.Roles))
+	for _, role := range auth.Roles {
+		permissions, err := auth.OrganizationRolePermissions(member.OrgID, role)
+		if err != nil {
+			log.Printf("❌ ListRolesHandler: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to load organization permissions",
+				"code":  "DATABASE_FETCH_ERROR",
+			})
+			return
+		}
+		roles = append(roles, RoleResponse{
+			Role:         role,
+			Permissions:  permissions,
+			Configurable: role != auth.RoleOwner,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roles":       roles,
+		"permissions": auth.AllPermissions,
+		"defaults":    auth.DefaultRolePermissions,
+	})
+}
+
+// UpdateRoleHandler handles PUT /api/organizations/:id/roles/:role. The owner
+// role always holds every permission and cannot be changed.
+func UpdateRoleHandler(c *gin.Context) {
+	member, ok := loadMembership(c, auth.PermOrganizationManage)
+	if !ok {
+		return
+	}
+
+	role := c.Param("role")
+	if !isConfigurableRole(c, role) {
+		return
+	}
+
+	var req UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"code":    "INVALID_REQUEST",
+			"details": err.Error(),
+		})
+		return
+	}
+	for _, permission := range req.Permissions {
+		if !contains(auth.AllPermissions, permission) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":       "Unknown permission: " + permission,
+				"code":        "INVALID_PERMISSION",
+				"permissions": auth.AllPermissions,
+			})
+			return
+		}
+	}
+
+	// Admins cannot take organization management away from their own role
+	if role == member.Role && !contains(req.Permissions, auth.PermOrganizationManage) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "You cannot remove organization:manage from your own role",
+			"code":  "SELF_LOCKOUT",
+		})
+		return
+	}
+
+	saved, err := auth.SetOrganizationRolePermissions(member.OrgID, role, req.Permissions, member.UserID)
+	if err != nil {
+		log.Printf("❌ UpdateRoleHandler: Failed to update %s in %s: %v", role, member.OrgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update role permissions",
+			"code":  "ROLE_UPDATE_ERROR",
+		})
+		return
+	}
+
+	log.Printf("🛡️ UpdateRoleHandler: %s changed %s permissions in %s to %v", member.UserID, role, member.OrgID, saved.Permissions)
+	c.JSON(http.StatusOK, RoleResponse{Role: role, Permissions: saved.Permissions, Configurable: true})
+}
+
+// ResetRoleHandler handles DELETE /api/organizations/:id/roles/:role,
+// restoring the role's default permissions
+func ResetRoleHandler(c *gin.Context) {
+	member, ok := loadMembership(c, auth.PermOrganizationManage)
+	if !ok {
+		return
+	}
+
+	role := c.Param("role")
+	if !isConfigurableRole(c, role) {
+		return
+	}
+
+	if err := auth.ResetOrganizationRolePermissions(member.OrgID, role); err != nil {
+		log.Printf("❌ ResetRoleHandler: Failed to reset %s in %s: %v", role, member.OrgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to reset role permissions",
+			"code":  "ROLE_UPDATE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, RoleResponse{Role: role, Permissions: auth.DefaultRolePermissions[role], Configurable: true})
+}
+
+// isConfigurableRole writes a 400 and returns false for unknown roles and the owner role
+func isConfigurableRole(c *gin.Context, role string) bool {
+	if !contains(auth.ConfigurableRoles, role) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Role cannot be configured: " + role,
+			"code":  "INVALID_ROLE",
+			"roles": auth.ConfigurableRoles,
+		})
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package pricing
+
+import (
+	"log"
+	"net/http"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// QuoteRequest is the body of POST /api/pricing/quote
+type QuoteRequest struct {
+	Product        string   `json:"product" binding:"required"` // website_risk or business_risk
+	AssessmentType string   `json:"assessment_type" binding:"required"`
+	Checks         []string `json:"checks"` // optional checks not included in the assessment type
+}
+
+// CatalogHandler handles GET /api/pricing/catalog
+func CatalogHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"items": Current().Items()})
+}
+
+// QuoteHandler handles POST /api/pricing/quote, the credits an assessment
+// would cost the workspace before it is submitted
+func QuoteHandler(c *gin.Context) {
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	var req QuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "product and assessment_type are required",
+			"code":  "INVALID_REQUEST",
+		})
+		return
+	}
+
+	quote, err := QuoteForWorkspace(workspace, req.Product, req.AssessmentType, req.Checks)
+	if err != nil {
+		RespondQuoteError(c, "QuoteHandler", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, quote)
+}
+
+// RespondQuoteError writes the response for an error of QuoteForWorkspace
+func RespondQuoteError(c *gin.Context, handler string, err error) {
+	if IsQuoteError(err) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+			"code":  "INVALID_PRICING_OPTION",
+		})
+		return
+	}
+
+	log.Printf("❌ %s: %v", handler, err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": "Failed to price assessment",
+		"code":  "PRICING_ERROR",
+	})
+}
@@ -0,0 +1,326 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	supa "github.com/nedpals/supabase-go"
+)
+
+// Products priced by the catalog
+const (
+	ProductWebsiteRisk  = "website_risk"
+	ProductBusinessRisk = "business_risk"
+)
+
+// Item types
+const (
+	ItemAssessment = "assessment"
+	ItemCheck      = "check"
+)
+
+// Assessment types
+const (
+	AssessmentQuick         = "quick"
+	AssessmentComprehensive = "comprehensive"
+)
+
+// Optional checks
+const (
+	CheckMCCClassification = "mcc_classification" // AI classification instead of keyword matching
+	CheckReputationLookup  = "reputation_lookup"  // URLVoid, IPVoid and Google Safe Browsing
+)
+
+// DefaultCreditValueCents is what a credit is worth when recording the cost
+// of an assessment
+const DefaultCreditValueCents = 1.0
+
+// catalogTTL is how long the catalog loaded from the database is used before
+// it is loaded again, so price changes apply without a restart
+const catalogTTL = 5 * time.Minute
+
+var (
+	ErrUnknownProduct        = errors.New("unknown product")
+	ErrUnknownAssessmentType = errors.New("unknown assessment type")
+	ErrUnknownCheck          = errors.New("unknown check")
+)
+
+// Config configures pricing
+type Config struct {
+	CreditValueCents float64 // cost recorded per credit; DefaultCreditValueCents when zero
+}
+
+// Item is a row of assessment_pricing
+type Item struct {
+	Product        string   `json:"product" db:"product"`
+	ItemKey        string   `json:"item_key" db:"item_key"`
+	ItemType       string   `json:"item_type" db:"item_type"`
+	Name           string   `json:"name" db:"name"`
+	Credits        int      `json:"credits" db:"credits"`
+	IncludedChecks []string `json:"included_checks" db:"included_checks"`
+	IsActive       bool     `json:"is_active" db:"is_active"`
+}
+
+// QuoteLine is an item of a quote
+type QuoteLine struct {
+	ItemType string `json:"item_type"`
+	ItemKey  string `json:"item_key"`
+	Name     string `json:"name"`
+	Credits  int    `json:"credits"`
+	Included bool   `json:"included"` // a check included in the assessment type
+}
+
+// Quote is the cost of an assessment
+type Quote struct {
+	Product         string      `json:"product"`
+	AssessmentType  string      `json:"assessment_type"`
+	AssessmentName  string      `json:"assessment_name"`
+	Checks          []string    `json:"checks"` // optional checks the assessment runs
+	Lines           []QuoteLine `json:"lines"`
+	SubtotalCredits int         `json:"subtotal_credits"`
+	DiscountPercent int         `json:"discount_percent"`
+	Credits         int         `json:"credits"`
+	Cost            float64     `json:"cost"` // USD
+}
+
+// HasCheck reports whether the assessment runs an optional check
+func (q *Quote) HasCheck(check string) bool {
+	for _, c := range q.Checks {
+		if c == check {
+			return true
+		}
+	}
+	return false
+}
+
+// Catalog prices assessments
+type Catalog struct {
+	items            map[string]Item // by product and item key
+	creditValueCents float64
+}
+
+// DefaultItems is the built-in catalog, used until the database catalog is
+// loaded. It matches the rows seeded by the schema.
+var DefaultItems = []Item{
+	{ProductWebsiteRisk, AssessmentQuick, ItemAssessment, "Quick Scan", 1, []string{CheckMCCClassification, CheckReputationLookup}, true},
+	{ProductWebsiteRisk, AssessmentComprehensive, ItemAssessment, "Comprehensive", 3, []string{CheckMCCClassification, CheckReputationLookup}, true},
+	{ProductWebsiteRisk, CheckMCCClassification, ItemCheck, "AI MCC classification", 1, nil, true},
+	{ProductWebsiteRisk, CheckReputationLookup, ItemCheck, "Reputation lookups", 1, nil, true},
+	{ProductBusinessRisk, AssessmentQuick, ItemAssessment, "Quick Scan", 1, nil, true},
+	{ProductBusinessRisk, AssessmentComprehensive, ItemAssessment, "Comprehensive", 3, []string{CheckMCCClassification, CheckReputationLookup}, true},
+	{ProductBusinessRisk, CheckMCCClassification, ItemCheck, "AI MCC classification", 1, nil, true},
+	{ProductBusinessRisk, CheckReputationLookup, ItemCheck, "Reputation lookups", 1, nil, true},
+}
+
+// NewCatalog builds a catalog from its active items
+func NewCatalog(items []Item, creditValueCents float64) *Catalog {
+	if creditValueCents <= 0 {
+		creditValueCents = DefaultCreditValueCents
+	}
+	catalog := &Catalog{items: map[string]Item{}, creditValueCents: creditValueCents}
+	for _, item := range items {
+		if item.IsActive {
+			catalog.items[item.Product+"/"+item.ItemKey] = item
+		}
+	}
+	return catalog
+}
+
+// Items returns the catalog's items ordered by product, type and key
+func (c *Catalog) Items() []Item {
+	items := make([]Item, 0, len(c.items))
+	for _, item := range c.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Product != b.Product {
+			return a.Product < b.Product
+		}
+		if a.ItemType != b.ItemType {
+			return a.ItemType == ItemAssessment
+		}
+		return a.ItemKey < b.ItemKey
+	})
+	return items
+}
+
+// lookup finds an item of a product by key or, for assessment types sent by
+// older clients such as "Quick Scan", by name
+func (c *Catalog) lookup(product, itemType, key string) (Item, bool) {
+	if item, ok := c.items[product+"/"+key]; ok && item.ItemType == itemType {
+		return item, true
+	}
+	for _, item := range c.items {
+		if item.Product == product && item.ItemType == itemType &&
+			(strings.EqualFold(item.ItemKey, key) || strings.EqualFold(item.Name, key)) {
+			return item, true
+		}
+	}
+	return Item{}, false
+}
+
+// Quote prices an assessment of a product with the optional checks asked for
+// and the plan discount in percent
+func (c *Catalog) Quote(product, assessmentType string, checks []string, discountPercent int) (*Quote, error) {
+	if product != ProductWebsiteRisk && product != ProductBusinessRisk {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProduct, product)
+	}
+	assessment, ok := c.lookup(product, ItemAssessment, strings.TrimSpace(assessmentType))
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAssessmentType, assessmentType)
+	}
+	discountPercent = min(max(discountPercent, 0), 100)
+
+	quote := &Quote{
+		Product:         product,
+		AssessmentType:  assessment.ItemKey,
+		AssessmentName:  assessment.Name,
+		Checks:          []string{},
+		Lines:           []QuoteLine{{ItemType: ItemAssessment, ItemKey: assessment.ItemKey, Name: assessment.Name, Credits: assessment.Credits}},
+		SubtotalCredits: assessment.Credits,
+		DiscountPercent: discountPercent,
+	}
+
+	seen := map[string]bool{}
+	addCheck := func(key string, included bool) error {
+		check, ok := c.lookup(product, ItemCheck, key)
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownCheck, key)
+		}
+		if seen[check.ItemKey] {
+			return nil
+		}
+		seen[check.ItemKey] = true
+
+		line := QuoteLine{ItemType: ItemCheck, ItemKey: check.ItemKey, Name: check.Name, Included: included}
+		if !included {
+			line.Credits = check.Credits
+			quote.SubtotalCredits += check.Credits
+		}
+		quote.Checks = append(quote.Checks, check.ItemKey)
+		quote.Lines = append(quote.Lines, line)
+		return nil
+	}
+	for _, key := range assessment.IncludedChecks {
+		// Included checks removed from the catalog are no longer run
+		_ = addCheck(key, true)
+	}
+	for _, key := range checks {
+		if err := addCheck(strings.TrimSpace(key), false); err != nil {
+			return nil, err
+		}
+	}
+
+	// Round the discounted price up so discounts never make an assessment free
+	quote.Credits = int(math.Ceil(float64(quote.SubtotalCredits*(100-discountPercent)) / 100))
+	quote.Cost = math.Round(float64(quote.Credits)*c.creditValueCents) / 100
+	return quote, nil
+}
+
+var (
+	supabaseClient *supa.Client
+
+	mu               sync.RWMutex
+	current          = NewCatalog(DefaultItems, DefaultCreditValueCents)
+	loadedAt         time.Time
+	creditValueCents = DefaultCreditValueCents
+)
+
+// InitPricing loads the pricing catalog from the database
+func InitPricing(url, key string, cfg Config) error {
+	client := supa.CreateClient(url, key)
+	if client == nil {
+		return fmt.Errorf("failed to create Supabase client for pricing")
+	}
+
+	mu.Lock()
+	supabaseClient = client
+	if cfg.CreditValueCents > 0 {
+		creditValueCents = cfg.CreditValueCents
+	}
+	current = NewCatalog(DefaultItems, creditValueCents)
+	mu.Unlock()
+
+	return reload()
+}
+
+// reload replaces the current catalog with the one in the database
+func reload() error {
+	var items []Item
+	if err := supabaseClient.DB.From("assessment_pricing").Select("*").Eq("is_active", "true").Execute(&items); err != nil {
+		return fmt.Errorf("failed to load assessment pricing: %v", err)
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("assessment_pricing is empty")
+	}
+
+	mu.Lock()
+	current = NewCatalog(items, creditValueCents)
+	loadedAt = time.Now()
+	mu.Unlock()
+	return nil
+}
+
+// Current returns the pricing catalog, loading it again from the database
+// once it is older than catalogTTL. Without a database the built-in catalog
+// is used.
+func Current() *Catalog {
+	mu.RLock()
+	catalog, stale := current, supabaseClient != nil && time.Since(loadedAt) > catalogTTL
+	mu.RUnlock()
+
+	if stale {
+		if err := reload(); err != nil {
+			log.Printf("⚠️ pricing: Using the previous catalog: %v", err)
+			// Wait a full TTL before trying again
+			mu.Lock()
+			loadedAt = time.Now()
+			mu.Unlock()
+		}
+		mu.RLock()
+		catalog = current
+		mu.RUnlock()
+	}
+	return catalog
+}
+
+// DiscountPercent returns the assessment discount of the plan paying for the
+// workspace's assessments. Organization wallets have no plan, so no discount.
+func DiscountPercent(workspace auth.Workspace) (int, error) {
+	if workspace.IsOrganization() {
+		return 0, nil
+	}
+
+	_, plan, err := auth.GetUserSubscription(workspace.UserID)
+	if errors.Is(err, auth.ErrSubscriptionNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return plan.AssessmentDiscountPercent, nil
+}
+
+// QuoteForWorkspace prices an assessment for a workspace, applying its plan
+// discount
+func QuoteForWorkspace(workspace auth.Workspace, product, assessmentType string, checks []string) (*Quote, error) {
+	discount, err := DiscountPercent(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch plan discount: %v", err)
+	}
+	return Current().Quote(product, assessmentType, checks, discount)
+}
+
+// IsQuoteError reports whether err is a request error of Quote rather than a
+// failure
+func IsQuoteError(err error) bool {
+	return errors.Is(err, ErrUnknownProduct) || errors.Is(err, ErrUnknownAssessmentType) || errors.Is(err, ErrUnknownCheck)
+}
@@ -0,0 +1,84 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	catalog := NewCatalog(append(DefaultItems,
+		Item{ProductWebsiteRisk, "basic", ItemAssessment, "Basic", 2, []string{CheckReputationLookup, "retired"}, true},
+		Item{ProductWebsiteRisk, "legacy", ItemAssessment, "Legacy", 9, nil, false},
+	), 0)
+
+	tests := []struct {
+		name           string
+		product        string
+		assessmentType string
+		checks         []string
+		discount       int
+		wantCredits    int
+		wantChecks     []string
+		wantCost       float64
+	}{
+		{"website quick", ProductWebsiteRisk, "quick", nil, 0, 1, []string{CheckMCCClassification, CheckReputationLookup}, 0.01},
+		{"included check is free", ProductWebsiteRisk, "comprehensive", []string{CheckReputationLookup}, 0, 3, []string{CheckMCCClassification, CheckReputationLookup}, 0.03},
+		{"business type by name", ProductBusinessRisk, "Quick Scan", nil, 0, 1, []string{}, 0.01},
+		{"add-on checks", ProductBusinessRisk, "quick", []string{CheckMCCClassification, CheckReputationLookup, CheckMCCClassification}, 0, 3, []string{CheckMCCClassification, CheckReputationLookup}, 0.03},
+		{"discount rounds up", ProductBusinessRisk, "comprehensive", nil, 50, 2, []string{CheckMCCClassification, CheckReputationLookup}, 0.02},
+		{"full discount", ProductBusinessRisk, "comprehensive", nil, 100, 0, []string{CheckMCCClassification, CheckReputationLookup}, 0},
+		{"retired included check", ProductWebsiteRisk, "basic", []string{CheckMCCClassification}, 0, 3, []string{CheckReputationLookup, CheckMCCClassification}, 0.03},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote, err := catalog.Quote(tt.product, tt.assessmentType, tt.checks, tt.discount)
+			if err != nil {
+				t.Fatalf("Quote failed: %v", err)
+			}
+			if quote.Credits != tt.wantCredits || quote.Cost != tt.wantCost {
+				t.Errorf("got %d credits costing %v, want %d costing %v", quote.Credits, quote.Cost, tt.wantCredits, tt.wantCost)
+			}
+			if len(quote.Checks) != len(tt.wantChecks) {
+				t.Fatalf("checks = %v, want %v", quote.Checks, tt.wantChecks)
+			}
+			for i, check := range tt.wantChecks {
+				if quote.Checks[i] != check {
+					t.Errorf("checks = %v, want %v", quote.Checks, tt.wantChecks)
+				}
+			}
+		})
+	}
+}
+
+func TestQuoteErrors(t *testing.T) {
+	catalog := NewCatalog(append(DefaultItems, Item{ProductWebsiteRisk, "legacy", ItemAssessment, "Legacy", 9, nil, false}), 0)
+
+	tests := map[string]struct {
+		product, assessmentType string
+		checks                  []string
+		want                    error
+	}{
+		"unknown product": {"credit_check", "quick", nil, ErrUnknownProduct},
+		"unknown type":    {ProductWebsiteRisk, "deep", nil, ErrUnknownAssessmentType},
+		"inactive type":   {ProductWebsiteRisk, "legacy", nil, ErrUnknownAssessmentType},
+		"check as type":   {ProductWebsiteRisk, CheckMCCClassification, nil, ErrUnknownAssessmentType},
+		"unknown check":   {ProductWebsiteRisk, "quick", []string{"dark_web"}, ErrUnknownCheck},
+		"type as check":   {ProductWebsiteRisk, "quick", []string{"comprehensive"}, ErrUnknownCheck},
+	}
+	for name, tt := range tests {
+		_, err := catalog.Quote(tt.product, tt.assessmentType, tt.checks, 0)
+		if !errors.Is(err, tt.want) || !IsQuoteError(err) {
+			t.Errorf("%s: got %v, want %v", name, err, tt.want)
+		}
+	}
+}
+
+func TestQuoteCreditValue(t *testing.T) {
+	quote, err := NewCatalog(DefaultItems, 2.5).Quote(ProductWebsiteRisk, "comprehensive", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Cost != 0.08 {
+		t.Errorf("cost = %v, want 0.08", quote.Cost)
+	}
+}
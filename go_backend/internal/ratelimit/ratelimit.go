@@ -0,0 +1,183 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	planCacheTTL = 5 * time.Minute
+	storeTimeout = 500 * time.Millisecond
+)
+
+// Policy is the limit applied to a group of routes. Authenticated requests
+// are counted per user and limited by their subscription plan; anonymous
+// requests are counted per client IP and get Default.
+type Policy struct {
+	Name    string
+	Window  time.Duration
+	Default int            // requests per window for anonymous users or unknown plans
+	Plans   map[string]int // requests per window by plan name, overriding the plan rate
+	// UsePlanRate derives the limit from the plan's requests_per_minute
+	UsePlanRate bool
+}
+
+// defaultPlanRates are used when a plan has no requests_per_minute set
+var defaultPlanRates = map[string]int{
+	"Free":       30,
+	"Startup":    120,
+	"Pro":        300,
+	"Enterprise": 1000,
+}
+
+// Policies applied by the API router. Route-specific policies run in
+// addition to APIPolicy and protect scrapers and SMS delivery.
+var (
+	// APIPolicy is the general per-plan limit for authenticated routes
+	APIPolicy = Policy{Name: "api", Window: time.Minute, Default: 30, UsePlanRate: true}
+
+	// PublicPolicy limits unauthenticated auth routes per client IP
+	PublicPolicy = Policy{Name: "public", Window: time.Minute, Default: 20}
+
+	// AssessmentPolicy limits new assessments, which run the website scrapers
+	AssessmentPolicy = Policy{
+		Name:    "assessment",
+		Window:  time.Minute,
+		Default: 5,
+		Plans:   map[string]int{"Free": 5, "Startup": 20, "Pro": 60, "Enterprise": 200},
+	}
+
+	// PhoneVerificationPolicy limits phone verification requests, which send SMS
+	PhoneVerificationPolicy = Policy{Name: "phone_verification", Window: 15 * time.Minute, Default: 5}
+
+	// TwoFactorPolicy limits two-factor code attempts against guessing
+	TwoFactorPolicy = Policy{Name: "two_factor", Window: 15 * time.Minute, Default: 10}
+)
+
+var (
+	store   Store = NewMemoryStore()
+	enabled       = true
+)
+
+// Init sets the counter store and whether limits are enforced
+func Init(s Store, enable bool) {
+	store = s
+	enabled = enable
+}
+
+// planCache avoids a subscription lookup per request
+var planCache = struct {
+	sync.Mutex
+	entries map[string]cachedPlan
+}{entries: map[string]cachedPlan{}}
+
+type cachedPlan struct {
+	plan     *auth.SubscriptionPlan
+	loadedAt time.Time
+}
+
+// userPlan returns the user's current plan, or nil when it cannot be determined
+func userPlan(userID string) *auth.SubscriptionPlan {
+	planCache.Lock()
+	entry, found := planCache.entries[userID]
+	planCache.Unlock()
+	if found && time.Since(entry.loadedAt) < planCacheTTL {
+		return entry.plan
+	}
+
+	_, plan, err := auth.GetUserSubscription(userID)
+	if err != nil {
+		plan = nil
+	}
+
+	planCache.Lock()
+	planCache.entries[userID] = cachedPlan{plan: plan, loadedAt: time.Now()}
+	planCache.Unlock()
+	return plan
+}
+
+// limitFor returns the number of requests a plan may make per policy window
+func (p Policy) limitFor(plan *auth.SubscriptionPlan) int {
+	if plan == nil {
+		return p.Default
+	}
+	if limit, ok := p.Plans[plan.PlanName]; ok {
+		return limit
+	}
+	if !p.UsePlanRate {
+		return p.Default
+	}
+
+	perMinute, ok := defaultPlanRates[plan.PlanName]
+	if plan.RequestsPerMinute != nil {
+		perMinute, ok = *plan.RequestsPerMinute, true
+	}
+	if !ok {
+		return p.Default
+	}
+	return int(math.Ceil(float64(perMinute) * p.Window.Minutes()))
+}
+
+// Middleware enforces policy. Place it after auth.AuthMiddleware so requests
+// are counted per user. If the store fails requests are let through.
+func Middleware(policy Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enabled {
+			c.Next()
+			return
+		}
+
+		var key string
+		var limit int
+		if userID := auth.GetUserID(c); userID != "" {
+			key = fmt.Sprintf("ratelimit:%s:user:%s", policy.Name, userID)
+			limit = policy.limitFor(userPlan(userID))
+		} else {
+			key = fmt.Sprintf("ratelimit:%s:ip:%s", policy.Name, c.ClientIP())
+			limit = policy.Default
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), storeTimeout)
+		count, reset, err := store.Increment(ctx, key, policy.Window)
+		cancel()
+		if err != nil {
+			log.Printf("⚠️ RateLimit: Store error for %s, allowing request: %v", key, err)
+			c.Next()
+			return
+		}
+
+		remaining := limit - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		resetSeconds := int(math.Ceil(reset.Seconds()))
+		c.Header("RateLimit-Limit", strconv.Itoa(limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(resetSeconds))
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit, int(policy.Window.Seconds())))
+
+		if count > limit {
+			c.Header("Retry-After", strconv.Itoa(resetSeconds))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":       "Rate limit exceeded, please retry later",
+				"code":        "RATE_LIMIT_EXCEEDED",
+				"policy":      policy.Name,
+				"limit":       limit,
+				"retry_after": resetSeconds,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
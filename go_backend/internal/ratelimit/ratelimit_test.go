@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMemoryStoreWindow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+
+	for want := 1; want <= 3; want++ {
+		count, reset, err := s.Increment(context.Background(), "k", time.Minute)
+		if err != nil || count != want || reset != time.Minute {
+			t.Fatalf("increment %d: got count=%d reset=%v err=%v", want, count, reset, err)
+		}
+	}
+
+	now = now.Add(time.Minute)
+	if count, _, _ := s.Increment(context.Background(), "k", time.Minute); count != 1 {
+		t.Errorf("expected a new window after reset, got count %d", count)
+	}
+}
+
+func TestPolicyLimitFor(t *testing.T) {
+	custom := 50
+	tests := []struct {
+		name   string
+		policy Policy
+		plan   *auth.SubscriptionPlan
+		want   int
+	}{
+		{"anonymous", APIPolicy, nil, APIPolicy.Default},
+		{"plan default", APIPolicy, &auth.SubscriptionPlan{PlanName: "Pro"}, 300},
+		{"plan column", APIPolicy, &auth.SubscriptionPlan{PlanName: "Pro", RequestsPerMinute: &custom}, 50},
+		{"route override", AssessmentPolicy, &auth.SubscriptionPlan{PlanName: "Startup"}, 20},
+		{"fixed policy", PhoneVerificationPolicy, &auth.SubscriptionPlan{PlanName: "Enterprise"}, 5},
+		{"scaled window", Policy{Window: 10 * time.Second, UsePlanRate: true}, &auth.SubscriptionPlan{PlanName: "Free"}, 5},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.limitFor(tt.plan); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestMiddlewareHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	Init(NewMemoryStore(), true)
+	t.Cleanup(func() { Init(NewMemoryStore(), true) })
+
+	router := gin.New()
+	router.POST("/login", Middleware(Policy{Name: "test", Window: time.Minute, Default: 2}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", nil))
+		if rec.Code != want {
+			t.Fatalf("request %d: expected %d, got %d", i+1, want, rec.Code)
+		}
+		if rec.Header().Get("RateLimit-Limit") != "2" || rec.Header().Get("RateLimit-Policy") != "2;w=60" {
+			t.Errorf("request %d: unexpected headers %v", i+1, rec.Header())
+		}
+		if want == http.StatusTooManyRequests {
+			if rec.Header().Get("Retry-After") == "" || rec.Header().Get("RateLimit-Remaining") != "0" {
+				t.Errorf("expected Retry-After and no remaining requests, got %v", rec.Header())
+			}
+		}
+	}
+}
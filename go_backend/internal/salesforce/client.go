@@ -0,0 +1,436 @@
+package salesforce
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported OAuth2 flows
+const (
+	AuthFlowClientCredentials = "client_credentials"
+	AuthFlowJWTBearer         = "jwt_bearer"
+)
+
+const (
+	defaultLoginURL   = "https://login.salesforce.com"
+	defaultAPIVersion = "v59.0"
+	defaultObjectType = "Opportunity"
+	defaultMaxRetries = 3
+	initialBackoff    = 500 * time.Millisecond
+	requestTimeout    = 15 * time.Second
+)
+
+// ErrNotConfigured is returned when no Salesforce client has been initialized
+var ErrNotConfigured = errors.New("salesforce integration is not configured")
+
+// FieldMap maps assessment values to Salesforce API field names. Empty entries are not written.
+type FieldMap struct {
+	QualificationStatus string
+	RiskScore           string
+	RiskCategory        string
+	ReportLink          string
+}
+
+// Config holds the connection and mapping settings for the Salesforce org
+type Config struct {
+	AuthFlow      string // client_credentials or jwt_bearer
+	LoginURL      string // token endpoint host, e.g. https://login.salesforce.com or a My Domain URL
+	InstanceURL   string // optional; overrides the instance_url returned with the token
+	APIVersion    string
+	ClientID      string
+	ClientSecret  string // client_credentials flow
+	Username      string // jwt_bearer flow
+	PrivateKeyPEM string // jwt_bearer flow, PKCS#1 or PKCS#8 RSA key
+	ObjectType    string // Opportunity or Account
+	Fields        FieldMap
+	ReportURL     string // report link template, "{id}" is replaced with the assessment ID
+	MaxRetries    int
+}
+
+// Enabled reports whether enough settings are present to authenticate
+func (c Config) Enabled() bool {
+	if c.ClientID == "" {
+		return false
+	}
+	if c.AuthFlow == AuthFlowJWTBearer {
+		return c.Username != "" && c.PrivateKeyPEM != ""
+	}
+	return c.ClientSecret != ""
+}
+
+// QualificationUpdate is the assessment outcome written back to Salesforce
+type QualificationUpdate struct {
+	AssessmentID        int64
+	QualificationStatus string
+	RiskScore           *int
+	RiskCategory        string
+}
+
+// APIError is a non-successful Salesforce REST response
+type APIError struct {
+	StatusCode int
+	ErrorCode  string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.ErrorCode != "" {
+		return fmt.Sprintf("salesforce: HTTP %d %s: %s", e.StatusCode, e.ErrorCode, e.Message)
+	}
+	return fmt.Sprintf("salesforce: HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+// retryable reports whether the request may succeed if repeated
+func (e *APIError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Client is a minimal Salesforce REST client that caches its access token
+type Client struct {
+	cfg        Config
+	httpClient *http.Client
+	privateKey *rsa.PrivateKey
+
+	mu          sync.Mutex
+	accessToken string
+	instanceURL string
+}
+
+// Default client used by the package-level helpers
+var defaultClient *Client
+
+// Init configures the package-level client. It is a no-op when the config is not enabled.
+func Init(cfg Config) error {
+	if !cfg.Enabled() {
+		return ErrNotConfigured
+	}
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	defaultClient = client
+	return nil
+}
+
+// Enabled reports whether the package-level client has been initialized
+func Enabled() bool {
+	return defaultClient != nil
+}
+
+// UpdateQualification writes an assessment outcome using the package-level client
+func UpdateQualification(ctx context.Context, recordID string, update QualificationUpdate) error {
+	if defaultClient == nil {
+		return ErrNotConfigured
+	}
+	return defaultClient.UpdateQualification(ctx, recordID, update)
+}
+
+// NewClient validates the config and creates a client
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.AuthFlow == "" {
+		cfg.AuthFlow = AuthFlowClientCredentials
+	}
+	if cfg.LoginURL == "" {
+		cfg.LoginURL = defaultLoginURL
+	}
+	if cfg.APIVersion == "" {
+		cfg.APIVersion = defaultAPIVersion
+	}
+	if cfg.ObjectType == "" {
+		cfg.ObjectType = defaultObjectType
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	cfg.LoginURL = strings.TrimRight(cfg.LoginURL, "/")
+	cfg.InstanceURL = strings.TrimRight(cfg.InstanceURL, "/")
+
+	client := &Client{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}
+
+	switch cfg.AuthFlow {
+	case AuthFlowClientCredentials:
+		if cfg.ClientID == "" || cfg.ClientSecret == "" {
+			return nil, fmt.Errorf("salesforce: client_credentials flow requires client ID and secret")
+		}
+	case AuthFlowJWTBearer:
+		if cfg.ClientID == "" || cfg.Username == "" {
+			return nil, fmt.Errorf("salesforce: jwt_bearer flow requires client ID and username")
+		}
+		key, err := parsePrivateKey(cfg.PrivateKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		client.privateKey = key
+	default:
+		return nil, fmt.Errorf("salesforce: unsupported auth flow %q", cfg.AuthFlow)
+	}
+
+	return client, nil
+}
+
+// UpdateQualification writes qualification status, risk score, risk category and
+// report link to the configured object using the field mapping
+func (c *Client) UpdateQualification(ctx context.Context, recordID string, update QualificationUpdate) error {
+	fields := map[string]interface{}{}
+	m := c.cfg.Fields
+	if m.QualificationStatus != "" && update.QualificationStatus != "" {
+		fields[m.QualificationStatus] = update.QualificationStatus
+	}
+	if m.RiskScore != "" && update.RiskScore != nil {
+		fields[m.RiskScore] = *update.RiskScore
+	}
+	if m.RiskCategory != "" && update.RiskCategory != "" {
+		fields[m.RiskCategory] = update.RiskCategory
+	}
+	if m.ReportLink != "" && c.cfg.ReportURL != "" && update.AssessmentID != 0 {
+		fields[m.ReportLink] = strings.ReplaceAll(c.cfg.ReportURL, "{id}", fmt.Sprintf("%d", update.AssessmentID))
+	}
+
+	if len(fields) == 0 {
+		return fmt.Errorf("salesforce: field mapping produced no fields to update")
+	}
+
+	return c.UpdateRecord(ctx, c.cfg.ObjectType, recordID, fields)
+}
+
+// UpdateRecord PATCHes fields on a single sObject record, retrying transient
+// failures with exponential backoff and re-authenticating once on an expired session
+func (c *Client) UpdateRecord(ctx context.Context, objectType, recordID string, fields map[string]interface{}) error {
+	if recordID == "" {
+		return fmt.Errorf("salesforce: record ID is required")
+	}
+
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("salesforce: failed to encode fields: %w", err)
+	}
+
+	backoff := initialBackoff
+	reauthenticated := false
+	var lastErr error
+
+	for attempt := 0; attempt <= c.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		token, instanceURL, err := c.token(ctx)
+		if err != nil {
+			lastErr = err
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && !apiErr.retryable() {
+				return err
+			}
+			continue
+		}
+
+		endpoint := fmt.Sprintf("%s/services/data/%s/sobjects/%s/%s",
+			instanceURL, c.cfg.APIVersion, url.PathEscape(objectType), url.PathEscape(recordID))
+		lastErr = c.patch(ctx, endpoint, token, body)
+		if lastErr == nil {
+			log.Printf("✅ Salesforce %s %s updated (%d fields)", objectType, recordID, len(fields))
+			return nil
+		}
+
+		var apiErr *APIError
+		if errors.As(lastErr, &apiErr) {
+			if apiErr.StatusCode == http.StatusUnauthorized && !reauthenticated {
+				// Session expired or revoked: fetch a new token and retry immediately
+				c.invalidateToken()
+				reauthenticated = true
+				attempt--
+				continue
+			}
+			if !apiErr.retryable() {
+				return lastErr
+			}
+		}
+
+		log.Printf("⚠️ Salesforce update of %s %s failed (attempt %d): %v", objectType, recordID, attempt+1, lastErr)
+	}
+
+	return lastErr
+}
+
+// patch sends a single PATCH request
+func (c *Client) patch(ctx context.Context, endpoint, token string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, endpoint, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return decodeAPIError(resp)
+}
+
+// token returns the cached access token, requesting a new one if needed
+func (c *Client) token(ctx context.Context) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.accessToken != "" {
+		return c.accessToken, c.instanceURL, nil
+	}
+
+	form := url.Values{}
+	switch c.cfg.AuthFlow {
+	case AuthFlowJWTBearer:
+		assertion, err := c.signAssertion()
+		if err != nil {
+			return "", "", err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	default:
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", c.cfg.ClientID)
+		form.Set("client_secret", c.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.LoginURL+"/services/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("salesforce: token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", decodeAPIError(resp)
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		InstanceURL string `json:"instance_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", "", fmt.Errorf("salesforce: invalid token response: %w", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return "", "", fmt.Errorf("salesforce: token response did not include an access token")
+	}
+
+	c.accessToken = tokenResp.AccessToken
+	c.instanceURL = c.cfg.InstanceURL
+	if c.instanceURL == "" {
+		c.instanceURL = strings.TrimRight(tokenResp.InstanceURL, "/")
+	}
+	if c.instanceURL == "" {
+		c.accessToken = ""
+		return "", "", fmt.Errorf("salesforce: no instance URL configured or returned with the token")
+	}
+
+	return c.accessToken, c.instanceURL, nil
+}
+
+// invalidateToken drops the cached token so the next request re-authenticates
+func (c *Client) invalidateToken() {
+	c.mu.Lock()
+	c.accessToken = ""
+	c.mu.Unlock()
+}
+
+// signAssertion builds the RS256 JWT used by the OAuth2 JWT bearer flow
+func (c *Client) signAssertion() (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss": c.cfg.ClientID,
+		"sub": c.cfg.Username,
+		"aud": c.cfg.LoginURL,
+		"exp": time.Now().Add(3 * time.Minute).Unix(),
+	})
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("salesforce: failed to sign JWT assertion: %w", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parsePrivateKey decodes a PEM encoded RSA private key
+func parsePrivateKey(pemData string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil {
+		return nil, fmt.Errorf("salesforce: private key is not valid PEM")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("salesforce: failed to parse private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("salesforce: private key must be RSA")
+	}
+	return key, nil
+}
+
+// decodeAPIError converts an error response into an APIError. Salesforce returns
+// either [{"errorCode","message"}] for REST calls or {"error","error_description"} for OAuth.
+func decodeAPIError(resp *http.Response) error {
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(raw))}
+
+	var restErrors []struct {
+		ErrorCode string `json:"errorCode"`
+		Message   string `json:"message"`
+	}
+	if json.Unmarshal(raw, &restErrors) == nil && len(restErrors) > 0 {
+		apiErr.ErrorCode = restErrors[0].ErrorCode
+		apiErr.Message = restErrors[0].Message
+		return apiErr
+	}
+
+	var oauthErr struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if json.Unmarshal(raw, &oauthErr) == nil && oauthErr.Error != "" {
+		apiErr.ErrorCode = oauthErr.Error
+		apiErr.Message = oauthErr.Description
+	}
+	return apiErr
+}
@@ -0,0 +1,135 @@
+package salesforce
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"testing"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/salesforce/salesforcetest"
+)
+
+func testConfig(loginURL string) Config {
+	return Config{
+		LoginURL:     loginURL,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		Fields: FieldMap{
+			QualificationStatus: "Qualification_Status__c",
+			RiskScore:           "Risk_Score__c",
+			ReportLink:          "Risk_Report_URL__c",
+		},
+		ReportURL: "https://app.example.com/assessments/{id}",
+	}
+}
+
+func TestUpdateQualificationWritesMappedFields(t *testing.T) {
+	server := salesforcetest.NewServer()
+	defer server.Close()
+
+	client, err := NewClient(testConfig(server.URL))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	score := 42
+	err = client.UpdateQualification(context.Background(), "006000000000001", QualificationUpdate{
+		AssessmentID:        7,
+		QualificationStatus: "Qualified",
+		RiskScore:           &score,
+		RiskCategory:        "low_risk",
+	})
+	if err != nil {
+		t.Fatalf("UpdateQualification: %v", err)
+	}
+
+	updates := server.Updates()
+	if len(updates) != 1 {
+		t.Fatalf("expected 1 update, got %d", len(updates))
+	}
+	u := updates[0]
+	if u.ObjectType != "Opportunity" || u.RecordID != "006000000000001" {
+		t.Errorf("updated %s/%s", u.ObjectType, u.RecordID)
+	}
+	if u.Fields["Qualification_Status__c"] != "Qualified" || u.Fields["Risk_Score__c"] != float64(42) {
+		t.Errorf("unexpected fields: %v", u.Fields)
+	}
+	if u.Fields["Risk_Report_URL__c"] != "https://app.example.com/assessments/7" {
+		t.Errorf("unexpected report link: %v", u.Fields["Risk_Report_URL__c"])
+	}
+	if len(u.Fields) != 3 {
+		t.Errorf("unmapped risk category should not be written: %v", u.Fields)
+	}
+}
+
+func TestUpdateRecordRetriesTransientFailures(t *testing.T) {
+	server := salesforcetest.NewServer()
+	defer server.Close()
+	server.FailNext(2, http.StatusServiceUnavailable)
+
+	client, _ := NewClient(testConfig(server.URL))
+	if err := client.UpdateRecord(context.Background(), "Account", "001", map[string]interface{}{"Name": "x"}); err != nil {
+		t.Fatalf("expected retries to succeed, got %v", err)
+	}
+	if len(server.Updates()) != 1 {
+		t.Errorf("expected update after retries")
+	}
+
+	server.FailNext(1, http.StatusBadRequest)
+	err := client.UpdateRecord(context.Background(), "Account", "001", map[string]interface{}{"Name": "x"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected non-retryable 400, got %v", err)
+	}
+}
+
+func TestUpdateRecordReauthenticatesOnExpiredSession(t *testing.T) {
+	server := salesforcetest.NewServer()
+	defer server.Close()
+
+	client, _ := NewClient(testConfig(server.URL))
+	ctx := context.Background()
+	if err := client.UpdateRecord(ctx, "Opportunity", "006", map[string]interface{}{"StageName": "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	server.ExpireToken()
+	if err := client.UpdateRecord(ctx, "Opportunity", "006", map[string]interface{}{"StageName": "y"}); err != nil {
+		t.Fatalf("expected re-authentication, got %v", err)
+	}
+	if got := server.TokenRequests(); got != 2 {
+		t.Errorf("expected 2 token requests, got %d", got)
+	}
+}
+
+func TestJWTBearerFlow(t *testing.T) {
+	server := salesforcetest.NewServer()
+	defer server.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	cfg := testConfig(server.URL)
+	cfg.AuthFlow = AuthFlowJWTBearer
+	cfg.ClientSecret = ""
+	cfg.Username = "integration@example.com"
+	cfg.PrivateKeyPEM = string(keyPEM)
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if err := client.UpdateRecord(context.Background(), "Opportunity", "006", map[string]interface{}{"StageName": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if grants := server.GrantTypes(); len(grants) != 1 || grants[0] != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		t.Errorf("unexpected grant types: %v", grants)
+	}
+}
@@ -0,0 +1,154 @@
+// Package salesforcetest provides a local fake of the Salesforce OAuth2 and
+// sObject REST endpoints for tests and local development.
+package salesforcetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Update is a PATCH received by the fake server
+type Update struct {
+	ObjectType string
+	RecordID   string
+	Fields     map[string]interface{}
+}
+
+// Server is a fake Salesforce org. It issues a new access token per token
+// request and accepts sObject PATCHes made with the current token.
+type Server struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	currentToken  string
+	tokenRequests int
+	grantTypes    []string
+	updates       []Update
+	failures      int
+	failureStatus int
+}
+
+// NewServer starts a fake Salesforce server. Call Close when done.
+func NewServer() *Server {
+	s := &Server{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/oauth2/token", s.handleToken)
+	mux.HandleFunc("/services/data/", s.handleSObject)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// FailNext makes the next n sObject requests respond with the given status
+func (s *Server) FailNext(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = n
+	s.failureStatus = status
+}
+
+// ExpireToken revokes the current access token so the next request gets a 401
+func (s *Server) ExpireToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentToken = ""
+}
+
+// Updates returns the PATCHes accepted so far
+func (s *Server) Updates() []Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Update(nil), s.updates...)
+}
+
+// TokenRequests returns how many access tokens were issued
+func (s *Server) TokenRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokenRequests
+}
+
+// GrantTypes returns the grant_type of each token request
+func (s *Server) GrantTypes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.grantTypes...)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	switch grantType {
+	case "client_credentials":
+		if r.PostForm.Get("client_id") == "" || r.PostForm.Get("client_secret") == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_client", "error_description": "missing client credentials"})
+			return
+		}
+	case "urn:ietf:params:oauth:grant-type:jwt-bearer":
+		if strings.Count(r.PostForm.Get("assertion"), ".") != 2 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "malformed assertion"})
+			return
+		}
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	s.mu.Lock()
+	s.tokenRequests++
+	s.grantTypes = append(s.grantTypes, grantType)
+	s.currentToken = fmt.Sprintf("fake-token-%d", s.tokenRequests)
+	token := s.currentToken
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": token,
+		"instance_url": s.URL,
+		"token_type":   "Bearer",
+	})
+}
+
+func (s *Server) handleSObject(w http.ResponseWriter, r *http.Request) {
+	// /services/data/{version}/sobjects/{object}/{id}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/services/data/"), "/")
+	if r.Method != http.MethodPatch || len(parts) != 4 || parts[1] != "sobjects" {
+		writeJSON(w, http.StatusNotFound, []map[string]string{{"errorCode": "NOT_FOUND", "message": "The requested resource does not exist"}})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.currentToken == "" || r.Header.Get("Authorization") != "Bearer "+s.currentToken {
+		writeJSON(w, http.StatusUnauthorized, []map[string]string{{"errorCode": "INVALID_SESSION_ID", "message": "Session expired or invalid"}})
+		return
+	}
+
+	if s.failures > 0 {
+		s.failures--
+		writeJSON(w, s.failureStatus, []map[string]string{{"errorCode": "SERVER_UNAVAILABLE", "message": "Try again later"}})
+		return
+	}
+
+	var fields map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		writeJSON(w, http.StatusBadRequest, []map[string]string{{"errorCode": "JSON_PARSER_ERROR", "message": err.Error()}})
+		return
+	}
+
+	s.updates = append(s.updates, Update{ObjectType: parts[2], RecordID: parts[3], Fields: fields})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
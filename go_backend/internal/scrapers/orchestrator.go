@@ -17,8 +17,24 @@ func NewWebsiteRiskAssessmentOrchestrator() *WebsiteRiskAssessmentOrchestrator {
 	return &WebsiteRiskAssessmentOrchestrator{}
 }
 
+// AssessmentOptions turns off the optional checks of an assessment. The zero
+// value runs every check.
+type AssessmentOptions struct {
+	SkipMCCClassification bool // use keyword matching instead of the AI classifier
+	SkipReputationLookups bool // skip URLVoid, IPVoid and Google Safe Browsing
+}
+
+// skippedCheck is the error recorded for checks not included in an assessment
+const skippedCheck = "Not included in this assessment"
+
 // RunAssessment runs the complete risk assessment for a domain
 func (o *WebsiteRiskAssessmentOrchestrator) RunAssessment(domainName, countryCode string) (*RiskAssessment, error) {
+	return o.RunAssessmentWithOptions(domainName, countryCode, AssessmentOptions{})
+}
+
+// RunAssessmentWithOptions runs the risk assessment for a domain without the
+// checks turned off in opts
+func (o *WebsiteRiskAssessmentOrchestrator) RunAssessmentWithOptions(domainName, countryCode string, opts AssessmentOptions) (*RiskAssessment, error) {
 	log.Printf("Starting risk assessment for domain: %s", domainName)
 
 	// Initialize assessment
@@ -40,7 +56,11 @@ func (o *WebsiteRiskAssessmentOrchestrator) RunAssessment(domainName, countryCod
 	// Step 2: Check MCC
 	log.Println("Running MCC classification...")
 	start := time.Now()
-	assessment.MCCDetails = CheckMCC(domainName)
+	if opts.SkipMCCClassification {
+		assessment.MCCDetails = fallbackMCCClassification(domainName)
+	} else {
+		assessment.MCCDetails = CheckMCC(domainName)
+	}
 	log.Printf("MCC classification completed in %v", time.Since(start))
 
 	// Step 3: Run all scrapers
@@ -77,10 +97,14 @@ func (o *WebsiteRiskAssessmentOrchestrator) RunAssessment(domainName, countryCod
 	log.Printf("WHOIS check completed in %v", time.Since(start))
 
 	// URLVoid Check
-	log.Println("Running URLVoid check...")
-	start = time.Now()
-	assessment.URLVoid = GetURLVoidData(domainName)
-	log.Printf("URLVoid check completed in %v", time.Since(start))
+	if opts.SkipReputationLookups {
+		assessment.URLVoid = URLVoidResult{Error: skippedCheck}
+	} else {
+		log.Println("Running URLVoid check...")
+		start = time.Now()
+		assessment.URLVoid = GetURLVoidData(domainName)
+		log.Printf("URLVoid check completed in %v", time.Since(start))
+	}
 
 	// GoDaddy WHOIS Check
 	log.Println("Running GoDaddy WHOIS check...")
@@ -89,10 +113,14 @@ func (o *WebsiteRiskAssessmentOrchestrator) RunAssessment(domainName, countryCod
 	log.Printf("GoDaddy WHOIS check completed in %v", time.Since(start))
 
 	// Google Safe Browsing Check
-	log.Println("Running Google Safe Browsing check...")
-	start = time.Now()
-	assessment.GoogleSafeBrowsing = GetGoogleSafeBrowsingData(domainName)
-	log.Printf("Google Safe Browsing check completed in %v", time.Since(start))
+	if opts.SkipReputationLookups {
+		assessment.GoogleSafeBrowsing = GoogleSafeBrowsingResult{Domain: domainName, Error: skippedCheck}
+	} else {
+		log.Println("Running Google Safe Browsing check...")
+		start = time.Now()
+		assessment.GoogleSafeBrowsing = GetGoogleSafeBrowsingData(domainName)
+		log.Printf("Google Safe Browsing check completed in %v", time.Since(start))
+	}
 
 	// Tranco List Ranking Check
 	log.Println("Running Tranco List ranking check...")
@@ -122,7 +150,9 @@ func (o *WebsiteRiskAssessmentOrchestrator) RunAssessment(domainName, countryCod
 	log.Println("Running IPVoid check...")
 	start = time.Now()
 	ipAddress := ExtractIPFromURLVoid(assessment.URLVoid)
-	if ipAddress != "" {
+	if opts.SkipReputationLookups {
+		assessment.IPVoid = IPVoidResult{Error: skippedCheck}
+	} else if ipAddress != "" {
 		assessment.IPVoid = GetIPVoidData(ipAddress)
 		log.Printf("IPVoid check completed for IP: %s in %v", ipAddress, time.Since(start))
 	} else {
@@ -0,0 +1,69 @@
+package sms
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TwilioStatusHandler handles POST /api/sms/status/twilio, the StatusCallback
+// Twilio calls as messages and calls progress. Requests must carry a valid
+// X-Twilio-Signature for the configured callback URL.
+func TwilioStatusHandler(c *gin.Context) {
+	mu.RLock()
+	cfg := twilio
+	mu.RUnlock()
+
+	if cfg.AuthToken == "" || cfg.StatusCallbackURL == "" {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Twilio status callbacks are not configured",
+			"code":  "NOT_CONFIGURED",
+		})
+		return
+	}
+
+	if err := c.Request.ParseForm(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid callback body",
+			"code":  "INVALID_REQUEST",
+		})
+		return
+	}
+	if !ValidateTwilioSignature(cfg.AuthToken, cfg.StatusCallbackURL, c.Request.PostForm, c.GetHeader("X-Twilio-Signature")) {
+		log.Printf("🚫 TwilioStatusHandler: Rejected callback with invalid signature from %s", c.ClientIP())
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Invalid signature",
+			"code":  "INVALID_SIGNATURE",
+		})
+		return
+	}
+
+	sid, providerStatus := c.Request.PostForm.Get("MessageSid"), c.Request.PostForm.Get("MessageStatus")
+	if sid == "" {
+		sid, providerStatus = c.Request.PostForm.Get("CallSid"), c.Request.PostForm.Get("CallStatus")
+	}
+	if sid == "" || providerStatus == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing message or call status",
+			"code":  "INVALID_REQUEST",
+		})
+		return
+	}
+
+	errorMessage := ""
+	if code := c.Request.PostForm.Get("ErrorCode"); code != "" {
+		errorMessage = "twilio error " + code
+	}
+
+	if err := UpdateStatus(ProviderTwilio, sid, twilioStatus(providerStatus), providerStatus, errorMessage); err != nil {
+		log.Printf("❌ TwilioStatusHandler: Failed to update %s: %v", sid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to record delivery status",
+			"code":  "DATABASE_ERROR",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
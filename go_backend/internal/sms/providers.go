@@ -0,0 +1,197 @@
+package sms
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// SNSSender sends SMS through AWS SNS. SNS does not report delivery, so
+// messages stay "sent".
+type SNSSender struct {
+	client   *sns.Client
+	senderID string
+}
+
+// NewSNSSender creates an SNS sender using the default AWS credentials
+func NewSNSSender(ctx context.Context, region, senderID string) (*SNSSender, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+	return &SNSSender{client: sns.NewFromConfig(cfg), senderID: senderID}, nil
+}
+
+// Name implements SMSSender
+func (s *SNSSender) Name() string { return ProviderSNS }
+
+// Send implements SMSSender
+func (s *SNSSender) Send(ctx context.Context, to, body string) (*Result, error) {
+	result, err := s.client.Publish(ctx, &sns.PublishInput{
+		Message:     aws.String(body),
+		PhoneNumber: aws.String(to),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"AWS.SNS.SMS.SenderID": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(s.senderID),
+			},
+			"AWS.SNS.SMS.SMSType": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String("Transactional"),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Result{ProviderMessageID: aws.ToString(result.MessageId), Status: StatusSent}, nil
+}
+
+// TwilioConfig configures the Twilio sender
+type TwilioConfig struct {
+	AccountSID string
+	AuthToken  string
+	From       string // E.164 number or messaging service SID (MG...)
+	// StatusCallbackURL is the public URL of TwilioStatusHandler. Delivery
+	// reports are requested only when it is set.
+	StatusCallbackURL string
+}
+
+// Enabled reports whether the Twilio credentials are configured
+func (c TwilioConfig) Enabled() bool {
+	return c.AccountSID != "" && c.AuthToken != "" && c.From != ""
+}
+
+const twilioAPIBaseURL = "https://api.twilio.com/2010-04-01"
+
+// TwilioSender sends SMS and places voice calls through the Twilio REST API
+type TwilioSender struct {
+	config  TwilioConfig
+	baseURL string
+	client  *http.Client
+}
+
+// NewTwilioSender creates a Twilio sender
+func NewTwilioSender(cfg TwilioConfig, client *http.Client) *TwilioSender {
+	return &TwilioSender{config: cfg, baseURL: twilioAPIBaseURL, client: client}
+}
+
+// Name implements SMSSender and Caller
+func (t *TwilioSender) Name() string { return ProviderTwilio }
+
+// Send implements SMSSender
+func (t *TwilioSender) Send(ctx context.Context, to, body string) (*Result, error) {
+	form := url.Values{"To": {to}, "Body": {body}}
+	if strings.HasPrefix(t.config.From, "MG") {
+		form.Set("MessagingServiceSid", t.config.From)
+	} else {
+		form.Set("From", t.config.From)
+	}
+	if t.config.StatusCallbackURL != "" {
+		form.Set("StatusCallback", t.config.StatusCallbackURL)
+	}
+	return t.post(ctx, "Messages.json", form)
+}
+
+// Call implements Caller
+func (t *TwilioSender) Call(ctx context.Context, to, message, language string) (*Result, error) {
+	if strings.HasPrefix(t.config.From, "MG") {
+		return nil, fmt.Errorf("voice calls require a Twilio phone number, not a messaging service")
+	}
+	twiml := fmt.Sprintf(`<Response><Say language="%s">%s</Say></Response>`, html.EscapeString(language), html.EscapeString(message))
+	form := url.Values{"To": {to}, "From": {t.config.From}, "Twiml": {twiml}}
+	if t.config.StatusCallbackURL != "" {
+		form.Set("StatusCallback", t.config.StatusCallbackURL)
+	}
+	return t.post(ctx, "Calls.json", form)
+}
+
+// twilioResponse is the subset of a Twilio message, call or error response we use
+type twilioResponse struct {
+	SID     string `json:"sid"`
+	Status  string `json:"status"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (t *TwilioSender) post(ctx context.Context, resource string, form url.Values) (*Result, error) {
+	endpoint := fmt.Sprintf("%s/Accounts/%s/%s", t.baseURL, t.config.AccountSID, resource)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(t.config.AccountSID, t.config.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var payload twilioResponse
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("twilio returned status %d with an unreadable body: %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("twilio returned status %d: %s (code %d)", resp.StatusCode, payload.Message, payload.Code)
+	}
+	return &Result{ProviderMessageID: payload.SID, Status: twilioStatus(payload.Status)}, nil
+}
+
+// twilioStatus maps Twilio message and call statuses to delivery statuses
+func twilioStatus(status string) string {
+	switch status {
+	case "accepted", "scheduled", "queued", "sending", "initiated", "ringing":
+		return StatusQueued
+	case "sent", "in-progress":
+		return StatusSent
+	case "delivered", "read", "completed":
+		return StatusDelivered
+	case "undelivered", "busy", "no-answer", "canceled":
+		return StatusUndelivered
+	case "failed":
+		return StatusFailed
+	default:
+		return StatusSent
+	}
+}
+
+// ValidateTwilioSignature checks the X-Twilio-Signature of a callback: the
+// base64 HMAC-SHA1, keyed by the auth token, of the callback URL followed by
+// each POST parameter name and value in name order.
+func ValidateTwilioSignature(authToken, callbackURL string, params url.Values, signature string) bool {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var data strings.Builder
+	data.WriteString(callbackURL)
+	for _, name := range names {
+		for _, value := range params[name] {
+			data.WriteString(name)
+			data.WriteString(value)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(data.String()))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
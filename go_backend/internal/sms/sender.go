@@ -0,0 +1,323 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	supa "github.com/nedpals/supabase-go"
+)
+
+const sendTimeout = 15 * time.Second
+
+// Config selects and configures the SMS providers
+type Config struct {
+	// Enabled sends through real providers. When false messages go to the
+	// development sinks: SinkFile and SinkURL if set, and the server log.
+	Enabled bool
+	// Providers lists the providers to try, in failover order
+	Providers []string
+	AWSRegion string
+	SenderID  string // alphanumeric sender ID shown by SNS where supported
+	Twilio    TwilioConfig
+	SinkFile  string
+	SinkURL   string
+}
+
+var (
+	supabaseClient *supa.Client
+
+	mu      sync.RWMutex
+	senders []SMSSender = []SMSSender{LogSink{}}
+	callers []Caller    = []Caller{LogSink{}}
+	twilio  TwilioConfig
+)
+
+// InitSMS initializes delivery tracking and the configured providers. url and
+// key may be empty, in which case messages are sent but not recorded.
+func InitSMS(url, key string, cfg Config) error {
+	if url != "" && key != "" {
+		client := supa.CreateClient(url, key)
+		if client == nil {
+			return fmt.Errorf("failed to create Supabase client for SMS")
+		}
+		supabaseClient = client
+	}
+
+	newSenders, newCallers, err := buildProviders(cfg)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	senders, callers, twilio = newSenders, newCallers, cfg.Twilio
+	mu.Unlock()
+
+	names := make([]string, 0, len(newSenders))
+	for _, sender := range newSenders {
+		names = append(names, sender.Name())
+	}
+	log.Printf("📱 SMS providers: %s (voice: %t)", strings.Join(names, " → "), len(newCallers) > 0)
+	return nil
+}
+
+// SetProviders replaces the providers, mainly for tests
+func SetProviders(s []SMSSender, c []Caller) {
+	mu.Lock()
+	senders, callers = s, c
+	mu.Unlock()
+}
+
+// buildProviders creates the senders and callers for a configuration.
+// Providers that are not configured are skipped with a warning.
+func buildProviders(cfg Config) ([]SMSSender, []Caller, error) {
+	httpClient := &http.Client{Timeout: sendTimeout}
+
+	if !cfg.Enabled {
+		var s []SMSSender
+		var c []Caller
+		if cfg.SinkFile != "" {
+			sink := NewFileSink(cfg.SinkFile)
+			s, c = append(s, sink), append(c, sink)
+		}
+		if cfg.SinkURL != "" {
+			sink := NewHTTPSink(cfg.SinkURL, httpClient)
+			s, c = append(s, sink), append(c, sink)
+		}
+		return append(s, LogSink{}), append(c, LogSink{}), nil
+	}
+
+	var s []SMSSender
+	var c []Caller
+	for _, name := range cfg.Providers {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case ProviderSNS:
+			sender, err := NewSNSSender(context.Background(), cfg.AWSRegion, cfg.SenderID)
+			if err != nil {
+				log.Printf("⚠️ SMS: Skipping SNS provider: %v", err)
+				continue
+			}
+			s = append(s, sender)
+		case ProviderTwilio:
+			if !cfg.Twilio.Enabled() {
+				log.Printf("⚠️ SMS: Skipping Twilio provider, TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM are required")
+				continue
+			}
+			sender := NewTwilioSender(cfg.Twilio, httpClient)
+			s = append(s, sender)
+			c = append(c, sender)
+		case ProviderFile:
+			if cfg.SinkFile == "" {
+				log.Printf("⚠️ SMS: Skipping file provider, SMS_SINK_FILE is not set")
+				continue
+			}
+			sink := NewFileSink(cfg.SinkFile)
+			s, c = append(s, sink), append(c, sink)
+		case ProviderHTTP:
+			if cfg.SinkURL == "" {
+				log.Printf("⚠️ SMS: Skipping http provider, SMS_SINK_URL is not set")
+				continue
+			}
+			sink := NewHTTPSink(cfg.SinkURL, httpClient)
+			s, c = append(s, sink), append(c, sink)
+		case ProviderLog:
+			s, c = append(s, LogSink{}), append(c, LogSink{})
+		case "":
+		default:
+			log.Printf("⚠️ SMS: Ignoring unknown provider %q", name)
+		}
+	}
+	if len(s) == 0 {
+		return nil, nil, fmt.Errorf("no SMS provider could be configured from %v", cfg.Providers)
+	}
+	return s, c, nil
+}
+
+// VoiceAvailable reports whether a provider can deliver messages by voice call
+func VoiceAvailable() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(callers) > 0
+}
+
+// Send renders a template and sends it by SMS, failing over to the next
+// provider when one fails. The returned message records the outcome.
+func Send(ctx context.Context, req Request) (*Message, error) {
+	body, locale, err := Render(req.Template, req.Locale, req.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.RLock()
+	attempts := make([]attempt, 0, len(senders))
+	for _, sender := range senders {
+		sender := sender
+		attempts = append(attempts, attempt{sender.Name(), func(ctx context.Context) (*Result, error) {
+			return sender.Send(ctx, req.To, body)
+		}})
+	}
+	mu.RUnlock()
+
+	return deliver(ctx, newMessage(req, ChannelSMS, locale), attempts)
+}
+
+// Call renders a template and reads it to the recipient in a voice call,
+// failing over between voice-capable providers
+func Call(ctx context.Context, req Request) (*Message, error) {
+	body, locale, err := Render(req.Template, req.Locale, req.Data)
+	if err != nil {
+		return nil, err
+	}
+	language := voiceLanguage(locale)
+
+	mu.RLock()
+	attempts := make([]attempt, 0, len(callers))
+	for _, caller := range callers {
+		caller := caller
+		attempts = append(attempts, attempt{caller.Name(), func(ctx context.Context) (*Result, error) {
+			return caller.Call(ctx, req.To, body, language)
+		}})
+	}
+	mu.RUnlock()
+
+	if len(attempts) == 0 {
+		return nil, fmt.Errorf("voice delivery is not configured")
+	}
+	return deliver(ctx, newMessage(req, ChannelVoice, locale), attempts)
+}
+
+// attempt is one provider's try at delivering a message
+type attempt struct {
+	provider string
+	send     func(ctx context.Context) (*Result, error)
+}
+
+func newMessage(req Request, channel, locale string) *Message {
+	now := time.Now().UTC()
+	msg := &Message{
+		ID:        uuid.New().String(),
+		Channel:   channel,
+		ToNumber:  req.To,
+		Template:  req.Template,
+		Locale:    locale,
+		Status:    StatusQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if req.UserID != "" {
+		msg.UserID = &req.UserID
+	}
+	return msg
+}
+
+// deliver tries each provider in order until one accepts the message
+func deliver(ctx context.Context, msg *Message, attempts []attempt) (*Message, error) {
+	var failures []string
+	for _, a := range attempts {
+		msg.Attempts++
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		result, err := a.send(sendCtx)
+		cancel()
+		if err != nil {
+			log.Printf("⚠️ SMS: %s delivery of %s to %s via %s failed: %v", msg.Channel, msg.Template, msg.ToNumber, a.provider, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", a.provider, err))
+			continue
+		}
+
+		provider := a.provider
+		msg.Provider = &provider
+		msg.ProviderMessageID = &result.ProviderMessageID
+		msg.Status = result.Status
+		recordMessage(msg)
+		log.Printf("✅ SMS: %s %s sent to %s via %s (%s)", msg.Channel, msg.Template, msg.ToNumber, provider, result.ProviderMessageID)
+		return msg, nil
+	}
+
+	errorMessage := strings.Join(failures, "; ")
+	msg.Status = StatusFailed
+	msg.ErrorMessage = &errorMessage
+	recordMessage(msg)
+	return msg, fmt.Errorf("all SMS providers failed: %s", errorMessage)
+}
+
+// recordMessage stores a message for delivery tracking
+func recordMessage(msg *Message) {
+	if supabaseClient == nil {
+		return
+	}
+
+	var results []Message
+	err := supabaseClient.DB.From("sms_messages").Insert(msg).Execute(&results)
+	if err != nil {
+		log.Printf("⚠️ SMS: Failed to record message %s: %v", msg.ID, err)
+	}
+}
+
+// GetMessage returns a tracked message by ID
+func GetMessage(id string) (*Message, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var results []Message
+	err := supabaseClient.DB.From("sms_messages").
+		Select("*").
+		Eq("id", id).
+		Execute(&results)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch SMS message: %v", err)
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+	return &results[0], nil
+}
+
+// UpdateStatus applies a provider's delivery report. Reports that arrive out
+// of order never move a message back to an earlier status.
+func UpdateStatus(provider, providerMessageID, status, providerStatus, errorMessage string) error {
+	if supabaseClient == nil {
+		return fmt.Errorf("supabase client not initialized")
+	}
+
+	var results []Message
+	err := supabaseClient.DB.From("sms_messages").
+		Select("*").
+		Eq("provider", provider).
+		Eq("provider_message_id", providerMessageID).
+		Execute(&results)
+	if err != nil {
+		return fmt.Errorf("failed to fetch SMS message: %v", err)
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	msg := results[0]
+	if statusRank[status] < statusRank[msg.Status] || msg.Status == status {
+		return nil
+	}
+
+	update := map[string]interface{}{
+		"status":          status,
+		"provider_status": providerStatus,
+		"updated_at":      time.Now().UTC().Format(time.RFC3339),
+	}
+	if errorMessage != "" {
+		update["error_message"] = errorMessage
+	}
+
+	var updated []Message
+	err = supabaseClient.DB.From("sms_messages").
+		Update(update).
+		Eq("id", msg.ID).
+		Execute(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to update SMS message: %v", err)
+	}
+	return nil
+}
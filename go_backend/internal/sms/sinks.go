@@ -0,0 +1,146 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Development sinks implement both SMSSender and Caller so the whole
+// verification flow can be exercised without a provider account.
+
+// sinkRecord is what the file and HTTP sinks write for each message
+type sinkRecord struct {
+	ID       string    `json:"id"`
+	Channel  string    `json:"channel"`
+	To       string    `json:"to"`
+	Body     string    `json:"body"`
+	Language string    `json:"language,omitempty"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+func newSinkRecord(channel, to, body, language string) sinkRecord {
+	return sinkRecord{
+		ID:       uuid.New().String(),
+		Channel:  channel,
+		To:       to,
+		Body:     body,
+		Language: language,
+		SentAt:   time.Now().UTC(),
+	}
+}
+
+// LogSink writes messages to the server log
+type LogSink struct{}
+
+// Name implements SMSSender and Caller
+func (LogSink) Name() string { return ProviderLog }
+
+// Send implements SMSSender
+func (LogSink) Send(_ context.Context, to, body string) (*Result, error) {
+	log.Printf("📱 [DEV MODE] SMS to %s: %s", to, body)
+	return &Result{ProviderMessageID: uuid.New().String(), Status: StatusSent}, nil
+}
+
+// Call implements Caller
+func (LogSink) Call(_ context.Context, to, message, language string) (*Result, error) {
+	log.Printf("📞 [DEV MODE] Voice call to %s (%s): %s", to, language, message)
+	return &Result{ProviderMessageID: uuid.New().String(), Status: StatusSent}, nil
+}
+
+// FileSink appends messages to a file as JSON lines
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileSink creates a sink writing to path
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+// Name implements SMSSender and Caller
+func (f *FileSink) Name() string { return ProviderFile }
+
+// Send implements SMSSender
+func (f *FileSink) Send(_ context.Context, to, body string) (*Result, error) {
+	return f.write(newSinkRecord(ChannelSMS, to, body, ""))
+}
+
+// Call implements Caller
+func (f *FileSink) Call(_ context.Context, to, message, language string) (*Result, error) {
+	return f.write(newSinkRecord(ChannelVoice, to, message, language))
+}
+
+func (f *FileSink) write(record sinkRecord) (*Result, error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SMS sink file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to write SMS sink file: %v", err)
+	}
+	return &Result{ProviderMessageID: record.ID, Status: StatusSent}, nil
+}
+
+// HTTPSink posts messages as JSON to a URL, such as a local mock server
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink creates a sink posting to url
+func NewHTTPSink(url string, client *http.Client) *HTTPSink {
+	return &HTTPSink{url: url, client: client}
+}
+
+// Name implements SMSSender and Caller
+func (h *HTTPSink) Name() string { return ProviderHTTP }
+
+// Send implements SMSSender
+func (h *HTTPSink) Send(ctx context.Context, to, body string) (*Result, error) {
+	return h.post(ctx, newSinkRecord(ChannelSMS, to, body, ""))
+}
+
+// Call implements Caller
+func (h *HTTPSink) Call(ctx context.Context, to, message, language string) (*Result, error) {
+	return h.post(ctx, newSinkRecord(ChannelVoice, to, message, language))
+}
+
+func (h *HTTPSink) post(ctx context.Context, record sinkRecord) (*Result, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("SMS sink returned status %d", resp.StatusCode)
+	}
+	return &Result{ProviderMessageID: record.ID, Status: StatusSent}, nil
+}
@@ -0,0 +1,171 @@
+package sms
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRenderLocaleFallback(t *testing.T) {
+	data := map[string]interface{}{"Code": "123456", "ValidMinutes": 10}
+
+	tests := []struct {
+		locale     string
+		wantLocale string
+		contains   string
+	}{
+		{"es", "es", "Tu código de verificación"},
+		{"pt-BR", "pt", "Seu código"},
+		{"de_AT", "de", "Bestätigungscode"},
+		{"ja", "en", "Your QuarkfinAI verification code is: 123456"},
+		{"", "en", "Valid for 10 minutes"},
+	}
+	for _, tt := range tests {
+		body, locale, err := Render(TemplateVerificationCode, tt.locale, data)
+		if err != nil {
+			t.Fatalf("Render(%q): %v", tt.locale, err)
+		}
+		if locale != tt.wantLocale {
+			t.Errorf("Render(%q) locale = %q, want %q", tt.locale, locale, tt.wantLocale)
+		}
+		if !strings.Contains(body, tt.contains) {
+			t.Errorf("Render(%q) = %q, want it to contain %q", tt.locale, body, tt.contains)
+		}
+	}
+
+	body, _, err := Render(TemplateVerificationCall, "en", map[string]interface{}{"Code": "4071"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, "4, 0, 7, 1") {
+		t.Errorf("voice template should spell the code, got %q", body)
+	}
+
+	if _, _, err := Render(TemplateVerificationCode, "en", map[string]interface{}{}); err == nil {
+		t.Error("expected an error for missing template data")
+	}
+}
+
+func TestLocaleForCountry(t *testing.T) {
+	for country, want := range map[string]string{"MX": "es", "br": "pt", "US": "en", "": "en"} {
+		if got := LocaleForCountry(country); got != want {
+			t.Errorf("LocaleForCountry(%q) = %q, want %q", country, got, want)
+		}
+	}
+}
+
+type fakeSender struct {
+	name string
+	err  error
+	sent []string
+}
+
+func (f *fakeSender) Name() string { return f.name }
+
+func (f *fakeSender) Send(_ context.Context, to, body string) (*Result, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.sent = append(f.sent, to+": "+body)
+	return &Result{ProviderMessageID: f.name + "-1", Status: StatusSent}, nil
+}
+
+func TestSendFailsOver(t *testing.T) {
+	broken := &fakeSender{name: "primary", err: errors.New("throttled")}
+	backup := &fakeSender{name: "backup"}
+	SetProviders([]SMSSender{broken, backup}, nil)
+	defer SetProviders([]SMSSender{LogSink{}}, []Caller{LogSink{}})
+
+	msg, err := Send(context.Background(), Request{
+		To:       "+14155550123",
+		Template: TemplateVerificationCode,
+		Locale:   "fr",
+		Data:     map[string]interface{}{"Code": "654321", "ValidMinutes": 10},
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if msg.Attempts != 2 || *msg.Provider != "backup" || msg.Status != StatusSent || msg.Locale != "fr" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if len(backup.sent) != 1 || !strings.Contains(backup.sent[0], "654321") {
+		t.Errorf("backup sender got %v", backup.sent)
+	}
+
+	SetProviders([]SMSSender{broken}, nil)
+	msg, err = Send(context.Background(), Request{
+		To:       "+14155550123",
+		Template: TemplateVerificationCode,
+		Data:     map[string]interface{}{"Code": "654321", "ValidMinutes": 10},
+	})
+	if err == nil || msg.Status != StatusFailed {
+		t.Errorf("expected failure when every provider fails, got %+v, %v", msg, err)
+	}
+	if VoiceAvailable() {
+		t.Error("voice should be unavailable without callers")
+	}
+}
+
+func TestTwilioSender(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "AC123" || pass != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code": 20003, "message": "Authenticate"}`))
+			return
+		}
+		r.ParseForm()
+		form = r.PostForm
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sid": "SM42", "status": "queued"}`))
+	}))
+	defer server.Close()
+
+	sender := NewTwilioSender(TwilioConfig{
+		AccountSID:        "AC123",
+		AuthToken:         "token",
+		From:              "+15005550006",
+		StatusCallbackURL: "https://api.example.com/api/sms/status/twilio",
+	}, server.Client())
+	sender.baseURL = server.URL
+
+	result, err := sender.Send(context.Background(), "+14155550123", "hello")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if result.ProviderMessageID != "SM42" || result.Status != StatusQueued {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if form.Get("From") != "+15005550006" || form.Get("StatusCallback") == "" || form.Get("Body") != "hello" {
+		t.Errorf("unexpected form %v", form)
+	}
+
+	sender.config.AuthToken = "wrong"
+	if _, err := sender.Send(context.Background(), "+14155550123", "hello"); err == nil {
+		t.Error("expected an error for rejected credentials")
+	}
+}
+
+func TestValidateTwilioSignature(t *testing.T) {
+	callbackURL := "https://api.example.com/api/sms/status/twilio"
+	params := url.Values{"MessageSid": {"SM42"}, "MessageStatus": {"delivered"}, "AccountSid": {"AC123"}}
+
+	mac := hmac.New(sha1.New, []byte("token"))
+	mac.Write([]byte(callbackURL + "AccountSidAC123MessageSidSM42MessageStatusdelivered"))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	if !ValidateTwilioSignature("token", callbackURL, params, signature) {
+		t.Error("expected a valid signature")
+	}
+	params.Set("MessageStatus", "failed")
+	if ValidateTwilioSignature("token", callbackURL, params, signature) {
+		t.Error("expected tampered parameters to be rejected")
+	}
+}
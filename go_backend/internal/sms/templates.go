@@ -0,0 +1,122 @@
+package sms
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Template names
+const (
+	TemplateVerificationCode = "verification_code"
+	TemplateVerificationCall = "verification_call"
+)
+
+// DefaultLocale is used when no translation exists for the requested locale
+const DefaultLocale = "en"
+
+// templates holds each message in every supported locale
+var templates = map[string]map[string]*template.Template{
+	TemplateVerificationCode: newLocalizedTemplate(map[string]string{
+		"en": `Your QuarkfinAI verification code is: {{.Code}}. Valid for {{.ValidMinutes}} minutes. Do not share this code.`,
+		"es": `Tu código de verificación de QuarkfinAI es: {{.Code}}. Válido durante {{.ValidMinutes}} minutos. No compartas este código.`,
+		"fr": `Votre code de vérification QuarkfinAI est : {{.Code}}. Valable {{.ValidMinutes}} minutes. Ne partagez pas ce code.`,
+		"de": `Ihr QuarkfinAI-Bestätigungscode lautet: {{.Code}}. {{.ValidMinutes}} Minuten gültig. Geben Sie diesen Code nicht weiter.`,
+		"pt": `Seu código de verificação QuarkfinAI é: {{.Code}}. Válido por {{.ValidMinutes}} minutos. Não compartilhe este código.`,
+		"it": `Il tuo codice di verifica QuarkfinAI è: {{.Code}}. Valido per {{.ValidMinutes}} minuti. Non condividere questo codice.`,
+		"nl": `Je QuarkfinAI-verificatiecode is: {{.Code}}. {{.ValidMinutes}} minuten geldig. Deel deze code niet.`,
+	}),
+	TemplateVerificationCall: newLocalizedTemplate(map[string]string{
+		"en": `Your QuarkfinAI verification code is: {{spell .Code}}. Again, your code is: {{spell .Code}}.`,
+		"es": `Tu código de verificación de QuarkfinAI es: {{spell .Code}}. Repito, tu código es: {{spell .Code}}.`,
+		"fr": `Votre code de vérification QuarkfinAI est : {{spell .Code}}. Je répète, votre code est : {{spell .Code}}.`,
+		"de": `Ihr QuarkfinAI-Bestätigungscode lautet: {{spell .Code}}. Noch einmal, Ihr Code lautet: {{spell .Code}}.`,
+		"pt": `Seu código de verificação QuarkfinAI é: {{spell .Code}}. Repetindo, seu código é: {{spell .Code}}.`,
+		"it": `Il tuo codice di verifica QuarkfinAI è: {{spell .Code}}. Ripeto, il tuo codice è: {{spell .Code}}.`,
+		"nl": `Je QuarkfinAI-verificatiecode is: {{spell .Code}}. Nogmaals, je code is: {{spell .Code}}.`,
+	}),
+}
+
+// voiceLanguages maps locales to text-to-speech languages
+var voiceLanguages = map[string]string{
+	"en": "en-US",
+	"es": "es-ES",
+	"fr": "fr-FR",
+	"de": "de-DE",
+	"pt": "pt-BR",
+	"it": "it-IT",
+	"nl": "nl-NL",
+}
+
+// countryLocales maps ISO country codes to the locale of their messages.
+// Countries not listed receive English.
+var countryLocales = map[string]string{
+	"ES": "es", "MX": "es", "AR": "es", "CO": "es", "CL": "es", "PE": "es",
+	"FR": "fr", "BE": "fr", "LU": "fr",
+	"DE": "de", "AT": "de", "CH": "de",
+	"PT": "pt", "BR": "pt",
+	"IT": "it",
+	"NL": "nl",
+}
+
+var templateFuncs = template.FuncMap{
+	// spell separates digits so text-to-speech reads them one by one
+	"spell": func(v interface{}) string {
+		return strings.Join(strings.Split(fmt.Sprint(v), ""), ", ")
+	},
+}
+
+func newLocalizedTemplate(bodies map[string]string) map[string]*template.Template {
+	localized := make(map[string]*template.Template, len(bodies))
+	for locale, body := range bodies {
+		localized[locale] = template.Must(template.New(locale).Funcs(templateFuncs).Option("missingkey=error").Parse(body))
+	}
+	return localized
+}
+
+// LocaleForCountry returns the message locale for an ISO country code
+func LocaleForCountry(country string) string {
+	if locale, ok := countryLocales[strings.ToUpper(country)]; ok {
+		return locale
+	}
+	return DefaultLocale
+}
+
+// resolveLocale returns the closest locale a template is translated to:
+// "pt-BR" falls back to "pt", unknown locales to English.
+func resolveLocale(localized map[string]*template.Template, locale string) string {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if _, ok := localized[locale]; ok {
+		return locale
+	}
+	if base, _, found := strings.Cut(locale, "-"); found {
+		if _, ok := localized[base]; ok {
+			return base
+		}
+	}
+	return DefaultLocale
+}
+
+// Render renders a template and returns the body and the locale used
+func Render(name, locale string, data map[string]interface{}) (string, string, error) {
+	localized, ok := templates[name]
+	if !ok {
+		return "", "", fmt.Errorf("no SMS template named %s", name)
+	}
+	locale = resolveLocale(localized, locale)
+
+	var body bytes.Buffer
+	if err := localized[locale].Execute(&body, data); err != nil {
+		return "", "", fmt.Errorf("failed to render SMS template %s: %v", name, err)
+	}
+	return body.String(), locale, nil
+}
+
+// voiceLanguage returns the text-to-speech language for a locale
+func voiceLanguage(locale string) string {
+	if language, ok := voiceLanguages[locale]; ok {
+		return language
+	}
+	return voiceLanguages[DefaultLocale]
+}
@@ -0,0 +1,87 @@
+package sms
+
+import (
+	"context"
+	"time"
+)
+
+// Delivery channels
+const (
+	ChannelSMS   = "sms"
+	ChannelVoice = "voice"
+)
+
+// Provider names used in SMS_PROVIDERS and recorded on each message
+const (
+	ProviderSNS    = "sns"
+	ProviderTwilio = "twilio"
+	ProviderFile   = "file"
+	ProviderHTTP   = "http"
+	ProviderLog    = "log"
+)
+
+// Delivery statuses. Providers that report delivery (Twilio) move messages
+// from sent to delivered or undelivered through status callbacks.
+const (
+	StatusQueued      = "queued"
+	StatusSent        = "sent"
+	StatusDelivered   = "delivered"
+	StatusUndelivered = "undelivered"
+	StatusFailed      = "failed"
+)
+
+// statusRank orders statuses so late callbacks cannot move a message back
+var statusRank = map[string]int{
+	StatusQueued:      0,
+	StatusSent:        1,
+	StatusDelivered:   2,
+	StatusUndelivered: 2,
+	StatusFailed:      2,
+}
+
+// Result is a provider's acknowledgement of an accepted message
+type Result struct {
+	ProviderMessageID string
+	Status            string // StatusQueued or StatusSent
+}
+
+// SMSSender delivers text messages through a single provider
+type SMSSender interface {
+	Name() string
+	Send(ctx context.Context, to, body string) (*Result, error)
+}
+
+// Caller reads a message aloud in a voice call. language is a BCP 47 tag
+// such as "en-US" used for text-to-speech.
+type Caller interface {
+	Name() string
+	Call(ctx context.Context, to, message, language string) (*Result, error)
+}
+
+// Request is a templated message to deliver
+type Request struct {
+	UserID   string // optional, owner of the message
+	To       string // E.164
+	Template string
+	Locale   string // falls back to the base language, then English
+	Data     map[string]interface{}
+}
+
+// Message is a row of sms_messages. The rendered body is not stored because
+// it usually contains a one-time code.
+type Message struct {
+	ID                string    `json:"id"`
+	UserID            *string   `json:"user_id,omitempty"`
+	Channel           string    `json:"channel"`
+	ToNumber          string    `json:"to_number"`
+	Template          string    `json:"template"`
+	Locale            string    `json:"locale"`
+	Provider          *string   `json:"provider"`
+	ProviderMessageID *string   `json:"provider_message_id"`
+	Status            string    `json:"status"`
+	ProviderStatus    *string   `json:"provider_status"`
+	ErrorMessage      *string   `json:"error_message"`
+	Attempts          int       `json:"attempts"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
@@ -0,0 +1,436 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	supa "github.com/nedpals/supabase-go"
+)
+
+// Delivery tuning
+const (
+	maxDeliveryAttempts = 6
+	initialRetryDelay   = 30 * time.Second
+	deliveryTimeout     = 10 * time.Second
+	secretPrefix        = "whsec_"
+	// deliveryLease is how long a sender owns a delivery before the retry
+	// job may send it again, such as after a restart
+	deliveryLease    = 2 * time.Minute
+	retryJobInterval = 15 * time.Second
+	retryBatchSize   = 50
+)
+
+// Headers sent with every delivery
+const (
+	SignatureHeader = "X-QuarkFin-Signature"
+	EventHeader     = "X-QuarkFin-Event"
+	DeliveryHeader  = "X-QuarkFin-Delivery"
+)
+
+// Database connection
+var supabaseClient *supa.Client
+
+var errPrivateAddress = errors.New("webhook URL must not point to a private address")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), internal to
+// the network like the private ranges
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// httpClient sends deliveries. Every connection is checked against the
+// address it actually dials, so hostnames resolving to internal addresses
+// are refused, and redirects are not followed.
+var httpClient = &http.Client{
+	Timeout: deliveryTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: deliveryTimeout,
+			Control: refusePrivateAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: deliveryTimeout,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// InitWebhooks initializes the Supabase connection for webhook endpoints and deliveries
+func InitWebhooks(url, key string) error {
+	client := supa.CreateClient(url, key)
+	if client == nil {
+		return fmt.Errorf("failed to create Supabase client for webhooks")
+	}
+	supabaseClient = client
+	auth.ScheduleJob("webhook_retries", retryJobInterval, retryDueDeliveries)
+	return nil
+}
+
+// Publish sends an event to every active personal endpoint of the user
+// subscribed to it. Deliveries happen in the background; failures are retried
+// with exponential backoff by the webhook_retries job.
+func Publish(userID, eventType string, data map[string]interface{}) {
+	if userID == "" {
+		return
+	}
+	PublishToWorkspace(auth.Workspace{UserID: userID}, eventType, data)
+}
+
+// PublishToWorkspace is Publish for the endpoints of a workspace: the
+// organization's endpoints, or the user's personal ones
+func PublishToWorkspace(workspace auth.Workspace, eventType string, data map[string]interface{}) {
+	if supabaseClient == nil {
+		return
+	}
+
+	endpoints, err := listEndpoints(workspace)
+	if err != nil {
+		log.Printf("⚠️ webhooks.Publish: Failed to load endpoints for %s: %v", describeWorkspace(workspace), err)
+		return
+	}
+
+	event := Event{
+		ID:        uuid.NewString(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	for i := range endpoints {
+		endpoint := endpoints[i]
+		if !endpoint.IsActive || !endpoint.subscribes(eventType) {
+			continue
+		}
+
+		delivery, err := createDelivery(&endpoint, event, nil)
+		if err != nil {
+			log.Printf("⚠️ webhooks.Publish: Failed to record delivery to %s: %v", endpoint.URL, err)
+			continue
+		}
+
+		go attemptDelivery(&endpoint, delivery)
+	}
+}
+
+// ComputeSignature returns the hex HMAC-SHA256 of "<timestamp>.<body>" using the endpoint secret
+func ComputeSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a signature header of the form "t=<unix>,v1=<hex>"
+// against the body, rejecting timestamps older than tolerance
+func VerifySignature(secret, header string, body []byte, tolerance time.Duration) bool {
+	var timestamp int64
+	var signature string
+	for _, part := range bytes.Split([]byte(header), []byte(",")) {
+		kv := bytes.SplitN(bytes.TrimSpace(part), []byte("="), 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch string(kv[0]) {
+		case "t":
+			timestamp, _ = strconv.ParseInt(string(kv[1]), 10, 64)
+		case "v1":
+			signature = string(kv[1])
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return false
+	}
+	if tolerance > 0 && time.Since(time.Unix(timestamp, 0)) > tolerance {
+		return false
+	}
+
+	expected := ComputeSignature(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// generateSecret creates a new random signing secret
+func generateSecret() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(buf), nil
+}
+
+// isPrivateIP reports whether ip is a loopback, private, link-local (such as
+// the 169.254.169.254 metadata service) or unspecified address
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// refusePrivateAddress is the dialer's Control hook: it runs after DNS
+// resolution with the address about to be dialed. Outside release mode
+// private addresses are allowed so local receivers can be tested.
+func refusePrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("webhook delivery to unexpected address %q", address)
+	}
+	if gin.Mode() == gin.ReleaseMode && isPrivateIP(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// retryDueDeliveries sends the deliveries whose next attempt is due: retries,
+// and first attempts whose sender did not finish within deliveryLease
+func retryDueDeliveries() (interface{}, error) {
+	if supabaseClient == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var deliveries []Delivery
+	err := supabaseClient.DB.Rpc("claim_due_webhook_deliveries", map[string]interface{}{
+		"p_limit":         retryBatchSize,
+		"p_lease_seconds": int(deliveryLease.Seconds()),
+	}).Execute(&deliveries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim due webhook deliveries: %v", err)
+	}
+
+	endpoints := map[string]*Endpoint{}
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		endpoint, found := endpoints[delivery.EndpointID]
+		if !found {
+			endpoint, err = getEndpoint(delivery.EndpointID)
+			if err != nil && !errors.Is(err, errEndpointNotFound) {
+				log.Printf("⚠️ retryDueDeliveries: %v", err)
+				continue
+			}
+			endpoints[delivery.EndpointID] = endpoint
+		}
+
+		if endpoint == nil || !endpoint.IsActive {
+			message := "webhook endpoint is inactive"
+			delivery.Status = DeliveryFailed
+			delivery.ErrorMessage = &message
+			delivery.NextAttemptAt = nil
+			delivery.UpdatedAt = time.Now().UTC()
+			if err := updateDelivery(delivery); err != nil {
+				log.Printf("⚠️ Failed to persist webhook delivery %s: %v", delivery.ID, err)
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attemptDelivery(endpoint, delivery)
+		}()
+	}
+	wg.Wait()
+
+	return map[string]int{"attempted": len(deliveries)}, nil
+}
+
+// attemptDelivery performs a single signed POST and records the outcome
+func attemptDelivery(endpoint *Endpoint, delivery *Delivery) {
+	body, err := json.Marshal(delivery.Payload)
+	if err != nil {
+		recordAttempt(delivery, nil, fmt.Errorf("failed to encode payload: %v", err))
+		return
+	}
+
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		recordAttempt(delivery, nil, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "QuarkFin-Webhooks/1.0")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, ComputeSignature(endpoint.Secret, timestamp, body)))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		recordAttempt(delivery, nil, err)
+		return
+	}
+	// Only the status is kept; response bodies are never stored or shown
+	resp.Body.Close()
+
+	status := resp.StatusCode
+	if status < 200 || status >= 300 {
+		recordAttempt(delivery, &status, fmt.Errorf("endpoint responded with HTTP %d", status))
+		return
+	}
+
+	recordAttempt(delivery, &status, nil)
+	markEndpointUsed(endpoint.ID)
+}
+
+// recordAttempt updates the delivery after an attempt and persists it
+func recordAttempt(delivery *Delivery, responseStatus *int, attemptErr error) {
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.ResponseStatus = responseStatus
+	delivery.UpdatedAt = now
+
+	switch {
+	case attemptErr == nil:
+		delivery.Status = DeliverySucceeded
+		delivery.DeliveredAt = &now
+		delivery.ErrorMessage = nil
+		delivery.NextAttemptAt = nil
+		log.Printf("✅ Webhook delivery %s (%s) succeeded on attempt %d", delivery.ID, delivery.EventType, delivery.Attempts)
+	case delivery.Attempts >= maxDeliveryAttempts:
+		message := attemptErr.Error()
+		delivery.Status = DeliveryFailed
+		delivery.ErrorMessage = &message
+		delivery.NextAttemptAt = nil
+		log.Printf("❌ Webhook delivery %s (%s) failed permanently after %d attempts: %v", delivery.ID, delivery.EventType, delivery.Attempts, attemptErr)
+	default:
+		message := attemptErr.Error()
+		next := now.Add(retryDelay(delivery.Attempts))
+		delivery.Status = DeliveryRetrying
+		delivery.ErrorMessage = &message
+		delivery.NextAttemptAt = &next
+		log.Printf("⚠️ Webhook delivery %s (%s) attempt %d failed, retrying at %s: %v", delivery.ID, delivery.EventType, delivery.Attempts, next.Format(time.RFC3339), attemptErr)
+	}
+
+	if err := updateDelivery(delivery); err != nil {
+		log.Printf("⚠️ Failed to persist webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// retryDelay returns the backoff before the next attempt after the given number of attempts
+func retryDelay(attempts int) time.Duration {
+	return initialRetryDelay << (attempts - 1)
+}
+
+// listEndpoints fetches all webhook endpoints registered in a workspace
+func listEndpoints(workspace auth.Workspace) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	err := workspace.Scope(supabaseClient.DB.From("webhook_endpoints").
+		Select("*")).
+		Execute(&endpoints)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch webhook endpoints: %v", err)
+	}
+	return endpoints, nil
+}
+
+// describeWorkspace names a workspace in log messages
+func describeWorkspace(workspace auth.Workspace) string {
+	if workspace.IsOrganization() {
+		return "organization " + workspace.OrgID
+	}
+	return "user " + workspace.UserID
+}
+
+// getEndpoint fetches a single endpoint by ID
+func getEndpoint(id string) (*Endpoint, error) {
+	var endpoints []Endpoint
+	err := supabaseClient.DB.From("webhook_endpoints").
+		Select("*").
+		Eq("id", id).
+		Execute(&endpoints)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch webhook endpoint: %v", err)
+	}
+	if len(endpoints) == 0 {
+		return nil, errEndpointNotFound
+	}
+	return &endpoints[0], nil
+}
+
+// createDelivery records a pending delivery of an event to an endpoint. The
+// caller owns its first attempt for deliveryLease; after that the retry job
+// sends it.
+func createDelivery(endpoint *Endpoint, event Event, redeliveryOf *string) (*Delivery, error) {
+	now := time.Now().UTC()
+	lease := now.Add(deliveryLease)
+	delivery := &Delivery{
+		ID:            uuid.NewString(),
+		EndpointID:    endpoint.ID,
+		UserID:        endpoint.UserID,
+		EventID:       event.ID,
+		EventType:     event.Type,
+		Payload:       event,
+		Status:        DeliveryPending,
+		NextAttemptAt: &lease,
+		RedeliveryOf:  redeliveryOf,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	record := map[string]interface{}{
+		"id":              delivery.ID,
+		"endpoint_id":     delivery.EndpointID,
+		"user_id":         delivery.UserID,
+		"event_id":        delivery.EventID,
+		"event_type":      delivery.EventType,
+		"payload":         delivery.Payload,
+		"status":          delivery.Status,
+		"attempts":        0,
+		"next_attempt_at": lease.Format(time.RFC3339),
+		"redelivery_of":   delivery.RedeliveryOf,
+	}
+
+	var results []map[string]interface{}
+	err := supabaseClient.DB.From("webhook_deliveries").Insert(record).Execute(&results)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook delivery: %v", err)
+	}
+	return delivery, nil
+}
+
+// updateDelivery persists the outcome of a delivery attempt
+func updateDelivery(delivery *Delivery) error {
+	updateData := map[string]interface{}{
+		"status":          delivery.Status,
+		"attempts":        delivery.Attempts,
+		"response_status": delivery.ResponseStatus,
+		"error_message":   delivery.ErrorMessage,
+		"next_attempt_at": delivery.NextAttemptAt,
+		"delivered_at":    delivery.DeliveredAt,
+		"updated_at":      delivery.UpdatedAt.Format(time.RFC3339),
+	}
+
+	var results []map[string]interface{}
+	return supabaseClient.DB.From("webhook_deliveries").
+		Update(updateData).
+		Eq("id", delivery.ID).
+		Execute(&results)
+}
+
+// markEndpointUsed records the time of the last successful delivery
+func markEndpointUsed(endpointID string) {
+	var results []map[string]interface{}
+	err := supabaseClient.DB.From("webhook_endpoints").
+		Update(map[string]interface{}{"last_sent_at": time.Now().UTC().Format(time.RFC3339)}).
+		Eq("id", endpointID).
+		Execute(&results)
+	if err != nil {
+		log.Printf("⚠️ Failed to update last_sent_at for webhook endpoint %s: %v", endpointID, err)
+	}
+}
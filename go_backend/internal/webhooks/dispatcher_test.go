@@ -0,0 +1,129 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"github.com/gin-gonic/gin"
+	supa "github.com/nedpals/supabase-go"
+)
+
+func TestVerifySignature(t *testing.T) {
+	secret := "whsec_test"
+	body := []byte(`{"type":"assessment.completed"}`)
+	now := time.Now().Unix()
+	header := fmt.Sprintf("t=%d,v1=%s", now, ComputeSignature(secret, now, body))
+
+	if !VerifySignature(secret, header, body, 5*time.Minute) {
+		t.Fatal("expected valid signature to verify")
+	}
+	if VerifySignature("whsec_other", header, body, 5*time.Minute) {
+		t.Error("signature verified with the wrong secret")
+	}
+	if VerifySignature(secret, header, []byte(`{}`), 5*time.Minute) {
+		t.Error("signature verified for a tampered body")
+	}
+
+	old := now - 3600
+	stale := fmt.Sprintf("t=%d,v1=%s", old, ComputeSignature(secret, old, body))
+	if VerifySignature(secret, stale, body, 5*time.Minute) {
+		t.Error("stale signature should be rejected")
+	}
+}
+
+func TestRetryDelayBacksOffExponentially(t *testing.T) {
+	expected := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute}
+	for i, want := range expected {
+		if got := retryDelay(i + 1); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestEndpointSubscribes(t *testing.T) {
+	e := &Endpoint{EventTypes: []string{EventAssessmentFailed}}
+	if !e.subscribes(EventAssessmentFailed) || e.subscribes(EventAssessmentCompleted) {
+		t.Error("endpoint should only receive subscribed events")
+	}
+	if !e.subscribes(EventWebhookTest) {
+		t.Error("test pings should always be delivered")
+	}
+	if !(&Endpoint{EventTypes: []string{EventWildcard}}).subscribes(EventAssessmentCompleted) {
+		t.Error("wildcard endpoint should receive every event")
+	}
+}
+
+func TestRefusePrivateAddress(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	defer gin.SetMode(gin.TestMode)
+
+	for _, address := range []string{"127.0.0.1:443", "10.1.2.3:443", "192.168.0.10:80", "169.254.169.254:80", "100.64.0.1:443", "[::1]:443", "[::ffff:127.0.0.1]:443", "0.0.0.0:443"} {
+		if err := refusePrivateAddress("tcp", address, nil); err == nil {
+			t.Errorf("%s allowed", address)
+		}
+	}
+	for _, address := range []string{"93.184.216.34:443", "[2606:2800:220:1:248:1893:25c8:1946]:443"} {
+		if err := refusePrivateAddress("tcp", address, nil); err != nil {
+			t.Errorf("%s refused: %v", address, err)
+		}
+	}
+	if err := validateEndpointURL("https://169.254.169.254/latest/meta-data"); err == nil {
+		t.Error("metadata service URL accepted")
+	}
+
+	// Hostnames are checked once resolved, when the delivery dials them
+	_, err := httpClient.Get("https://localhost:1/")
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("delivery to a hostname resolving to loopback: %v", err)
+	}
+}
+
+func TestDeliveryDoesNotFollowRedirects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	followed := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { followed = true }))
+	defer target.Close()
+	endpoint := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+	defer endpoint.Close()
+
+	resp, err := httpClient.Post(endpoint.URL, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if followed || resp.StatusCode != http.StatusFound {
+		t.Errorf("redirect followed: %v, status %d", followed, resp.StatusCode)
+	}
+}
+
+func TestListEndpointsScopedToWorkspace(t *testing.T) {
+	var query url.Values
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte("[]"))
+	}))
+	defer storage.Close()
+	supabaseClient = supa.CreateClient(storage.URL, "service-key")
+	defer func() { supabaseClient = nil }()
+
+	// Organization alerts reach the organization's endpoints, not the member's
+	if _, err := listEndpoints(auth.Workspace{UserID: "user-1", OrgID: "org-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if query.Get("org_id") != "eq.org-1" || query.Has("user_id") {
+		t.Errorf("organization query %v", query)
+	}
+	if _, err := listEndpoints(auth.Workspace{UserID: "user-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if query.Get("user_id") != "eq.user-1" || query.Get("org_id") != "is.null" {
+		t.Errorf("personal query %v", query)
+	}
+}
@@ -0,0 +1,512 @@
+package webhooks
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	errEndpointNotFound = errors.New("webhook endpoint not found")
+	errDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// CreateEndpointHandler registers a new webhook endpoint. The signing secret is
+// only returned in full by this call.
+func CreateEndpointHandler(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireDatabase(c) {
+		return
+	}
+
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	var req CreateEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"code":    "INVALID_REQUEST",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := validateEndpointURL(req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+			"code":  "INVALID_WEBHOOK_URL",
+		})
+		return
+	}
+
+	if err := validateEventTypes(req.EventTypes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":       err.Error(),
+			"code":        "INVALID_EVENT_TYPES",
+			"event_types": SupportedEventTypes,
+		})
+		return
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		log.Printf("❌ CreateEndpointHandler: Failed to generate secret: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create webhook endpoint",
+			"code":  "WEBHOOK_CREATE_ERROR",
+		})
+		return
+	}
+
+	record := map[string]interface{}{
+		"id":          uuid.NewString(),
+		"user_id":     userID,
+		"org_id":      workspace.OrgIDPtr(),
+		"url":         req.URL,
+		"description": req.Description,
+		"secret":      secret,
+		"event_types": req.EventTypes,
+		"is_active":   true,
+	}
+
+	var results []Endpoint
+	if err := supabaseClient.DB.From("webhook_endpoints").Insert(record).Execute(&results); err != nil || len(results) == 0 {
+		log.Printf("❌ CreateEndpointHandler: Failed to insert endpoint for %s: %v", describeWorkspace(workspace), err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create webhook endpoint",
+			"code":  "WEBHOOK_CREATE_ERROR",
+		})
+		return
+	}
+
+	log.Printf("✅ Webhook endpoint %s registered for %s by user %s", results[0].ID, describeWorkspace(workspace), userID)
+	auth.OmitIdempotentResponse(c)
+	c.JSON(http.StatusCreated, gin.H{
+		"endpoint": results[0],
+		"message":  "Store the signing secret now; it will not be shown again",
+	})
+}
+
+// ListEndpointsHandler returns the webhook endpoints of the workspace
+func ListEndpointsHandler(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	if !requireDatabase(c) {
+		return
+	}
+
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	endpoints, err := listEndpoints(workspace)
+	if err != nil {
+		log.Printf("❌ ListEndpointsHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch webhook endpoints",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	for i := range endpoints {
+		endpoints[i] = endpoints[i].redacted()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"endpoints":   endpoints,
+		"total":       len(endpoints),
+		"event_types": SupportedEventTypes,
+	})
+}
+
+// GetEndpointHandler returns a single webhook endpoint
+func GetEndpointHandler(c *gin.Context) {
+	endpoint, ok := loadAuthorizedEndpoint(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, endpoint.redacted())
+}
+
+// UpdateEndpointHandler changes the URL, description, event filter or active flag of an endpoint
+func UpdateEndpointHandler(c *gin.Context) {
+	endpoint, ok := loadAuthorizedEndpoint(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"code":    "INVALID_REQUEST",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	updateData := map[string]interface{}{
+		"updated_at": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if req.URL != nil {
+		if err := validateEndpointURL(*req.URL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+				"code":  "INVALID_WEBHOOK_URL",
+			})
+			return
+		}
+		updateData["url"] = *req.URL
+	}
+	if req.Description != nil {
+		updateData["description"] = *req.Description
+	}
+	if req.EventTypes != nil {
+		if err := validateEventTypes(req.EventTypes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":       err.Error(),
+				"code":        "INVALID_EVENT_TYPES",
+				"event_types": SupportedEventTypes,
+			})
+			return
+		}
+		updateData["event_types"] = req.EventTypes
+	}
+	if req.IsActive != nil {
+		updateData["is_active"] = *req.IsActive
+	}
+
+	var results []Endpoint
+	err := supabaseClient.DB.From("webhook_endpoints").
+		Update(updateData).
+		Eq("id", endpoint.ID).
+		Execute(&results)
+	if err != nil || len(results) == 0 {
+		log.Printf("❌ UpdateEndpointHandler: Failed to update endpoint %s: %v", endpoint.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update webhook endpoint",
+			"code":  "WEBHOOK_UPDATE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, results[0].redacted())
+}
+
+// DeleteEndpointHandler removes a webhook endpoint and its delivery log
+func DeleteEndpointHandler(c *gin.Context) {
+	endpoint, ok := loadAuthorizedEndpoint(c)
+	if !ok {
+		return
+	}
+
+	var results []map[string]interface{}
+	err := supabaseClient.DB.From("webhook_endpoints").
+		Delete().
+		Eq("id", endpoint.ID).
+		Execute(&results)
+	if err != nil {
+		log.Printf("❌ DeleteEndpointHandler: Failed to delete endpoint %s: %v", endpoint.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete webhook endpoint",
+			"code":  "WEBHOOK_DELETE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Webhook endpoint deleted",
+		"id":      endpoint.ID,
+	})
+}
+
+// TestEndpointHandler sends a signed webhook.test ping synchronously and reports the result
+func TestEndpointHandler(c *gin.Context) {
+	endpoint, ok := loadAuthorizedEndpoint(c)
+	if !ok {
+		return
+	}
+
+	event := Event{
+		ID:        uuid.NewString(),
+		Type:      EventWebhookTest,
+		CreatedAt: time.Now().UTC(),
+		Data: map[string]interface{}{
+			"endpoint_id": endpoint.ID,
+			"message":     "This is a test event from QuarkFin",
+		},
+	}
+
+	delivery, err := createDelivery(endpoint, event, nil)
+	if err != nil {
+		log.Printf("❌ TestEndpointHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to record test delivery",
+			"code":  "WEBHOOK_DELIVERY_ERROR",
+		})
+		return
+	}
+
+	// Test pings are a single attempt so the caller gets an immediate answer
+	attemptDelivery(endpoint, delivery)
+	if delivery.Status == DeliveryRetrying {
+		delivery.Status = DeliveryFailed
+		delivery.NextAttemptAt = nil
+		if err := updateDelivery(delivery); err != nil {
+			log.Printf("⚠️ Failed to persist webhook delivery %s: %v", delivery.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  delivery.Status == DeliverySucceeded,
+		"delivery": delivery,
+	})
+}
+
+// ListDeliveriesHandler returns the delivery log for an endpoint, newest first
+func ListDeliveriesHandler(c *gin.Context) {
+	endpoint, ok := loadAuthorizedEndpoint(c)
+	if !ok {
+		return
+	}
+
+	query := supabaseClient.DB.From("webhook_deliveries").
+		Select("*").
+		OrderBy("created_at", "desc").
+		Limit(100).
+		Eq("endpoint_id", endpoint.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Eq("status", status)
+	}
+
+	var deliveries []Delivery
+	if err := query.Execute(&deliveries); err != nil {
+		log.Printf("❌ ListDeliveriesHandler: Failed to fetch deliveries for endpoint %s: %v", endpoint.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch webhook deliveries",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+		"total":      len(deliveries),
+	})
+}
+
+// RedeliverHandler re-sends the event of a previous delivery as a new delivery
+func RedeliverHandler(c *gin.Context) {
+	endpoint, ok := loadAuthorizedEndpoint(c)
+	if !ok {
+		return
+	}
+
+	original, err := getDelivery(endpoint.ID, c.Param("delivery_id"))
+	if err != nil {
+		if errors.Is(err, errDeliveryNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Webhook delivery not found",
+				"code":  "WEBHOOK_DELIVERY_NOT_FOUND",
+			})
+			return
+		}
+		log.Printf("❌ RedeliverHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch webhook delivery",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	delivery, err := createDelivery(endpoint, original.Payload, &original.ID)
+	if err != nil {
+		log.Printf("❌ RedeliverHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to record redelivery",
+			"code":  "WEBHOOK_DELIVERY_ERROR",
+		})
+		return
+	}
+
+	go attemptDelivery(endpoint, delivery)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "Redelivery scheduled",
+		"delivery": delivery,
+	})
+}
+
+// loadAuthorizedEndpoint fetches the :id endpoint and checks it belongs to the
+// workspace. It writes the error response and returns false on failure.
+func loadAuthorizedEndpoint(c *gin.Context) (*Endpoint, bool) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return nil, false
+	}
+
+	if !requireDatabase(c) {
+		return nil, false
+	}
+
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return nil, false
+	}
+
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid webhook endpoint ID",
+			"code":  "INVALID_WEBHOOK_ID",
+		})
+		return nil, false
+	}
+
+	endpoint, err := getEndpoint(id)
+	if err != nil {
+		if errors.Is(err, errEndpointNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Webhook endpoint not found",
+				"code":  "WEBHOOK_NOT_FOUND",
+			})
+			return nil, false
+		}
+		log.Printf("❌ loadAuthorizedEndpoint: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch webhook endpoint",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return nil, false
+	}
+
+	// Report another workspace's endpoint as missing rather than forbidden
+	if !workspace.Contains(endpoint.UserID, endpoint.OrgID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Webhook endpoint not found",
+			"code":  "WEBHOOK_NOT_FOUND",
+		})
+		return nil, false
+	}
+
+	return endpoint, true
+}
+
+// getDelivery fetches a delivery belonging to an endpoint
+func getDelivery(endpointID, deliveryID string) (*Delivery, error) {
+	if _, err := uuid.Parse(deliveryID); err != nil {
+		return nil, errDeliveryNotFound
+	}
+
+	var deliveries []Delivery
+	err := supabaseClient.DB.From("webhook_deliveries").
+		Select("*").
+		Eq("id", deliveryID).
+		Eq("endpoint_id", endpointID).
+		Execute(&deliveries)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, errDeliveryNotFound
+	}
+	return &deliveries[0], nil
+}
+
+// requireDatabase writes a 503 when webhooks storage is not configured
+func requireDatabase(c *gin.Context) bool {
+	if supabaseClient == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Webhooks are not available",
+			"code":  "DATABASE_CONNECTION_ERROR",
+		})
+		return false
+	}
+	return true
+}
+
+// validateEndpointURL requires an absolute http(s) URL; release builds only
+// accept https and refuse localhost and private IP literals. Hostnames are
+// checked when a delivery dials them (refusePrivateAddress).
+func validateEndpointURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("webhook URL must be an absolute URL")
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return errors.New("webhook URL must use http or https")
+	}
+
+	if gin.Mode() != gin.ReleaseMode {
+		return nil
+	}
+
+	if u.Scheme != "https" {
+		return errors.New("webhook URL must use https")
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		return errPrivateAddress
+	}
+	if ip := net.ParseIP(host); ip != nil && isPrivateIP(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// validateEventTypes checks an endpoint's event filter against the supported events
+func validateEventTypes(eventTypes []string) error {
+	if len(eventTypes) == 0 {
+		return errors.New("at least one event type is required")
+	}
+	for _, t := range eventTypes {
+		if t == EventWildcard {
+			continue
+		}
+		supported := false
+		for _, s := range SupportedEventTypes {
+			if t == s {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return errors.New("unsupported event type: " + t)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package webhooks
+
+import (
+	"time"
+)
+
+// Event types
+const (
+	EventAssessmentCompleted    = "assessment.completed"
+	EventAssessmentFailed       = "assessment.failed"
+	EventAssessmentManualReview = "assessment.manual_review_required"
+	EventWebhookTest            = "webhook.test"
+
+	// EventCreditsLowBalance fires when a low-balance alert rule triggers
+	EventCreditsLowBalance = "credits.low_balance"
+
+	// EventWildcard subscribes an endpoint to every event type
+	EventWildcard = "*"
+)
+
+// SupportedEventTypes lists the event types endpoints can subscribe to
+var SupportedEventTypes = []string{
+	EventAssessmentCompleted,
+	EventAssessmentFailed,
+	EventAssessmentManualReview,
+	EventCreditsLowBalance,
+}
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryRetrying  = "retrying"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Endpoint is a URL registered in a workspace that receives signed event
+// payloads. Organization endpoints have OrgID set and are shared by members.
+type Endpoint struct {
+	ID          string     `json:"id" db:"id"`
+	UserID      string     `json:"user_id" db:"user_id"`
+	OrgID       *string    `json:"org_id" db:"org_id"`
+	URL         string     `json:"url" db:"url"`
+	Description string     `json:"description" db:"description"`
+	Secret      string     `json:"secret,omitempty" db:"secret"`
+	EventTypes  []string   `json:"event_types" db:"event_types"`
+	IsActive    bool       `json:"is_active" db:"is_active"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+	LastSentAt  *time.Time `json:"last_sent_at" db:"last_sent_at"`
+}
+
+// Event is the JSON body POSTed to webhook endpoints
+type Event struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	CreatedAt time.Time              `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// Delivery records each attempt to send an event to an endpoint
+type Delivery struct {
+	ID             string     `json:"id" db:"id"`
+	EndpointID     string     `json:"endpoint_id" db:"endpoint_id"`
+	UserID         string     `json:"user_id" db:"user_id"`
+	EventID        string     `json:"event_id" db:"event_id"`
+	EventType      string     `json:"event_type" db:"event_type"`
+	Payload        Event      `json:"payload" db:"payload"`
+	Status         string     `json:"status" db:"status"`
+	Attempts       int        `json:"attempts" db:"attempts"`
+	ResponseStatus *int       `json:"response_status" db:"response_status"`
+	ErrorMessage   *string    `json:"error_message" db:"error_message"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at" db:"next_attempt_at"`
+	DeliveredAt    *time.Time `json:"delivered_at" db:"delivered_at"`
+	RedeliveryOf   *string    `json:"redelivery_of" db:"redelivery_of"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// CreateEndpointRequest represents the request to register a webhook endpoint
+type CreateEndpointRequest struct {
+	URL         string   `json:"url" binding:"required"`
+	Description string   `json:"description"`
+	EventTypes  []string `json:"event_types" binding:"required,min=1"`
+}
+
+// UpdateEndpointRequest represents a partial update of a webhook endpoint
+type UpdateEndpointRequest struct {
+	URL         *string  `json:"url"`
+	Description *string  `json:"description"`
+	EventTypes  []string `json:"event_types"`
+	IsActive    *bool    `json:"is_active"`
+}
+
+// subscribes reports whether the endpoint wants events of the given type
+func (e *Endpoint) subscribes(eventType string) bool {
+	if eventType == EventWebhookTest {
+		return true
+	}
+	for _, t := range e.EventTypes {
+		if t == eventType || t == EventWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// redacted returns a copy of the endpoint safe to return from list/get endpoints
+func (e Endpoint) redacted() Endpoint {
+	if len(e.Secret) > 8 {
+		e.Secret = e.Secret[:8] + "…"
+	}
+	return e
+}
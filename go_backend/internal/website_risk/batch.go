@@ -0,0 +1,889 @@
+package website_risk
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/pricing"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Batch processing limits
+const (
+	maxBatchRows       = 500
+	batchWorkerCount   = 3
+	batchUploadFormKey = "file"
+	// maxBatchUploadBytes bounds the request body, checked while it is read
+	maxBatchUploadBytes = 5 << 20
+)
+
+// In-memory batch storage (persisted to assessment_batches when a database is available)
+var (
+	batchStore = make(map[string]*AssessmentBatch)
+	batchMutex = sync.RWMutex{}
+)
+
+// errBatchNotFound is returned when no batch matches the requested ID
+var errBatchNotFound = errors.New("batch not found")
+
+// batchCSVColumns maps accepted CSV headers to request fields
+var batchCSVColumns = map[string]func(*DoRiskAssessmentRequest, string){
+	"website":            func(r *DoRiskAssessmentRequest, v string) { r.Website = v },
+	"id":                 func(r *DoRiskAssessmentRequest, v string) { r.ID = v },
+	"billingcountrycode": func(r *DoRiskAssessmentRequest, v string) { r.BillingCountryCode = v },
+	"description":        func(r *DoRiskAssessmentRequest, v string) { r.Description = v },
+	"annual_revenue__c":  func(r *DoRiskAssessmentRequest, v string) { r.AnnualRevenue = v },
+	"cb_sic_code__c":     func(r *DoRiskAssessmentRequest, v string) { r.CBSICCode = v },
+	"cb_pay_method__c":   func(r *DoRiskAssessmentRequest, v string) { r.CBPayMethod = v },
+	"assessment_type":    func(r *DoRiskAssessmentRequest, v string) { r.AssessmentType = v },
+	"checks": func(r *DoRiskAssessmentRequest, v string) {
+		for _, check := range strings.Split(v, ";") {
+			if check = strings.TrimSpace(check); check != "" {
+				r.Checks = append(r.Checks, check)
+			}
+		}
+	},
+}
+
+// CreateBatchHandler handles POST /api/website-risk-assessment/batches
+func CreateBatchHandler(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	requests, source, err := parseBatchRequests(c)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":     fmt.Sprintf("Batch upload exceeds the maximum of %d bytes", maxBatchUploadBytes),
+			"code":      "BATCH_UPLOAD_TOO_LARGE",
+			"max_bytes": maxBatchUploadBytes,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+			"code":  "INVALID_BATCH_PAYLOAD",
+		})
+		return
+	}
+
+	if len(requests) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Batch contains no rows",
+			"code":  "EMPTY_BATCH",
+		})
+		return
+	}
+
+	if len(requests) > maxBatchRows {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    fmt.Sprintf("Batch exceeds the maximum of %d rows", maxBatchRows),
+			"code":     "BATCH_TOO_LARGE",
+			"max_rows": maxBatchRows,
+		})
+		return
+	}
+
+	discount, err := pricing.DiscountPercent(workspace)
+	if err != nil {
+		pricing.RespondQuoteError(c, "CreateBatchHandler", err)
+		return
+	}
+
+	batch := newBatch(workspace, source, requests, pricing.Current(), discount)
+	log.Printf("📦 CreateBatchHandler: Batch %s from user %s has %d valid and %d invalid rows",
+		batch.ID, userID, batch.ValidRows, batch.InvalidRows)
+
+	if batch.ValidRows == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "No valid rows in batch",
+			"code":       "NO_VALID_ROWS",
+			"row_errors": batchRowErrors(batch),
+		})
+		return
+	}
+
+	// Reserve credits for every valid row up front so the batch either runs in full or not at all.
+	// Each row captures its share as it completes; what is left is released when the batch ends.
+	reservation, err := reserveAssessmentCredits(c, workspace, batch.CreditsReserved, idempotencyKey(c, "batch"),
+		fmt.Sprintf("Batch risk assessment %s (%d websites)", batch.ID, batch.ValidRows))
+	if err != nil {
+		log.Printf("❌ CreateBatchHandler: Credit reservation failed for batch %s: %v", batch.ID, err)
+		respondCreditError(c, err, batch.CreditsReserved)
+		return
+	}
+	batch.CreditReservationID = &reservation.ID
+
+	batchMutex.Lock()
+	batchStore[batch.ID] = batch
+	batchMutex.Unlock()
+
+	if err := saveBatchToDatabase(batch); err != nil {
+		log.Printf("⚠️ CreateBatchHandler: Failed to save batch %s to database: %v", batch.ID, err)
+	}
+
+	go processBatch(batch)
+
+	auth.SetAuditResource(c, batch.ID)
+	c.JSON(http.StatusAccepted, summarizeBatch(batch, true))
+}
+
+// CancelBatchHandler handles POST /api/website-risk-assessment/batches/:id/cancel.
+// Rows already running finish; the credits of rows never started are released.
+func CancelBatchHandler(c *gin.Context) {
+	batch, ok := loadAuthorizedBatch(c)
+	if !ok {
+		return
+	}
+
+	batchMutex.Lock()
+	status := batch.Status
+	if status == "queued" || status == "processing" {
+		batch.Status = "cancelled"
+		batch.UpdatedAt = time.Now()
+	}
+	batchMutex.Unlock()
+
+	if status != "queued" && status != "processing" {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  fmt.Sprintf("Batch is already %s", status),
+			"code":   "BATCH_NOT_CANCELLABLE",
+			"status": status,
+		})
+		return
+	}
+
+	// Batches from before a restart have no worker left to release their credits
+	batchMutex.RLock()
+	_, running := batchStore[batch.ID]
+	batchMutex.RUnlock()
+	if !running {
+		finishBatch(batch)
+	}
+
+	log.Printf("🛑 CancelBatchHandler: Batch %s cancelled by user %s", batch.ID, auth.GetUserID(c))
+	auth.SetAuditResource(c, batch.ID)
+	c.JSON(http.StatusOK, summarizeBatch(batch, false))
+}
+
+// ListBatchesHandler handles GET /api/website-risk-assessment/batches
+func ListBatchesHandler(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	batches, err := listBatches(workspace)
+	if err != nil {
+		log.Printf("❌ ListBatchesHandler: Failed to list batches for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch batches",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
+	}
+
+	result := make([]BatchSummaryResponse, 0, len(batches))
+	for _, batch := range batches {
+		result = append(result, summarizeBatch(batch, false))
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetBatchHandler handles GET /api/website-risk-assessment/batches/:id
+func GetBatchHandler(c *gin.Context) {
+	batch, ok := loadAuthorizedBatch(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, summarizeBatch(batch, true))
+}
+
+// GetBatchResultsHandler handles GET /api/website-risk-assessment/batches/:id/results
+func GetBatchResultsHandler(c *gin.Context) {
+	batch, ok := loadAuthorizedBatch(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"batch_id": batch.ID,
+		"status":   currentBatchStatus(batch),
+		"results":  batchResults(batch),
+	})
+}
+
+// ExportBatchResultsCSVHandler handles GET /api/website-risk-assessment/batches/:id/export/csv
+func ExportBatchResultsCSVHandler(c *gin.Context) {
+	batch, ok := loadAuthorizedBatch(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=batch-%s-results.csv", batch.ID))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{
+		"Row", "Website", "Salesforce Id", "Billing Country Code", "Status",
+		"Assessment ID", "Risk Score", "Risk Category", "MCC Restricted", "Errors",
+	})
+
+	for _, result := range batchResults(batch) {
+		assessmentID := ""
+		if result.AssessmentID != 0 {
+			assessmentID = fmt.Sprintf("%d", result.AssessmentID)
+		}
+		riskScore := ""
+		if result.RiskScore != nil {
+			riskScore = fmt.Sprintf("%d", *result.RiskScore)
+		}
+		errorText := strings.Join(result.Errors, "; ")
+		if errorText == "" {
+			errorText = result.ErrorMessage
+		}
+
+		writer.Write([]string{
+			fmt.Sprintf("%d", result.RowNumber),
+			result.Website,
+			result.SalesforceID,
+			result.CountryCode,
+			result.Status,
+			assessmentID,
+			riskScore,
+			result.RiskCategory,
+			fmt.Sprintf("%t", result.MCCRestricted),
+			errorText,
+		})
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("⚠️ ExportBatchResultsCSVHandler: Failed to write CSV for batch %s: %v", batch.ID, err)
+	}
+}
+
+// parseBatchRequests reads batch rows from a multipart CSV upload, a raw CSV body
+// or a JSON array body. Bodies over maxBatchUploadBytes fail with *http.MaxBytesError.
+func parseBatchRequests(c *gin.Context) ([]DoRiskAssessmentRequest, string, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchUploadBytes)
+	contentType := c.ContentType()
+
+	switch {
+	case contentType == "multipart/form-data":
+		fileHeader, err := c.FormFile(batchUploadFormKey)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, "", err
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("missing CSV upload in form field %q", batchUploadFormKey)
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to open uploaded file: %v", err)
+		}
+		defer file.Close()
+
+		requests, err := parseBatchCSV(file)
+		return requests, "csv", err
+
+	case contentType == "text/csv" || contentType == "application/csv":
+		requests, err := parseBatchCSV(c.Request.Body)
+		return requests, "csv", err
+
+	default:
+		var requests []DoRiskAssessmentRequest
+		if err := json.NewDecoder(c.Request.Body).Decode(&requests); err != nil {
+			return nil, "", fmt.Errorf("request body must be a JSON array of assessment requests: %w", err)
+		}
+		return requests, "json", nil
+	}
+}
+
+// parseBatchCSV parses a CSV file whose header row uses the do-assessment field names
+func parseBatchCSV(r io.Reader) ([]DoRiskAssessmentRequest, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	setters := make([]func(*DoRiskAssessmentRequest, string), len(header))
+	hasWebsite := false
+	for i, column := range header {
+		key := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		setters[i] = batchCSVColumns[key]
+		if key == "website" {
+			hasWebsite = true
+		}
+	}
+	if !hasWebsite {
+		return nil, fmt.Errorf("CSV header must include a Website column")
+	}
+
+	var requests []DoRiskAssessmentRequest
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV row %d: %w", len(requests)+1, err)
+		}
+
+		var req DoRiskAssessmentRequest
+		empty := true
+		for i, value := range record {
+			if i >= len(setters) || setters[i] == nil {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if value != "" {
+				empty = false
+			}
+			setters[i](&req, value)
+		}
+		if empty {
+			continue // Skip blank lines left by spreadsheet exports
+		}
+		requests = append(requests, req)
+	}
+
+	return requests, nil
+}
+
+// newBatch validates submitted rows, prices them with the catalog and the
+// plan discount in percent, and builds a queued batch in a workspace
+func newBatch(workspace auth.Workspace, source string, requests []DoRiskAssessmentRequest, catalog *pricing.Catalog, discountPercent int) *AssessmentBatch {
+	now := time.Now()
+	batch := &AssessmentBatch{
+		ID:        uuid.NewString(),
+		UserID:    workspace.UserID,
+		OrgID:     workspace.OrgIDPtr(),
+		Status:    "queued",
+		Source:    source,
+		TotalRows: len(requests),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	seenWebsites := make(map[string]int)
+	for i, req := range requests {
+		row := &BatchRow{
+			RowNumber: i + 1,
+			Request:   normalizeBatchRequest(req),
+		}
+		row.Errors = validateBatchRow(row.Request)
+		if quote, err := quoteRequest(catalog, row.Request, discountPercent); err != nil {
+			row.Errors = append(row.Errors, err.Error())
+		} else {
+			row.quote = quote
+		}
+
+		website := strings.ToLower(row.Request.Website)
+		if firstRow, duplicate := seenWebsites[website]; duplicate && website != "" {
+			row.Errors = append(row.Errors, fmt.Sprintf("duplicate of row %d", firstRow))
+		} else if website != "" {
+			seenWebsites[website] = row.RowNumber
+		}
+
+		if len(row.Errors) == 0 {
+			row.CreditsRequired = row.quote.Credits
+			batch.ValidRows++
+			batch.CreditsReserved += row.CreditsRequired
+		} else {
+			batch.InvalidRows++
+		}
+
+		batch.Rows = append(batch.Rows, row)
+	}
+
+	return batch
+}
+
+// normalizeBatchRequest trims user-supplied values and canonicalizes the website
+func normalizeBatchRequest(req DoRiskAssessmentRequest) DoRiskAssessmentRequest {
+	req.Website = strings.TrimSpace(req.Website)
+	req.ID = strings.TrimSpace(req.ID)
+	req.BillingCountryCode = strings.ToUpper(strings.TrimSpace(req.BillingCountryCode))
+	req.Description = strings.TrimSpace(req.Description)
+
+	if req.Website != "" {
+		candidate := req.Website
+		if !strings.Contains(candidate, "://") {
+			candidate = "https://" + candidate
+		}
+		if parsed, err := url.Parse(candidate); err == nil && parsed.Hostname() != "" {
+			req.Website = strings.ToLower(parsed.Hostname())
+		}
+	}
+
+	return req
+}
+
+// validateBatchRow returns the validation errors for a normalized row
+func validateBatchRow(req DoRiskAssessmentRequest) []string {
+	var errs []string
+
+	if req.Website == "" {
+		errs = append(errs, "Website is required")
+	} else if !strings.Contains(req.Website, ".") || strings.ContainsAny(req.Website, " /\\") {
+		errs = append(errs, "Website is not a valid domain")
+	}
+
+	if req.ID == "" {
+		errs = append(errs, "Id is required")
+	}
+
+	if len(req.BillingCountryCode) != 2 {
+		errs = append(errs, "BillingCountryCode must be a 2-letter ISO country code")
+	}
+
+	return errs
+}
+
+// processBatch runs the assessments for every valid row with bounded concurrency
+func processBatch(batch *AssessmentBatch) {
+	log.Printf("🔄 processBatch: Starting batch %s (%d assessments)", batch.ID, batch.ValidRows)
+
+	batchMutex.Lock()
+	if batch.Status == "queued" {
+		batch.Status = "processing"
+	}
+	batch.UpdatedAt = time.Now()
+	batchMutex.Unlock()
+
+	rows := make(chan *BatchRow)
+	var wg sync.WaitGroup
+	for i := 0; i < batchWorkerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range rows {
+				runBatchRow(batch, row)
+			}
+		}()
+	}
+
+	for _, row := range batch.Rows {
+		if currentBatchStatus(batch) == "cancelled" {
+			break
+		}
+		if len(row.Errors) == 0 {
+			rows <- row
+		}
+	}
+	close(rows)
+	wg.Wait()
+
+	finishBatch(batch)
+}
+
+// finishBatch marks a batch completed unless it was cancelled and releases the
+// credits its rows did not use
+func finishBatch(batch *AssessmentBatch) {
+	batchMutex.Lock()
+	if batch.Status != "cancelled" {
+		batch.Status = "completed"
+	}
+	batch.UpdatedAt = time.Now()
+	status := batch.Status
+	reservationID := batch.CreditReservationID
+	batchMutex.Unlock()
+
+	if reservationID != nil {
+		released, err := auth.ReleaseCredits(*reservationID, 0, fmt.Sprintf("Batch %s %s", batch.ID, status), "")
+		if err != nil {
+			log.Printf("❌ finishBatch: Failed to release unused credits of batch %s: %v", batch.ID, err)
+		} else if released != nil {
+			log.Printf("♻️ finishBatch: Released %d unused credits of batch %s", -released.ReservedChange, batch.ID)
+		}
+	}
+
+	if err := updateBatchInDatabase(batch); err != nil {
+		log.Printf("⚠️ finishBatch: Failed to update batch %s in database: %v", batch.ID, err)
+	}
+
+	log.Printf("✅ finishBatch: Batch %s %s", batch.ID, status)
+}
+
+// runBatchRow creates and runs the assessment for a single row
+func runBatchRow(batch *AssessmentBatch, row *BatchRow) {
+	assessment := newAssessmentRecord(row.Request, batch.workspace(), row.quote)
+
+	assessmentMutex.Lock()
+	assessment.AssessmentData["batch_id"] = batch.ID
+	assessment.AssessmentData["batch_row"] = row.RowNumber
+	if batch.CreditReservationID != nil {
+		// Rows share the batch reservation, so each settles only its own credits
+		assessment.CreditReservationID = batch.CreditReservationID
+		assessment.settlement = creditSettlement{
+			reservationID: *batch.CreditReservationID,
+			credits:       row.CreditsRequired,
+			key:           fmt.Sprintf("%s:row:%d", *batch.CreditReservationID, row.RowNumber),
+		}
+	}
+	assessmentMutex.Unlock()
+
+	// Save synchronously so the row is linked to the database ID
+	if err := saveAssessmentToDatabase(assessment, batch.UserID, row.CreditsRequired); err != nil {
+		log.Printf("⚠️ runBatchRow: Failed to save assessment for %s to database: %v", row.Request.Website, err)
+	}
+
+	batchMutex.Lock()
+	row.assessment = assessment
+	row.AssessmentID = assessment.ID
+	batch.UpdatedAt = time.Now()
+	batchMutex.Unlock()
+
+	runAssessment(assessment)
+}
+
+// loadAuthorizedBatch fetches the batch named by the :id path parameter and checks
+// that the caller may access it. On failure the error response has already been written.
+func loadAuthorizedBatch(c *gin.Context) (*AssessmentBatch, bool) {
+	batch, err := getBatch(c.Param("id"))
+	if errors.Is(err, errBatchNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Batch not found",
+			"code":  "BATCH_NOT_FOUND",
+		})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("❌ loadAuthorizedBatch: Failed to fetch batch %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch batch",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return nil, false
+	}
+
+	if !auth.AuthorizeAssessmentAccess(c, batch.UserID, batch.workspace().OrgID) {
+		return nil, false
+	}
+
+	return batch, true
+}
+
+// getBatch returns a batch from memory, falling back to the database for batches
+// submitted before the last restart
+func getBatch(id string) (*AssessmentBatch, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errBatchNotFound
+	}
+
+	batchMutex.RLock()
+	batch, exists := batchStore[id]
+	batchMutex.RUnlock()
+	if exists {
+		return batch, nil
+	}
+
+	if supabaseClient == nil {
+		return nil, errBatchNotFound
+	}
+
+	var results []AssessmentBatch
+	err := supabaseClient.DB.From("assessment_batches").
+		Select("*").
+		Eq("id", id).
+		Execute(&results)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, errBatchNotFound
+	}
+
+	batch = &results[0]
+	attachBatchAssessments(batch)
+	return batch, nil
+}
+
+// listBatches returns the workspace's batches, newest first
+func listBatches(workspace auth.Workspace) ([]*AssessmentBatch, error) {
+	byID := make(map[string]*AssessmentBatch)
+
+	if supabaseClient != nil {
+		var results []AssessmentBatch
+		err := workspace.Scope(supabaseClient.DB.From("assessment_batches").Select("*")).
+			Execute(&results)
+		if err != nil {
+			return nil, err
+		}
+		for i := range results {
+			byID[results[i].ID] = &results[i]
+		}
+	}
+
+	// In-memory batches carry live row state, so they take precedence
+	batchMutex.RLock()
+	for id, batch := range batchStore {
+		if workspace.Contains(batch.UserID, batch.OrgID) {
+			byID[id] = batch
+		}
+	}
+	batchMutex.RUnlock()
+
+	batches := make([]*AssessmentBatch, 0, len(byID))
+	for _, batch := range byID {
+		batches = append(batches, batch)
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].CreatedAt.After(batches[j].CreatedAt)
+	})
+
+	return batches, nil
+}
+
+// attachBatchAssessments loads the assessments referenced by a persisted batch
+func attachBatchAssessments(batch *AssessmentBatch) {
+	var ids []string
+	for _, row := range batch.Rows {
+		if row.AssessmentID != 0 {
+			ids = append(ids, fmt.Sprintf("%d", row.AssessmentID))
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	var assessments []Assessment
+	err := supabaseClient.DB.From("assessments").
+		Select("*").
+		In("id", ids).
+		Execute(&assessments)
+	if err != nil {
+		log.Printf("⚠️ attachBatchAssessments: Failed to load assessments for batch %s: %v", batch.ID, err)
+		return
+	}
+
+	byID := make(map[int64]*Assessment, len(assessments))
+	for i := range assessments {
+		byID[assessments[i].ID] = &assessments[i]
+	}
+	for _, row := range batch.Rows {
+		row.assessment = byID[row.AssessmentID]
+	}
+}
+
+// summarizeBatch builds the status view of a batch
+func summarizeBatch(batch *AssessmentBatch, includeErrors bool) BatchSummaryResponse {
+	statuses := make(map[string]int)
+	for _, result := range batchResults(batch) {
+		statuses[result.Status]++
+	}
+
+	batchMutex.RLock()
+	summary := BatchSummaryResponse{
+		ID:              batch.ID,
+		Status:          batch.Status,
+		Source:          batch.Source,
+		TotalRows:       batch.TotalRows,
+		ValidRows:       batch.ValidRows,
+		InvalidRows:     batch.InvalidRows,
+		CreditsReserved: batch.CreditsReserved,
+		RowStatuses:     statuses,
+		CreatedAt:       batch.CreatedAt,
+		UpdatedAt:       batch.UpdatedAt,
+	}
+	batchMutex.RUnlock()
+
+	if includeErrors {
+		summary.RowErrors = batchRowErrors(batch)
+	}
+
+	return summary
+}
+
+// currentBatchStatus reads the batch status under lock
+func currentBatchStatus(batch *AssessmentBatch) string {
+	batchMutex.RLock()
+	defer batchMutex.RUnlock()
+	return batch.Status
+}
+
+// batchRowErrors lists the rows rejected during validation
+func batchRowErrors(batch *AssessmentBatch) []BatchRowError {
+	var rowErrors []BatchRowError
+	for _, row := range batch.Rows {
+		if len(row.Errors) > 0 {
+			rowErrors = append(rowErrors, BatchRowError{
+				RowNumber: row.RowNumber,
+				Website:   row.Request.Website,
+				Errors:    row.Errors,
+			})
+		}
+	}
+	return rowErrors
+}
+
+// batchResults reports the current outcome of every row in a batch
+func batchResults(batch *AssessmentBatch) []BatchRowResult {
+	batchMutex.RLock()
+	defer batchMutex.RUnlock()
+
+	results := make([]BatchRowResult, 0, len(batch.Rows))
+	for _, row := range batch.Rows {
+		result := BatchRowResult{
+			RowNumber:    row.RowNumber,
+			Website:      row.Request.Website,
+			SalesforceID: row.Request.ID,
+			CountryCode:  row.Request.BillingCountryCode,
+			AssessmentID: row.AssessmentID,
+			Errors:       row.Errors,
+		}
+
+		switch {
+		case len(row.Errors) > 0:
+			result.Status = "invalid"
+		case row.assessment == nil && batch.Status == "cancelled":
+			result.Status = "cancelled"
+		case row.assessment == nil:
+			result.Status = "queued"
+		default:
+			fillBatchRowResult(&result, row.assessment)
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// fillBatchRowResult copies assessment outcome fields into a row result
+func fillBatchRowResult(result *BatchRowResult, assessment *Assessment) {
+	assessmentMutex.RLock()
+	defer assessmentMutex.RUnlock()
+
+	result.Status = assessment.Status
+	result.AssessmentID = assessment.ID
+	if assessment.ErrorMessage != nil {
+		result.ErrorMessage = *assessment.ErrorMessage
+	}
+
+	result.RiskScore = assessment.RiskScore
+	if assessment.RiskCategory != nil {
+		result.RiskCategory = *assessment.RiskCategory
+	}
+	if assessment.AssessmentData == nil {
+		return
+	}
+
+	// In-memory assessments only carry results inside assessment_data
+	if result.RiskScore == nil {
+		switch score := assessment.AssessmentData["risk_score"].(type) {
+		case int:
+			result.RiskScore = &score
+		case float64:
+			value := int(score)
+			result.RiskScore = &value
+		}
+	}
+	if category, ok := assessment.AssessmentData["risk_category"].(string); ok && result.RiskCategory == "" {
+		result.RiskCategory = category
+	}
+	if mccRestricted, ok := assessment.AssessmentData["mcc_restricted"].(bool); ok {
+		result.MCCRestricted = mccRestricted
+	}
+}
+
+// workspace returns the workspace the batch was submitted in
+func (b *AssessmentBatch) workspace() auth.Workspace {
+	workspace := auth.Workspace{UserID: b.UserID}
+	if b.OrgID != nil {
+		workspace.OrgID = *b.OrgID
+	}
+	return workspace
+}
+
+// saveBatchToDatabase persists a newly submitted batch
+func saveBatchToDatabase(batch *AssessmentBatch) error {
+	if supabaseClient == nil {
+		return fmt.Errorf("no database connection available")
+	}
+
+	batchMutex.RLock()
+	record := map[string]interface{}{
+		"id":                    batch.ID,
+		"user_id":               batch.UserID,
+		"org_id":                batch.OrgID,
+		"status":                batch.Status,
+		"source":                batch.Source,
+		"total_rows":            batch.TotalRows,
+		"valid_rows":            batch.ValidRows,
+		"invalid_rows":          batch.InvalidRows,
+		"credits_reserved":      batch.CreditsReserved,
+		"credit_reservation_id": batch.CreditReservationID,
+		"rows":                  batch.Rows,
+	}
+	batchMutex.RUnlock()
+
+	var results []map[string]interface{}
+	return supabaseClient.DB.From("assessment_batches").Insert(record).Execute(&results)
+}
+
+// updateBatchInDatabase stores the batch status and the assessment IDs linked to its rows
+func updateBatchInDatabase(batch *AssessmentBatch) error {
+	if supabaseClient == nil {
+		return fmt.Errorf("no database connection available")
+	}
+
+	batchMutex.RLock()
+	updateData := map[string]interface{}{
+		"status":     batch.Status,
+		"rows":       batch.Rows,
+		"updated_at": time.Now().Format(time.RFC3339),
+	}
+	batchMutex.RUnlock()
+
+	var results []map[string]interface{}
+	return supabaseClient.DB.From("assessment_batches").
+		Update(updateData).
+		Eq("id", batch.ID).
+		Execute(&results)
+}
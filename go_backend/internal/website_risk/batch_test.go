@@ -0,0 +1,102 @@
+package website_risk
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/pricing"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseBatchCSV(t *testing.T) {
+	input := "Website,Id,BillingCountryCode,Description\n" +
+		"example.com,006A,us,Online store\n" +
+		",,,\n" +
+		"https://Shop.Example.org/path,006B,GB,\n"
+
+	requests, err := parseBatchCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseBatchCSV returned error: %v", err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(requests))
+	}
+	if requests[0].Website != "example.com" || requests[0].ID != "006A" || requests[0].Description != "Online store" {
+		t.Errorf("unexpected first row: %+v", requests[0])
+	}
+}
+
+func TestParseBatchCSVRequiresWebsiteColumn(t *testing.T) {
+	if _, err := parseBatchCSV(strings.NewReader("Id,BillingCountryCode\n006A,US\n")); err == nil {
+		t.Fatal("expected an error for a CSV without a Website column")
+	}
+}
+
+func TestNewBatchValidatesRows(t *testing.T) {
+	catalog := pricing.NewCatalog(pricing.DefaultItems, 0)
+	batch := newBatch(auth.Workspace{UserID: "user-1"}, "json", []DoRiskAssessmentRequest{
+		{Website: "https://Example.com/shop", ID: "006A", BillingCountryCode: "us"},
+		{Website: "example.com", ID: "006B", BillingCountryCode: "US"},
+		{Website: "not a domain", ID: "", BillingCountryCode: "USA"},
+		{Website: "valid.io", ID: "006C", BillingCountryCode: "DE", Description: strings.Repeat("x", 60)},
+		{Website: "other.io", ID: "006D", BillingCountryCode: "DE", AssessmentType: "quick", Checks: []string{"dark_web"}},
+	}, catalog, 0)
+
+	if batch.ValidRows != 2 || batch.InvalidRows != 3 {
+		t.Fatalf("expected 2 valid and 3 invalid rows, got %d valid and %d invalid", batch.ValidRows, batch.InvalidRows)
+	}
+	if len(batch.Rows[4].Errors) != 1 || !strings.Contains(batch.Rows[4].Errors[0], "unknown check") {
+		t.Errorf("expected unknown check error on row 5, got %v", batch.Rows[4].Errors)
+	}
+	if batch.Rows[0].Request.Website != "example.com" || batch.Rows[0].Request.BillingCountryCode != "US" {
+		t.Errorf("row was not normalized: %+v", batch.Rows[0].Request)
+	}
+	if len(batch.Rows[1].Errors) != 1 || !strings.Contains(batch.Rows[1].Errors[0], "duplicate of row 1") {
+		t.Errorf("expected duplicate error on row 2, got %v", batch.Rows[1].Errors)
+	}
+	if len(batch.Rows[2].Errors) != 3 {
+		t.Errorf("expected 3 errors on row 3, got %v", batch.Rows[2].Errors)
+	}
+	if batch.CreditsReserved != 4 {
+		t.Errorf("expected 4 credits reserved, got %d", batch.CreditsReserved)
+	}
+}
+
+func TestParseBatchRequestsRejectsOversizedBodies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	row := "example.com,006A,US," + strings.Repeat("x", 1000) + "\n"
+	csvBody := "Website,Id,BillingCountryCode,Description\n" + strings.Repeat(row, maxBatchUploadBytes/len(row)+1)
+
+	var multipartBody bytes.Buffer
+	form := multipart.NewWriter(&multipartBody)
+	part, _ := form.CreateFormFile(batchUploadFormKey, "batch.csv")
+	part.Write([]byte(csvBody))
+	form.Close()
+
+	bodies := map[string]struct {
+		contentType string
+		body        string
+	}{
+		"csv":       {"text/csv", csvBody},
+		"json":      {"application/json", `[{"website":"` + strings.Repeat("x", maxBatchUploadBytes) + `"}]`},
+		"multipart": {form.FormDataContentType(), multipartBody.String()},
+	}
+	for name, tt := range bodies {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/website-risk-assessment/batches", strings.NewReader(tt.body))
+		c.Request.Header.Set("Content-Type", tt.contentType)
+
+		_, _, err := parseBatchRequests(c)
+		var tooLarge *http.MaxBytesError
+		if !errors.As(err, &tooLarge) {
+			t.Errorf("%s: expected a body size error, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package website_risk
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/notifications"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/webhooks"
+)
+
+// publishAssessmentEvent notifies the owner's webhook endpoints and
+// notification rules about an assessment lifecycle change
+func publishAssessmentEvent(eventType string, assessment *Assessment) {
+	assessmentMutex.RLock()
+	data := assessmentEventData(assessment)
+	event := notifications.Event{
+		Type:         eventType,
+		UserID:       assessment.UserID,
+		AssessmentID: assessment.ID,
+		Source:       "website_risk_assessment",
+		Website:      assessment.Website,
+		Status:       assessment.Status,
+		RiskScore:    riskScoreFromData(data["risk_score"]),
+		LinkPath:     fmt.Sprintf("/website-risk-assessment/assessments/%d", assessment.ID),
+		OccurredAt:   time.Now().UTC(),
+	}
+	assessmentMutex.RUnlock()
+
+	switch category := data["risk_category"].(type) {
+	case string:
+		event.RiskCategory = category
+	case *string:
+		if category != nil {
+			event.RiskCategory = *category
+		}
+	}
+	if errorMessage, ok := data["error_message"].(*string); ok && errorMessage != nil {
+		event.ErrorMessage = *errorMessage
+	}
+
+	log.Printf("📢 %s: %s (assessment %d, user %s)", eventType, event.Website, event.AssessmentID, event.UserID)
+
+	go webhooks.Publish(event.UserID, eventType, data)
+	go notifications.Notify(event)
+}
+
+// assessmentEventData builds the webhook payload for an assessment
+func assessmentEventData(assessment *Assessment) map[string]interface{} {
+	data := map[string]interface{}{
+		"assessment_id":   assessment.ID,
+		"reference":       fmt.Sprintf("WRA-%d", assessment.ID),
+		"source":          "website_risk_assessment",
+		"website":         assessment.Website,
+		"country_code":    assessment.CountryCode,
+		"status":          assessment.Status,
+		"risk_score":      assessment.RiskScore,
+		"risk_category":   assessment.RiskCategory,
+		"error_message":   assessment.ErrorMessage,
+		"created_at":      assessment.CreatedAt,
+		"updated_at":      assessment.UpdatedAt,
+		"mcc_restricted":  false,
+		"salesforce_id":   nil,
+		"credits_used":    assessment.CreditsConsumed,
+		"processing_time": assessment.ProcessingTimeSeconds,
+	}
+
+	if assessment.AssessmentData != nil {
+		if assessment.RiskScore == nil {
+			data["risk_score"] = assessment.AssessmentData["risk_score"]
+		}
+		if assessment.RiskCategory == nil {
+			data["risk_category"] = assessment.AssessmentData["risk_category"]
+		}
+		if mr, ok := assessment.AssessmentData["mcc_restricted"].(bool); ok {
+			data["mcc_restricted"] = mr
+		}
+		if cs, ok := assessment.AssessmentData["country_supported"].(bool); ok {
+			data["country_supported"] = cs
+		}
+		if batchID, ok := assessment.AssessmentData["batch_id"].(string); ok {
+			data["batch_id"] = batchID
+		}
+		if sf, ok := assessment.AssessmentData["salesforce_request"].(map[string]interface{}); ok {
+			data["salesforce_id"] = sf["Id"]
+		}
+	}
+
+	return data
+}
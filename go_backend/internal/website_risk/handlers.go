@@ -1,16 +1,21 @@
 package website_risk
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
 	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/billing"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/pricing"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/salesforce"
 	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/scrapers"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/webhooks"
 	"github.com/gin-gonic/gin"
 	supa "github.com/nedpals/supabase-go"
 )
@@ -23,6 +28,9 @@ var (
 	assessmentCounter int64
 )
 
+// errAssessmentNotFound is returned when no assessment matches the requested ID
+var errAssessmentNotFound = errors.New("assessment not found")
+
 // InitDatabase initializes the Supabase connection using your .env values
 func InitDatabase(url, key string) error {
 	client := supa.CreateClient(url, key)
@@ -51,9 +59,16 @@ type Assessment struct {
 	CreatedBy             string                 `json:"created_by" db:"created_by"`
 	UpdatedBy             string                 `json:"updated_by" db:"updated_by"`
 	UserID                string                 `json:"user_id" db:"user_id"`
+	OrgID                 *string                `json:"org_id" db:"org_id"` // owning organization, nil for personal assessments
 	CreditsConsumed       int                    `json:"credits_consumed" db:"credits_consumed"`
 	AssessmentCost        *float64               `json:"assessment_cost" db:"assessment_cost"`
 	AssessmentType        string                 `json:"assessment_type" db:"assessment_type"`
+	CreditReservationID   *string                `json:"credit_reservation_id" db:"credit_reservation_id"` // credits held until the assessment completes or fails
+
+	// settlement is the part of the reservation this assessment settles
+	settlement creditSettlement
+	// options turns off the optional checks the assessment was not priced with
+	options scrapers.AssessmentOptions
 
 	// Auto-generated fields (read-only, computed from JSON)
 	RiskScore        *int    `json:"risk_score,omitempty" db:"risk_score"`
@@ -62,6 +77,14 @@ type Assessment struct {
 	MCCRestricted    *bool   `json:"mcc_restricted,omitempty" db:"mcc_restricted"`
 }
 
+// orgID returns the owning organization ID, or "" for personal assessments
+func (a *Assessment) orgID() string {
+	if a.OrgID == nil {
+		return ""
+	}
+	return *a.OrgID
+}
+
 // DoRiskAssessmentHandler handles POST /api/website-risk-assessment/do-assessment
 func DoRiskAssessmentHandler(c *gin.Context) {
 	// Get authenticated user ID
@@ -74,6 +97,11 @@ func DoRiskAssessmentHandler(c *gin.Context) {
 		return
 	}
 
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
 	var req DoRiskAssessmentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -82,59 +110,194 @@ func DoRiskAssessmentHandler(c *gin.Context) {
 
 	log.Printf("🚀 DoRiskAssessmentHandler: Starting assessment for %s by user %s", req.Website, userID)
 
-	// Determine credit cost based on request (you can add logic here)
-	creditsRequired := 1 // Default for quick assessment
-	if req.Description != "" && len(req.Description) > 50 {
-		creditsRequired = 3 // Comprehensive assessment
+	// Price the assessment with the catalog and the workspace's plan discount
+	discount, err := pricing.DiscountPercent(workspace)
+	if err != nil {
+		pricing.RespondQuoteError(c, "DoRiskAssessmentHandler", err)
+		return
+	}
+	quote, err := quoteRequest(pricing.Current(), req, discount)
+	if err != nil {
+		pricing.RespondQuoteError(c, "DoRiskAssessmentHandler", err)
+		return
 	}
+	creditsRequired := quote.Credits
 
-	log.Printf("💳 DoRiskAssessmentHandler: Credits required: %d for %s", creditsRequired, req.Website)
+	log.Printf("💳 DoRiskAssessmentHandler: Credits required: %d for %s (%s)", creditsRequired, req.Website, quote.AssessmentType)
 
-	// Check and consume credits before processing
-	err := auth.ConsumeCredits(userID, creditsRequired, nil, fmt.Sprintf("Website risk assessment for %s", req.Website))
+	// Reserve credits before processing; they are charged once the assessment
+	// completes and returned if it fails
+	reservation, err := reserveAssessmentCredits(c, workspace, creditsRequired, idempotencyKey(c, "website_risk"),
+		fmt.Sprintf("Website risk assessment for %s", req.Website))
 	if err != nil {
-		log.Printf("❌ DoRiskAssessmentHandler: Credit consumption failed: %v", err)
-		
-		// If no credits found, try to initialize the user first
-		if strings.Contains(err.Error(), "not found") {
-			log.Printf("🔧 DoRiskAssessmentHandler: Initializing credits for user %s", userID)
-			
-			// Get user email from context
-			userEmail := auth.GetUserEmail(c)
-			if userEmail != "" {
-				initErr := auth.InitializeUserCreditsAndSubscription(userID, userEmail)
-				if initErr != nil {
-					log.Printf("❌ DoRiskAssessmentHandler: Failed to initialize user: %v", initErr)
-				} else {
-					log.Printf("✅ DoRiskAssessmentHandler: User initialized, retrying credit consumption")
-					// Try consuming credits again
-					err = auth.ConsumeCredits(userID, creditsRequired, nil, fmt.Sprintf("Website risk assessment for %s", req.Website))
-				}
-			}
-		}
-		
-		if err != nil {
-			if err.Error() == "insufficient credits" || strings.Contains(err.Error(), "insufficient") {
-				c.JSON(http.StatusPaymentRequired, gin.H{
-					"error": "Insufficient credits",
-					"code":  "INSUFFICIENT_CREDITS",
-					"credits_required": creditsRequired,
-				})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": "Failed to process credit transaction",
-					"code":  "CREDIT_TRANSACTION_ERROR",
-					"details": err.Error(),
-				})
-			}
-			return
-		}
+		log.Printf("❌ DoRiskAssessmentHandler: Credit reservation failed: %v", err)
+		respondCreditError(c, err, creditsRequired)
+		return
 	}
 
-	log.Printf("✅ DoRiskAssessmentHandler: Credits consumed successfully for %s", req.Website)
+	log.Printf("✅ DoRiskAssessmentHandler: Credits reserved successfully for %s", req.Website)
 
 	// Create assessment record with JSON data structure
+	assessment := newAssessmentRecord(req, workspace, quote)
 	assessmentMutex.Lock()
+	assessment.CreditReservationID = &reservation.ID
+	assessment.settlement = creditSettlement{reservationID: reservation.ID}
+	assessmentMutex.Unlock()
+
+	log.Printf("📄 DoRiskAssessmentHandler: Created assessment record - ID: %d, Website: %s, UserID: %s", assessment.ID, assessment.Website, assessment.UserID)
+
+	// Try to save to database as well (if available)
+	log.Printf("💾 DoRiskAssessmentHandler: Attempting to save to database...")
+	go func() {
+		if err := saveAssessmentToDatabase(assessment, userID, creditsRequired); err != nil {
+			log.Printf("⚠️ DoRiskAssessmentHandler: Failed to save to database: %v", err)
+		} else {
+			log.Printf("✅ DoRiskAssessmentHandler: Successfully saved to database")
+		}
+	}()
+
+	// Start assessment in background
+	log.Printf("🔄 DoRiskAssessmentHandler: Starting background assessment...")
+	go runAssessment(assessment)
+
+	log.Printf("✅ DoRiskAssessmentHandler: Assessment initiated successfully for %s", req.Website)
+	auth.SetAuditResource(c, strconv.FormatInt(assessment.ID, 10))
+
+	// Return response
+	c.JSON(http.StatusOK, DoRiskAssessmentResponse{
+		Status:  "Pending",
+		Website: req.Website,
+		ID:      req.ID,
+	})
+}
+
+// assessmentTypeForRequest returns the requested assessment type. Requests
+// without one, such as those from Salesforce, are comprehensive when they
+// describe the business in more than 50 characters.
+func assessmentTypeForRequest(req DoRiskAssessmentRequest) string {
+	if req.AssessmentType != "" {
+		return req.AssessmentType
+	}
+	if len(req.Description) > 50 {
+		return pricing.AssessmentComprehensive
+	}
+	return pricing.AssessmentQuick
+}
+
+// quoteRequest prices assessing a single website
+func quoteRequest(catalog *pricing.Catalog, req DoRiskAssessmentRequest, discountPercent int) (*pricing.Quote, error) {
+	return catalog.Quote(pricing.ProductWebsiteRisk, assessmentTypeForRequest(req), req.Checks, discountPercent)
+}
+
+// reserveAssessmentCredits holds credits for assessments in the workspace's
+// wallet, initializing a user's credits and subscription first if they have
+// never been set up
+func reserveAssessmentCredits(c *gin.Context, workspace auth.Workspace, credits int, key, description string) (*auth.CreditReservation, error) {
+	// Alert on the balance left, or on running out
+	defer func() { go billing.CheckCreditAlerts(workspace) }()
+
+	reservation, err := workspace.ReserveCredits(credits, key, description)
+	if !errors.Is(err, auth.ErrCreditWalletNotFound) || workspace.IsOrganization() {
+		return reservation, err
+	}
+
+	userID := workspace.UserID
+
+	log.Printf("🔧 reserveAssessmentCredits: Initializing credits for user %s", userID)
+
+	// Get user email from context
+	userEmail := auth.GetUserEmail(c)
+	if userEmail == "" {
+		return nil, err
+	}
+
+	if initErr := auth.InitializeUserCreditsAndSubscription(userID, userEmail); initErr != nil {
+		log.Printf("❌ reserveAssessmentCredits: Failed to initialize user: %v", initErr)
+		return nil, err
+	}
+
+	log.Printf("✅ reserveAssessmentCredits: User initialized, retrying credit reservation")
+	return workspace.ReserveCredits(credits, key, description)
+}
+
+// idempotencyKey scopes the request's Idempotency-Key header to the caller,
+// or returns "" when the header is absent
+func idempotencyKey(c *gin.Context, operation string) string {
+	key := c.GetHeader("Idempotency-Key")
+	if key == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s:%s", operation, auth.GetUserID(c), key)
+}
+
+// creditSettlement names the credits an assessment captures when it
+// completes or releases when it fails
+type creditSettlement struct {
+	reservationID string
+	credits       int    // 0 settles everything left in the reservation
+	key           string // idempotency key when several assessments share a reservation
+}
+
+// settleAssessmentCredits charges the assessment's reserved credits once it
+// has completed, or returns them to the wallet when it failed
+func settleAssessmentCredits(assessment *Assessment) {
+	assessmentMutex.RLock()
+	settlement := assessment.settlement
+	status := assessment.Status
+	assessmentID := assessment.ID
+	assessmentMutex.RUnlock()
+
+	if settlement.reservationID == "" {
+		return
+	}
+
+	var err error
+	if status == "completed" {
+		_, err = auth.CaptureCredits(settlement.reservationID, settlement.credits, &assessmentID, settlement.key)
+	} else {
+		_, err = auth.ReleaseCredits(settlement.reservationID, settlement.credits,
+			fmt.Sprintf("Assessment of %s %s", assessment.Website, status), settlement.key)
+	}
+	if err != nil {
+		// Unsettled credits are released when the reservation expires
+		log.Printf("❌ settleAssessmentCredits: Failed to settle reservation %s for assessment %d: %v", settlement.reservationID, assessmentID, err)
+	}
+}
+
+// respondCreditError writes the API response for a failed credit deduction
+func respondCreditError(c *gin.Context, err error, creditsRequired int) {
+	if errors.Is(err, auth.ErrInsufficientCredits) {
+		c.JSON(http.StatusPaymentRequired, gin.H{
+			"error":            "Insufficient credits",
+			"code":             "INSUFFICIENT_CREDITS",
+			"credits_required": creditsRequired,
+		})
+		return
+	}
+	if errors.Is(err, auth.ErrOverageCapReached) {
+		c.JSON(http.StatusPaymentRequired, gin.H{
+			"error":            "Overage spending cap reached",
+			"code":             "OVERAGE_CAP_REACHED",
+			"credits_required": creditsRequired,
+		})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error":   "Failed to process credit transaction",
+		"code":    "CREDIT_TRANSACTION_ERROR",
+		"details": err.Error(),
+	})
+}
+
+// newAssessmentRecord builds a pending assessment for a priced request in a
+// workspace and registers it in the in-memory store
+func newAssessmentRecord(req DoRiskAssessmentRequest, workspace auth.Workspace, quote *pricing.Quote) *Assessment {
+	userID := workspace.UserID
+
+	assessmentMutex.Lock()
+	defer assessmentMutex.Unlock()
+
 	assessmentCounter++
 
 	// Build comprehensive assessment data in JSON format
@@ -150,6 +313,7 @@ func DoRiskAssessmentHandler(c *gin.Context) {
 		},
 		"country_supported": isCountrySupported(req.BillingCountryCode),
 		"mcc_restricted":    false, // Will be updated during assessment
+		"pricing":           quote,
 		"timestamps": map[string]interface{}{
 			"assessment_started": time.Now().Format(time.RFC3339),
 		},
@@ -164,40 +328,21 @@ func DoRiskAssessmentHandler(c *gin.Context) {
 		CreatedBy:       userID,
 		UpdatedBy:       userID,
 		UserID:          userID, // Explicitly set UserID field
-		CreditsConsumed: creditsRequired,
-		AssessmentType:  "comprehensive", // Default type
+		OrgID:           workspace.OrgIDPtr(),
+		CreditsConsumed: quote.Credits,
+		AssessmentCost:  &quote.Cost,
+		AssessmentType:  quote.AssessmentType,
 		CreatedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
+		options: scrapers.AssessmentOptions{
+			SkipMCCClassification: !quote.HasCheck(pricing.CheckMCCClassification),
+			SkipReputationLookups: !quote.HasCheck(pricing.CheckReputationLookup),
+		},
 	}
 
-	log.Printf("📄 DoRiskAssessmentHandler: Created assessment record - ID: %d, Website: %s, UserID: %s", assessment.ID, assessment.Website, assessment.UserID)
-
 	// Store in memory
 	assessmentStore[req.Website] = assessment
-	assessmentMutex.Unlock()
-
-	// Try to save to database as well (if available)
-	log.Printf("💾 DoRiskAssessmentHandler: Attempting to save to database...")
-	go func() {
-		if err := saveAssessmentToDatabase(assessment, userID, creditsRequired); err != nil {
-			log.Printf("⚠️ DoRiskAssessmentHandler: Failed to save to database: %v", err)
-		} else {
-			log.Printf("✅ DoRiskAssessmentHandler: Successfully saved to database")
-		}
-	}()
-
-	// Start assessment in background
-	log.Printf("🔄 DoRiskAssessmentHandler: Starting background assessment...")
-	go runAssessment(assessment)
-
-	log.Printf("✅ DoRiskAssessmentHandler: Assessment initiated successfully for %s", req.Website)
-
-	// Return response
-	c.JSON(http.StatusOK, DoRiskAssessmentResponse{
-		Status:  "Pending",
-		Website: req.Website,
-		ID:      req.ID,
-	})
+	return assessment
 }
 
 // GetRiskAssessmentHandler handles POST /api/website-risk-assessment/get-assessment
@@ -218,6 +363,12 @@ func GetRiskAssessmentHandler(c *gin.Context) {
 		return
 	}
 
+	if !auth.AuthorizeAssessmentAccess(c, assessment.UserID, assessment.orgID()) {
+		return
+	}
+
+	auth.SetAuditResource(c, strconv.FormatInt(assessment.ID, 10))
+
 	// Convert to WebsiteRiskAssessment format for response
 	result := convertToWebsiteRiskAssessment(assessment)
 	c.JSON(http.StatusOK, result)
@@ -235,6 +386,11 @@ func ListAssessmentsHandler(c *gin.Context) {
 		return
 	}
 
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
 	// Parse query parameters
 	limit := 50
 	offset := 0
@@ -252,12 +408,10 @@ func ListAssessmentsHandler(c *gin.Context) {
 		}
 	}
 
-	// Get from database with user filtering
+	// Get from database filtered to the workspace
 	if supabaseClient != nil {
 		var assessments []Assessment
-		query := supabaseClient.DB.From("assessments").
-			Select("*").
-			Eq("user_id", userID)
+		query := workspace.Scope(supabaseClient.DB.From("assessments").Select("*"))
 
 		if status != "" {
 			query = query.Eq("status", status)
@@ -297,12 +451,12 @@ func ListAssessmentsHandler(c *gin.Context) {
 		return
 	}
 
-	// Fallback to in-memory store (filtered by user)
+	// Fallback to in-memory store (filtered by workspace)
 	assessmentMutex.RLock()
 	var assessments []*Assessment
 	for _, assessment := range assessmentStore {
-		// Only include assessments for this user
-		if assessment.CreatedBy == userID {
+		// Only include assessments for this workspace
+		if workspace.Contains(assessment.UserID, assessment.OrgID) {
 			if status == "" || assessment.Status == status {
 				assessments = append(assessments, assessment)
 			}
@@ -340,18 +494,21 @@ func GetAssessmentByIDHandler(c *gin.Context) {
 		return
 	}
 
-	assessmentMutex.RLock()
-	var foundAssessment *Assessment
-	for _, assessment := range assessmentStore {
-		if assessment.ID == id {
-			foundAssessment = assessment
-			break
-		}
+	foundAssessment, err := getAssessmentByID(id)
+	if errors.Is(err, errAssessmentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Assessment not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ GetAssessmentByIDHandler: Failed to fetch assessment %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch assessment from database",
+			"code":  "DATABASE_FETCH_ERROR",
+		})
+		return
 	}
-	assessmentMutex.RUnlock()
 
-	if foundAssessment == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Assessment not found"})
+	if !auth.AuthorizeAssessmentAccess(c, foundAssessment.UserID, foundAssessment.orgID()) {
 		return
 	}
 
@@ -359,39 +516,72 @@ func GetAssessmentByIDHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
-// ManualQualificationUpdateHandler handles POST /api/website-risk-assessment/manual-update
+// ManualQualificationUpdateHandler handles POST /api/website-risk-assessment/manual-update.
+// The route requires the assessments:qualify permission; the caller must also
+// hold it in the organization the assessment belongs to.
 func ManualQualificationUpdateHandler(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+			"code":  "AUTHENTICATION_REQUIRED",
+		})
+		return
+	}
+
 	var req ManualQualificationUpdateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Update in memory
-	assessmentMutex.Lock()
+	workspace, ok := auth.ResolveWorkspace(c)
+	if !ok {
+		return
+	}
+
+	// Only the workspace's own assessment of the website may be updated
+	assessmentMutex.RLock()
 	assessment, exists := assessmentStore[req.Website]
-	if exists {
-		assessment.Status = "completed"
-		assessment.UpdatedBy = "manual"
-		assessment.UpdatedAt = time.Now()
-		if assessment.AssessmentData == nil {
-			assessment.AssessmentData = make(map[string]interface{})
+	assessmentMutex.RUnlock()
+
+	if !exists || !workspace.Contains(assessment.UserID, assessment.OrgID) {
+		dbAssessment, err := getAssessmentFromDatabase(workspace, req.Website)
+		if err != nil || dbAssessment == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Assessment not found"})
+			return
 		}
-		assessment.AssessmentData["qualification_status"] = req.QualificationStatus
-		assessment.AssessmentData["manual_update"] = true
+		assessment = dbAssessment
 	}
-	assessmentMutex.Unlock()
 
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Assessment not found"})
+	if !auth.AuthorizeAssessmentPermission(c, assessment.UserID, assessment.orgID(), auth.PermAssessmentsQualify) {
 		return
 	}
 
-	// Try to update in database as well
+	// Update in memory
+	assessmentMutex.Lock()
+	assessment.Status = "completed"
+	assessment.UpdatedBy = userID
+	assessment.UpdatedAt = time.Now()
+	if assessment.AssessmentData == nil {
+		assessment.AssessmentData = make(map[string]interface{})
+	}
+	assessment.AssessmentData["qualification_status"] = req.QualificationStatus
+	assessment.AssessmentData["manual_update"] = true
+	assessment.AssessmentData["qualified_by"] = userID
+	assessmentMutex.Unlock()
+
+	log.Printf("✍️ ManualQualificationUpdateHandler: User %s set %s to %s", userID, req.Website, req.QualificationStatus)
+	auth.SetAuditResource(c, strconv.FormatInt(assessment.ID, 10))
+	auth.SetAuditMetadata(c, "website", req.Website)
+	auth.SetAuditMetadata(c, "qualification_status", req.QualificationStatus)
+
+	// Try to update in database as well, then push the manual decision to Salesforce
 	go func() {
 		if err := updateAssessmentInDatabase(assessment); err != nil {
 			log.Printf("Warning: Failed to update in database: %v", err)
 		}
+		syncQualificationToSalesforce(assessment, req.QualificationStatus)
 	}()
 
 	c.JSON(http.StatusOK, gin.H{
@@ -421,8 +611,10 @@ func saveAssessmentToDatabase(assessment *Assessment, userID string, creditsCons
 		"created_by":              assessment.CreatedBy,
 		"updated_by":              assessment.UpdatedBy,
 		"user_id":                 userID, // Associate with user
+		"org_id":                  assessment.OrgID,
 		"credits_consumed":        creditsConsumed,
-		"assessment_cost":         float64(creditsConsumed) * 0.01, // Example cost calculation
+		"credit_reservation_id":   assessment.CreditReservationID,
+		"assessment_cost":         assessment.AssessmentCost,
 		"assessment_type":         assessment.AssessmentType,
 	}
 
@@ -443,11 +635,6 @@ func saveAssessmentToDatabase(assessment *Assessment, userID string, creditsCons
 		if id, ok := results[0]["id"].(float64); ok {
 			assessment.ID = int64(id)
 			log.Printf("✅ saveAssessmentToDatabase: Updated assessment ID to %d", assessment.ID)
-			
-			// Update credit transaction with assessment ID
-			go func() {
-				auth.ConsumeCredits(userID, 0, &assessment.ID, fmt.Sprintf("Assessment ID %d linked", assessment.ID))
-			}()
 		} else {
 			log.Printf("⚠️ saveAssessmentToDatabase: Could not extract ID from response")
 		}
@@ -490,14 +677,17 @@ func updateAssessmentInDatabase(assessment *Assessment) error {
 	return nil
 }
 
-func getAssessmentFromDatabase(website string) (*Assessment, error) {
+// getAssessmentFromDatabase returns the workspace's latest assessment of a website
+func getAssessmentFromDatabase(workspace auth.Workspace, website string) (*Assessment, error) {
 	if supabaseClient == nil {
 		return nil, fmt.Errorf("no database connection available")
 	}
 
 	var results []Assessment
-	err := supabaseClient.DB.From("assessments").
+	err := workspace.Scope(supabaseClient.DB.From("assessments").
 		Select("*").
+		OrderBy("created_at", "desc").
+		Limit(1)).
 		Eq("website", website).
 		Execute(&results)
 
@@ -512,6 +702,38 @@ func getAssessmentFromDatabase(website string) (*Assessment, error) {
 	return &results[0], nil
 }
 
+// getAssessmentByID looks an assessment up by ID, preferring the database and
+// falling back to the in-memory store when no database is configured
+func getAssessmentByID(id int64) (*Assessment, error) {
+	if supabaseClient != nil {
+		var results []Assessment
+		err := supabaseClient.DB.From("assessments").
+			Select("*").
+			Eq("id", fmt.Sprintf("%d", id)).
+			Execute(&results)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(results) == 0 {
+			return nil, errAssessmentNotFound
+		}
+
+		return &results[0], nil
+	}
+
+	assessmentMutex.RLock()
+	defer assessmentMutex.RUnlock()
+	for _, assessment := range assessmentStore {
+		if assessment.ID == id {
+			return assessment, nil
+		}
+	}
+
+	return nil, errAssessmentNotFound
+}
+
 // runAssessment performs the actual risk assessment
 func runAssessment(assessment *Assessment) {
 	startTime := time.Now()
@@ -538,15 +760,16 @@ func runAssessment(assessment *Assessment) {
 
 		// Update in database
 		updateAssessmentInDatabase(assessment)
+		settleAssessmentCredits(assessment)
 
-		// Send notification for unsupported country
-		sendNotification(fmt.Sprintf("Assessment completed for %s. Country not supported", assessment.Website), fmt.Sprintf("%s (country not supported)", assessment.Website))
+		// Unsupported countries complete immediately as high risk
+		publishAssessmentEvent(webhooks.EventAssessmentCompleted, assessment)
 		return
 	}
 
 	// Run the full risk assessment using the scrapers orchestrator
 	orchestrator := scrapers.NewWebsiteRiskAssessmentOrchestrator()
-	result, err := orchestrator.RunAssessment(assessment.Website, assessment.CountryCode)
+	result, err := orchestrator.RunAssessmentWithOptions(assessment.Website, assessment.CountryCode, assessment.options)
 
 	if err != nil {
 		errorMsg := err.Error()
@@ -561,8 +784,9 @@ func runAssessment(assessment *Assessment) {
 
 		// Update in database
 		updateAssessmentInDatabase(assessment)
+		settleAssessmentCredits(assessment)
 
-		sendNotification(fmt.Sprintf("Assessment failed for %s: %s", assessment.Website, err.Error()), fmt.Sprintf("%s (failed)", assessment.Website))
+		publishAssessmentEvent(webhooks.EventAssessmentFailed, assessment)
 		return
 	}
 
@@ -649,8 +873,11 @@ func runAssessment(assessment *Assessment) {
 
 	// Update in database
 	updateAssessmentInDatabase(assessment)
+	settleAssessmentCredits(assessment)
+
+	// Publish completion to webhooks and notification channels
+	publishAssessmentEvent(webhooks.EventAssessmentCompleted, assessment)
 
-	// Send completion notification
 	mccRestricted := false
 	if assessment.AssessmentData != nil {
 		if mr, ok := assessment.AssessmentData["mcc_restricted"].(bool); ok {
@@ -658,16 +885,8 @@ func runAssessment(assessment *Assessment) {
 		}
 	}
 	if mccRestricted {
-		sendNotification(
-			fmt.Sprintf("Manual assessment required for %s", assessment.Website),
-			fmt.Sprintf("%s (manual_assessment)", assessment.Website),
-		)
+		publishAssessmentEvent(webhooks.EventAssessmentManualReview, assessment)
 	} else {
-		sendNotification(
-			fmt.Sprintf("Assessment completed for %s", assessment.Website),
-			fmt.Sprintf("%s (completed)", assessment.Website),
-		)
-
 		// Update Salesforce if needed
 		checkRiskScoreAndUpdateSalesforce(assessment)
 	}
@@ -744,33 +963,97 @@ func convertToWebsiteRiskAssessment(assessment *Assessment) *WebsiteRiskAssessme
 	return wra
 }
 
-// sendNotification sends a notification (placeholder implementation)
-func sendNotification(message, title string) {
-	log.Printf("📢 NOTIFICATION: %s - %s", title, message)
-}
-
-// checkRiskScoreAndUpdateSalesforce checks risk score and updates Salesforce
+// checkRiskScoreAndUpdateSalesforce derives the qualification status from the
+// risk category and writes it back to the originating Salesforce record
 func checkRiskScoreAndUpdateSalesforce(assessment *Assessment) {
+	qualificationStatus := "Qualified"
+	assessmentMutex.RLock()
 	if assessment.AssessmentData != nil {
-		if salesforceReq, exists := assessment.AssessmentData["salesforce_request"]; exists {
-			if salesforceData, ok := salesforceReq.(map[string]interface{}); ok {
-				if id, exists := salesforceData["Id"]; exists {
-					qualificationStatus := "Qualified"
-					if assessment.AssessmentData != nil {
-						if riskCategory, ok := assessment.AssessmentData["risk_category"].(string); ok && riskCategory == "high_risk" {
-							qualificationStatus = "Not Qualified"
-						}
-					}
+		if riskCategory, ok := assessment.AssessmentData["risk_category"].(string); ok && riskCategory == "high_risk" {
+			qualificationStatus = "Not Qualified"
+		}
+	}
+	assessmentMutex.RUnlock()
 
-					log.Printf("🔄 Would update Salesforce opportunity %v as %s for domain %s", id, qualificationStatus, assessment.Website)
-					sendNotification(
-						fmt.Sprintf("Updated Salesforce opportunity %v as %s", id, qualificationStatus),
-						fmt.Sprintf("%s (%s)", assessment.Website, qualificationStatus),
-					)
-				}
+	syncQualificationToSalesforce(assessment, qualificationStatus)
+}
+
+// syncQualificationToSalesforce updates the Salesforce record referenced by the
+// assessment request and records the outcome in assessment_data.salesforce_sync
+func syncQualificationToSalesforce(assessment *Assessment, qualificationStatus string) {
+	assessmentMutex.RLock()
+	recordID := ""
+	update := salesforce.QualificationUpdate{
+		AssessmentID:        assessment.ID,
+		QualificationStatus: qualificationStatus,
+	}
+	if assessment.AssessmentData != nil {
+		if salesforceData, ok := assessment.AssessmentData["salesforce_request"].(map[string]interface{}); ok {
+			if id, ok := salesforceData["Id"].(string); ok {
+				recordID = id
 			}
 		}
+		update.RiskScore = riskScoreFromData(assessment.AssessmentData["risk_score"])
+		if riskCategory, ok := assessment.AssessmentData["risk_category"].(string); ok {
+			update.RiskCategory = riskCategory
+		}
+	}
+	assessmentMutex.RUnlock()
+
+	if recordID == "" {
+		return
+	}
+	if !salesforce.Enabled() {
+		log.Printf("⚠️ Salesforce not configured, skipping update of %s as %s for domain %s", recordID, qualificationStatus, assessment.Website)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	syncResult := map[string]interface{}{
+		"record_id":            recordID,
+		"qualification_status": qualificationStatus,
+		"synced_at":            time.Now().Format(time.RFC3339),
+	}
+	err := salesforce.UpdateQualification(ctx, recordID, update)
+	if err != nil {
+		log.Printf("❌ Failed to update Salesforce record %s for domain %s: %v", recordID, assessment.Website, err)
+		syncResult["status"] = "failed"
+		syncResult["error"] = err.Error()
+	} else {
+		syncResult["status"] = "synced"
+	}
+
+	assessmentMutex.Lock()
+	if assessment.AssessmentData == nil {
+		assessment.AssessmentData = make(map[string]interface{})
 	}
+	assessment.AssessmentData["salesforce_sync"] = syncResult
+	assessmentMutex.Unlock()
+
+	if err := updateAssessmentInDatabase(assessment); err != nil {
+		log.Printf("Warning: Failed to record Salesforce sync result: %v", err)
+	}
+
+	if syncResult["status"] == "synced" {
+		log.Printf("✅ Updated Salesforce record %s as %s for domain %s", recordID, qualificationStatus, assessment.Website)
+	}
+}
+
+// riskScoreFromData reads a risk score stored in assessment_data, which is an
+// int in memory and a float64 once it has round-tripped through JSON
+func riskScoreFromData(value interface{}) *int {
+	switch v := value.(type) {
+	case *int:
+		return v
+	case int:
+		return &v
+	case float64:
+		score := int(v)
+		return &score
+	}
+	return nil
 }
 
 // Helper function to check if country is supported
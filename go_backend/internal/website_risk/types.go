@@ -2,6 +2,8 @@ package website_risk
 
 import (
 	"time"
+
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/pricing"
 )
 
 // Request and Response types
@@ -13,6 +15,10 @@ type DoRiskAssessmentRequest struct {
 	AnnualRevenue      string `json:"Annual_Revenue__c,omitempty"`
 	CBSICCode          string `json:"CB_SIC_Code__c,omitempty"`
 	CBPayMethod        string `json:"CB_Pay_Method__c,omitempty"`
+
+	// Pricing options; see GET /api/pricing/catalog
+	AssessmentType string   `json:"assessment_type,omitempty"` // quick or comprehensive
+	Checks         []string `json:"checks,omitempty"`          // optional checks not included in the assessment type
 }
 
 type GetRiskAssessmentRequest struct {
@@ -271,3 +277,69 @@ func IsRestrictedMCC(mccCode string) bool {
 	}
 	return false
 }
+
+// AssessmentBatch represents a set of website assessments submitted together
+type AssessmentBatch struct {
+	ID                  string      `json:"id"`
+	UserID              string      `json:"user_id"`
+	OrgID               *string     `json:"org_id"`
+	Status              string      `json:"status"` // queued, processing, completed, cancelled
+	Source              string      `json:"source"` // csv, json
+	TotalRows           int         `json:"total_rows"`
+	ValidRows           int         `json:"valid_rows"`
+	InvalidRows         int         `json:"invalid_rows"`
+	CreditsReserved     int         `json:"credits_reserved"`
+	CreditReservationID *string     `json:"credit_reservation_id"`
+	Rows                []*BatchRow `json:"rows"`
+	CreatedAt           time.Time   `json:"created_at"`
+	UpdatedAt           time.Time   `json:"updated_at"`
+}
+
+// BatchRow is a single merchant row of a batch and the assessment queued for it
+type BatchRow struct {
+	RowNumber       int                     `json:"row_number"`
+	Request         DoRiskAssessmentRequest `json:"request"`
+	Errors          []string                `json:"errors,omitempty"`
+	CreditsRequired int                     `json:"credits_required"`
+	AssessmentID    int64                   `json:"assessment_id,omitempty"`
+
+	quote      *pricing.Quote
+	assessment *Assessment
+}
+
+// BatchSummaryResponse is the API view of a batch without its rows
+type BatchSummaryResponse struct {
+	ID              string          `json:"id"`
+	Status          string          `json:"status"`
+	Source          string          `json:"source"`
+	TotalRows       int             `json:"total_rows"`
+	ValidRows       int             `json:"valid_rows"`
+	InvalidRows     int             `json:"invalid_rows"`
+	CreditsReserved int             `json:"credits_reserved"`
+	RowStatuses     map[string]int  `json:"row_statuses"`
+	RowErrors       []BatchRowError `json:"row_errors,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+// BatchRowError describes why a submitted row was rejected
+type BatchRowError struct {
+	RowNumber int      `json:"row_number"`
+	Website   string   `json:"website"`
+	Errors    []string `json:"errors"`
+}
+
+// BatchRowResult is the per-row outcome of a batch
+type BatchRowResult struct {
+	RowNumber     int      `json:"row_number"`
+	Website       string   `json:"website"`
+	SalesforceID  string   `json:"salesforce_id"`
+	CountryCode   string   `json:"billing_country_code"`
+	Status        string   `json:"status"`
+	AssessmentID  int64    `json:"assessment_id,omitempty"`
+	RiskScore     *int     `json:"risk_score,omitempty"`
+	RiskCategory  string   `json:"risk_category,omitempty"`
+	MCCRestricted bool     `json:"mcc_restricted"`
+	ErrorMessage  string   `json:"error_message,omitempty"`
+	Errors        []string `json:"errors,omitempty"`
+}
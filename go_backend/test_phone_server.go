@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/auth"
+	"bitbucket.org/quarkfin/platform-e2e/go_backend/internal/sms"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
@@ -24,7 +25,14 @@ func main() {
 		log.Printf("Warning: Failed to initialize auth: %v", err)
 	}
 
-	if err := auth.InitSMS(); err != nil {
+	smsConfig := sms.Config{
+		Enabled:   os.Getenv("ENABLE_SMS") == "true",
+		Providers: []string{sms.ProviderSNS},
+		AWSRegion: os.Getenv("AWS_REGION"),
+		SenderID:  "QuarkfinAI",
+		SinkFile:  os.Getenv("SMS_SINK_FILE"),
+	}
+	if err := sms.InitSMS(supabaseURL, supabaseKey, smsConfig); err != nil {
 		log.Printf("Warning: Failed to initialize SMS: %v", err)
 	}
 